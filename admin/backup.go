@@ -0,0 +1,56 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/tsuru/gandalf/backup"
+)
+
+func backupCmd(args []string, stdout, stderr io.Writer) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	out := stdout
+	if args[0] != "-" {
+		f, err := os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	manifest, err := backup.Create(out)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stderr, "Backup of %d repositories successfully created\n", len(manifest.Repositories))
+	return nil
+}
+
+func restoreCmd(args []string, stdout, stderr io.Writer) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	var in io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	manifest, err := backup.Restore(in)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stderr, "Backup of %d repositories created at %s successfully restored\n", len(manifest.Repositories), manifest.CreatedAt.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/tsuru/gandalf/consistency"
+)
+
+func checkCmd(args []string, stdout, stderr io.Writer) error {
+	fix := false
+	switch {
+	case len(args) == 1 && (args[0] == "--fix" || args[0] == "-fix"):
+		fix = true
+	case len(args) != 0:
+		return errUsage
+	}
+	var (
+		report *consistency.Report
+		err    error
+	)
+	if fix {
+		report, err = consistency.Fix()
+	} else {
+		report, err = consistency.Check()
+	}
+	if err != nil {
+		return err
+	}
+	printCheckReport(stdout, report)
+	return nil
+}
+
+func printCheckReport(w io.Writer, report *consistency.Report) {
+	for _, name := range report.MissingBare {
+		fmt.Fprintf(w, "Repository %q has no bare repository\n", name)
+	}
+	for _, name := range report.OrphanBare {
+		fmt.Fprintf(w, "Bare repository %q has no repository in the database\n", name)
+	}
+	for _, ref := range report.MissingUsers {
+		fmt.Fprintf(w, "Repository %q references user %q, which does not exist\n", ref.Repository, ref.User)
+	}
+	for _, k := range report.OrphanKeys {
+		fmt.Fprintf(w, "Key %q belongs to user %q, which does not exist\n", k.Name, k.User)
+	}
+	switch {
+	case report.Problems() == 0:
+		fmt.Fprintln(w, "No problems found")
+	case report.Fixed:
+		fmt.Fprintf(w, "%d problems found and fixed\n", report.Problems())
+	default:
+		fmt.Fprintf(w, "%d problems found, run with --fix to fix them\n", report.Problems())
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/tsuru/gandalf/user"
+)
+
+func syncKeysCmd(args []string, stdout, stderr io.Writer) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	report, err := user.SyncAuthorizedKeys()
+	if err != nil {
+		return err
+	}
+	printSyncReport(stdout, report)
+	return nil
+}
+
+func printSyncReport(w io.Writer, report *user.SyncReport) {
+	for _, k := range report.Added {
+		fmt.Fprintf(w, "+ %s %s\n", k.UserName, k.Key)
+	}
+	for _, k := range report.Removed {
+		fmt.Fprintf(w, "- %s %s\n", k.UserName, k.Key)
+	}
+	fmt.Fprintf(w, "%d keys added, %d keys removed, %d unmanaged lines kept\n", len(report.Added), len(report.Removed), report.Unmanaged)
+}
@@ -0,0 +1,114 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/log"
+)
+
+const version = "0.7.3"
+
+// command is a gandalf-admin subcommand. run receives the arguments after the
+// name of the command.
+type command struct {
+	usage       string
+	description string
+	run         func(args []string, stdout, stderr io.Writer) error
+}
+
+var commands = map[string]command{
+	"backup": {
+		usage:       "backup <file>",
+		description: "Writes a backup of the database and repositories to file (- for stdout)",
+		run:         backupCmd,
+	},
+	"check": {
+		usage:       "check [--fix]",
+		description: "Checks whether the database matches the bare repositories, fixing the problems with --fix",
+		run:         checkCmd,
+	},
+	"restore": {
+		usage:       "restore <file>",
+		description: "Restores a backup from file (- for stdin)",
+		run:         restoreCmd,
+	},
+	"sync-keys": {
+		usage:       "sync-keys",
+		description: "Rebuilds the authorized_keys file from the keys in the database",
+		run:         syncKeysCmd,
+	},
+}
+
+// errUsage is returned by commands called with invalid arguments.
+var errUsage = errors.New("invalid arguments")
+
+func usage(w io.Writer, flags *flag.FlagSet) {
+	fmt.Fprintln(w, "Usage: gandalf-admin [options] <command> [args]")
+	fmt.Fprintln(w, "\nCommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-24s %s\n", commands[name].usage, commands[name].description)
+	}
+	fmt.Fprintln(w, "\nOptions:")
+	flags.SetOutput(w)
+	flags.PrintDefaults()
+}
+
+// run executes gandalf-admin with the given arguments, returning the exit
+// code.
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("gandalf-admin", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configFile := flags.String("config", "/etc/gandalf.conf", "Gandalf configuration file")
+	gVersion := flags.Bool("version", false, "Print version and exit")
+	flags.Usage = func() { usage(stderr, flags) }
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *gVersion {
+		fmt.Fprintf(stdout, "gandalf-admin version %s\n", version)
+		return 0
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "Unknown command %q.\n\n", flags.Arg(0))
+		flags.Usage()
+		return 2
+	}
+	if err := config.ReadConfigFile(*configFile); err != nil {
+		fmt.Fprintf(stderr, "Could not open gandalf config file at %s (%s).\n", *configFile, err)
+		return 1
+	}
+	log.Init()
+	if err := cmd.run(flags.Args()[1:], stdout, stderr); err != nil {
+		if err == errUsage {
+			fmt.Fprintf(stderr, "Usage: gandalf-admin %s\n", cmd.usage)
+			return 2
+		}
+		fmt.Fprintf(stderr, "Error: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
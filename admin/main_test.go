@@ -0,0 +1,109 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tsuru/gandalf/consistency"
+	"github.com/tsuru/gandalf/user"
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct{}
+
+var _ = check.Suite(&S{})
+
+func (s *S) TestRunWithoutCommand(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	code := run(nil, &stdout, &stderr)
+	c.Assert(code, check.Equals, 2)
+	c.Assert(stderr.String(), check.Matches, "(?s)Usage: gandalf-admin .*backup <file>.*restore <file>.*")
+}
+
+func (s *S) TestRunUnknownCommand(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"dance"}, &stdout, &stderr)
+	c.Assert(code, check.Equals, 2)
+	c.Assert(stderr.String(), check.Matches, "(?s)Unknown command \"dance\".*")
+}
+
+func (s *S) TestRunVersion(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-version"}, &stdout, &stderr)
+	c.Assert(code, check.Equals, 0)
+	c.Assert(stdout.String(), check.Equals, "gandalf-admin version "+version+"\n")
+}
+
+func (s *S) TestRunInvalidConfig(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-config", "/nonexistent/gandalf.conf", "backup", "-"}, &stdout, &stderr)
+	c.Assert(code, check.Equals, 1)
+	c.Assert(stderr.String(), check.Matches, "Could not open gandalf config file at /nonexistent/gandalf.conf .*\n")
+}
+
+func (s *S) TestRunInvalidArguments(c *check.C) {
+	for _, args := range [][]string{{"backup"}, {"restore", "a", "b"}} {
+		var stdout, stderr bytes.Buffer
+		code := run(append([]string{"-config", "../etc/gandalf.conf"}, args...), &stdout, &stderr)
+		c.Check(code, check.Equals, 2)
+		c.Check(stderr.String(), check.Matches, "Usage: gandalf-admin "+args[0]+" <file>\n")
+	}
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-config", "../etc/gandalf.conf", "sync-keys", "now"}, &stdout, &stderr)
+	c.Check(code, check.Equals, 2)
+	c.Check(stderr.String(), check.Equals, "Usage: gandalf-admin sync-keys\n")
+	stderr.Reset()
+	code = run([]string{"-config", "../etc/gandalf.conf", "check", "--force"}, &stdout, &stderr)
+	c.Check(code, check.Equals, 2)
+	c.Check(stderr.String(), check.Equals, "Usage: gandalf-admin check [--fix]\n")
+}
+
+func (s *S) TestPrintSyncReport(c *check.C) {
+	report := user.SyncReport{
+		Added:     []user.KeyChange{{UserName: "bilbo", Key: "ssh-rsa AAAAbilbo bilbo@shire"}},
+		Removed:   []user.KeyChange{{UserName: "sauron", Key: "ssh-rsa AAAAsauron eye@mordor"}},
+		Unmanaged: 2,
+	}
+	var buf bytes.Buffer
+	printSyncReport(&buf, &report)
+	expected := "+ bilbo ssh-rsa AAAAbilbo bilbo@shire\n- sauron ssh-rsa AAAAsauron eye@mordor\n1 keys added, 1 keys removed, 2 unmanaged lines kept\n"
+	c.Assert(buf.String(), check.Equals, expected)
+}
+
+func (s *S) TestPrintCheckReport(c *check.C) {
+	report := consistency.Report{
+		MissingBare:  []string{"moria"},
+		OrphanBare:   []string{"isengard/orthanc"},
+		MissingUsers: []consistency.UserReference{{Repository: "moria", User: "balrog"}},
+		OrphanKeys:   []consistency.KeyReference{{User: "sauron", Name: "palantir"}},
+	}
+	var buf bytes.Buffer
+	printCheckReport(&buf, &report)
+	expected := `Repository "moria" has no bare repository
+Bare repository "isengard/orthanc" has no repository in the database
+Repository "moria" references user "balrog", which does not exist
+Key "palantir" belongs to user "sauron", which does not exist
+4 problems found, run with --fix to fix them
+`
+	c.Assert(buf.String(), check.Equals, expected)
+	buf.Reset()
+	report.Fixed = true
+	printCheckReport(&buf, &report)
+	c.Assert(buf.String(), check.Matches, "(?s).*\n4 problems found and fixed\n")
+	buf.Reset()
+	printCheckReport(&buf, &consistency.Report{})
+	c.Assert(buf.String(), check.Equals, "No problems found\n")
+}
+
+func (s *S) TestRestoreInvalidFile(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-config", "../etc/gandalf.conf", "restore", "/nonexistent/backup.tar.gz"}, &stdout, &stderr)
+	c.Assert(code, check.Equals, 1)
+	c.Assert(stderr.String(), check.Equals, "Error: open /nonexistent/backup.tar.gz: no such file or directory\n")
+}
@@ -0,0 +1,200 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/tsuru/gandalf/audit"
+	"github.com/tsuru/gandalf/auth"
+	"github.com/tsuru/gandalf/hook"
+	"github.com/tsuru/gandalf/repository"
+	"github.com/tsuru/tsuru/log"
+)
+
+// gitServices maps the git services available through the smart HTTP
+// transport to the permission required to use them. They are the same rules
+// enforced by gandalf-ssh.
+var gitServices = map[string]func(*repository.Repository, string) bool{
+	"git-upload-pack":  (*repository.Repository).HasReadPermission,
+	"git-receive-pack": (*repository.Repository).HasWritePermission,
+}
+
+// gitScopes maps the git services to the scope required from the token sent
+// as password when authentication is enabled.
+var gitScopes = map[string]string{
+	"git-upload-pack":  auth.ReadScope,
+	"git-receive-pack": auth.WriteScope,
+}
+
+func pktLine(line string) []byte {
+	return []byte(fmt.Sprintf("%04x%s", len(line)+4, line))
+}
+
+// authorizeGit finds the requested repository and checks whether the user
+// identified by the basic auth credentials may use the given service on it.
+// Anonymous requests are identified by an empty user name, which only grants
+// read access to public repositories. Users must send as password an API
+// token belonging to them, or with the admin scope, and with the scope
+// required by the service, so only anonymous requests are accepted when
+// authentication is disabled.
+//
+// In case of failure, the response is written and an error is returned.
+func authorizeGit(w http.ResponseWriter, r *http.Request, service string) (*repository.Repository, string, error) {
+	allowed, ok := gitServices[service]
+	if !ok {
+		err := fmt.Errorf("Unsupported git service %q", service)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return nil, "", err
+	}
+	repo, err := repository.Resolve(r.URL.Query().Get(":name"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == repository.ErrRepositoryNotFound {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return nil, "", err
+	}
+	userName, password, _ := r.BasicAuth()
+	if userName != "" {
+		if !auth.Enabled() {
+			err = fmt.Errorf("Authentication is disabled, only public repositories may be fetched over HTTP")
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return nil, "", err
+		}
+		if _, err = getUserOr404(userName); err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="gandalf"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return nil, "", err
+		}
+		t, err := auth.Authenticate(password)
+		if err == nil && !t.IdentifiesUser(userName) {
+			err = auth.ErrInvalidToken
+		}
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="gandalf"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return nil, "", err
+		}
+		if !t.Allows(gitScopes[service]) {
+			err = fmt.Errorf("token %s does not have the %s scope", t.Name, gitScopes[service])
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return nil, "", err
+		}
+	}
+	if !allowed(&repo, userName) {
+		if userName == "" {
+			err = fmt.Errorf("Authentication required")
+			w.Header().Set("WWW-Authenticate", `Basic realm="gandalf"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return nil, "", err
+		}
+		err = fmt.Errorf("User %q does not have access to %s on repository %q", userName, service, repo.Name)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return nil, "", err
+	}
+	return &repo, userName, nil
+}
+
+func gitCommand(r *http.Request, service, repo string, env []string, args ...string) *exec.Cmd {
+	args = append([]string{strings.TrimPrefix(service, "git-"), "--stateless-rpc"}, args...)
+	args = append(args, repository.BarePath(repo))
+	cmd := exec.Command("git", args...)
+	if protocol := r.Header.Get("Git-Protocol"); protocol != "" {
+		env = append(env, "GIT_PROTOCOL="+protocol)
+	}
+	cmd.Env = env
+	return cmd
+}
+
+func gitInfoRefs(w http.ResponseWriter, r *http.Request) {
+	service := r.URL.Query().Get("service")
+	if service == "" {
+		http.Error(w, "Dumb HTTP transport is not supported, please use a git client with smart HTTP support", http.StatusForbidden)
+		return
+	}
+	repo, userName, err := authorizeGit(w, r, service)
+	if err != nil {
+		return
+	}
+	stderr := &bytes.Buffer{}
+	env := append(os.Environ(), "TSURU_USER="+userName)
+	cmd := gitCommand(r, service, repo.Name, env, "--advertise-refs")
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		log.Errorf("Error advertising refs of repository %q: %s [%s]", repo.Name, err, stderr.String())
+		http.Error(w, fmt.Sprintf("Could not advertise refs of repository %q", repo.Name), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-advertisement", service))
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(pktLine(fmt.Sprintf("# service=%s\n", service)))
+	w.Write([]byte("0000"))
+	w.Write(out)
+}
+
+func gitServiceRPC(service string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		repo, userName, err := authorizeGit(w, r, service)
+		if err != nil {
+			return
+		}
+		if r.Header.Get("Content-Type") != fmt.Sprintf("application/x-%s-request", service) {
+			http.Error(w, "Invalid content type for "+service, http.StatusBadRequest)
+			return
+		}
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer gz.Close()
+			body = gz
+		}
+		env := append(os.Environ(), "TSURU_USER="+userName)
+		if service == "git-receive-pack" {
+			// pushes go through the built-in hooks, which enforce the
+			// protection rules of the repository.
+			if env, err = hook.ReceiveEnv(repo.Name, userName); err != nil {
+				log.Errorf("Error installing built-in hooks: %s", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		stderr := &bytes.Buffer{}
+		cmd := gitCommand(r, service, repo.Name, env)
+		cmd.Stdin = body
+		cmd.Stdout = w
+		cmd.Stderr = stderr
+		w.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-result", service))
+		w.Header().Set("Cache-Control", "no-cache")
+		log.Debugf("Executing %s on repository %q for user %q", service, repo.Name, userName)
+		if err := cmd.Run(); err != nil {
+			log.Errorf("Got error while executing %s on repository %q: %s [%s]", service, repo.Name, err, stderr.String())
+			return
+		}
+		// pushes are recorded by the built-in post-receive hook, which knows
+		// the updated refs.
+		if service == "git-upload-pack" {
+			actor := userName
+			if actor == "" {
+				actor = requestActor(r)
+			}
+			audit.Record(audit.Entry{Action: audit.GitFetch, Actor: actor, Repositories: []string{repo.Name}, Details: "http"})
+		}
+	}
+}
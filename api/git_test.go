@@ -0,0 +1,213 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/commandmocker"
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/auth"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/gandalf/repository"
+	"github.com/tsuru/gandalf/user"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) insertGitFixtures(c *check.C, repo repository.Repository, users ...string) func() {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repo)
+	c.Assert(err, check.IsNil)
+	for _, name := range users {
+		err = conn.User().Insert(&user.User{Name: name})
+		c.Assert(err, check.IsNil)
+	}
+	return func() {
+		conn, err := db.Conn()
+		c.Assert(err, check.IsNil)
+		defer conn.Close()
+		conn.Repository().RemoveId(repo.Name)
+		conn.User().RemoveAll(bson.M{"_id": bson.M{"$in": users}})
+	}
+}
+
+// gitToken enables authentication and returns a token belonging to the given
+// user, to be sent as the basic authentication password.
+func (s *S) gitToken(c *check.C, userName string, scopes ...string) (string, func()) {
+	config.Set("auth:enabled", true)
+	if len(scopes) == 0 {
+		scopes = []string{auth.WriteScope}
+	}
+	_, raw, err := auth.NewUserToken("git-"+userName, userName, scopes)
+	c.Assert(err, check.IsNil)
+	return raw, func() {
+		auth.Revoke("git-" + userName)
+		config.Unset("auth:enabled")
+	}
+}
+
+func (s *S) lastGitParameters(n int) []string {
+	params := commandmocker.Parameters(s.tmpdir)
+	if len(params) < n {
+		return params
+	}
+	return params[len(params)-n:]
+}
+
+func (s *S) TestPktLine(c *check.C) {
+	c.Assert(string(pktLine("# service=git-upload-pack\n")), check.Equals, "001e# service=git-upload-pack\n")
+}
+
+func (s *S) TestGitInfoRefsUploadPackPublicRepository(c *check.C) {
+	cleanup := s.insertGitFixtures(c, repository.Repository{Name: "publicrepo", Users: []string{"bob"}, IsPublic: true})
+	defer cleanup()
+	recorder, request := get("/repository/publicrepo.git/info/refs?service=git-upload-pack", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/x-git-upload-pack-advertisement")
+	c.Assert(recorder.Body.String(), check.Equals, "001e# service=git-upload-pack\n0000")
+	expected := []string{"upload-pack", "--stateless-rpc", "--advertise-refs", repository.BarePath("publicrepo")}
+	c.Assert(s.lastGitParameters(4), check.DeepEquals, expected)
+}
+
+func (s *S) TestGitInfoRefsWithNamespace(c *check.C) {
+	cleanup := s.insertGitFixtures(c, repository.Repository{Name: "ns/repo", Users: []string{"bob"}}, "bob")
+	defer cleanup()
+	token, revoke := s.gitToken(c, "bob")
+	defer revoke()
+	recorder, request := get("/repository/ns/repo.git/info/refs?service=git-receive-pack", nil, c)
+	request.SetBasicAuth("bob", token)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "001f# service=git-receive-pack\n0000")
+	expected := []string{"receive-pack", "--stateless-rpc", "--advertise-refs", repository.BarePath("ns/repo")}
+	c.Assert(s.lastGitParameters(4), check.DeepEquals, expected)
+}
+
+func (s *S) TestGitInfoRefsDumbProtocol(c *check.C) {
+	recorder, request := get("/repository/somerepo.git/info/refs", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestGitInfoRefsUnknownService(c *check.C) {
+	recorder, request := get("/repository/somerepo.git/info/refs?service=git-upload-archive", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestGitInfoRefsRepositoryNotFound(c *check.C) {
+	recorder, request := get("/repository/ghost.git/info/refs?service=git-upload-pack", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestGitInfoRefsRequiresAuthentication(c *check.C) {
+	cleanup := s.insertGitFixtures(c, repository.Repository{Name: "privaterepo", Users: []string{"bob"}})
+	defer cleanup()
+	recorder, request := get("/repository/privaterepo.git/info/refs?service=git-upload-pack", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusUnauthorized)
+	c.Assert(recorder.Header().Get("WWW-Authenticate"), check.Equals, `Basic realm="gandalf"`)
+}
+
+func (s *S) TestGitInfoRefsUnknownUser(c *check.C) {
+	cleanup := s.insertGitFixtures(c, repository.Repository{Name: "privaterepo", Users: []string{"bob"}})
+	defer cleanup()
+	token, revoke := s.gitToken(c, "bob")
+	defer revoke()
+	recorder, request := get("/repository/privaterepo.git/info/refs?service=git-upload-pack", nil, c)
+	request.SetBasicAuth("bob", token)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusUnauthorized)
+}
+
+func (s *S) TestGitInfoRefsReceivePackReadOnlyUser(c *check.C) {
+	repo := repository.Repository{Name: "privaterepo", Users: []string{"bob"}, ReadOnlyUsers: []string{"alice"}}
+	cleanup := s.insertGitFixtures(c, repo, "alice")
+	defer cleanup()
+	token, revoke := s.gitToken(c, "alice")
+	defer revoke()
+	recorder, request := get("/repository/privaterepo.git/info/refs?service=git-receive-pack", nil, c)
+	request.SetBasicAuth("alice", token)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestGitReceivePack(c *check.C) {
+	cleanup := s.insertGitFixtures(c, repository.Repository{Name: "privaterepo", Users: []string{"bob"}}, "bob")
+	defer cleanup()
+	token, revoke := s.gitToken(c, "bob")
+	defer revoke()
+	recorder, request := post("/repository/privaterepo.git/git-receive-pack", strings.NewReader("0000"), c)
+	request.Header.Set("Content-Type", "application/x-git-receive-pack-request")
+	request.SetBasicAuth("bob", token)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/x-git-receive-pack-result")
+	expected := []string{"receive-pack", "--stateless-rpc", repository.BarePath("privaterepo")}
+	c.Assert(s.lastGitParameters(3), check.DeepEquals, expected)
+	envs := commandmocker.Envs(s.tmpdir)
+	c.Assert(envs, check.Matches, `(?s).*TSURU_USER=bob.*`)
+	c.Assert(envs, check.Matches, `(?s).*GANDALF_REPOSITORY=privaterepo.*`)
+	c.Assert(envs, check.Matches, `(?s).*GIT_CONFIG_VALUE_0=/var/lib/gandalf/repositories/\.hooks.*`)
+	c.Assert(s.rfs.HasAction("openfile /var/lib/gandalf/repositories/.hooks/pre-receive with mode 0755"), check.Equals, true)
+}
+
+func (s *S) TestGitUploadPackInvalidContentType(c *check.C) {
+	cleanup := s.insertGitFixtures(c, repository.Repository{Name: "publicrepo", Users: []string{"bob"}, IsPublic: true})
+	defer cleanup()
+	recorder, request := post("/repository/publicrepo.git/git-upload-pack", strings.NewReader("0000"), c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestGitInfoRefsAuthenticationDisabled(c *check.C) {
+	cleanup := s.insertGitFixtures(c, repository.Repository{Name: "privaterepo", Users: []string{"bob"}}, "bob")
+	defer cleanup()
+	recorder, request := get("/repository/privaterepo.git/info/refs?service=git-receive-pack", nil, c)
+	request.SetBasicAuth("bob", "")
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	c.Assert(recorder.Body.String(), check.Equals, "Authentication is disabled, only public repositories may be fetched over HTTP\n")
+}
+
+func (s *S) TestGitInfoRefsWrongPassword(c *check.C) {
+	cleanup := s.insertGitFixtures(c, repository.Repository{Name: "privaterepo", Users: []string{"bob"}}, "bob")
+	defer cleanup()
+	_, revoke := s.gitToken(c, "bob")
+	defer revoke()
+	recorder, request := get("/repository/privaterepo.git/info/refs?service=git-receive-pack", nil, c)
+	request.SetBasicAuth("bob", "wrong")
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusUnauthorized)
+	c.Assert(recorder.Header().Get("WWW-Authenticate"), check.Equals, `Basic realm="gandalf"`)
+}
+
+func (s *S) TestGitInfoRefsAnotherUserToken(c *check.C) {
+	cleanup := s.insertGitFixtures(c, repository.Repository{Name: "privaterepo", Users: []string{"bob"}}, "bob", "alice")
+	defer cleanup()
+	token, revoke := s.gitToken(c, "alice")
+	defer revoke()
+	recorder, request := get("/repository/privaterepo.git/info/refs?service=git-receive-pack", nil, c)
+	request.SetBasicAuth("bob", token)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusUnauthorized)
+}
+
+func (s *S) TestGitInfoRefsReadOnlyToken(c *check.C) {
+	cleanup := s.insertGitFixtures(c, repository.Repository{Name: "privaterepo", Users: []string{"bob"}}, "bob")
+	defer cleanup()
+	token, revoke := s.gitToken(c, "bob", auth.ReadScope)
+	defer revoke()
+	recorder, request := get("/repository/privaterepo.git/info/refs?service=git-receive-pack", nil, c)
+	request.SetBasicAuth("bob", token)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
@@ -12,17 +12,27 @@ import (
 	"io/ioutil"
 	"mime"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"reflect"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/globalsign/mgo"
 	"github.com/gorilla/pat"
 	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/audit"
+	"github.com/tsuru/gandalf/auth"
+	"github.com/tsuru/gandalf/consistency"
 	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/gandalf/group"
 	"github.com/tsuru/gandalf/hook"
 	"github.com/tsuru/gandalf/multipartzip"
 	"github.com/tsuru/gandalf/repository"
 	"github.com/tsuru/gandalf/user"
+	"github.com/tsuru/gandalf/webhook"
+	"github.com/tsuru/tsuru/log"
 )
 
 var maxMemory uint
@@ -39,157 +49,1450 @@ func maxMemoryValue() uint {
 	return maxMemory
 }
 
-func accessParameters(body io.ReadCloser) (repositories, users []string, err error) {
+func accessParameters(body io.ReadCloser) (repositories, users, groups []string, err error) {
 	var params map[string][]string
 	if err := parseBody(body, &params); err != nil {
-		return []string{}, []string{}, err
+		return []string{}, []string{}, nil, err
 	}
-	users, ok := params["users"]
-	if !ok {
-		return []string{}, []string{}, errors.New("It is need a user list")
+	users, hasUsers := params["users"]
+	groups, hasGroups := params["groups"]
+	if !hasUsers && !hasGroups {
+		return []string{}, []string{}, nil, errors.New("It is need a user list")
 	}
-	repositories, ok = params["repositories"]
+	repositories, ok := params["repositories"]
 	if !ok {
-		return []string{}, []string{}, errors.New("It is need a repository list")
+		return []string{}, []string{}, nil, errors.New("It is need a repository list")
+	}
+	return repositories, users, groups, nil
+}
+
+// exact registers a route matching the whole path, while the routes of pat
+// match its prefix. The routes following the name of a repository must be
+// exact, otherwise requests to namespaced repositories whose names start with
+// their suffix, such as "team/membership", would be routed to them.
+func exact(router *pat.Router, method, path string, h http.HandlerFunc) {
+	router.NewRoute().Path(path).Methods(method).Handler(h)
+}
+
+// resolveRepository resolves the name of the repository in the route, so the
+// handler receives the current name of a renamed repository when it's called
+// with a former one. Names that can not be resolved are kept, leaving the
+// handler to report the error.
+func resolveRepository(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		name := query.Get(":name")
+		if repo, err := repository.Resolve(name); err == nil && repo.Name != name {
+			query.Set(":name", repo.Name)
+			r.URL.RawQuery = query.Encode()
+		}
+		h(w, r)
 	}
-	return repositories, users, nil
 }
 
 func SetupRouter() *pat.Router {
 	router := pat.New()
+	router.Get("/repository/{name:[^/]*/?[^/]+}.git/info/refs", http.HandlerFunc(gitInfoRefs))
+	router.Post("/repository/{name:[^/]*/?[^/]+}.git/git-upload-pack", gitServiceRPC("git-upload-pack"))
+	router.Post("/repository/{name:[^/]*/?[^/]+}.git/git-receive-pack", gitServiceRPC("git-receive-pack"))
+	router.Post("/repository/{name:[^/]*/?[^/]+}.git/info/lfs/objects/batch", http.HandlerFunc(lfsBatch))
+	router.Get("/repository/{name:[^/]*/?[^/]+}.git/info/lfs/objects/{oid}", http.HandlerFunc(lfsDownload))
+	router.Put("/repository/{name:[^/]*/?[^/]+}.git/info/lfs/objects/{oid}", http.HandlerFunc(lfsUpload))
 	router.Post("/user/{name}/key", http.HandlerFunc(addKey))
 	router.Delete("/user/{name}/key/{keyname}", http.HandlerFunc(removeKey))
 	router.Put("/user/{name}/key/{keyname}", http.HandlerFunc(updateKey))
 	router.Get("/user/{name}/keys", http.HandlerFunc(listKeys))
+	router.Get("/user/{name}/repositories", http.HandlerFunc(listUserRepositories))
 	router.Post("/user", http.HandlerFunc(newUser))
 	router.Delete("/user/{name}", http.HandlerFunc(removeUser))
+	router.Get("/user/{name}", http.HandlerFunc(getUser))
+	router.Put("/user/{name}", http.HandlerFunc(renameUser))
+	router.Get("/user", http.HandlerFunc(listUsers))
+	router.Post("/group/{name}/members", http.HandlerFunc(addGroupMembers))
+	router.Delete("/group/{name}/members", http.HandlerFunc(removeGroupMembers))
+	router.Get("/group/{name}", http.HandlerFunc(getGroup))
+	router.Delete("/group/{name}", http.HandlerFunc(removeGroup))
+	router.Post("/group", http.HandlerFunc(newGroup))
+	router.Get("/group", http.HandlerFunc(listGroups))
 	router.Delete("/repository/revoke", http.HandlerFunc(revokeAccess))
-	router.Get("/repository/{name:[^/]*/?[^/]+}/archive", http.HandlerFunc(getArchive))
-	router.Get("/repository/{name:[^/]*/?[^/]+}/contents", http.HandlerFunc(getFileContents))
-	router.Get("/repository/{name:[^/]*/?[^/]+}/tree", http.HandlerFunc(getTree))
-	router.Get("/repository/{name:[^/]*/?[^/]+}/branches", http.HandlerFunc(getBranches))
-	router.Get("/repository/{name:[^/]*/?[^/]+}/tags", http.HandlerFunc(getTags))
-	router.Get("/repository/{name:[^/]*/?[^/]+}/diff/commits", http.HandlerFunc(getDiff))
-	router.Post("/repository/{name:[^/]*/?[^/]+}/commit", http.HandlerFunc(commit))
-	router.Get("/repository/{name:[^/]*/?[^/]+}/logs", http.HandlerFunc(getLogs))
+	exact(router, "GET", "/repository/{name:[^/]*/?[^/]+}/archive", resolveRepository(getArchive))
+	exact(router, "GET", "/repository/{name:[^/]*/?[^/]+}/bundle", resolveRepository(getBundle))
+	exact(router, "POST", "/repository/{name:[^/]*/?[^/]+}/bundle", resolveRepository(restoreBundle))
+	exact(router, "GET", "/repository/{name:[^/]*/?[^/]+}/contents", resolveRepository(getFileContents))
+	exact(router, "GET", "/repository/{name:[^/]*/?[^/]+}/tree", resolveRepository(getTree))
+	exact(router, "GET", "/repository/{name:[^/]*/?[^/]+}/branches", resolveRepository(getBranches))
+	exact(router, "GET", "/repository/{name:[^/]*/?[^/]+}/tags", resolveRepository(getTags))
+	exact(router, "GET", "/repository/{name:[^/]*/?[^/]+}/diff/commits", resolveRepository(getDiff))
+	exact(router, "POST", "/repository/{name:[^/]*/?[^/]+}/commit", resolveRepository(commit))
+	exact(router, "GET", "/repository/{name:[^/]*/?[^/]+}/protections", resolveRepository(getProtections))
+	exact(router, "POST", "/repository/{name:[^/]*/?[^/]+}/protections", resolveRepository(addProtection))
+	exact(router, "DELETE", "/repository/{name:[^/]*/?[^/]+}/protections", resolveRepository(removeProtection))
+	exact(router, "GET", "/repository/{name:[^/]*/?[^/]+}/logs", resolveRepository(getLogs))
+	exact(router, "POST", "/repository/{name:[^/]*/?[^/]+}/fork", resolveRepository(forkRepository))
+	exact(router, "POST", "/repository/{name:[^/]*/?[^/]+}/import", resolveRepository(importRepository))
+	exact(router, "GET", "/repository/{name:[^/]*/?[^/]+}/import", resolveRepository(getImport))
+	exact(router, "POST", "/repository/{name:[^/]*/?[^/]+}/restore", restoreRepository)
+	exact(router, "POST", "/repository/{name:[^/]*/?[^/]+}/maintenance", resolveRepository(startMaintenance))
+	exact(router, "GET", "/repository/{name:[^/]*/?[^/]+}/maintenance", resolveRepository(getMaintenance))
+	exact(router, "GET", "/repository/{name:[^/]*/?[^/]+}/forks", resolveRepository(listForks))
+	exact(router, "PUT", "/repository/{name:[^/]*/?[^/]+}/quota", resolveRepository(setQuota))
+	exact(router, "GET", "/repository/{name:[^/]*/?[^/]+}/hooks", resolveRepository(listRepositoryHooks))
+	exact(router, "GET", "/repository/{name:[^/]*/?[^/]+}/members", resolveRepository(getMembers))
+	exact(router, "POST", "/repository/{name:[^/]*/?[^/]+}/members", resolveRepository(setMember))
+	exact(router, "DELETE", "/repository/{name:[^/]*/?[^/]+}/members", resolveRepository(removeMember))
 	router.Post("/repository/grant", http.HandlerFunc(grantAccess))
 	router.Post("/repository", http.HandlerFunc(newRepository))
 	router.Get("/repository/{name:[^/]*/?[^/]+}", http.HandlerFunc(getRepository))
-	router.Delete("/repository/{name:[^/]*/?[^/]+}", http.HandlerFunc(removeRepository))
-	router.Put("/repository/{name:[^/]*/?[^/]+}", http.HandlerFunc(updateRepository))
+	router.Delete("/repository/{name:[^/]*/?[^/]+}", resolveRepository(removeRepository))
+	router.Put("/repository/{name:[^/]*/?[^/]+}", resolveRepository(updateRepository))
+	router.Get("/repository", http.HandlerFunc(listRepositories))
 	router.Get("/healthcheck", http.HandlerFunc(healthCheck))
+	router.Post("/hook/apply", http.HandlerFunc(applyTemplateHooks))
 	router.Post("/hook/{name}", http.HandlerFunc(addHook))
+	router.Get("/hook/{name}", http.HandlerFunc(getHook))
+	router.Delete("/hook/{name}", http.HandlerFunc(removeHook))
+	router.Get("/hook", http.HandlerFunc(listHooks))
+	router.Get("/webhook/{id}/deliveries", http.HandlerFunc(listDeliveries))
+	router.Get("/webhook/{id}", http.HandlerFunc(getWebhook))
+	router.Delete("/webhook/{id}", http.HandlerFunc(removeWebhook))
+	router.Post("/webhook", http.HandlerFunc(newWebhook))
+	router.Get("/webhook", http.HandlerFunc(listWebhooks))
+	router.Post("/token", http.HandlerFunc(newToken))
+	router.Get("/token", http.HandlerFunc(listTokens))
+	router.Delete("/token/{name}", http.HandlerFunc(revokeToken))
+	router.Get("/audit", http.HandlerFunc(listAudit))
+	router.Post("/admin/keys/sync", http.HandlerFunc(syncKeys))
+	router.Get("/admin/usage", http.HandlerFunc(getDiskUsage))
+	router.Get("/admin/consistency", http.HandlerFunc(checkConsistency))
+	router.Post("/admin/consistency", http.HandlerFunc(fixConsistency))
 	return router
 }
 
 func grantAccess(w http.ResponseWriter, r *http.Request) {
-	repositories, users, err := accessParameters(r.Body)
+	repositories, users, groups, err := accessParameters(r.Body)
 	readOnly := r.URL.Query().Get("readonly") == "yes"
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := repository.GrantAccess(repositories, users, readOnly); err != nil {
+	for _, name := range repositories {
+		if !authorizeRepository(w, r, name, repository.RoleAdmin) {
+			return
+		}
+	}
+	var granted []string
+	if users != nil {
+		if err := repository.GrantAccess(repositories, users, readOnly); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		granted = append(granted, fmt.Sprintf("users \"%s\"", users))
+	}
+	if groups != nil {
+		if err := repository.GrantGroupAccess(repositories, groups, readOnly); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		granted = append(granted, fmt.Sprintf("groups \"%s\"", groups))
+	}
+	access := "full"
+	if readOnly {
+		access = "read-only"
+	}
+	details := access + " access"
+	if groups != nil {
+		details += fmt.Sprintf(" to groups %s", groups)
+	}
+	recordAudit(r, audit.Entry{
+		Action:       audit.AccessGrant,
+		Repositories: repositories,
+		Users:        users,
+		Details:      details,
+	})
+	fmt.Fprintf(w, "Successfully granted %s access to %s into repository \"%s\"", access, strings.Join(granted, " and "), repositories)
+}
+
+func revokeAccess(w http.ResponseWriter, r *http.Request) {
+	repositories, users, groups, err := accessParameters(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, name := range repositories {
+		if !authorizeRepository(w, r, name, repository.RoleAdmin) {
+			return
+		}
+	}
+	var revoked []string
+	if users != nil {
+		if err := revokeFullAccess(repositories, users, repository.RevokeAccess); err != nil {
+			writeRevokeError(w, err)
+			return
+		}
+		revoked = append(revoked, fmt.Sprintf("users \"%s\"", users))
+	}
+	if groups != nil {
+		if err := revokeFullAccess(repositories, groups, repository.RevokeGroupAccess); err != nil {
+			writeRevokeError(w, err)
+			return
+		}
+		revoked = append(revoked, fmt.Sprintf("groups \"%s\"", groups))
+	}
+	var details string
+	if groups != nil {
+		details = fmt.Sprintf("groups %s", groups)
+	}
+	recordAudit(r, audit.Entry{
+		Action:       audit.AccessRevoke,
+		Repositories: repositories,
+		Users:        users,
+		Details:      details,
+	})
+	fmt.Fprintf(w, "Successfully revoked access to %s into repositories \"%s\"", strings.Join(revoked, " and "), repositories)
+}
+
+// revokeFullAccess revokes both read-only and full access using the given
+// revoke function.
+func revokeFullAccess(repositories, names []string, revoke func([]string, []string, bool) error) error {
+	if err := revoke(repositories, names, true); err != nil {
+		return err
+	}
+	return revoke(repositories, names, false)
+}
+
+func writeRevokeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if err == repository.ErrRepositoryNotFound {
+		status = http.StatusNotFound
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func addKey(w http.ResponseWriter, r *http.Request) {
+	keys := map[string]string{}
+	if err := parseBody(r.Body, &keys); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(keys) == 0 {
+		http.Error(w, "A key is needed", http.StatusBadRequest)
+		return
+	}
+	uName := r.URL.Query().Get(":name")
+	if err := user.AddKey(uName, keys); err != nil {
+		switch err {
+		case user.ErrInvalidKey:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case user.ErrDuplicateKey:
+			http.Error(w, "Key already exists.", http.StatusConflict)
+		case user.ErrUserNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	recordAudit(r, audit.Entry{Action: audit.KeyAdd, Users: []string{uName}, Details: fmt.Sprintf("keys %s", names)})
+	fmt.Fprint(w, "Key(s) successfully created")
+}
+
+func updateKey(w http.ResponseWriter, r *http.Request) {
+	uName := r.URL.Query().Get(":name")
+	kName := r.URL.Query().Get(":keyname")
+	defer r.Body.Close()
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	key := user.Key{Name: kName, Body: string(content)}
+	if err := user.UpdateKey(uName, key); err != nil {
+		switch err {
+		case user.ErrInvalidKey:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case user.ErrUserNotFound, user.ErrKeyNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	recordAudit(r, audit.Entry{Action: audit.KeyUpdate, Users: []string{uName}, Details: "key " + kName})
+	fmt.Fprintf(w, "Key %q successfully updated!", kName)
+}
+
+func removeKey(w http.ResponseWriter, r *http.Request) {
+	uName := r.URL.Query().Get(":name")
+	kName := r.URL.Query().Get(":keyname")
+	if err := user.RemoveKey(uName, kName); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	recordAudit(r, audit.Entry{Action: audit.KeyRemove, Users: []string{uName}, Details: "key " + kName})
+	fmt.Fprintf(w, "Key \"%s\" successfully removed", kName)
+}
+
+func listKeys(w http.ResponseWriter, r *http.Request) {
+	uName := r.URL.Query().Get(":name")
+	keys, err := user.ListKeys(uName)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
-	if readOnly {
-		fmt.Fprintf(w, "Successfully granted read-only access to users \"%s\" into repository \"%s\"", users, repositories)
-	} else {
-		fmt.Fprintf(w, "Successfully granted full access to users \"%s\" into repository \"%s\"", users, repositories)
+	out, err := json.Marshal(&keys)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	w.Write(out)
 }
 
-func revokeAccess(w http.ResponseWriter, r *http.Request) {
-	repositories, users, err := accessParameters(r.Body)
+func listUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := user.ListFilter{Search: query.Get("search"), Cursor: query.Get("cursor")}
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit <= 0 {
+			http.Error(w, "Invalid limit: "+value, http.StatusBadRequest)
+			return
+		}
+	}
+	page, err := user.List(filter)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == user.ErrInvalidCursor {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	out, err := json.Marshal(page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(out)
+}
+
+func getUser(w http.ResponseWriter, r *http.Request) {
+	u, err := user.Get(r.URL.Query().Get(":name"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == user.ErrUserNotFound {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	out, err := json.Marshal(u)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(out)
+}
+
+func listUserRepositories(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get(":name")
+	filter, err := repositoryFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err = user.Get(name); err != nil {
+		status := http.StatusInternalServerError
+		if err == user.ErrUserNotFound {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	filter.User = name
+	writeRepositoryPage(w, filter)
+}
+
+type jsonUser struct {
+	Name string
+	Keys map[string]string
+}
+
+func newUser(w http.ResponseWriter, r *http.Request) {
+	var usr jsonUser
+	if err := parseBody(r.Body, &usr); err != nil {
+		http.Error(w, "Got error while parsing body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	u, err := user.New(usr.Name, usr.Keys)
 	if err != nil {
+		status := http.StatusInternalServerError
+		if err == user.ErrUserAlreadyExists {
+			status = http.StatusConflict
+		}
+		if _, ok := err.(*user.InvalidUserError); ok {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	recordAudit(r, audit.Entry{Action: audit.UserCreate, Users: []string{u.Name}})
+	fmt.Fprintf(w, "User \"%s\" successfully created\n", u.Name)
+}
+
+func removeUser(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get(":name")
+	if err := user.Remove(name); err != nil {
+		status := http.StatusInternalServerError
+		if err == user.ErrUserNotFound {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	recordAudit(r, audit.Entry{Action: audit.UserRemove, Users: []string{name}})
+	fmt.Fprintf(w, "User \"%s\" successfully removed\n", name)
+}
+
+func renameUser(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get(":name")
+	var params struct{ Name string }
+	if err := parseBody(r.Body, &params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := user.Rename(name, params.Name); err != nil {
+		status := http.StatusInternalServerError
+		switch err {
+		case user.ErrUserNotFound:
+			status = http.StatusNotFound
+		case user.ErrUserAlreadyExists:
+			status = http.StatusConflict
+		}
+		if _, ok := err.(*user.InvalidUserError); ok {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	recordAudit(r, audit.Entry{
+		Action:  audit.UserRename,
+		Users:   []string{name, params.Name},
+		Details: fmt.Sprintf("%s renamed to %s", name, params.Name),
+	})
+	fmt.Fprintf(w, "User %q successfully renamed to %q\n", name, params.Name)
+}
+
+func newGroup(w http.ResponseWriter, r *http.Request) {
+	var params group.Group
+	if err := parseBody(r.Body, &params); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := repository.RevokeAccess(repositories, users, true); err != nil {
-		status := http.StatusInternalServerError
-		if err == repository.ErrRepositoryNotFound {
-			status = http.StatusNotFound
-		}
-		http.Error(w, err.Error(), status)
+	g, err := group.New(params.Name, params.Members)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == group.ErrGroupAlreadyExists {
+			status = http.StatusConflict
+		}
+		if _, ok := err.(*group.InvalidGroupError); ok {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	recordAudit(r, audit.Entry{Action: audit.GroupCreate, Users: g.Members, Details: "group " + g.Name})
+	fmt.Fprintf(w, "Group \"%s\" successfully created\n", g.Name)
+}
+
+func getGroup(w http.ResponseWriter, r *http.Request) {
+	g, err := group.Get(r.URL.Query().Get(":name"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == group.ErrGroupNotFound {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	out, err := json.Marshal(&g)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(out)
+}
+
+func listGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := group.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out, err := json.Marshal(groups)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(out)
+}
+
+func removeGroup(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get(":name")
+	if err := group.Remove(name); err != nil {
+		status := http.StatusInternalServerError
+		if err == group.ErrGroupNotFound {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	recordAudit(r, audit.Entry{Action: audit.GroupRemove, Details: "group " + name})
+	fmt.Fprintf(w, "Group \"%s\" successfully removed\n", name)
+}
+
+func addGroupMembers(w http.ResponseWriter, r *http.Request) {
+	updateGroupMembers(w, r, group.AddMembers, audit.GroupAddMembers, "added to")
+}
+
+func removeGroupMembers(w http.ResponseWriter, r *http.Request) {
+	updateGroupMembers(w, r, group.RemoveMembers, audit.GroupDelMembers, "removed from")
+}
+
+func updateGroupMembers(w http.ResponseWriter, r *http.Request, update func(string, []string) error, auditAction, action string) {
+	name := r.URL.Query().Get(":name")
+	var params group.Group
+	if err := parseBody(r.Body, &params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(params.Members) == 0 {
+		http.Error(w, "It is need a member list", http.StatusBadRequest)
+		return
+	}
+	if err := update(name, params.Members); err != nil {
+		status := http.StatusInternalServerError
+		if err == group.ErrGroupNotFound {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	recordAudit(r, audit.Entry{Action: auditAction, Users: params.Members, Details: "group " + name})
+	fmt.Fprintf(w, "Users \"%s\" successfully %s group \"%s\"\n", params.Members, action, name)
+}
+
+func newRepository(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		repository.Repository
+		Import string
+	}
+	if err := parseBody(r.Body, &body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	repo := body.Repository
+	_, err := repository.New(repo.Name, repo.Users, repo.ReadOnlyUsers, repo.IsPublic)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == repository.ErrRepositoryAlreadyExists {
+			status = http.StatusConflict
+		}
+		if _, ok := err.(*repository.InvalidRepositoryError); ok {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if body.Import != "" {
+		if _, err = repository.Import(repo.Name, body.Import); err != nil {
+			repository.Remove(repo.Name)
+			writeImportError(w, err)
+			return
+		}
+	}
+	recordAudit(r, audit.Entry{Action: audit.RepositoryCreate, Repositories: []string{repo.Name}, Users: repo.Users})
+	if body.Import != "" {
+		recordAudit(r, audit.Entry{Action: audit.RepositoryImport, Repositories: []string{repo.Name}, Details: body.Import})
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "Repository \"%s\" successfully created, importing \"%s\"\n", repo.Name, body.Import)
+		return
+	}
+	fmt.Fprintf(w, "Repository \"%s\" successfully created\n", repo.Name)
+}
+
+func importRepository(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get(":name")
+	if !authorizeRepository(w, r, name, repository.RoleWrite) {
+		return
+	}
+	var (
+		job *repository.ImportJob
+		err error
+	)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err = r.ParseMultipartForm(int64(maxMemoryValue())); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		file, _, fileErr := r.FormFile("bundle")
+		if fileErr != nil {
+			http.Error(w, fileErr.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		job, err = repository.ImportBundle(name, file)
+	} else {
+		var body struct{ Source string }
+		if err = parseBody(r.Body, &body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		job, err = repository.Import(name, body.Source)
+	}
+	if err != nil {
+		writeImportError(w, err)
+		return
+	}
+	recordAudit(r, audit.Entry{Action: audit.RepositoryImport, Repositories: []string{name}, Details: job.Source})
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "Importing \"%s\" into repository \"%s\"\n", job.Source, name)
+}
+
+func writeImportError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch err {
+	case repository.ErrRepositoryNotFound:
+		status = http.StatusNotFound
+	case repository.ErrImportInProgress, repository.ErrRepositoryNotEmpty:
+		status = http.StatusConflict
+	}
+	if _, ok := err.(*repository.InvalidRepositoryError); ok {
+		status = http.StatusBadRequest
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func getImport(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get(":name")
+	if !authorizeRepository(w, r, name, repository.RoleRead) {
+		return
+	}
+	job, err := repository.GetImport(name)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == repository.ErrImportNotFound {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	out, err := json.Marshal(job)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(out)
+}
+
+func startMaintenance(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get(":name")
+	if !authorizeRepository(w, r, name, repository.RoleMaintainer) {
+		return
+	}
+	var body struct{ Tasks []string }
+	defer r.Body.Close()
+	if r.ContentLength != 0 {
+		if err := parseBody(r.Body, &body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	run, err := repository.StartMaintenance(name, body.Tasks)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err {
+		case repository.ErrRepositoryNotFound:
+			status = http.StatusNotFound
+		case repository.ErrMaintenanceInProgress:
+			status = http.StatusConflict
+		}
+		if _, ok := err.(*repository.InvalidRepositoryError); ok {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	tasks := strings.Join(run.Tasks, ", ")
+	recordAudit(r, audit.Entry{Action: audit.MaintenanceStart, Repositories: []string{name}, Details: tasks})
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "Running %s in repository \"%s\"\n", tasks, name)
+}
+
+func getMaintenance(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get(":name")
+	if !authorizeRepository(w, r, name, repository.RoleRead) {
+		return
+	}
+	run, err := repository.GetMaintenance(name)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == repository.ErrMaintenanceNotFound {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	out, err := json.Marshal(run)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(out)
+}
+
+func forkRepository(w http.ResponseWriter, r *http.Request) {
+	source := r.URL.Query().Get(":name")
+	if !authorizeRepository(w, r, source, repository.RoleRead) {
+		return
+	}
+	var repo repository.Repository
+	if err := parseBody(r.Body, &repo); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, err := repository.Fork(source, repo.Name, repo.Users, repo.ReadOnlyUsers, repo.IsPublic)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err {
+		case repository.ErrRepositoryNotFound:
+			status = http.StatusNotFound
+		case repository.ErrRepositoryAlreadyExists:
+			status = http.StatusConflict
+		}
+		if _, ok := err.(*repository.InvalidRepositoryError); ok {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	recordAudit(r, audit.Entry{Action: audit.RepositoryFork, Repositories: []string{source, repo.Name}, Users: repo.Users})
+	fmt.Fprintf(w, "Repository \"%s\" successfully forked into \"%s\"\n", source, repo.Name)
+}
+
+func listForks(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get(":name")
+	if !authorizeRepository(w, r, name, repository.RoleRead) {
+		return
+	}
+	forks, err := repository.ListForks(name)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == repository.ErrRepositoryNotFound {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	out, err := json.Marshal(&forks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(out)
+}
+
+// repositoryFilter returns the filter of repository listings defined by the
+// query string of the request.
+func repositoryFilter(query url.Values) (repository.ListFilter, error) {
+	filter := repository.ListFilter{
+		Namespace: query.Get("namespace"),
+		User:      query.Get("user"),
+		Search:    query.Get("search"),
+		Sort:      query.Get("sort"),
+		Cursor:    query.Get("cursor"),
+	}
+	if value := query.Get("public"); value != "" {
+		public, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, errors.New("Invalid value for public, expected true or false: " + value)
+		}
+		filter.Public = &public
+	}
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit <= 0 {
+			return filter, errors.New("Invalid limit: " + value)
+		}
+	}
+	return filter, nil
+}
+
+func writeRepositoryPage(w http.ResponseWriter, filter repository.ListFilter) {
+	page, err := repository.List(filter)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if _, ok := err.(*repository.InvalidRepositoryError); ok {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	out, err := json.Marshal(page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(out)
+}
+
+func listRepositories(w http.ResponseWriter, r *http.Request) {
+	filter, err := repositoryFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeRepositoryPage(w, filter)
+}
+
+func getRepository(w http.ResponseWriter, r *http.Request) {
+	repo, err := repository.Resolve(r.URL.Query().Get(":name"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == repository.ErrRepositoryNotFound {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if !authorizeRepository(w, r, repo.Name, repository.RoleRead) {
+		return
+	}
+	if r.URL.Query().Get("refresh") == "true" {
+		if repo.DiskUsage, err = repository.UpdateDiskUsage(repo.Name); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	out, err := json.Marshal(&repo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(out)
+}
+
+func setQuota(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get(":name")
+	if r.Header.Get(userHeader) != "" {
+		http.Error(w, "Quotas can not be changed on behalf of users", http.StatusForbidden)
+		return
+	}
+	var params struct {
+		Quota int64
+	}
+	defer r.Body.Close()
+	if err := parseBody(r.Body, &params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := repository.SetQuota(name, params.Quota); err != nil {
+		status := http.StatusInternalServerError
+		if err == repository.ErrRepositoryNotFound {
+			status = http.StatusNotFound
+		}
+		if _, ok := err.(*repository.InvalidRepositoryError); ok {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	recordAudit(r, audit.Entry{Action: audit.RepositoryQuota, Repositories: []string{name}, Details: fmt.Sprintf("%d bytes", params.Quota)})
+	if params.Quota == 0 {
+		fmt.Fprintf(w, "Quota of repository %q successfully removed\n", name)
+		return
+	}
+	fmt.Fprintf(w, "Quota of repository %q successfully set to %s\n", name, repository.FormatSize(params.Quota))
+}
+
+func getDiskUsage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := 10
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit: "+value, http.StatusBadRequest)
+			return
+		}
+	}
+	if query.Get("refresh") == "true" {
+		if err := repository.RefreshDiskUsage(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	total, err := repository.TotalDiskUsage()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	repos, err := repository.Largest(limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out, err := json.Marshal(map[string]interface{}{
+		"total":        total,
+		"total_quota":  repository.TotalQuota(),
+		"repositories": repos,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(out)
+}
+
+func removeRepository(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get(":name")
+	if !authorizeRepository(w, r, name, repository.RoleAdmin) {
+		return
+	}
+	if err := repository.Delete(name); err != nil {
+		status := http.StatusBadRequest
+		if err == repository.ErrRepositoryNotFound {
+			status = http.StatusNotFound
+		}
+		if err == repository.ErrMaintenanceInProgress {
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	recordAudit(r, audit.Entry{Action: audit.RepositoryRemove, Repositories: []string{name}})
+	fmt.Fprintf(w, "Repository \"%s\" successfully removed\n", name)
+}
+
+func restoreRepository(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get(":name")
+	if userName := r.Header.Get(userHeader); userName != "" {
+		deleted, err := repository.GetDeleted(name)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if err == repository.ErrDeletedRepositoryNotFound {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if !deleted.Repository.HasRole(userName, repository.RoleAdmin) {
+			http.Error(w, fmt.Sprintf("User %q does not have the %s role in repository %q", userName, repository.RoleAdmin, name), http.StatusForbidden)
+			return
+		}
+	}
+	if err := repository.Restore(name); err != nil {
+		status := http.StatusInternalServerError
+		switch err {
+		case repository.ErrDeletedRepositoryNotFound:
+			status = http.StatusNotFound
+		case repository.ErrRepositoryAlreadyExists:
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	recordAudit(r, audit.Entry{Action: audit.RepositoryRestore, Repositories: []string{name}})
+	fmt.Fprintf(w, "Repository %q successfully restored\n", name)
+}
+
+func updateRepository(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get(":name")
+	if !authorizeRepository(w, r, name, repository.RoleAdmin) {
+		return
+	}
+	repo, err := repository.Get(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	// only these fields may be changed, the others have their own endpoints
+	body := struct {
+		Name          string
+		Users         []string
+		ReadOnlyUsers []string
+		IsPublic      bool
+	}{repo.Name, repo.Users, repo.ReadOnlyUsers, repo.IsPublic}
+	defer r.Body.Close()
+	err = parseBody(r.Body, &body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	repo.Name, repo.Users, repo.ReadOnlyUsers, repo.IsPublic = body.Name, body.Users, body.ReadOnlyUsers, body.IsPublic
+	err = repository.Update(name, repo)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == repository.ErrRepositoryNotFound {
+			status = http.StatusNotFound
+		}
+		if mgo.IsDup(err) || err == repository.ErrMaintenanceInProgress {
+			status = http.StatusConflict
+		}
+		if _, ok := err.(*repository.InvalidRepositoryError); ok {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+	} else {
+		repositories := []string{name}
+		if repo.Name != name {
+			repositories = append(repositories, repo.Name)
+		}
+		recordAudit(r, audit.Entry{Action: audit.RepositoryUpdate, Repositories: repositories})
+	}
+}
+
+func getProtections(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get(":name")
+	if !authorizeRepository(w, r, name, repository.RoleRead) {
+		return
+	}
+	repo, err := repository.Get(name)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == repository.ErrRepositoryNotFound {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	protections := repo.Protections
+	if protections == nil {
+		protections = []repository.Protection{}
+	}
+	out, err := json.Marshal(protections)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(out)
+}
+
+func addProtection(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get(":name")
+	if !authorizeRepository(w, r, name, repository.RoleAdmin) {
+		return
+	}
+	var p repository.Protection
+	defer r.Body.Close()
+	if err := parseBody(r.Body, &p); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := repository.AddProtection(name, p); err != nil {
+		status := http.StatusInternalServerError
+		switch err {
+		case repository.ErrRepositoryNotFound:
+			status = http.StatusNotFound
+		case repository.ErrProtectionAlreadyExists:
+			status = http.StatusConflict
+		}
+		if _, ok := err.(*repository.InvalidRepositoryError); ok {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	recordAudit(r, audit.Entry{Action: audit.ProtectionAdd, Repositories: []string{name}, Users: p.Users, Details: "pattern " + p.Pattern})
+	fmt.Fprintf(w, "Branches matching %q are now protected in repository %q\n", p.Pattern, name)
+}
+
+func removeProtection(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get(":name")
+	if !authorizeRepository(w, r, name, repository.RoleAdmin) {
+		return
+	}
+	pattern := r.URL.Query().Get("pattern")
+	if pattern == "" {
+		http.Error(w, "You must provide the pattern of the protection", http.StatusBadRequest)
+		return
+	}
+	if err := repository.RemoveProtection(name, pattern); err != nil {
+		status := http.StatusInternalServerError
+		if err == repository.ErrRepositoryNotFound || err == repository.ErrProtectionNotFound {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	recordAudit(r, audit.Entry{Action: audit.ProtectionRemove, Repositories: []string{name}, Details: "pattern " + pattern})
+	fmt.Fprintf(w, "Protection %q successfully removed from repository %q\n", pattern, name)
+}
+
+func getMembers(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get(":name")
+	if !authorizeRepository(w, r, name, repository.RoleRead) {
+		return
+	}
+	repo, err := repository.Get(name)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == repository.ErrRepositoryNotFound {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	out, err := json.Marshal(repo.Members())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(out)
+}
+
+func setMember(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get(":name")
+	if !authorizeRepository(w, r, name, repository.RoleAdmin) {
+		return
+	}
+	var m repository.Member
+	defer r.Body.Close()
+	if err := parseBody(r.Body, &m); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if m.User == "" {
+		http.Error(w, "You must provide the user", http.StatusBadRequest)
+		return
+	}
+	if err := repository.SetMember(name, m.User, m.Role); err != nil {
+		status := http.StatusInternalServerError
+		if err == repository.ErrRepositoryNotFound {
+			status = http.StatusNotFound
+		}
+		if _, ok := err.(*repository.InvalidRepositoryError); ok {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	recordAudit(r, audit.Entry{Action: audit.MemberSet, Repositories: []string{name}, Users: []string{m.User}, Details: "role " + m.Role})
+	fmt.Fprintf(w, "User %q is now %s of repository %q\n", m.User, m.Role, name)
+}
+
+func removeMember(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get(":name")
+	userName := r.URL.Query().Get("user")
+	if userName == "" {
+		http.Error(w, "You must provide the user", http.StatusBadRequest)
+		return
+	}
+	if !authorizeRepository(w, r, name, repository.RoleAdmin) {
+		return
+	}
+	if err := repository.RemoveMember(name, userName); err != nil {
+		status := http.StatusInternalServerError
+		if err == repository.ErrRepositoryNotFound || err == repository.ErrMemberNotFound {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	recordAudit(r, audit.Entry{Action: audit.MemberRemove, Repositories: []string{name}, Users: []string{userName}})
+	fmt.Fprintf(w, "User %q successfully removed from repository %q\n", userName, name)
+}
+
+type repositoryHook struct {
+	Repositories []string
+	Script       string
+	Content      string
+}
+
+// addHook, removeHook and applyTemplateHooks are refused on behalf of users,
+// as hook scripts run on the server with access to every repository.
+func addHook(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get(userHeader) != "" {
+		http.Error(w, "Hooks can not be managed on behalf of users", http.StatusForbidden)
+		return
+	}
+	name := r.URL.Query().Get(":name")
+	if !hook.Supported(name) {
+		http.Error(w, hook.ErrUnsupportedHook.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	var params repositoryHook
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	repos := []string{}
+	content := body
+	script := r.URL.Query().Get("script")
+	if err := json.Unmarshal(body, &params); err == nil {
+		repos = params.Repositories
+		content = []byte(params.Content)
+		if params.Script != "" {
+			script = params.Script
+		}
+	}
+	if err := hook.Add(name, script, repos, content, requestActor(r)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	recordAudit(r, audit.Entry{Action: audit.HookAdd, Repositories: repos, Details: hookDetails(name, script)})
+	if script != "" {
+		name = name + " script " + script
+	}
+	if len(repos) > 0 {
+		fmt.Fprint(w, "hook ", name, " successfully created for ", repos, "\n")
+	} else {
+		fmt.Fprint(w, "hook ", name, " successfully created\n")
+	}
+}
+
+// hookDetails describes the script of a hook in the audit log.
+func hookDetails(name, script string) string {
+	if script == "" {
+		return "hook " + name
+	}
+	return "hook " + name + " script " + script
+}
+
+func listHooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := hook.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out, err := json.Marshal(hooks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(out)
+}
+
+func listRepositoryHooks(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get(":name")
+	if !authorizeRepository(w, r, name, repository.RoleRead) {
+		return
+	}
+	if _, err := repository.Get(name); err != nil {
+		status := http.StatusInternalServerError
+		if err == repository.ErrRepositoryNotFound {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	hooks, err := hook.ListRepository(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out, err := json.Marshal(hooks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(out)
+}
+
+func getHook(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get(":name")
+	if !hook.Supported(name) {
+		http.Error(w, hook.ErrUnsupportedHook.Error(), http.StatusBadRequest)
+		return
+	}
+	h, err := hook.Get(name, r.URL.Query().Get("script"), r.URL.Query().Get("repository"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err {
+		case hook.ErrHookNotFound:
+			status = http.StatusNotFound
+		case hook.ErrInvalidScript, hook.ErrNotChainable:
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	out, err := json.Marshal(h)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(out)
+}
+
+func removeHook(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get(userHeader) != "" {
+		http.Error(w, "Hooks can not be managed on behalf of users", http.StatusForbidden)
+		return
+	}
+	name := r.URL.Query().Get(":name")
+	if !hook.Supported(name) {
+		http.Error(w, hook.ErrUnsupportedHook.Error(), http.StatusBadRequest)
+		return
+	}
+	repos := r.URL.Query()["repository"]
+	script := r.URL.Query().Get("script")
+	if err := hook.Remove(name, script, repos); err != nil {
+		status := http.StatusInternalServerError
+		switch err {
+		case hook.ErrHookNotFound:
+			status = http.StatusNotFound
+		case hook.ErrInvalidScript, hook.ErrNotChainable:
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	recordAudit(r, audit.Entry{Action: audit.HookRemove, Repositories: repos, Details: hookDetails(name, script)})
+	if script != "" {
+		name = name + " script " + script
+	}
+	if len(repos) > 0 {
+		fmt.Fprintf(w, "hook %s successfully removed from %s\n", name, repos)
+	} else {
+		fmt.Fprintf(w, "hook %s successfully removed\n", name)
+	}
+}
+
+func applyTemplateHooks(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get(userHeader) != "" {
+		http.Error(w, "Hooks can not be managed on behalf of users", http.StatusForbidden)
+		return
+	}
+	var params struct {
+		Repositories []string `json:"repositories"`
+	}
+	defer r.Body.Close()
+	if err := parseBody(r.Body, &params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hooks, err := hook.ApplyTemplate(params.Repositories, requestActor(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out, err := json.Marshal(hooks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, audit.Entry{Action: audit.HookApply, Repositories: params.Repositories, Details: fmt.Sprintf("%d hooks installed", len(hooks))})
+	w.Write(out)
+}
+
+type jsonWebhook struct {
+	Repository string   `json:"repository"`
+	URL        string   `json:"url"`
+	Secret     string   `json:"secret"`
+	Events     []string `json:"events"`
+}
+
+func writeWebhookError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch err {
+	case webhook.ErrWebhookNotFound, repository.ErrRepositoryNotFound:
+		status = http.StatusNotFound
+	}
+	if _, ok := err.(*webhook.InvalidWebhookError); ok {
+		status = http.StatusBadRequest
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func newWebhook(w http.ResponseWriter, r *http.Request) {
+	var params jsonWebhook
+	if err := parseBody(r.Body, &params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !authorizeRepositories(w, r, repositoryList(params.Repository)) {
+		return
+	}
+	h := webhook.Webhook{Repository: params.Repository, URL: params.URL, Secret: params.Secret, Events: params.Events}
+	if err := webhook.Create(&h); err != nil {
+		writeWebhookError(w, err)
 		return
 	}
-	if err := repository.RevokeAccess(repositories, users, false); err != nil {
+	out, err := json.Marshal(&h)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	fmt.Fprintf(w, "Successfully revoked access to users \"%s\" into repositories \"%s\"", users, repositories)
+	recordAudit(r, audit.Entry{Action: audit.WebhookAdd, Repositories: repositoryList(h.Repository), Details: fmt.Sprintf("webhook %s to %s", h.ID.Hex(), h.URL)})
+	w.WriteHeader(http.StatusCreated)
+	w.Write(out)
 }
 
-func addKey(w http.ResponseWriter, r *http.Request) {
-	keys := map[string]string{}
-	if err := parseBody(r.Body, &keys); err != nil {
+func listWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := webhook.List(r.URL.Query().Get("repository"))
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	if len(keys) == 0 {
-		http.Error(w, "A key is needed", http.StatusBadRequest)
-		return
-	}
-	uName := r.URL.Query().Get(":name")
-	if err := user.AddKey(uName, keys); err != nil {
-		switch err {
-		case user.ErrInvalidKey:
-			http.Error(w, err.Error(), http.StatusBadRequest)
-		case user.ErrDuplicateKey:
-			http.Error(w, "Key already exists.", http.StatusConflict)
-		case user.ErrUserNotFound:
-			http.Error(w, err.Error(), http.StatusNotFound)
-		default:
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+	out, err := json.Marshal(hooks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	fmt.Fprint(w, "Key(s) successfully created")
+	w.Write(out)
 }
 
-func updateKey(w http.ResponseWriter, r *http.Request) {
-	uName := r.URL.Query().Get(":name")
-	kName := r.URL.Query().Get(":keyname")
-	defer r.Body.Close()
-	content, err := ioutil.ReadAll(r.Body)
+func getWebhook(w http.ResponseWriter, r *http.Request) {
+	h, err := webhook.Get(r.URL.Query().Get(":id"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeWebhookError(w, err)
 		return
 	}
-	key := user.Key{Name: kName, Body: string(content)}
-	if err := user.UpdateKey(uName, key); err != nil {
-		switch err {
-		case user.ErrInvalidKey:
-			http.Error(w, err.Error(), http.StatusBadRequest)
-		case user.ErrUserNotFound, user.ErrKeyNotFound:
-			http.Error(w, err.Error(), http.StatusNotFound)
-		default:
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+	out, err := json.Marshal(h)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	fmt.Fprintf(w, "Key %q successfully updated!", kName)
+	w.Write(out)
 }
 
-func removeKey(w http.ResponseWriter, r *http.Request) {
-	uName := r.URL.Query().Get(":name")
-	kName := r.URL.Query().Get(":keyname")
-	if err := user.RemoveKey(uName, kName); err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+func removeWebhook(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get(":id")
+	h, err := webhook.Get(id)
+	if err != nil {
+		writeWebhookError(w, err)
 		return
 	}
-	fmt.Fprintf(w, "Key \"%s\" successfully removed", kName)
+	if !authorizeRepositories(w, r, repositoryList(h.Repository)) {
+		return
+	}
+	if err = webhook.Remove(id); err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+	recordAudit(r, audit.Entry{Action: audit.WebhookRemove, Repositories: repositoryList(h.Repository), Details: fmt.Sprintf("webhook %s to %s", id, h.URL)})
+	fmt.Fprintf(w, "Webhook %q successfully removed\n", id)
 }
 
-func listKeys(w http.ResponseWriter, r *http.Request) {
-	uName := r.URL.Query().Get(":name")
-	keys, err := user.ListKeys(uName)
+func listDeliveries(w http.ResponseWriter, r *http.Request) {
+	var limit int
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit: "+value, http.StatusBadRequest)
+			return
+		}
+	}
+	deliveries, err := webhook.ListDeliveries(r.URL.Query().Get(":id"), limit)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeWebhookError(w, err)
 		return
 	}
-	out, err := json.Marshal(&keys)
+	out, err := json.Marshal(deliveries)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -197,77 +1500,107 @@ func listKeys(w http.ResponseWriter, r *http.Request) {
 	w.Write(out)
 }
 
-type jsonUser struct {
-	Name string
-	Keys map[string]string
+type jsonToken struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	User   string   `json:"user,omitempty"`
+	Token  string   `json:"token,omitempty"`
 }
 
-func newUser(w http.ResponseWriter, r *http.Request) {
-	var usr jsonUser
-	if err := parseBody(r.Body, &usr); err != nil {
-		http.Error(w, "Got error while parsing body: "+err.Error(), http.StatusBadRequest)
+func newToken(w http.ResponseWriter, r *http.Request) {
+	var params jsonToken
+	if err := parseBody(r.Body, &params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	u, err := user.New(usr.Name, usr.Keys)
+	if params.User != "" {
+		if _, err := user.Get(params.User); err != nil {
+			status := http.StatusInternalServerError
+			if err == user.ErrUserNotFound {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+	}
+	t, raw, err := auth.NewUserToken(params.Name, params.User, params.Scopes)
 	if err != nil {
 		status := http.StatusInternalServerError
-		if err == user.ErrUserAlreadyExists {
+		if err == auth.ErrTokenAlreadyExists {
 			status = http.StatusConflict
 		}
-		if _, ok := err.(*user.InvalidUserError); ok {
+		if _, ok := err.(*auth.InvalidTokenError); ok {
 			status = http.StatusBadRequest
 		}
 		http.Error(w, err.Error(), status)
 		return
 	}
-	fmt.Fprintf(w, "User \"%s\" successfully created\n", u.Name)
+	out, err := json.Marshal(jsonToken{Name: t.Name, Scopes: t.Scopes, User: t.User, Token: raw})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, audit.Entry{Action: audit.TokenCreate, Details: fmt.Sprintf("token %s with scopes %s", t.Name, t.Scopes)})
+	w.WriteHeader(http.StatusCreated)
+	w.Write(out)
 }
 
-func removeUser(w http.ResponseWriter, r *http.Request) {
+func listTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := auth.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out, err := json.Marshal(tokens)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(out)
+}
+
+func revokeToken(w http.ResponseWriter, r *http.Request) {
 	name := r.URL.Query().Get(":name")
-	if err := user.Remove(name); err != nil {
+	if err := auth.Revoke(name); err != nil {
 		status := http.StatusInternalServerError
-		if err == user.ErrUserNotFound {
+		if err == auth.ErrTokenNotFound {
 			status = http.StatusNotFound
 		}
 		http.Error(w, err.Error(), status)
 		return
 	}
-	fmt.Fprintf(w, "User \"%s\" successfully removed\n", name)
+	recordAudit(r, audit.Entry{Action: audit.TokenRevoke, Details: "token " + name})
+	fmt.Fprintf(w, "Token %q successfully revoked\n", name)
 }
 
-func newRepository(w http.ResponseWriter, r *http.Request) {
-	var repo repository.Repository
-	if err := parseBody(r.Body, &repo); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+func listAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Repository: query.Get("repository"),
+		User:       query.Get("user"),
+		Action:     query.Get("action"),
 	}
-	_, err := repository.New(repo.Name, repo.Users, repo.ReadOnlyUsers, repo.IsPublic)
-	if err != nil {
-		status := http.StatusInternalServerError
-		if err == repository.ErrRepositoryAlreadyExists {
-			status = http.StatusConflict
+	var err error
+	for param, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(param); value != "" {
+			if *t, err = time.Parse(time.RFC3339, value); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid value for %s, expected a RFC 3339 date: %s", param, value), http.StatusBadRequest)
+				return
+			}
 		}
-		if _, ok := err.(*repository.InvalidRepositoryError); ok {
-			status = http.StatusBadRequest
+	}
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit <= 0 {
+			http.Error(w, "Invalid limit: "+value, http.StatusBadRequest)
+			return
 		}
-		http.Error(w, err.Error(), status)
-		return
 	}
-	fmt.Fprintf(w, "Repository \"%s\" successfully created\n", repo.Name)
-}
-
-func getRepository(w http.ResponseWriter, r *http.Request) {
-	repo, err := repository.Get(r.URL.Query().Get(":name"))
+	entries, err := audit.List(filter)
 	if err != nil {
-		status := http.StatusInternalServerError
-		if err == repository.ErrRepositoryNotFound {
-			status = http.StatusNotFound
-		}
-		http.Error(w, err.Error(), status)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	out, err := json.Marshal(&repo)
+	out, err := json.Marshal(entries)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -275,78 +1608,58 @@ func getRepository(w http.ResponseWriter, r *http.Request) {
 	w.Write(out)
 }
 
-func removeRepository(w http.ResponseWriter, r *http.Request) {
-	name := r.URL.Query().Get(":name")
-	if err := repository.Remove(name); err != nil {
-		status := http.StatusBadRequest
-		if err == repository.ErrRepositoryNotFound {
-			status = http.StatusNotFound
-		}
-		http.Error(w, err.Error(), status)
+func syncKeys(w http.ResponseWriter, r *http.Request) {
+	report, err := user.SyncAuthorizedKeys()
+	if err == user.ErrAuthorizedKeysDisabled {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	fmt.Fprintf(w, "Repository \"%s\" successfully removed\n", name)
-}
-
-func updateRepository(w http.ResponseWriter, r *http.Request) {
-	name := r.URL.Query().Get(":name")
-	repo, err := repository.Get(name)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer r.Body.Close()
-	err = parseBody(r.Body, &repo)
+	recordAudit(r, audit.Entry{
+		Action:  audit.KeySync,
+		Details: fmt.Sprintf("%d added, %d removed", len(report.Added), len(report.Removed)),
+	})
+	out, err := json.Marshal(report)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	err = repository.Update(name, repo)
-	if err != nil && err == repository.ErrRepositoryNotFound {
-		http.Error(w, err.Error(), http.StatusNotFound)
-	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	w.Write(out)
 }
 
-type repositoryHook struct {
-	Repositories []string
-	Content      string
+func checkConsistency(w http.ResponseWriter, r *http.Request) {
+	report, err := consistency.Check()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeConsistencyReport(w, report)
 }
 
-func addHook(w http.ResponseWriter, r *http.Request) {
-	name := r.URL.Query().Get(":name")
-	if name != "post-receive" && name != "pre-receive" && name != "update" {
-		http.Error(w,
-			"Unsupported hook, valid options are: post-receive, pre-receive or update",
-			http.StatusBadRequest)
+func fixConsistency(w http.ResponseWriter, r *http.Request) {
+	report, err := consistency.Fix()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer r.Body.Close()
-	var params repositoryHook
-	body, err := ioutil.ReadAll(r.Body)
+	recordAudit(r, audit.Entry{
+		Action:       audit.ConsistencyFix,
+		Repositories: append(append([]string{}, report.MissingBare...), report.OrphanBare...),
+		Details:      fmt.Sprintf("%d problems fixed", report.Problems()),
+	})
+	writeConsistencyReport(w, report)
+}
+
+func writeConsistencyReport(w http.ResponseWriter, report *consistency.Report) {
+	out, err := json.Marshal(report)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	repos := []string{}
-	if err := json.Unmarshal(body, &params); err != nil {
-		if err := hook.Add(name, repos, body); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-	} else {
-		repos = params.Repositories
-		if err := hook.Add(name, repos, []byte(params.Content)); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-	}
-	if len(repos) > 0 {
-		fmt.Fprint(w, "hook ", name, " successfully created for ", repos, "\n")
-	} else {
-		fmt.Fprint(w, "hook ", name, " successfully created\n")
-	}
+	w.Write(out)
 }
 
 func parseBody(body io.ReadCloser, result interface{}) error {
@@ -388,6 +1701,9 @@ func getMimeType(path string, content []byte) string {
 
 func getFileContents(w http.ResponseWriter, r *http.Request) {
 	repo := r.URL.Query().Get(":name")
+	if !authorizeRepository(w, r, repo, repository.RoleRead) {
+		return
+	}
 	path := r.URL.Query().Get("path")
 	ref := r.URL.Query().Get("ref")
 	if ref == "" {
@@ -410,6 +1726,9 @@ func getFileContents(w http.ResponseWriter, r *http.Request) {
 
 func getArchive(w http.ResponseWriter, r *http.Request) {
 	repo := r.URL.Query().Get(":name")
+	if !authorizeRepository(w, r, repo, repository.RoleRead) {
+		return
+	}
 	ref := r.URL.Query().Get("ref")
 	format := r.URL.Query().Get("format")
 	if ref == "" || format == "" {
@@ -444,8 +1763,93 @@ func getArchive(w http.ResponseWriter, r *http.Request) {
 	w.Write(contents)
 }
 
+func getBundle(w http.ResponseWriter, r *http.Request) {
+	repo := r.URL.Query().Get(":name")
+	if !authorizeRepository(w, r, repo, repository.RoleRead) {
+		return
+	}
+	refs := r.URL.Query()["ref"]
+	bundle, err := repository.GetBundle(repo, refs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer bundle.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.bundle\"", repo))
+	w.Header().Set("Content-Transfer-Encoding", "binary")
+	w.Header().Set("Cache-Control", "private")
+	w.Header().Set("Pragma", "private")
+	w.Header().Set("Expires", "Mon, 26 Jul 1997 05:00:00 GMT")
+	if _, err = io.Copy(w, bundle); err != nil {
+		log.Errorf("Could not send bundle of repository %q: %s", repo, err)
+		return
+	}
+	recordAudit(r, audit.Entry{Action: audit.GitFetch, Repositories: []string{repo}, Details: "bundle"})
+}
+
+func restoreBundle(w http.ResponseWriter, r *http.Request) {
+	repo := r.URL.Query().Get(":name")
+	if !authorizeRepository(w, r, repo, repository.RoleWrite) {
+		return
+	}
+	if _, err := repository.Get(repo); err != nil {
+		status := http.StatusInternalServerError
+		if err == repository.ErrRepositoryNotFound {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if err := r.ParseMultipartForm(int64(maxMemoryValue())); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	file, _, err := r.FormFile("bundle")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	userName := r.Header.Get(userHeader)
+	updates, err := repository.RestoreBundle(repo, userName, file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	refs := make([]string, len(updates))
+	var changed []webhook.RefUpdate
+	for i, u := range updates {
+		refs[i] = u.Ref
+		if u.Before != u.After {
+			changed = append(changed, webhook.RefUpdate{Ref: u.Ref, Before: u.Before, After: u.After})
+		}
+	}
+	recordAudit(r, audit.Entry{
+		Action:       audit.GitPush,
+		Repositories: []string{repo},
+		Details:      fmt.Sprintf("bundle restoring %s", strings.Join(refs, ", ")),
+	})
+	// bundles are restored without the built-in hooks, so the work of the
+	// post-receive hook is done here, as for commits.
+	pusher := userName
+	if pusher == "" {
+		pusher = requestActor(r)
+	}
+	if err = webhook.Notify(repo, pusher, changed); err != nil {
+		log.Errorf("Failed to queue webhook deliveries for repository %q: %s", repo, err)
+	}
+	if _, err = repository.UpdateDiskUsage(repo); err != nil {
+		log.Errorf("Failed to update the disk usage of repository %q: %s", repo, err)
+	}
+	fmt.Fprintf(w, "Refs \"%s\" successfully restored into repository \"%s\"\n", refs, repo)
+}
+
 func getTree(w http.ResponseWriter, r *http.Request) {
 	repo := r.URL.Query().Get(":name")
+	if !authorizeRepository(w, r, repo, repository.RoleRead) {
+		return
+	}
 	path := r.URL.Query().Get("path")
 	ref := r.URL.Query().Get("ref")
 	if ref == "" {
@@ -471,6 +1875,9 @@ func getTree(w http.ResponseWriter, r *http.Request) {
 
 func getBranches(w http.ResponseWriter, r *http.Request) {
 	repo := r.URL.Query().Get(":name")
+	if !authorizeRepository(w, r, repo, repository.RoleRead) {
+		return
+	}
 	branches, err := repository.GetBranches(repo)
 	if err != nil {
 		err = fmt.Errorf("Error when trying to obtain the branches of repository %s (%s).", repo, err)
@@ -488,6 +1895,9 @@ func getBranches(w http.ResponseWriter, r *http.Request) {
 
 func getTags(w http.ResponseWriter, r *http.Request) {
 	repo := r.URL.Query().Get(":name")
+	if !authorizeRepository(w, r, repo, repository.RoleRead) {
+		return
+	}
 	ref := r.URL.Query().Get("ref")
 	tags, err := repository.GetTags(repo)
 	if err != nil {
@@ -506,6 +1916,9 @@ func getTags(w http.ResponseWriter, r *http.Request) {
 
 func getDiff(w http.ResponseWriter, r *http.Request) {
 	repo := r.URL.Query().Get(":name")
+	if !authorizeRepository(w, r, repo, repository.RoleRead) {
+		return
+	}
 	previousCommit := r.URL.Query().Get("previous_commit")
 	lastCommit := r.URL.Query().Get("last_commit")
 	if previousCommit == "" || lastCommit == "" {
@@ -524,6 +1937,9 @@ func getDiff(w http.ResponseWriter, r *http.Request) {
 
 func commit(w http.ResponseWriter, r *http.Request) {
 	repo := r.URL.Query().Get(":name")
+	if !authorizeRepository(w, r, repo, repository.RoleWrite) {
+		return
+	}
 	err := r.ParseMultipartForm(int64(maxMemoryValue()))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -557,11 +1973,38 @@ func commit(w http.ResponseWriter, r *http.Request) {
 			Email: data["committer-email"],
 		},
 	}
-	ref, err := repository.CommitZip(repo, r.MultipartForm.File["zipfile"][0], commit)
+	userName := r.Header.Get(userHeader)
+	before := strings.Repeat("0", 40)
+	if branches, err := repository.GetBranches(repo); err == nil {
+		for _, branch := range branches {
+			if branch.Name == commit.Branch {
+				before = branch.Ref
+			}
+		}
+	}
+	ref, err := repository.CommitZip(repo, userName, r.MultipartForm.File["zipfile"][0], commit)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	recordAudit(r, audit.Entry{
+		Action:       audit.GitPush,
+		Repositories: []string{repo},
+		Details:      fmt.Sprintf("commit %s to refs/heads/%s", ref.Ref, commit.Branch),
+	})
+	// commits are pushed without the built-in hooks, so the work of the
+	// post-receive hook is done here.
+	pusher := userName
+	if pusher == "" {
+		pusher = requestActor(r)
+	}
+	update := webhook.RefUpdate{Ref: "refs/heads/" + commit.Branch, Before: before, After: ref.Ref}
+	if err = webhook.Notify(repo, pusher, []webhook.RefUpdate{update}); err != nil {
+		log.Errorf("Failed to queue webhook deliveries for repository %q: %s", repo, err)
+	}
+	if _, err = repository.UpdateDiskUsage(repo); err != nil {
+		log.Errorf("Failed to update the disk usage of repository %q: %s", repo, err)
+	}
 	b, err := json.Marshal(ref)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -572,6 +2015,9 @@ func commit(w http.ResponseWriter, r *http.Request) {
 
 func getLogs(w http.ResponseWriter, r *http.Request) {
 	repo := r.URL.Query().Get(":name")
+	if !authorizeRepository(w, r, repo, repository.RoleRead) {
+		return
+	}
 	ref := r.URL.Query().Get("ref")
 	path := r.URL.Query().Get("path")
 	total, err := strconv.Atoi(r.URL.Query().Get("total"))
@@ -11,20 +11,30 @@ import (
 	"io"
 	"io/ioutil"
 	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path"
+	"reflect"
 	"strings"
+	"time"
 
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
+	"github.com/gorilla/mux"
 	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/audit"
+	"github.com/tsuru/gandalf/auth"
+	"github.com/tsuru/gandalf/consistency"
 	"github.com/tsuru/gandalf/db"
 	"github.com/tsuru/gandalf/fs"
+	"github.com/tsuru/gandalf/group"
+	"github.com/tsuru/gandalf/hook"
 	"github.com/tsuru/gandalf/multipartzip"
 	"github.com/tsuru/gandalf/repository"
 	"github.com/tsuru/gandalf/user"
+	"github.com/tsuru/gandalf/webhook"
 	"gopkg.in/check.v1"
 )
 
@@ -95,31 +105,31 @@ func (s *S) TestMaxMemoryValueDontResetMaxMemory(c *check.C) {
 
 func (s *S) TestAccessParametersShouldReturnErrorWhenInvalidJSONInput(c *check.C) {
 	b := bufferCloser{bytes.NewBufferString(``)}
-	_, _, err := accessParameters(b)
+	_, _, _, err := accessParameters(b)
 	c.Assert(err, check.ErrorMatches, `^Could not parse json: .+$`)
 	b = bufferCloser{bytes.NewBufferString(`{`)}
-	_, _, err = accessParameters(b)
+	_, _, _, err = accessParameters(b)
 	c.Assert(err, check.ErrorMatches, `^Could not parse json: .+$`)
 	b = bufferCloser{bytes.NewBufferString(`bang`)}
-	_, _, err = accessParameters(b)
+	_, _, _, err = accessParameters(b)
 	c.Assert(err, check.ErrorMatches, `^Could not parse json: .+$`)
 	b = bufferCloser{bytes.NewBufferString(` `)}
-	_, _, err = accessParameters(b)
+	_, _, _, err = accessParameters(b)
 	c.Assert(err, check.ErrorMatches, `^Could not parse json: .+$`)
 }
 
 func (s *S) TestAccessParametersShouldReturnErrorWhenNoUserListProvided(c *check.C) {
 	b := bufferCloser{bytes.NewBufferString(`{"users": "oneuser"}`)}
-	_, _, err := accessParameters(b)
+	_, _, _, err := accessParameters(b)
 	c.Assert(err, check.ErrorMatches, `^Could not parse json: json: cannot unmarshal string into Go value of type \[\]string$`)
 	b = bufferCloser{bytes.NewBufferString(`{"repositories": ["barad-dur"]}`)}
-	_, _, err = accessParameters(b)
+	_, _, _, err = accessParameters(b)
 	c.Assert(err, check.ErrorMatches, `^It is need a user list$`)
 }
 
 func (s *S) TestAccessParametersShouldReturnErrorWhenNoRepositoryListProvided(c *check.C) {
 	b := bufferCloser{bytes.NewBufferString(`{"users": ["nazgul"]}`)}
-	_, _, err := accessParameters(b)
+	_, _, _, err := accessParameters(b)
 	c.Assert(err, check.ErrorMatches, `^It is need a repository list$`)
 }
 
@@ -218,10 +228,11 @@ func (s *S) TestGetRepository(c *check.C) {
 	err = json.Unmarshal(body, &data)
 	c.Assert(err, check.IsNil)
 	expected := map[string]interface{}{
-		"name":    r.Name,
-		"public":  r.IsPublic,
-		"ssh_url": r.ReadWriteURL(),
-		"git_url": r.ReadOnlyURL(),
+		"name":       r.Name,
+		"public":     r.IsPublic,
+		"ssh_url":    r.ReadWriteURL(),
+		"git_url":    r.ReadOnlyURL(),
+		"disk_usage": float64(0),
 	}
 	c.Assert(data, check.DeepEquals, expected)
 }
@@ -242,14 +253,44 @@ func (s *S) TestGetRepositoryWithNamespace(c *check.C) {
 	err = json.Unmarshal(body, &data)
 	c.Assert(err, check.IsNil)
 	expected := map[string]interface{}{
-		"name":    r.Name,
-		"public":  r.IsPublic,
-		"ssh_url": r.ReadWriteURL(),
-		"git_url": r.ReadOnlyURL(),
+		"name":       r.Name,
+		"public":     r.IsPublic,
+		"ssh_url":    r.ReadWriteURL(),
+		"git_url":    r.ReadOnlyURL(),
+		"disk_usage": float64(0),
 	}
 	c.Assert(data, check.DeepEquals, expected)
 }
 
+func (s *S) TestGetRepositoryReturnsRecordedDiskUsage(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "onerepo", DiskUsage: 1024})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("onerepo")
+	recorder, request := get("/repository/onerepo", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var data map[string]interface{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &data)
+	c.Assert(err, check.IsNil)
+	c.Assert(data["disk_usage"], check.Equals, float64(1024))
+}
+
+func (s *S) TestGetRepositoryRefreshDiskUsageWithoutBare(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "onerepo", DiskUsage: 1024})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("onerepo")
+	recorder, request := get("/repository/onerepo?refresh=true", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusInternalServerError)
+	c.Assert(recorder.Body.String(), check.Equals, "repository not found\n")
+}
+
 func (s *S) TestGetRepositoryDoesNotExist(c *check.C) {
 	recorder, request := get("/repository/doesnotexist", nil, c)
 	s.router.ServeHTTP(recorder, request)
@@ -523,6 +564,162 @@ func (s *S) TestRevokeAccessRepositoryNotFound(c *check.C) {
 	c.Assert(rec.Code, check.Equals, http.StatusNotFound)
 }
 
+func (s *S) TestGrantAccessToGroups(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	r := repository.Repository{Name: "onerepo"}
+	err = conn.Repository().Insert(&r)
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId(r.Name)
+	b := bytes.NewBufferString(`{"repositories": ["onerepo"], "groups": ["devs"]}`)
+	rec, req := post("/repository/grant?readonly=yes", b, c)
+	s.router.ServeHTTP(rec, req)
+	c.Assert(rec.Code, check.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), check.Equals, `Successfully granted read-only access to groups "[devs]" into repository "[onerepo]"`)
+	repo, err := repository.Get(r.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(repo.ReadOnlyGroups, check.DeepEquals, []string{"devs"})
+	c.Assert(repo.ReadOnlyUsers, check.HasLen, 0)
+}
+
+func (s *S) TestRevokeAccessFromUsersAndGroups(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	r := repository.Repository{Name: "onerepo", Users: []string{"bob", "alice"}, Groups: []string{"devs"}, ReadOnlyGroups: []string{"devs", "qa"}}
+	err = conn.Repository().Insert(&r)
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId(r.Name)
+	b := bytes.NewBufferString(`{"repositories": ["onerepo"], "users": ["bob"], "groups": ["devs"]}`)
+	rec, req := del("/repository/revoke", b, c)
+	s.router.ServeHTTP(rec, req)
+	c.Assert(rec.Code, check.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), check.Equals, `Successfully revoked access to users "[bob]" and groups "[devs]" into repositories "[onerepo]"`)
+	repo, err := repository.Get(r.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(repo.Users, check.DeepEquals, []string{"alice"})
+	c.Assert(repo.Groups, check.HasLen, 0)
+	c.Assert(repo.ReadOnlyGroups, check.DeepEquals, []string{"qa"})
+}
+
+func (s *S) TestNewGroup(c *check.C) {
+	b := strings.NewReader(`{"name": "devs", "members": ["bob"]}`)
+	recorder, request := post("/group", b, c)
+	s.router.ServeHTTP(recorder, request)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Group().RemoveId("devs")
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "Group \"devs\" successfully created\n")
+	g, err := group.Get("devs")
+	c.Assert(err, check.IsNil)
+	c.Assert(g.Members, check.DeepEquals, []string{"bob"})
+}
+
+func (s *S) TestNewGroupInvalidName(c *check.C) {
+	b := strings.NewReader(`{"name": "dev team"}`)
+	recorder, request := post("/group", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestNewGroupDuplicate(c *check.C) {
+	_, err := group.New("devs", nil)
+	c.Assert(err, check.IsNil)
+	defer group.Remove("devs")
+	b := strings.NewReader(`{"name": "devs"}`)
+	recorder, request := post("/group", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusConflict)
+}
+
+func (s *S) TestGetGroup(c *check.C) {
+	_, err := group.New("devs", []string{"bob"})
+	c.Assert(err, check.IsNil)
+	defer group.Remove("devs")
+	recorder, request := get("/group/devs", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, `{"name":"devs","members":["bob"]}`)
+}
+
+func (s *S) TestGetGroupNotFound(c *check.C) {
+	recorder, request := get("/group/ghost", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestListGroups(c *check.C) {
+	_, err := group.New("devs", []string{"bob"})
+	c.Assert(err, check.IsNil)
+	defer group.Remove("devs")
+	recorder, request := get("/group", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, `[{"name":"devs","members":["bob"]}]`)
+}
+
+func (s *S) TestRemoveGroup(c *check.C) {
+	_, err := group.New("devs", nil)
+	c.Assert(err, check.IsNil)
+	recorder, request := del("/group/devs", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "Group \"devs\" successfully removed\n")
+	_, err = group.Get("devs")
+	c.Assert(err, check.Equals, group.ErrGroupNotFound)
+}
+
+func (s *S) TestRemoveGroupNotFound(c *check.C) {
+	recorder, request := del("/group/ghost", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestAddGroupMembers(c *check.C) {
+	_, err := group.New("devs", []string{"bob"})
+	c.Assert(err, check.IsNil)
+	defer group.Remove("devs")
+	b := strings.NewReader(`{"members": ["alice"]}`)
+	recorder, request := post("/group/devs/members", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "Users \"[alice]\" successfully added to group \"devs\"\n")
+	g, err := group.Get("devs")
+	c.Assert(err, check.IsNil)
+	c.Assert(g.Members, check.DeepEquals, []string{"bob", "alice"})
+}
+
+func (s *S) TestAddGroupMembersWithoutMembers(c *check.C) {
+	b := strings.NewReader(`{}`)
+	recorder, request := post("/group/devs/members", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestAddGroupMembersGroupNotFound(c *check.C) {
+	b := strings.NewReader(`{"members": ["alice"]}`)
+	recorder, request := post("/group/ghost/members", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestRemoveGroupMembers(c *check.C) {
+	_, err := group.New("devs", []string{"bob", "alice"})
+	c.Assert(err, check.IsNil)
+	defer group.Remove("devs")
+	b := strings.NewReader(`{"members": ["bob"]}`)
+	recorder, request := del("/group/devs/members", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "Users \"[bob]\" successfully removed from group \"devs\"\n")
+	g, err := group.Get("devs")
+	c.Assert(err, check.IsNil)
+	c.Assert(g.Members, check.DeepEquals, []string{"alice"})
+}
+
 func (s *S) TestAddKey(c *check.C) {
 	usr, err := user.New("Frodo", map[string]string{})
 	c.Assert(err, check.IsNil)
@@ -648,11 +845,194 @@ func (s *S) TestAddInvalidHookRepository(c *check.C) {
 	recorder, request := post("/hook/invalid-hook", b, c)
 	s.router.ServeHTTP(recorder, request)
 	got := readBody(recorder.Body, c)
-	expected := "Unsupported hook, valid options are: post-receive, pre-receive or update\n"
+	expected := "Unsupported hook, valid options are: pre-receive, update, post-receive, post-update, push-to-checkout, reference-transaction or proc-receive\n"
 	c.Assert(got, check.Equals, expected)
 	c.Assert(recorder.Code, check.Equals, 400)
 }
 
+func (s *S) TestAddHookScript(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Hook().RemoveAll(nil)
+	b := strings.NewReader(`{"repositories": ["some-repo"], "script": "10-deploy", "content": "some content"}`)
+	recorder, request := post("/hook/post-receive", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "hook post-receive script 10-deploy successfully created for [some-repo]\n")
+	recorder, request = get("/hook/post-receive?repository=some-repo&script=10-deploy", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var h hook.Hook
+	err = json.Unmarshal(recorder.Body.Bytes(), &h)
+	c.Assert(err, check.IsNil)
+	c.Assert(h.Script, check.Equals, "10-deploy")
+	c.Assert(h.Content, check.Equals, "some content")
+	recorder, request = del("/hook/post-receive?repository=some-repo&script=10-deploy", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "hook post-receive script 10-deploy successfully removed from [some-repo]\n")
+}
+
+func (s *S) TestAddHookInvalidScript(c *check.C) {
+	b := strings.NewReader(`{"script": "../update", "content": "some content"}`)
+	recorder, request := post("/hook/post-receive", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, hook.ErrInvalidScript.Error()+"\n")
+	b = strings.NewReader(`{"script": "deploy", "content": "some content"}`)
+	recorder, request = post("/hook/proc-receive", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, hook.ErrNotChainable.Error()+"\n")
+}
+
+func (s *S) TestHooksCanNotBeManagedOnBehalfOfUsers(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Hook().RemoveAll(nil)
+	err = conn.Repository().Insert(&repository.Repository{Name: "some-repo", Users: []string{"bob"}, Admins: []string{"alice"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("some-repo")
+	err = hook.Add("post-receive", "", []string{"some-repo"}, []byte("some content"), "tsuru")
+	c.Assert(err, check.IsNil)
+	requests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"POST", "/hook/post-receive", `{"repositories": ["some-repo"], "content": "some content"}`},
+		{"POST", "/hook/post-receive", `{"content": "some content"}`},
+		{"DELETE", "/hook/post-receive?repository=some-repo", ""},
+		{"POST", "/hook/apply", `{"repositories": ["some-repo"]}`},
+	}
+	for _, userName := range []string{"alice", "bob"} {
+		for _, req := range requests {
+			request, err := http.NewRequest(req.method, req.path, strings.NewReader(req.body))
+			c.Assert(err, check.IsNil)
+			request.Header.Set("X-Gandalf-User", userName)
+			recorder := httptest.NewRecorder()
+			s.router.ServeHTTP(recorder, request)
+			c.Check(recorder.Code, check.Equals, http.StatusForbidden, check.Commentf("%s %s", req.method, req.path))
+			c.Check(recorder.Body.String(), check.Equals, "Hooks can not be managed on behalf of users\n")
+		}
+	}
+	h, err := hook.Get("post-receive", "", "some-repo")
+	c.Assert(err, check.IsNil)
+	c.Assert(h.Content, check.Equals, "some content")
+}
+
+func (s *S) TestListHooks(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Hook().RemoveAll(nil)
+	err = hook.Add("post-receive", "", []string{"some-repo"}, []byte("some content"), "tsuru")
+	c.Assert(err, check.IsNil)
+	recorder, request := get("/hook", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var hooks []hook.Hook
+	err = json.Unmarshal(recorder.Body.Bytes(), &hooks)
+	c.Assert(err, check.IsNil)
+	c.Assert(hooks, check.HasLen, 1)
+	c.Assert(hooks[0].Name, check.Equals, "post-receive")
+	c.Assert(hooks[0].Repository, check.Equals, "some-repo")
+	c.Assert(hooks[0].InstalledBy, check.Equals, "tsuru")
+}
+
+func (s *S) TestListRepositoryHooks(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Hook().RemoveAll(nil)
+	err = conn.Repository().Insert(&repository.Repository{Name: "some-repo", Users: []string{"bob"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("some-repo")
+	err = hook.Add("update", "", []string{"some-repo", "other-repo"}, []byte("some content"), "tsuru")
+	c.Assert(err, check.IsNil)
+	recorder, request := get("/repository/some-repo/hooks", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var hooks []hook.Hook
+	err = json.Unmarshal(recorder.Body.Bytes(), &hooks)
+	c.Assert(err, check.IsNil)
+	c.Assert(hooks, check.HasLen, 1)
+	c.Assert(hooks[0].Name, check.Equals, "update")
+}
+
+func (s *S) TestListRepositoryHooksRepositoryNotFound(c *check.C) {
+	recorder, request := get("/repository/nothere/hooks", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestGetHook(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Hook().RemoveAll(nil)
+	err = hook.Add("pre-receive", "", []string{"some-repo"}, []byte("some content"), "tsuru")
+	c.Assert(err, check.IsNil)
+	recorder, request := get("/hook/pre-receive?repository=some-repo", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var h hook.Hook
+	err = json.Unmarshal(recorder.Body.Bytes(), &h)
+	c.Assert(err, check.IsNil)
+	c.Assert(h.Content, check.Equals, "some content")
+	c.Assert(h.InstalledBy, check.Equals, "tsuru")
+	recorder, request = get("/hook/pre-receive", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestRemoveHook(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Hook().RemoveAll(nil)
+	err = hook.Add("post-receive", "", []string{"some-repo"}, []byte("some content"), "tsuru")
+	c.Assert(err, check.IsNil)
+	recorder, request := del("/hook/post-receive?repository=some-repo", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "hook post-receive successfully removed from [some-repo]\n")
+	_, err = hook.Get("post-receive", "", "some-repo")
+	c.Assert(err, check.Equals, hook.ErrHookNotFound)
+	recorder, request = del("/hook/post-receive?repository=some-repo", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestRemoveInvalidHook(c *check.C) {
+	recorder, request := del("/hook/invalid-hook", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestApplyTemplateHooks(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Hook().RemoveAll(nil)
+	err = hook.Add("post-receive", "", nil, []byte("template content"), "admin")
+	c.Assert(err, check.IsNil)
+	b := strings.NewReader(`{"repositories": ["some-repo"]}`)
+	recorder, request := post("/hook/apply", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var hooks []hook.Hook
+	err = json.Unmarshal(recorder.Body.Bytes(), &hooks)
+	c.Assert(err, check.IsNil)
+	c.Assert(hooks, check.HasLen, 1)
+	c.Assert(hooks[0].Repository, check.Equals, "some-repo")
+	h, err := hook.Get("post-receive", "", "some-repo")
+	c.Assert(err, check.IsNil)
+	c.Assert(h.Content, check.Equals, "template content")
+}
+
 func (s *S) TestAddPostReceiveHook(c *check.C) {
 	b := strings.NewReader(`{"content": "some content"}`)
 	recorder, request := post("/hook/post-receive", b, c)
@@ -703,7 +1083,7 @@ func (s *S) TestAddInvalidHook(c *check.C) {
 	recorder, request := post("/hook/invalid-hook", b, c)
 	s.router.ServeHTTP(recorder, request)
 	got := readBody(recorder.Body, c)
-	expected := "Unsupported hook, valid options are: post-receive, pre-receive or update\n"
+	expected := "Unsupported hook, valid options are: pre-receive, update, post-receive, post-update, push-to-checkout, reference-transaction or proc-receive\n"
 	c.Assert(got, check.Equals, expected)
 	c.Assert(recorder.Code, check.Equals, 400)
 }
@@ -758,7 +1138,7 @@ func (s *S) TestAddInvalidOldFormatHook(c *check.C) {
 	recorder, request := post("/hook/invalid-hook", b, c)
 	s.router.ServeHTTP(recorder, request)
 	got := readBody(recorder.Body, c)
-	expected := "Unsupported hook, valid options are: post-receive, pre-receive or update\n"
+	expected := "Unsupported hook, valid options are: pre-receive, update, post-receive, post-update, push-to-checkout, reference-transaction or proc-receive\n"
 	c.Assert(got, check.Equals, expected)
 	c.Assert(recorder.Code, check.Equals, 400)
 }
@@ -1686,6 +2066,41 @@ func (s *S) TestPostNewCommit(c *check.C) {
 	c.Assert(data, check.DeepEquals, expected)
 }
 
+func (s *S) TestPostNewCommitOnBehalfOfUser(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "repo", Users: []string{"r2d2"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("repo")
+	defer conn.Audit().RemoveAll(nil)
+	params := map[string]string{
+		"message":         "Repository scaffold",
+		"author-name":     "Doge Dog",
+		"author-email":    "doge@much.com",
+		"committer-name":  "Doge Dog",
+		"committer-email": "doge@much.com",
+		"branch":          "master",
+	}
+	buf, err := multipartzip.CreateZipBuffer([]multipartzip.File{{Name: "doge.txt", Body: "Much doge"}})
+	c.Assert(err, check.IsNil)
+	reader, writer := io.Pipe()
+	go multipartzip.StreamWriteMultipartForm(params, "zipfile", "scaffold.zip", "muchBOUNDARY", writer, buf)
+	mockRetriever := repository.MockContentRetriever{Ref: repository.Ref{Ref: "some-random-ref", Name: "master"}}
+	repository.Retriever = &mockRetriever
+	defer func() {
+		repository.Retriever = nil
+	}()
+	request, err := http.NewRequest("POST", "/repository/repo/commit", reader)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "multipart/form-data;boundary=muchBOUNDARY")
+	request.Header.Set("X-Gandalf-User", "r2d2")
+	recorder := httptest.NewRecorder()
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(mockRetriever.LastUser, check.Equals, "r2d2")
+}
+
 func (s *S) TestPostNewCommitWithoutBranch(c *check.C) {
 	url := "/repository/repo/commit"
 	params := map[string]string{
@@ -1853,3 +2268,1650 @@ func (s *S) TestGetMimeTypeFromContent(c *check.C) {
 	content.Write([]byte{10, 20, 30, 0, 9, 200})
 	c.Assert(getMimeType(path, content.Bytes()), check.Equals, "application/octet-stream")
 }
+
+func (s *S) TestNewToken(c *check.C) {
+	b := strings.NewReader(`{"name": "tsuru", "scopes": ["write"]}`)
+	recorder, request := post("/token", b, c)
+	s.router.ServeHTTP(recorder, request)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Token().RemoveId("tsuru")
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	var result map[string]interface{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result["name"], check.Equals, "tsuru")
+	c.Assert(result["token"], check.HasLen, 64)
+	t, err := auth.Authenticate(result["token"].(string))
+	c.Assert(err, check.IsNil)
+	c.Assert(t.Name, check.Equals, "tsuru")
+}
+
+func (s *S) TestNewTokenForUser(c *check.C) {
+	_, err := user.New("bob", nil)
+	c.Assert(err, check.IsNil)
+	defer user.Remove("bob")
+	b := strings.NewReader(`{"name": "bob-laptop", "scopes": ["write"], "user": "bob"}`)
+	recorder, request := post("/token", b, c)
+	s.router.ServeHTTP(recorder, request)
+	defer auth.Revoke("bob-laptop")
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	var result map[string]interface{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result["user"], check.Equals, "bob")
+	t, err := auth.Authenticate(result["token"].(string))
+	c.Assert(err, check.IsNil)
+	c.Assert(t.User, check.Equals, "bob")
+}
+
+func (s *S) TestNewTokenForUnknownUser(c *check.C) {
+	b := strings.NewReader(`{"name": "gollum-laptop", "scopes": ["write"], "user": "gollum"}`)
+	recorder, request := post("/token", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestNewTokenDuplicate(c *check.C) {
+	_, _, err := auth.New("tsuru", []string{auth.ReadScope})
+	c.Assert(err, check.IsNil)
+	defer auth.Revoke("tsuru")
+	b := strings.NewReader(`{"name": "tsuru", "scopes": ["write"]}`)
+	recorder, request := post("/token", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusConflict)
+}
+
+func (s *S) TestNewTokenInvalidScope(c *check.C) {
+	b := strings.NewReader(`{"name": "tsuru", "scopes": ["root"]}`)
+	recorder, request := post("/token", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "invalid scope: root\n")
+}
+
+func (s *S) TestListTokens(c *check.C) {
+	_, _, err := auth.New("tsuru", []string{auth.ReadScope})
+	c.Assert(err, check.IsNil)
+	defer auth.Revoke("tsuru")
+	recorder, request := get("/token", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var tokens []map[string]interface{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &tokens)
+	c.Assert(err, check.IsNil)
+	c.Assert(tokens, check.HasLen, 1)
+	c.Assert(tokens[0]["name"], check.Equals, "tsuru")
+	_, ok := tokens[0]["token"]
+	c.Assert(ok, check.Equals, false)
+}
+
+func (s *S) TestRevokeToken(c *check.C) {
+	_, _, err := auth.New("tsuru", []string{auth.ReadScope})
+	c.Assert(err, check.IsNil)
+	recorder, request := del("/token/tsuru", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "Token \"tsuru\" successfully revoked\n")
+}
+
+func (s *S) TestRevokeTokenNotFound(c *check.C) {
+	recorder, request := del("/token/ghost", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestGetProtections(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	repo := repository.Repository{Name: "protected", Protections: []repository.Protection{{Pattern: "master", Users: []string{"bob"}}}}
+	err = conn.Repository().Insert(&repo)
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId(repo.Name)
+	recorder, request := get("/repository/protected/protections", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, `[{"pattern":"master","users":["bob"]}]`)
+}
+
+func (s *S) TestGetProtectionsRepositoryNotFound(c *check.C) {
+	recorder, request := get("/repository/ghost/protections", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestAddProtection(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "protected"})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("protected")
+	b := strings.NewReader(`{"pattern": "release/*", "users": ["bob"]}`)
+	recorder, request := post("/repository/protected/protections", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "Branches matching \"release/*\" are now protected in repository \"protected\"\n")
+	repo, err := repository.Get("protected")
+	c.Assert(err, check.IsNil)
+	c.Assert(repo.Protections, check.DeepEquals, []repository.Protection{{Pattern: "release/*", Users: []string{"bob"}}})
+}
+
+func (s *S) TestAddProtectionInvalidPattern(c *check.C) {
+	b := strings.NewReader(`{"pattern": "refs/heads/master"}`)
+	recorder, request := post("/repository/protected/protections", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestAddProtectionDuplicate(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	repo := repository.Repository{Name: "protected", Protections: []repository.Protection{{Pattern: "master"}}}
+	err = conn.Repository().Insert(&repo)
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId(repo.Name)
+	b := strings.NewReader(`{"pattern": "master"}`)
+	recorder, request := post("/repository/protected/protections", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusConflict)
+}
+
+func (s *S) TestRemoveProtection(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	repo := repository.Repository{Name: "protected", Protections: []repository.Protection{{Pattern: "release/*"}}}
+	err = conn.Repository().Insert(&repo)
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId(repo.Name)
+	recorder, request := del("/repository/protected/protections?pattern=release/*", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "Protection \"release/*\" successfully removed from repository \"protected\"\n")
+	stored, err := repository.Get("protected")
+	c.Assert(err, check.IsNil)
+	c.Assert(stored.Protections, check.HasLen, 0)
+}
+
+func (s *S) TestRemoveProtectionWithoutPattern(c *check.C) {
+	recorder, request := del("/repository/protected/protections", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestAddProtectionRequiresAdminRole(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	repo := repository.Repository{Name: "protected", Users: []string{"bob"}, Admins: []string{"alice"}}
+	err = conn.Repository().Insert(&repo)
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId(repo.Name)
+	recorder, request := post("/repository/protected/protections", strings.NewReader(`{"pattern": "master"}`), c)
+	request.Header.Set("X-Gandalf-User", "bob")
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	c.Assert(recorder.Body.String(), check.Equals, "User \"bob\" does not have the admin role in repository \"protected\"\n")
+	recorder, request = post("/repository/protected/protections", strings.NewReader(`{"pattern": "master"}`), c)
+	request.Header.Set("X-Gandalf-User", "alice")
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+}
+
+func (s *S) TestRemoveRepositoryRequiresAdminRole(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	repo := repository.Repository{Name: "guarded", Users: []string{"bob"}, Maintainers: []string{"carol"}}
+	err = conn.Repository().Insert(&repo)
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId(repo.Name)
+	for _, userName := range []string{"bob", "carol", "mallory"} {
+		recorder, request := del("/repository/guarded", nil, c)
+		request.Header.Set("X-Gandalf-User", userName)
+		s.router.ServeHTTP(recorder, request)
+		c.Check(recorder.Code, check.Equals, http.StatusForbidden)
+	}
+	n, err := conn.Repository().FindId("guarded").Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 1)
+}
+
+func (s *S) TestRoleCheckRepositoryNotFound(c *check.C) {
+	recorder, request := del("/repository/nothere", nil, c)
+	request.Header.Set("X-Gandalf-User", "bob")
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestGetMembers(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	repo := repository.Repository{Name: "team", Users: []string{"bob"}, ReadOnlyUsers: []string{"dave"}, Admins: []string{"alice"}}
+	err = conn.Repository().Insert(&repo)
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId(repo.Name)
+	recorder, request := get("/repository/team/members", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var members []repository.Member
+	err = json.Unmarshal(recorder.Body.Bytes(), &members)
+	c.Assert(err, check.IsNil)
+	expected := []repository.Member{
+		{User: "alice", Role: repository.RoleAdmin},
+		{User: "bob", Role: repository.RoleWrite},
+		{User: "dave", Role: repository.RoleRead},
+	}
+	c.Assert(members, check.DeepEquals, expected)
+}
+
+func (s *S) TestGetMembersRepositoryNotFound(c *check.C) {
+	recorder, request := get("/repository/nothere/members", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestSetMember(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	repo := repository.Repository{Name: "team", Users: []string{"bob"}}
+	err = conn.Repository().Insert(&repo)
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId(repo.Name)
+	b := strings.NewReader(`{"user": "bob", "role": "maintainer"}`)
+	recorder, request := post("/repository/team/members", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "User \"bob\" is now maintainer of repository \"team\"\n")
+	stored, err := repository.Get("team")
+	c.Assert(err, check.IsNil)
+	c.Assert(stored.Users, check.HasLen, 0)
+	c.Assert(stored.Maintainers, check.DeepEquals, []string{"bob"})
+	entries, err := audit.List(audit.Filter{Action: audit.MemberSet})
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.Not(check.HasLen), 0)
+	c.Assert(entries[0].Details, check.Equals, "role maintainer")
+}
+
+func (s *S) TestSetMemberInvalidRole(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "team", Users: []string{"bob"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("team")
+	b := strings.NewReader(`{"user": "bob", "role": "owner"}`)
+	recorder, request := post("/repository/team/members", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestSetMemberWithoutUser(c *check.C) {
+	b := strings.NewReader(`{"role": "admin"}`)
+	recorder, request := post("/repository/team/members", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestSetMemberRepositoryNotFound(c *check.C) {
+	b := strings.NewReader(`{"user": "bob", "role": "admin"}`)
+	recorder, request := post("/repository/nothere/members", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestRemoveMember(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	repo := repository.Repository{Name: "team", Users: []string{"bob"}, Admins: []string{"alice"}}
+	err = conn.Repository().Insert(&repo)
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId(repo.Name)
+	recorder, request := del("/repository/team/members?user=bob", nil, c)
+	request.Header.Set("X-Gandalf-User", "alice")
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "User \"bob\" successfully removed from repository \"team\"\n")
+	stored, err := repository.Get("team")
+	c.Assert(err, check.IsNil)
+	c.Assert(stored.Users, check.HasLen, 0)
+	recorder, request = del("/repository/team/members?user=bob", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestRemoveMemberWithoutUser(c *check.C) {
+	recorder, request := del("/repository/team/members", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestRemoveProtectionNotFound(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "protected"})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("protected")
+	recorder, request := del("/repository/protected/protections?pattern=master", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestListAudit(c *check.C) {
+	audit.Record(audit.Entry{Action: audit.RepositoryCreate, Actor: "tsuru", Repositories: []string{"myrepo"}})
+	audit.Record(audit.Entry{Action: audit.GitPush, Actor: "bob", Repositories: []string{"otherrepo"}})
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Audit().RemoveAll(nil)
+	recorder, request := get("/audit?repository=myrepo&since=2020-01-01T00:00:00Z", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var entries []audit.Entry
+	err = json.Unmarshal(recorder.Body.Bytes(), &entries)
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 1)
+	c.Assert(entries[0].Action, check.Equals, audit.RepositoryCreate)
+	c.Assert(entries[0].Actor, check.Equals, "tsuru")
+}
+
+func (s *S) TestListAuditInvalidParameters(c *check.C) {
+	for _, query := range []string{"since=yesterday", "until=2020-01-01", "limit=-1", "limit=many"} {
+		recorder, request := get("/audit?"+query, nil, c)
+		s.router.ServeHTTP(recorder, request)
+		c.Check(recorder.Code, check.Equals, http.StatusBadRequest)
+	}
+}
+
+func (s *S) TestSyncKeys(c *check.C) {
+	u, err := user.New("Gandalf", map[string]string{"key1": rawKey})
+	c.Assert(err, check.IsNil)
+	defer user.Remove(u.Name)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Audit().RemoveAll(nil)
+	recorder, request := post("/admin/keys/sync", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var report user.SyncReport
+	err = json.Unmarshal(recorder.Body.Bytes(), &report)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Added, check.HasLen, 0)
+	c.Assert(report.Removed, check.HasLen, 0)
+	content := s.authKeysContent(c)
+	c.Assert(strings.HasPrefix(content, "# BEGIN gandalf managed keys\n"), check.Equals, true)
+	c.Assert(strings.Contains(content, rawKey), check.Equals, true)
+	entries, err := audit.List(audit.Filter{Action: audit.KeySync})
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 1)
+	c.Assert(entries[0].Details, check.Equals, "0 added, 0 removed")
+}
+
+func (s *S) TestSyncKeysWithAuthorizedKeysCommand(c *check.C) {
+	config.Set("authorized-keys-command", true)
+	defer config.Unset("authorized-keys-command")
+	recorder, request := post("/admin/keys/sync", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, user.ErrAuthorizedKeysDisabled.Error()+"\n")
+}
+
+func (s *S) TestSetQuota(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Audit().RemoveAll(nil)
+	err = conn.Repository().Insert(&repository.Repository{Name: "big"})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("big")
+	recorder, request := put("/repository/big/quota", strings.NewReader(`{"quota": 1048576}`), c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "Quota of repository \"big\" successfully set to 1.0 MiB\n")
+	repo, err := repository.Get("big")
+	c.Assert(err, check.IsNil)
+	c.Assert(repo.Quota, check.Equals, int64(1048576))
+	entries, err := audit.List(audit.Filter{Action: audit.RepositoryQuota})
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 1)
+	c.Assert(entries[0].Details, check.Equals, "1048576 bytes")
+}
+
+func (s *S) TestSetQuotaRemove(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "big", Quota: 1024})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("big")
+	recorder, request := put("/repository/big/quota", strings.NewReader(`{"quota": 0}`), c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "Quota of repository \"big\" successfully removed\n")
+	repo, err := repository.Get("big")
+	c.Assert(err, check.IsNil)
+	c.Assert(repo.Quota, check.Equals, int64(0))
+}
+
+func (s *S) TestSetQuotaNegative(c *check.C) {
+	recorder, request := put("/repository/big/quota", strings.NewReader(`{"quota": -1}`), c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestSetQuotaRepositoryNotFound(c *check.C) {
+	recorder, request := put("/repository/ghost/quota", strings.NewReader(`{"quota": 1024}`), c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestSetQuotaOnBehalfOfUser(c *check.C) {
+	recorder, request := put("/repository/big/quota", strings.NewReader(`{"quota": 1024}`), c)
+	request.Header.Set("X-Gandalf-User", "bob")
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	c.Assert(recorder.Body.String(), check.Equals, "Quotas can not be changed on behalf of users\n")
+}
+
+func (s *S) TestUpdateRepositoryDoesNotChangeQuota(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "big", Quota: 1024})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("big")
+	recorder, request := put("/repository/big", strings.NewReader(`{"ispublic": true, "quota": 0}`), c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	repo, err := repository.Get("big")
+	c.Assert(err, check.IsNil)
+	c.Assert(repo.IsPublic, check.Equals, true)
+	c.Assert(repo.Quota, check.Equals, int64(1024))
+}
+
+func (s *S) TestUpdateRepositoryOnlyChangesAllowedFields(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	stored := repository.Repository{
+		Name:           "guarded",
+		Users:          []string{"bob"},
+		Groups:         []string{"hobbits"},
+		ReadOnlyGroups: []string{"elves"},
+		Maintainers:    []string{"alice"},
+		Admins:         []string{"gandalf"},
+		Protections:    []repository.Protection{{Pattern: "master"}},
+		Parent:         "upstream",
+	}
+	err = conn.Repository().Insert(&stored)
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("guarded")
+	b := strings.NewReader(`{"ispublic": true, "users": ["bob", "eve"], "groups": ["orcs"], "readonlygroups": [],
+		"maintainers": ["eve"], "admins": ["eve"], "protections": [], "parent": "mordor"}`)
+	recorder, request := put("/repository/guarded", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	stored.IsPublic = true
+	stored.Users = []string{"bob", "eve"}
+	repo, err := repository.Get("guarded")
+	c.Assert(err, check.IsNil)
+	c.Assert(repo, check.DeepEquals, stored)
+}
+
+func (s *S) TestGetDiskUsage(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	for name, usage := range map[string]int64{"small": 10, "big": 1000, "medium": 100} {
+		err = conn.Repository().Insert(&repository.Repository{Name: name, DiskUsage: usage})
+		c.Assert(err, check.IsNil)
+		defer conn.Repository().RemoveId(name)
+	}
+	config.Set("quota:total", "1G")
+	defer config.Unset("quota:total")
+	recorder, request := get("/admin/usage?limit=2", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var result struct {
+		Total        int64
+		TotalQuota   int64 `json:"total_quota"`
+		Repositories []map[string]interface{}
+	}
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Total, check.Equals, int64(1110))
+	c.Assert(result.TotalQuota, check.Equals, int64(1<<30))
+	c.Assert(result.Repositories, check.HasLen, 2)
+	c.Assert(result.Repositories[0]["name"], check.Equals, "big")
+	c.Assert(result.Repositories[0]["disk_usage"], check.Equals, float64(1000))
+	c.Assert(result.Repositories[1]["name"], check.Equals, "medium")
+}
+
+func (s *S) TestGetDiskUsageInvalidLimit(c *check.C) {
+	recorder, request := get("/admin/usage?limit=0", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "Invalid limit: 0\n")
+}
+
+func (s *S) TestNewRepositoryRecordsAudit(c *check.C) {
+	b := strings.NewReader(`{"name": "audited", "users": ["bob"]}`)
+	recorder, request := post("/repository", b, c)
+	request.RemoteAddr = "10.0.0.2:43210"
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Repository().RemoveId("audited")
+	defer conn.Audit().RemoveAll(nil)
+	entries, err := audit.List(audit.Filter{Repository: "audited"})
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 1)
+	c.Assert(entries[0].Action, check.Equals, audit.RepositoryCreate)
+	c.Assert(entries[0].Actor, check.Equals, "10.0.0.2")
+	c.Assert(entries[0].Users, check.DeepEquals, []string{"bob"})
+}
+
+func (s *S) TestNewRepositoryOnBehalfOfUserRecordsAudit(c *check.C) {
+	b := strings.NewReader(`{"name": "audited", "users": ["bob"]}`)
+	recorder, request := post("/repository", b, c)
+	request.RemoteAddr = "10.0.0.2:43210"
+	request.Header.Set("X-Gandalf-User", "bob")
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Repository().RemoveId("audited")
+	defer conn.Audit().RemoveAll(nil)
+	entries, err := audit.List(audit.Filter{Repository: "audited"})
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 1)
+	c.Assert(entries[0].Actor, check.Equals, "10.0.0.2")
+	c.Assert(entries[0].User, check.Equals, "bob")
+	recorder, request = get("/audit?user=bob&action=repository.create", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var listed []audit.Entry
+	err = json.Unmarshal(recorder.Body.Bytes(), &listed)
+	c.Assert(err, check.IsNil)
+	c.Assert(listed, check.HasLen, 1)
+	c.Assert(listed[0].Repositories, check.DeepEquals, []string{"audited"})
+}
+
+func (s *S) TestForkRepository(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "upstream", Users: []string{"r2d2"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("upstream")
+	defer conn.Repository().RemoveId("downstream")
+	defer conn.Audit().RemoveAll(nil)
+	b := strings.NewReader(`{"name": "downstream", "users": ["c3po"]}`)
+	recorder, request := post("/repository/upstream/fork", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "Repository \"upstream\" successfully forked into \"downstream\"\n")
+	fork, err := repository.Get("downstream")
+	c.Assert(err, check.IsNil)
+	c.Assert(fork.Parent, check.Equals, "upstream")
+	c.Assert(fork.Users, check.DeepEquals, []string{"c3po"})
+	entries, err := audit.List(audit.Filter{Action: audit.RepositoryFork})
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 1)
+	c.Assert(entries[0].Repositories, check.DeepEquals, []string{"upstream", "downstream"})
+}
+
+func (s *S) TestForkRepositoryWithoutReadPermission(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "upstream", Users: []string{"r2d2"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("upstream")
+	b := strings.NewReader(`{"name": "downstream", "users": ["c3po"]}`)
+	recorder, request := post("/repository/upstream/fork", b, c)
+	request.Header.Set("X-Gandalf-User", "c3po")
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	_, err = repository.Get("downstream")
+	c.Assert(err, check.Equals, repository.ErrRepositoryNotFound)
+}
+
+func (s *S) TestForkPublicRepositoryOnBehalfOfUser(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "upstream", Users: []string{"r2d2"}, IsPublic: true})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("upstream")
+	defer conn.Repository().RemoveId("downstream")
+	defer conn.Audit().RemoveAll(nil)
+	b := strings.NewReader(`{"name": "downstream", "users": ["c3po"]}`)
+	recorder, request := post("/repository/upstream/fork", b, c)
+	request.Header.Set("X-Gandalf-User", "c3po")
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+}
+
+func (s *S) TestForkRepositorySourceNotFound(c *check.C) {
+	b := strings.NewReader(`{"name": "downstream", "users": ["c3po"]}`)
+	recorder, request := post("/repository/nothere/fork", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+	c.Assert(recorder.Body.String(), check.Equals, "repository not found\n")
+}
+
+func (s *S) TestForkRepositoryInvalid(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "upstream", Users: []string{"r2d2"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("upstream")
+	b := strings.NewReader(`{"name": "downstream"}`)
+	recorder, request := post("/repository/upstream/fork", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestListForks(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "upstream", Users: []string{"r2d2"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("upstream")
+	err = conn.Repository().Insert(&repository.Repository{Name: "downstream", Users: []string{"c3po"}, Parent: "upstream"})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("downstream")
+	recorder, request := get("/repository/upstream/forks", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var forks []map[string]interface{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &forks)
+	c.Assert(err, check.IsNil)
+	c.Assert(forks, check.HasLen, 1)
+	c.Assert(forks[0]["name"], check.Equals, "downstream")
+	c.Assert(forks[0]["parent"], check.Equals, "upstream")
+}
+
+func (s *S) TestListForksRepositoryNotFound(c *check.C) {
+	recorder, request := get("/repository/nothere/forks", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestNewRepositoryWithImport(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Repository().RemoveId("imported")
+	defer conn.ImportJob().RemoveId("imported")
+	source := c.MkDir()
+	b := strings.NewReader(fmt.Sprintf(`{"name": "imported", "users": ["r2d2"], "import": %q}`, source))
+	recorder, request := post("/repository", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusAccepted)
+	c.Assert(recorder.Body.String(), check.Equals, fmt.Sprintf("Repository \"imported\" successfully created, importing \"%s\"\n", source))
+	job, err := repository.GetImport("imported")
+	c.Assert(err, check.IsNil)
+	c.Assert(job.Source, check.Equals, source)
+}
+
+func (s *S) TestNewRepositoryWithInvalidImport(c *check.C) {
+	b := strings.NewReader(`{"name": "imported", "users": ["r2d2"], "import": "https://github.com/tsuru/gandalf.git"}`)
+	recorder, request := post("/repository", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "import source is not valid\n")
+	_, err := repository.Get("imported")
+	c.Assert(err, check.Equals, repository.ErrRepositoryNotFound)
+}
+
+func (s *S) TestImportRepository(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "imported", Users: []string{"r2d2"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("imported")
+	defer conn.ImportJob().RemoveId("imported")
+	defer conn.Audit().RemoveAll(nil)
+	source := "file://" + c.MkDir()
+	b := strings.NewReader(fmt.Sprintf(`{"source": %q}`, source))
+	recorder, request := post("/repository/imported/import", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusAccepted)
+	c.Assert(recorder.Body.String(), check.Equals, fmt.Sprintf("Importing \"%s\" into repository \"imported\"\n", source))
+	entries, err := audit.List(audit.Filter{Action: audit.RepositoryImport})
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 1)
+	c.Assert(entries[0].Details, check.Equals, source)
+}
+
+func (s *S) TestImportRepositoryBundle(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "imported", Users: []string{"r2d2"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("imported")
+	defer conn.ImportJob().RemoveId("imported")
+	body, contentType := bundleForm(c, "# v2 git bundle\n")
+	recorder, request := post("/repository/imported/import", body, c)
+	request.Header.Set("Content-Type", contentType)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusAccepted)
+	c.Assert(recorder.Body.String(), check.Equals, "Importing \"bundle\" into repository \"imported\"\n")
+}
+
+func (s *S) TestImportRepositoryInProgress(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "imported", Users: []string{"r2d2"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("imported")
+	err = conn.ImportJob().Insert(repository.ImportJob{Repository: "imported", Status: repository.ImportRunning})
+	c.Assert(err, check.IsNil)
+	defer conn.ImportJob().RemoveId("imported")
+	b := strings.NewReader(fmt.Sprintf(`{"source": %q}`, c.MkDir()))
+	recorder, request := post("/repository/imported/import", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusConflict)
+	c.Assert(recorder.Body.String(), check.Equals, "import already in progress\n")
+}
+
+func (s *S) TestImportRepositoryOutsideImportLocation(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "imported", Users: []string{"r2d2"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("imported")
+	b := strings.NewReader(`{"source": "file:///etc"}`)
+	recorder, request := post("/repository/imported/import", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Matches, "import source must be inside .*\n")
+	_, err = repository.GetImport("imported")
+	c.Assert(err, check.Equals, repository.ErrImportNotFound)
+}
+
+func (s *S) TestImportRepositoryNotFound(c *check.C) {
+	b := strings.NewReader(fmt.Sprintf(`{"source": %q}`, c.MkDir()))
+	recorder, request := post("/repository/nothere/import", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestGetImport(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.ImportJob().Insert(repository.ImportJob{Repository: "imported", Source: "bundle", Status: repository.ImportFailed, Error: "bad bundle"})
+	c.Assert(err, check.IsNil)
+	defer conn.ImportJob().RemoveId("imported")
+	recorder, request := get("/repository/imported/import", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var job map[string]interface{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &job)
+	c.Assert(err, check.IsNil)
+	c.Assert(job["repository"], check.Equals, "imported")
+	c.Assert(job["status"], check.Equals, "failed")
+	c.Assert(job["error"], check.Equals, "bad bundle")
+}
+
+func (s *S) TestGetImportNotFound(c *check.C) {
+	recorder, request := get("/repository/nothere/import", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+	c.Assert(recorder.Body.String(), check.Equals, "import not found\n")
+}
+
+func (s *S) TestStartMaintenance(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "maintained", Users: []string{"r2d2"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("maintained")
+	defer conn.Maintenance().RemoveId("maintained")
+	defer conn.Audit().RemoveAll(nil)
+	recorder, request := post("/repository/maintained/maintenance", strings.NewReader(`{"tasks": ["repack", "fsck"]}`), c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusAccepted)
+	c.Assert(recorder.Body.String(), check.Equals, "Running repack, fsck in repository \"maintained\"\n")
+	entries, err := audit.List(audit.Filter{Action: audit.MaintenanceStart})
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 1)
+	c.Assert(entries[0].Details, check.Equals, "repack, fsck")
+}
+
+func (s *S) TestStartMaintenanceWithoutBody(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "maintained", Users: []string{"r2d2"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("maintained")
+	defer conn.Maintenance().RemoveId("maintained")
+	recorder, request := post("/repository/maintained/maintenance", strings.NewReader(""), c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusAccepted)
+	c.Assert(recorder.Body.String(), check.Equals, "Running gc, commit-graph in repository \"maintained\"\n")
+}
+
+func (s *S) TestStartMaintenanceInProgress(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "maintained", Users: []string{"r2d2"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("maintained")
+	running := repository.MaintenanceRun{Repository: "maintained", Status: repository.MaintenanceRunning, Expires: time.Now().Add(time.Hour)}
+	err = conn.Maintenance().Insert(&running)
+	c.Assert(err, check.IsNil)
+	defer conn.Maintenance().RemoveId("maintained")
+	recorder, request := post("/repository/maintained/maintenance", strings.NewReader(""), c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusConflict)
+	c.Assert(recorder.Body.String(), check.Equals, "maintenance already in progress\n")
+}
+
+func (s *S) TestStartMaintenanceInvalidTask(c *check.C) {
+	recorder, request := post("/repository/maintained/maintenance", strings.NewReader(`{"tasks": ["rm"]}`), c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestStartMaintenanceRepositoryNotFound(c *check.C) {
+	recorder, request := post("/repository/nothere/maintenance", strings.NewReader(""), c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestStartMaintenanceRequiresMaintainer(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "maintained", Users: []string{"r2d2"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("maintained")
+	recorder, request := post("/repository/maintained/maintenance", strings.NewReader(""), c)
+	request.Header.Set("X-Gandalf-User", "r2d2")
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestGetMaintenance(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	run := repository.MaintenanceRun{
+		Repository: "maintained",
+		Tasks:      []string{"gc"},
+		Results:    []repository.TaskResult{{Name: "gc", Error: "exit status 128", Output: "fatal: bad object"}},
+		Status:     repository.MaintenanceFailed,
+	}
+	err = conn.Maintenance().Insert(&run)
+	c.Assert(err, check.IsNil)
+	defer conn.Maintenance().RemoveId("maintained")
+	recorder, request := get("/repository/maintained/maintenance", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var result repository.MaintenanceRun
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Repository, check.Equals, "maintained")
+	c.Assert(result.Status, check.Equals, repository.MaintenanceFailed)
+	c.Assert(result.Results, check.HasLen, 1)
+	c.Assert(result.Results[0].Output, check.Equals, "fatal: bad object")
+}
+
+func (s *S) TestGetMaintenanceNotFound(c *check.C) {
+	recorder, request := get("/repository/nothere/maintenance", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+	c.Assert(recorder.Body.String(), check.Equals, "maintenance not found\n")
+}
+
+func (s *S) TestGetBundle(c *check.C) {
+	mockRetriever := repository.MockContentRetriever{ResultContents: []byte("# v2 git bundle\n")}
+	repository.Retriever = &mockRetriever
+	defer func() {
+		repository.Retriever = nil
+	}()
+	recorder, request := get("/repository/repo/bundle?ref=master&ref=v1", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "# v2 git bundle\n")
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/octet-stream")
+	c.Assert(recorder.Header().Get("Content-Disposition"), check.Equals, "attachment; filename=\"repo.bundle\"")
+	c.Assert(mockRetriever.LastRefs, check.DeepEquals, []string{"master", "v1"})
+}
+
+func (s *S) TestGetBundleAllRefs(c *check.C) {
+	mockRetriever := repository.MockContentRetriever{ResultContents: []byte("# v2 git bundle\n")}
+	repository.Retriever = &mockRetriever
+	defer func() {
+		repository.Retriever = nil
+	}()
+	recorder, request := get("/repository/repo/bundle", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(mockRetriever.LastRefs, check.HasLen, 0)
+}
+
+func (s *S) TestGetBundleWithoutReadPermission(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "repo", Users: []string{"r2d2"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("repo")
+	mockRetriever := repository.MockContentRetriever{ResultContents: []byte("# v2 git bundle\n")}
+	repository.Retriever = &mockRetriever
+	defer func() {
+		repository.Retriever = nil
+	}()
+	recorder, request := get("/repository/repo/bundle", nil, c)
+	request.Header.Set("X-Gandalf-User", "c3po")
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	c.Assert(recorder.Body.String(), check.Not(check.Equals), "# v2 git bundle\n")
+}
+
+func (s *S) TestReadEndpointsRequireReadPermission(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "repo", Users: []string{"r2d2"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("repo")
+	mockRetriever := repository.MockContentRetriever{ResultContents: []byte("secret"), Refs: []repository.Ref{}}
+	repository.Retriever = &mockRetriever
+	defer func() {
+		repository.Retriever = nil
+	}()
+	paths := []string{
+		"/repository/repo",
+		"/repository/repo/contents?path=README",
+		"/repository/repo/archive?ref=master&format=zip",
+		"/repository/repo/tree",
+		"/repository/repo/branches",
+		"/repository/repo/tags",
+		"/repository/repo/diff/commits?previous_commit=1b970b076bbb30d708e262b402d4e31910e1dc10&last_commit=545b1904af34458704e2aa06ff1aaffad5289f8f",
+		"/repository/repo/logs?ref=master&total=1",
+		"/repository/repo/protections",
+		"/repository/repo/members",
+		"/repository/repo/hooks",
+		"/repository/repo/forks",
+		"/repository/repo/import",
+		"/repository/repo/maintenance",
+	}
+	for _, path := range paths {
+		recorder, request := get(path, nil, c)
+		request.Header.Set("X-Gandalf-User", "c3po")
+		s.router.ServeHTTP(recorder, request)
+		c.Check(recorder.Code, check.Equals, http.StatusForbidden, check.Commentf("%s", path))
+		c.Check(strings.Contains(recorder.Body.String(), "secret"), check.Equals, false, check.Commentf("%s", path))
+	}
+	recorder, request := get("/repository/repo/branches", nil, c)
+	request.Header.Set("X-Gandalf-User", "r2d2")
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+}
+
+func (s *S) TestGetBundleWhenCommandFails(c *check.C) {
+	mockRetriever := repository.MockContentRetriever{OutputError: fmt.Errorf("output error")}
+	repository.Retriever = &mockRetriever
+	defer func() {
+		repository.Retriever = nil
+	}()
+	recorder, request := get("/repository/repo/bundle", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+	c.Assert(recorder.Body.String(), check.Equals, "output error\n")
+}
+
+func bundleForm(c *check.C, contents string) (io.Reader, string) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	part, err := writer.CreateFormFile("bundle", "repo.bundle")
+	c.Assert(err, check.IsNil)
+	part.Write([]byte(contents))
+	writer.Close()
+	return &buf, writer.FormDataContentType()
+}
+
+func (s *S) TestRestoreBundle(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "repo", Users: []string{"r2d2"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("repo")
+	defer conn.Audit().RemoveAll(nil)
+	mockRetriever := repository.MockContentRetriever{Refs: []repository.Ref{{Name: "refs/heads/master"}}}
+	repository.Retriever = &mockRetriever
+	defer func() {
+		repository.Retriever = nil
+	}()
+	body, contentType := bundleForm(c, "# v2 git bundle\n")
+	recorder, request := post("/repository/repo/bundle", body, c)
+	request.Header.Set("Content-Type", contentType)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "Refs \"[refs/heads/master]\" successfully restored into repository \"repo\"\n")
+	c.Assert(string(mockRetriever.ResultContents), check.Equals, "# v2 git bundle\n")
+	entries, err := audit.List(audit.Filter{Repository: "repo"})
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 1)
+	c.Assert(entries[0].Action, check.Equals, audit.GitPush)
+	c.Assert(entries[0].Details, check.Equals, "bundle restoring refs/heads/master")
+}
+
+func (s *S) TestRestoreBundleOnBehalfOfUser(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "repo", Users: []string{"r2d2"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("repo")
+	defer conn.Audit().RemoveAll(nil)
+	defer conn.Webhook().RemoveAll(nil)
+	defer conn.WebhookDelivery().RemoveAll(nil)
+	h := webhook.Webhook{Repository: "repo", URL: "https://tsuru.example.com/hook"}
+	err = webhook.Create(&h)
+	c.Assert(err, check.IsNil)
+	head := "9a8b7c6d5e4f30219a8b7c6d5e4f30219a8b7c6d"
+	mockRetriever := repository.MockContentRetriever{Refs: []repository.Ref{{Name: "refs/heads/master", Ref: head}}}
+	repository.Retriever = &mockRetriever
+	defer func() {
+		repository.Retriever = nil
+	}()
+	body, contentType := bundleForm(c, "# v2 git bundle\n")
+	recorder, request := post("/repository/repo/bundle", body, c)
+	request.Header.Set("Content-Type", contentType)
+	request.Header.Set("X-Gandalf-User", "r2d2")
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(mockRetriever.LastUser, check.Equals, "r2d2")
+	entries, err := audit.List(audit.Filter{Repository: "repo", User: "r2d2"})
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 1)
+	c.Assert(entries[0].Action, check.Equals, audit.GitPush)
+	deliveries, err := webhook.ListDeliveries(h.ID.Hex(), 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(deliveries, check.HasLen, 1)
+	c.Assert(deliveries[0].Payload.Pusher, check.Equals, "r2d2")
+	c.Assert(deliveries[0].Payload.Refs, check.DeepEquals, []webhook.RefUpdate{{Ref: "refs/heads/master", Before: "0000000000000000000000000000000000000000", After: head}})
+}
+
+func (s *S) TestRestoreBundleWhenCommandFails(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "repo", Users: []string{"r2d2"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("repo")
+	mockRetriever := repository.MockContentRetriever{OutputError: fmt.Errorf("invalid bundle")}
+	repository.Retriever = &mockRetriever
+	defer func() {
+		repository.Retriever = nil
+	}()
+	body, contentType := bundleForm(c, "not a bundle")
+	recorder, request := post("/repository/repo/bundle", body, c)
+	request.Header.Set("Content-Type", contentType)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "invalid bundle\n")
+}
+
+func (s *S) TestRestoreBundleRepositoryNotFound(c *check.C) {
+	body, contentType := bundleForm(c, "# v2 git bundle\n")
+	recorder, request := post("/repository/nothere/bundle", body, c)
+	request.Header.Set("Content-Type", contentType)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestNewWebhook(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "hooked", Users: []string{"bob"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("hooked")
+	defer conn.Webhook().RemoveAll(nil)
+	b := strings.NewReader(`{"repository": "hooked", "url": "https://tsuru.example.com/hook", "secret": "s3cr3t", "events": ["push"]}`)
+	recorder, request := post("/webhook", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	var h map[string]interface{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &h)
+	c.Assert(err, check.IsNil)
+	c.Assert(h["repository"], check.Equals, "hooked")
+	c.Assert(h["url"], check.Equals, "https://tsuru.example.com/hook")
+	c.Assert(h["events"], check.DeepEquals, []interface{}{"push"})
+	_, ok := h["secret"]
+	c.Assert(ok, check.Equals, false)
+	stored, err := webhook.Get(h["id"].(string))
+	c.Assert(err, check.IsNil)
+	c.Assert(stored.Secret, check.Equals, "s3cr3t")
+	entries, err := audit.List(audit.Filter{Action: audit.WebhookAdd})
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 1)
+	c.Assert(entries[0].Repositories, check.DeepEquals, []string{"hooked"})
+}
+
+func (s *S) TestNewWebhookInvalid(c *check.C) {
+	b := strings.NewReader(`{"url": "https://tsuru.example.com/hook", "events": ["fetch"]}`)
+	recorder, request := post("/webhook", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "event fetch is not valid, valid events are push, tag and delete\n")
+}
+
+func (s *S) TestNewWebhookRepositoryNotFound(c *check.C) {
+	b := strings.NewReader(`{"repository": "nothere", "url": "https://tsuru.example.com/hook"}`)
+	recorder, request := post("/webhook", b, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestNewGlobalWebhookOnBehalfOfUser(c *check.C) {
+	b := strings.NewReader(`{"url": "https://tsuru.example.com/hook"}`)
+	recorder, request := post("/webhook", b, c)
+	request.Header.Set("X-Gandalf-User", "bob")
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestListWebhooks(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Webhook().RemoveAll(nil)
+	err = webhook.Create(&webhook.Webhook{URL: "https://tsuru.example.com/hook"})
+	c.Assert(err, check.IsNil)
+	recorder, request := get("/webhook", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var hooks []webhook.Webhook
+	err = json.Unmarshal(recorder.Body.Bytes(), &hooks)
+	c.Assert(err, check.IsNil)
+	c.Assert(hooks, check.HasLen, 1)
+	c.Assert(hooks[0].URL, check.Equals, "https://tsuru.example.com/hook")
+	recorder, request = get("/webhook?repository=other", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "[]")
+}
+
+func (s *S) TestGetWebhookNotFound(c *check.C) {
+	recorder, request := get("/webhook/5a1b2c3d4e5f60718293a4b5", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestRemoveWebhook(c *check.C) {
+	h := webhook.Webhook{URL: "https://tsuru.example.com/hook"}
+	err := webhook.Create(&h)
+	c.Assert(err, check.IsNil)
+	recorder, request := del("/webhook/"+h.ID.Hex(), nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, fmt.Sprintf("Webhook %q successfully removed\n", h.ID.Hex()))
+	_, err = webhook.Get(h.ID.Hex())
+	c.Assert(err, check.Equals, webhook.ErrWebhookNotFound)
+}
+
+func (s *S) TestListDeliveries(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Webhook().RemoveAll(nil)
+	defer conn.WebhookDelivery().RemoveAll(nil)
+	h := webhook.Webhook{URL: "https://tsuru.example.com/hook"}
+	err = webhook.Create(&h)
+	c.Assert(err, check.IsNil)
+	updates := []webhook.RefUpdate{{Ref: "refs/heads/master", Before: "0000000000000000000000000000000000000000", After: "9a8b7c6d5e4f30219a8b7c6d5e4f30219a8b7c6d"}}
+	err = webhook.Notify("hooked", "bob", updates)
+	c.Assert(err, check.IsNil)
+	recorder, request := get("/webhook/"+h.ID.Hex()+"/deliveries", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var deliveries []webhook.Delivery
+	err = json.Unmarshal(recorder.Body.Bytes(), &deliveries)
+	c.Assert(err, check.IsNil)
+	c.Assert(deliveries, check.HasLen, 1)
+	c.Assert(deliveries[0].Status, check.Equals, webhook.DeliveryPending)
+	c.Assert(deliveries[0].Payload.Refs, check.DeepEquals, updates)
+}
+
+func (s *S) TestListDeliveriesInvalidLimit(c *check.C) {
+	recorder, request := get("/webhook/5a1b2c3d4e5f60718293a4b5/deliveries?limit=none", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestCheckConsistency(c *check.C) {
+	oldBare, _ := config.GetString("git:bare:location")
+	config.Set("git:bare:location", c.MkDir())
+	defer config.Set("git:bare:location", oldBare)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "lost", Users: []string{"nobody"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("lost")
+	recorder, request := get("/admin/consistency", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var report consistency.Report
+	err = json.Unmarshal(recorder.Body.Bytes(), &report)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.MissingBare, check.DeepEquals, []string{"lost"})
+	c.Assert(report.MissingUsers, check.DeepEquals, []consistency.UserReference{{Repository: "lost", User: "nobody"}})
+	c.Assert(report.Fixed, check.Equals, false)
+}
+
+func (s *S) TestFixConsistency(c *check.C) {
+	oldBare, _ := config.GetString("git:bare:location")
+	config.Set("git:bare:location", c.MkDir())
+	defer config.Set("git:bare:location", oldBare)
+	config.Set("authorized-keys-command", true)
+	defer config.Unset("authorized-keys-command")
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Audit().RemoveAll(nil)
+	err = conn.Key().Insert(&user.Key{Name: "laptop", UserName: "nobody", Body: rawKey})
+	c.Assert(err, check.IsNil)
+	defer conn.Key().RemoveAll(bson.M{"username": "nobody"})
+	recorder, request := post("/admin/consistency", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var report consistency.Report
+	err = json.Unmarshal(recorder.Body.Bytes(), &report)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.OrphanKeys, check.DeepEquals, []consistency.KeyReference{{User: "nobody", Name: "laptop"}})
+	c.Assert(report.Fixed, check.Equals, true)
+	count, err := conn.Key().Find(bson.M{"username": "nobody"}).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 0)
+	entries, err := audit.List(audit.Filter{Action: audit.ConsistencyFix})
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 1)
+	c.Assert(entries[0].Details, check.Equals, "1 problems fixed")
+}
+
+func (s *S) TestUpdateRepositoryRename(c *check.C) {
+	r, err := repository.New("shire", []string{"bilbo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Repository().RemoveId("bag-end")
+	defer conn.Redirect().RemoveId(r.Name)
+	recorder, request := put("/repository/shire", strings.NewReader(`{"name": "bag-end"}`), c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	recorder, request = get("/repository/shire", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var data map[string]interface{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &data)
+	c.Assert(err, check.IsNil)
+	c.Assert(data["name"], check.Equals, "bag-end")
+}
+
+func (s *S) TestRepositoryEndpointsResolveFormerNames(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "bag-end", Users: []string{"bilbo"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("bag-end")
+	now := time.Now().UTC()
+	err = conn.Redirect().Insert(&repository.Redirect{Name: "shire", Repository: "bag-end", Created: now, Expires: now.Add(time.Hour)})
+	c.Assert(err, check.IsNil)
+	defer conn.Redirect().RemoveId("shire")
+	recorder, request := post("/repository/shire/members", strings.NewReader(`{"user": "frodo", "role": "maintainer"}`), c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	recorder, request = get("/repository/shire/members", nil, c)
+	request.Header.Set("X-Gandalf-User", "bilbo")
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	repo, err := repository.Get("bag-end")
+	c.Assert(err, check.IsNil)
+	c.Assert(repo.Maintainers, check.DeepEquals, []string{"frodo"})
+	_, err = repository.Get("shire")
+	c.Assert(err, check.Equals, repository.ErrRepositoryNotFound)
+}
+
+func (s *S) TestUpdateRepositoryRenameInvalidName(c *check.C) {
+	r, err := repository.New("shire", []string{"bilbo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Repository().RemoveId(r.Name)
+	recorder, request := put("/repository/shire", strings.NewReader(`{"name": "../bag-end"}`), c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "repository name is not valid\n")
+}
+
+func (s *S) TestUpdateRepositoryRenameAlreadyExists(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	for _, name := range []string{"shire", "bag-end"} {
+		err = conn.Repository().Insert(&repository.Repository{Name: name, Users: []string{"bilbo"}})
+		c.Assert(err, check.IsNil)
+		defer conn.Repository().RemoveId(name)
+	}
+	recorder, request := put("/repository/shire", strings.NewReader(`{"name": "bag-end"}`), c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusConflict)
+}
+
+func (s *S) TestRestoreRepository(c *check.C) {
+	_, err := repository.New("shire", []string{"bilbo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Audit().RemoveAll(nil)
+	defer conn.DeletedRepository().RemoveAll(nil)
+	recorder, request := del("/repository/shire", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	recorder, request = post("/repository/shire/restore", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "Repository \"shire\" successfully restored\n")
+	defer conn.Repository().RemoveId("shire")
+	repo, err := repository.Get("shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(repo.Users, check.DeepEquals, []string{"bilbo"})
+	entries, err := audit.List(audit.Filter{Action: audit.RepositoryRestore})
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 1)
+}
+
+func (s *S) TestRestoreRepositoryNotFound(c *check.C) {
+	recorder, request := post("/repository/mordor/restore", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+	c.Assert(recorder.Body.String(), check.Equals, "deleted repository not found\n")
+}
+
+func (s *S) TestRestoreRepositoryRequiresAdminRole(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.DeletedRepository().RemoveAll(nil)
+	deleted := repository.DeletedRepository{
+		ID:         bson.NewObjectId(),
+		Repository: repository.Repository{Name: "shire", Users: []string{"bilbo"}, Admins: []string{"gandalf"}},
+		Deleted:    time.Now(),
+	}
+	err = conn.DeletedRepository().Insert(&deleted)
+	c.Assert(err, check.IsNil)
+	recorder, request := post("/repository/shire/restore", nil, c)
+	request.Header.Set("X-Gandalf-User", "bilbo")
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	_, err = repository.Get("shire")
+	c.Assert(err, check.Equals, repository.ErrRepositoryNotFound)
+}
+
+func (s *S) TestListRepositories(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	for _, repo := range []repository.Repository{
+		{Name: "team/myapp", Users: []string{"r2d2"}},
+		{Name: "team/yourapp", ReadOnlyUsers: []string{"r2d2"}, IsPublic: true},
+		{Name: "other", Users: []string{"c3po"}},
+	} {
+		err = conn.Repository().Insert(repo)
+		c.Assert(err, check.IsNil)
+		defer conn.Repository().RemoveId(repo.Name)
+	}
+	recorder, request := get("/repository?user=r2d2&limit=1", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var page struct {
+		Repositories []map[string]interface{}
+		Next         string
+	}
+	err = json.Unmarshal(recorder.Body.Bytes(), &page)
+	c.Assert(err, check.IsNil)
+	c.Assert(page.Repositories, check.HasLen, 1)
+	c.Assert(page.Repositories[0]["name"], check.Equals, "team/myapp")
+	c.Assert(page.Next, check.Not(check.Equals), "")
+	recorder, request = get("/repository?user=r2d2&limit=1&cursor="+page.Next, nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	page.Repositories = nil
+	err = json.Unmarshal(recorder.Body.Bytes(), &page)
+	c.Assert(err, check.IsNil)
+	c.Assert(page.Repositories, check.HasLen, 1)
+	c.Assert(page.Repositories[0]["name"], check.Equals, "team/yourapp")
+	c.Assert(page.Next, check.Equals, "")
+	recorder, request = get("/repository?namespace=team&public=false&search=APP", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	page.Repositories = nil
+	err = json.Unmarshal(recorder.Body.Bytes(), &page)
+	c.Assert(err, check.IsNil)
+	c.Assert(page.Repositories, check.HasLen, 1)
+	c.Assert(page.Repositories[0]["name"], check.Equals, "team/myapp")
+}
+
+func (s *S) TestListRepositoriesInvalidParameters(c *check.C) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{"limit=0", "Invalid limit: 0\n"},
+		{"public=maybe", "Invalid value for public, expected true or false: maybe\n"},
+		{"sort=size", "invalid sort \"size\", valid options are: name, -name, disk_usage, -disk_usage\n"},
+		{"cursor=invalid!", "invalid cursor\n"},
+	}
+	for _, t := range tests {
+		recorder, request := get("/repository?"+t.query, nil, c)
+		s.router.ServeHTTP(recorder, request)
+		c.Check(recorder.Code, check.Equals, http.StatusBadRequest)
+		c.Check(recorder.Body.String(), check.Equals, t.expected)
+	}
+}
+
+func (s *S) TestGetUser(c *check.C) {
+	_, err := user.New("frodo", map[string]string{"somekey": rawKey})
+	c.Assert(err, check.IsNil)
+	defer user.Remove("frodo")
+	recorder, request := get("/user/frodo", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var got map[string]interface{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &got)
+	c.Assert(err, check.IsNil)
+	c.Assert(got["name"], check.Equals, "frodo")
+	c.Assert(got["keys"], check.Equals, float64(1))
+	c.Assert(got["created"], check.NotNil)
+}
+
+func (s *S) TestGetUserNotFound(c *check.C) {
+	recorder, request := get("/user/gollum", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+	c.Assert(recorder.Body.String(), check.Equals, "user not found\n")
+}
+
+func (s *S) TestListUsers(c *check.C) {
+	for _, name := range []string{"frodo", "bilbo", "sam"} {
+		_, err := user.New(name, nil)
+		c.Assert(err, check.IsNil)
+		defer user.Remove(name)
+	}
+	recorder, request := get("/user?search=o&limit=1", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var page struct {
+		Users []map[string]interface{}
+		Next  string
+	}
+	err := json.Unmarshal(recorder.Body.Bytes(), &page)
+	c.Assert(err, check.IsNil)
+	c.Assert(page.Users, check.HasLen, 1)
+	c.Assert(page.Users[0]["name"], check.Equals, "bilbo")
+	c.Assert(page.Next, check.Not(check.Equals), "")
+	recorder, request = get("/user?search=o&limit=1&cursor="+page.Next, nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	page.Users = nil
+	err = json.Unmarshal(recorder.Body.Bytes(), &page)
+	c.Assert(err, check.IsNil)
+	c.Assert(page.Users, check.HasLen, 1)
+	c.Assert(page.Users[0]["name"], check.Equals, "frodo")
+	c.Assert(page.Next, check.Equals, "")
+}
+
+func (s *S) TestListUsersInvalidParameters(c *check.C) {
+	recorder, request := get("/user?limit=none", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "Invalid limit: none\n")
+	recorder, request = get("/user?cursor=invalid!", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "invalid cursor\n")
+}
+
+func (s *S) TestListUserRepositories(c *check.C) {
+	_, err := user.New("frodo", nil)
+	c.Assert(err, check.IsNil)
+	defer user.Remove("frodo")
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	for _, repo := range []repository.Repository{
+		{Name: "shire", Users: []string{"frodo"}},
+		{Name: "rivendell", ReadOnlyUsers: []string{"frodo"}},
+		{Name: "mordor", Users: []string{"sauron"}},
+	} {
+		err = conn.Repository().Insert(repo)
+		c.Assert(err, check.IsNil)
+		defer conn.Repository().RemoveId(repo.Name)
+	}
+	recorder, request := get("/user/frodo/repositories", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var page struct {
+		Repositories []map[string]interface{}
+		Next         string
+	}
+	err = json.Unmarshal(recorder.Body.Bytes(), &page)
+	c.Assert(err, check.IsNil)
+	c.Assert(page.Repositories, check.HasLen, 2)
+	c.Assert(page.Repositories[0]["name"], check.Equals, "rivendell")
+	c.Assert(page.Repositories[1]["name"], check.Equals, "shire")
+	c.Assert(page.Next, check.Equals, "")
+}
+
+func (s *S) TestListUserRepositoriesUserNotFound(c *check.C) {
+	recorder, request := get("/user/gollum/repositories", nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestRenameUser(c *check.C) {
+	_, err := user.New("frodo", nil)
+	c.Assert(err, check.IsNil)
+	defer user.Remove("ringbearer")
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&repository.Repository{Name: "shire", Users: []string{"frodo"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("shire")
+	recorder, request := put("/user/frodo", strings.NewReader(`{"name": "ringbearer"}`), c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "User \"frodo\" successfully renamed to \"ringbearer\"\n")
+	_, err = user.Get("ringbearer")
+	c.Assert(err, check.IsNil)
+	repo, err := repository.Get("shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(repo.Users, check.DeepEquals, []string{"ringbearer"})
+}
+
+func (s *S) TestRenameUserErrors(c *check.C) {
+	_, err := user.New("frodo", nil)
+	c.Assert(err, check.IsNil)
+	defer user.Remove("frodo")
+	_, err = user.New("sam", nil)
+	c.Assert(err, check.IsNil)
+	defer user.Remove("sam")
+	tests := []struct {
+		url    string
+		body   string
+		status int
+	}{
+		{"/user/gollum", `{"name": "smeagol"}`, http.StatusNotFound},
+		{"/user/frodo", `{"name": "sam"}`, http.StatusConflict},
+		{"/user/frodo", `{"name": "ring bearer"}`, http.StatusBadRequest},
+		{"/user/frodo", `not json`, http.StatusBadRequest},
+	}
+	for _, t := range tests {
+		recorder, request := put(t.url, strings.NewReader(t.body), c)
+		s.router.ServeHTTP(recorder, request)
+		c.Check(recorder.Code, check.Equals, t.status, check.Commentf("%s %s", t.url, t.body))
+	}
+}
+
+func (s *S) TestRepositoryRoutes(c *check.C) {
+	routes := []struct {
+		method  string
+		path    string
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"DELETE", "/repository/team/members", "team", removeMember},
+		{"DELETE", "/repository/team/membership", "team/membership", removeRepository},
+		{"GET", "/repository/team/tags", "team", getTags},
+		{"GET", "/repository/team/tagsmith", "team/tagsmith", getRepository},
+		{"PUT", "/repository/team/quotas", "team/quotas", updateRepository},
+		{"GET", "/repository/team/myapp/members", "team/myapp", getMembers},
+		{"GET", "/repository/team/myapp/diff/commits", "team/myapp", getDiff},
+		{"GET", "/repository/team/myapp.git/info/refs", "team/myapp", gitInfoRefs},
+	}
+	for _, r := range routes {
+		request, err := http.NewRequest(r.method, r.path, nil)
+		c.Assert(err, check.IsNil)
+		var match mux.RouteMatch
+		c.Assert(s.router.Match(request, &match), check.Equals, true, check.Commentf("%s %s", r.method, r.path))
+		c.Check(match.Vars["name"], check.Equals, r.name, check.Commentf("%s %s", r.method, r.path))
+		c.Check(reflect.ValueOf(match.Handler).Pointer(), check.Equals, reflect.ValueOf(r.handler).Pointer(), check.Commentf("%s %s", r.method, r.path))
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/lfs"
+	"github.com/tsuru/gandalf/repository"
+	"github.com/tsuru/tsuru/log"
+)
+
+// lfsServices maps the LFS operations to the git service requiring the same
+// permission.
+var lfsServices = map[string]string{
+	lfs.OperationDownload: "git-upload-pack",
+	lfs.OperationUpload:   "git-receive-pack",
+}
+
+func lfsError(w http.ResponseWriter, message string, status int) {
+	w.Header().Set("Content-Type", lfs.MediaType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+// authorizeLFS finds the requested repository and checks whether the user
+// identified by the basic auth credentials may run the given LFS operation on
+// it. Clients connecting through SSH send the token created by
+// git-lfs-authenticate as password, the other ones are authorized just like
+// git clients, see authorizeGit.
+//
+// In case of failure, the response is written and an error is returned.
+func authorizeLFS(w http.ResponseWriter, r *http.Request, operation string) (*repository.Repository, string, error) {
+	service := lfsServices[operation]
+	name := r.URL.Query().Get(":name")
+	userName, password, _ := r.BasicAuth()
+	repo, err := repository.Resolve(name)
+	if err == nil {
+		name = repo.Name
+	}
+	if userName == "" || lfs.CheckToken(password, userName, name, operation) != nil {
+		return authorizeGit(w, r, service)
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == repository.ErrRepositoryNotFound {
+			status = http.StatusNotFound
+		}
+		lfsError(w, err.Error(), status)
+		return nil, "", err
+	}
+	if !gitServices[service](&repo, userName) {
+		err = fmt.Errorf("User %q does not have access to LFS %s on repository %q", userName, operation, repo.Name)
+		lfsError(w, err.Error(), http.StatusForbidden)
+		return nil, "", err
+	}
+	return &repo, userName, nil
+}
+
+// lfsURL returns the URL of the LFS API of the given repository, built from
+// the "lfs:url" setting or, when it's not defined, from the request.
+func lfsURL(r *http.Request, repo string) string {
+	base, err := config.GetString("lfs:url")
+	if err != nil {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		base = scheme + "://" + r.Host
+	}
+	return strings.TrimRight(base, "/") + "/repository/" + repo + ".git/info/lfs"
+}
+
+func lfsBatch(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var req lfs.BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		lfsError(w, "Could not parse json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !lfs.ValidOperation(req.Operation) {
+		lfsError(w, lfs.ErrInvalidOperation.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	repo, _, err := authorizeLFS(w, r, req.Operation)
+	if err != nil {
+		return
+	}
+	header := map[string]string{}
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		header["Authorization"] = authorization
+	}
+	resp, err := lfs.Batch(repo.Name, &req, lfsURL(r, repo.Name), header)
+	if err == lfs.ErrUnsupportedTransfer {
+		lfsError(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		lfsError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", lfs.MediaType)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func lfsDownload(w http.ResponseWriter, r *http.Request) {
+	repo, _, err := authorizeLFS(w, r, lfs.OperationDownload)
+	if err != nil {
+		return
+	}
+	file, err := lfs.Open(repo.Name, r.URL.Query().Get(":oid"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err {
+		case lfs.ErrObjectNotFound:
+			status = http.StatusNotFound
+		case lfs.ErrInvalidOid:
+			status = http.StatusUnprocessableEntity
+		}
+		lfsError(w, err.Error(), status)
+		return
+	}
+	defer file.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if _, err = io.Copy(w, file); err != nil {
+		log.Errorf("Error sending LFS object of repository %q: %s", repo.Name, err)
+	}
+}
+
+func lfsUpload(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	repo, _, err := authorizeLFS(w, r, lfs.OperationUpload)
+	if err != nil {
+		return
+	}
+	if err = lfs.Store(repo.Name, r.URL.Query().Get(":oid"), r.ContentLength, r.Body); err != nil {
+		status := http.StatusInternalServerError
+		if _, ok := err.(*lfs.InvalidObjectError); ok || err == lfs.ErrInvalidOid {
+			status = http.StatusUnprocessableEntity
+		}
+		lfsError(w, err.Error(), status)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,118 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/lfs"
+	"github.com/tsuru/gandalf/repository"
+	"gopkg.in/check.v1"
+)
+
+const (
+	lfsContent = "some content"
+	lfsOid     = "290f493c44f5d63d06b374d0a5abd292fae38b92cab2fae5efefe1b0e9347f56"
+)
+
+func (s *S) TestLFSBatchDownload(c *check.C) {
+	cleanup := s.insertGitFixtures(c, repository.Repository{Name: "lfsrepo", ReadOnlyUsers: []string{"bob"}}, "bob")
+	defer cleanup()
+	token, revoke := s.gitToken(c, "bob")
+	defer revoke()
+	err := lfs.Store("lfsrepo", lfsOid, -1, strings.NewReader(lfsContent))
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader(`{"operation": "download", "objects": [{"oid": "` + lfsOid + `", "size": 12}]}`)
+	recorder, request := post("/repository/lfsrepo.git/info/lfs/objects/batch", body, c)
+	request.Host = "gandalf.example.com"
+	request.SetBasicAuth("bob", token)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, lfs.MediaType)
+	var resp lfs.BatchResponse
+	err = json.Unmarshal(recorder.Body.Bytes(), &resp)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.Objects, check.HasLen, 1)
+	action := resp.Objects[0].Actions["download"]
+	c.Assert(action.Href, check.Equals, "http://gandalf.example.com/repository/lfsrepo.git/info/lfs/objects/"+lfsOid)
+	c.Assert(action.Header["Authorization"], check.Equals, request.Header.Get("Authorization"))
+}
+
+func (s *S) TestLFSBatchUploadRequiresWritePermission(c *check.C) {
+	cleanup := s.insertGitFixtures(c, repository.Repository{Name: "lfsrepo", ReadOnlyUsers: []string{"bob"}}, "bob")
+	defer cleanup()
+	token, revoke := s.gitToken(c, "bob")
+	defer revoke()
+	body := strings.NewReader(`{"operation": "upload", "objects": [{"oid": "` + lfsOid + `", "size": 12}]}`)
+	recorder, request := post("/repository/lfsrepo.git/info/lfs/objects/batch", body, c)
+	request.SetBasicAuth("bob", token)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestLFSBatchInvalidOperation(c *check.C) {
+	body := strings.NewReader(`{"operation": "verify", "objects": []}`)
+	recorder, request := post("/repository/lfsrepo.git/info/lfs/objects/batch", body, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusUnprocessableEntity)
+	c.Assert(recorder.Body.String(), check.Equals, `{"message":"invalid operation, valid options are: upload or download"}`+"\n")
+}
+
+func (s *S) TestLFSUploadAndDownload(c *check.C) {
+	cleanup := s.insertGitFixtures(c, repository.Repository{Name: "lfsrepo", Users: []string{"bob"}}, "bob")
+	defer cleanup()
+	token, revoke := s.gitToken(c, "bob")
+	defer revoke()
+	recorder, request := put("/repository/lfsrepo.git/info/lfs/objects/"+lfsOid, strings.NewReader(lfsContent), c)
+	request.SetBasicAuth("bob", token)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	recorder, request = get("/repository/lfsrepo.git/info/lfs/objects/"+lfsOid, nil, c)
+	request.SetBasicAuth("bob", token)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, lfsContent)
+}
+
+func (s *S) TestLFSUploadInvalidContent(c *check.C) {
+	cleanup := s.insertGitFixtures(c, repository.Repository{Name: "lfsrepo", Users: []string{"bob"}}, "bob")
+	defer cleanup()
+	token, revoke := s.gitToken(c, "bob")
+	defer revoke()
+	recorder, request := put("/repository/lfsrepo.git/info/lfs/objects/"+lfsOid, strings.NewReader("other content"), c)
+	request.SetBasicAuth("bob", token)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusUnprocessableEntity)
+}
+
+func (s *S) TestLFSDownloadNotFound(c *check.C) {
+	cleanup := s.insertGitFixtures(c, repository.Repository{Name: "lfsrepo", IsPublic: true})
+	defer cleanup()
+	recorder, request := get("/repository/lfsrepo.git/info/lfs/objects/"+lfsOid, nil, c)
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestLFSWithSSHToken(c *check.C) {
+	config.Set("auth:enabled", true)
+	defer config.Unset("auth:enabled")
+	config.Set("lfs:url", "https://gandalf.example.com")
+	defer config.Unset("lfs:url")
+	cleanup := s.insertGitFixtures(c, repository.Repository{Name: "lfsrepo", Users: []string{"bob"}}, "bob")
+	defer cleanup()
+	a, err := lfs.Authenticate("bob", "lfsrepo", lfs.OperationUpload)
+	c.Assert(err, check.IsNil)
+	recorder, request := put("/repository/lfsrepo.git/info/lfs/objects/"+lfsOid, strings.NewReader(lfsContent), c)
+	request.Header.Set("Authorization", a.Header["Authorization"])
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	recorder, request = get("/repository/lfsrepo.git/info/lfs/objects/"+lfsOid, nil, c)
+	request.SetBasicAuth("bob", "invalid")
+	s.router.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusUnauthorized)
+}
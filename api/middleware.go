@@ -5,12 +5,20 @@
 package api
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/codegangsta/negroni"
+	"github.com/tsuru/gandalf/audit"
+	"github.com/tsuru/gandalf/auth"
+	"github.com/tsuru/gandalf/repository"
 )
 
 type loggerMiddleware struct {
@@ -49,3 +57,180 @@ func (m *responseHeaderMiddleware) ServeHTTP(rw http.ResponseWriter, r *http.Req
 	rw.Header().Set(m.name, m.value)
 	next(rw, r)
 }
+
+// adminPaths lists the path prefixes that require a token with the admin
+// scope, regardless of the method. Hooks run scripts on the server and
+// webhooks make it send requests to any URL, so they are managed by admins
+// only.
+var adminPaths = []string{"/token", "/audit", "/admin", "/hook", "/webhook"}
+
+type contextKey string
+
+// clientKey is the key of the authenticated client name in the request
+// context.
+const clientKey = contextKey("client")
+
+// requestActor returns the name of the API client that sent the request. It's
+// the name of the token used in the request, or the remote address when
+// authentication is disabled.
+func requestActor(r *http.Request) string {
+	if client, ok := r.Context().Value(clientKey).(string); ok {
+		return client
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// recordAudit records the entry of an operation requested through the API,
+// setting the API client as its actor, along with the user identified by the
+// X-Gandalf-User header, if any.
+func recordAudit(r *http.Request, e audit.Entry) {
+	e.Actor = requestActor(r)
+	e.User = r.Header.Get(userHeader)
+	audit.Record(e)
+}
+
+// userHeader is the header identifying the gandalf user on behalf of whom an
+// API client sends the request.
+const userHeader = "X-Gandalf-User"
+
+// authorizeRepository checks whether the user identified by the X-Gandalf-User
+// header has the given role in the repository. Public repositories may be
+// read by any user. Requests without the header are made by the API client
+// on its own behalf, and are always authorized.
+//
+// In case of failure, the response is written and false is returned.
+func authorizeRepository(w http.ResponseWriter, r *http.Request, name, role string) bool {
+	userName := r.Header.Get(userHeader)
+	if userName == "" {
+		return true
+	}
+	repo, err := repository.Get(name)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == repository.ErrRepositoryNotFound {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return false
+	}
+	allowed := repo.HasRole(userName, role)
+	if role == repository.RoleRead {
+		allowed = repo.HasReadPermission(userName)
+	}
+	if !allowed {
+		http.Error(w, fmt.Sprintf("User %q does not have the %s role in repository %q", userName, role, name), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// authorizeRepositories checks whether the user identified by the
+// X-Gandalf-User header has the admin role in all the given repositories. An
+// empty list stands for the settings shared by all repositories, which may
+// only be changed by API clients on their own behalf.
+//
+// In case of failure, the response is written and false is returned.
+func authorizeRepositories(w http.ResponseWriter, r *http.Request, repos []string) bool {
+	if len(repos) == 0 && r.Header.Get(userHeader) != "" {
+		http.Error(w, "Settings of all repositories can not be changed on behalf of users", http.StatusForbidden)
+		return false
+	}
+	for _, name := range repos {
+		if !authorizeRepository(w, r, name, repository.RoleAdmin) {
+			return false
+		}
+	}
+	return true
+}
+
+// repositoryList returns a list with the given repository, or an empty list
+// when the name is empty.
+func repositoryList(name string) []string {
+	if name == "" {
+		return nil
+	}
+	return []string{name}
+}
+
+// gitRoutes lists the routes of the git smart HTTP transport and of the LFS
+// API, which are authenticated by the git handlers themselves. Paths must
+// match them exactly, as routes are matched by prefix.
+var gitRoutes = []struct {
+	method string
+	path   *regexp.Regexp
+}{
+	{"GET", regexp.MustCompile(`^/repository/[^/]*/?[^/]+\.git/info/refs$`)},
+	{"POST", regexp.MustCompile(`^/repository/[^/]*/?[^/]+\.git/git-(upload|receive)-pack$`)},
+	{"POST", regexp.MustCompile(`^/repository/[^/]*/?[^/]+\.git/info/lfs/objects/batch$`)},
+	{"GET", regexp.MustCompile(`^/repository/[^/]*/?[^/]+\.git/info/lfs/objects/[^/]+$`)},
+	{"PUT", regexp.MustCompile(`^/repository/[^/]*/?[^/]+\.git/info/lfs/objects/[^/]+$`)},
+}
+
+func isGitRequest(r *http.Request) bool {
+	for _, route := range gitRoutes {
+		if r.Method == route.method && route.path.MatchString(r.URL.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+type authMiddleware struct{}
+
+// NewAuthMiddleware returns a middleware that requires a bearer token in
+// every request when the "auth:enabled" setting is true. Git requests over
+// HTTP are authenticated by the git handlers themselves, and tokens belonging
+// to users are only accepted there.
+func NewAuthMiddleware() *authMiddleware {
+	return &authMiddleware{}
+}
+
+func requiredScope(r *http.Request) string {
+	for _, prefix := range adminPaths {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return auth.AdminScope
+		}
+	}
+	if r.Method == "GET" || r.Method == "HEAD" {
+		return auth.ReadScope
+	}
+	return auth.WriteScope
+}
+
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+func (m *authMiddleware) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if !auth.Enabled() || r.URL.Path == "/healthcheck" || isGitRequest(r) {
+		next(rw, r)
+		return
+	}
+	t, err := auth.Authenticate(bearerToken(r))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == auth.ErrInvalidToken {
+			status = http.StatusUnauthorized
+			rw.Header().Set("WWW-Authenticate", `Bearer realm="gandalf"`)
+		}
+		http.Error(rw, err.Error(), status)
+		return
+	}
+	if t.User != "" {
+		http.Error(rw, fmt.Sprintf("token %s belongs to user %q and may only be used for git over HTTP", t.Name, t.User), http.StatusForbidden)
+		return
+	}
+	if scope := requiredScope(r); !t.Allows(scope) {
+		http.Error(rw, "token "+t.Name+" does not have the "+scope+" scope", http.StatusForbidden)
+		return
+	}
+	next(rw, r.WithContext(context.WithValue(r.Context(), clientKey, t.Name)))
+}
@@ -6,6 +6,7 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -13,6 +14,9 @@ import (
 	"time"
 
 	"github.com/codegangsta/negroni"
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/auth"
+	"github.com/tsuru/gandalf/repository"
 	"gopkg.in/check.v1"
 )
 
@@ -53,3 +57,213 @@ func (s *S) TestResponseHeaderMiddlewareDuplicate(c *check.C) {
 	}))
 	c.Assert(recorder.Header().Get("Server"), check.Equals, "mini-server/0.1")
 }
+
+func (s *S) TestAuthMiddlewareDisabled(c *check.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("DELETE", "/repository/myrepo", nil)
+	c.Assert(err, check.IsNil)
+	var called bool
+	middle := NewAuthMiddleware()
+	middle.ServeHTTP(negroni.NewResponseWriter(recorder), request, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	c.Assert(called, check.Equals, true)
+}
+
+func (s *S) TestAuthMiddlewareMissingToken(c *check.C) {
+	config.Set("auth:enabled", true)
+	defer config.Unset("auth:enabled")
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("DELETE", "/repository/myrepo", nil)
+	c.Assert(err, check.IsNil)
+	var called bool
+	middle := NewAuthMiddleware()
+	middle.ServeHTTP(negroni.NewResponseWriter(recorder), request, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	c.Assert(called, check.Equals, false)
+	c.Assert(recorder.Code, check.Equals, http.StatusUnauthorized)
+	c.Assert(recorder.Header().Get("WWW-Authenticate"), check.Equals, `Bearer realm="gandalf"`)
+}
+
+func (s *S) TestAuthMiddlewareAdminToken(c *check.C) {
+	config.Set("auth:enabled", true)
+	config.Set("auth:admin-token", "s3cr3t")
+	defer config.Unset("auth:enabled")
+	defer config.Unset("auth:admin-token")
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/token", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer s3cr3t")
+	var called bool
+	middle := NewAuthMiddleware()
+	middle.ServeHTTP(negroni.NewResponseWriter(recorder), request, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	c.Assert(called, check.Equals, true)
+}
+
+func (s *S) TestAuthMiddlewareSkipsHealthcheckAndGit(c *check.C) {
+	config.Set("auth:enabled", true)
+	defer config.Unset("auth:enabled")
+	for _, path := range []string{"/healthcheck", "/repository/myrepo.git/info/refs"} {
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest("GET", path, nil)
+		c.Assert(err, check.IsNil)
+		var called bool
+		middle := NewAuthMiddleware()
+		middle.ServeHTTP(negroni.NewResponseWriter(recorder), request, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		}))
+		c.Assert(called, check.Equals, true)
+	}
+}
+
+func (s *S) TestAuthMiddlewareGitSuffixedPaths(c *check.C) {
+	config.Set("auth:enabled", true)
+	defer config.Unset("auth:enabled")
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{"DELETE", "/user/alice/.git/"},
+		{"POST", "/admin/keys/sync/.git/"},
+		{"GET", "/audit/.git/info/refs"},
+		{"POST", "/repository/myrepo.git/info/refs"},
+		{"DELETE", "/repository/myrepo.git/info/lfs/objects/abc"},
+		{"GET", "/repository/myrepo.git/info/refs/extra"},
+	}
+	for _, t := range tests {
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest(t.method, t.path, nil)
+		c.Assert(err, check.IsNil)
+		var called bool
+		middle := NewAuthMiddleware()
+		middle.ServeHTTP(negroni.NewResponseWriter(recorder), request, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		}))
+		c.Check(called, check.Equals, false, check.Commentf("%s %s", t.method, t.path))
+		c.Check(recorder.Code, check.Equals, http.StatusUnauthorized, check.Commentf("%s %s", t.method, t.path))
+	}
+}
+
+func (s *S) TestIsGitRequest(c *check.C) {
+	tests := []struct {
+		method   string
+		path     string
+		expected bool
+	}{
+		{"GET", "/repository/myrepo.git/info/refs", true},
+		{"GET", "/repository/ns/myrepo.git/info/refs", true},
+		{"POST", "/repository/myrepo.git/git-upload-pack", true},
+		{"POST", "/repository/myrepo.git/git-receive-pack", true},
+		{"POST", "/repository/myrepo.git/info/lfs/objects/batch", true},
+		{"GET", "/repository/myrepo.git/info/lfs/objects/290f493c", true},
+		{"PUT", "/repository/myrepo.git/info/lfs/objects/290f493c", true},
+		{"DELETE", "/user/alice/.git/", false},
+		{"POST", "/admin/keys/sync/.git/", false},
+		{"POST", "/repository/myrepo.git/info/refs", false},
+		{"GET", "/repository/a/b/c.git/info/refs", false},
+		{"GET", "/repository/myrepo.git/info/refs/extra", false},
+	}
+	for _, t := range tests {
+		request, err := http.NewRequest(t.method, t.path, nil)
+		c.Assert(err, check.IsNil)
+		c.Check(isGitRequest(request), check.Equals, t.expected, check.Commentf("%s %s", t.method, t.path))
+	}
+}
+
+func (s *S) TestAuthMiddlewareScopeNotAllowed(c *check.C) {
+	config.Set("auth:enabled", true)
+	defer config.Unset("auth:enabled")
+	_, raw, err := auth.New("readonly-client", []string{auth.ReadScope})
+	c.Assert(err, check.IsNil)
+	defer auth.Revoke("readonly-client")
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("DELETE", "/repository/myrepo", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "Bearer "+raw)
+	var called bool
+	middle := NewAuthMiddleware()
+	middle.ServeHTTP(negroni.NewResponseWriter(recorder), request, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	c.Assert(called, check.Equals, false)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestAuthMiddlewareRejectsUserToken(c *check.C) {
+	config.Set("auth:enabled", true)
+	defer config.Unset("auth:enabled")
+	r, err := repository.New("bobrepo", []string{"bob"}, nil, false)
+	c.Assert(err, check.IsNil)
+	defer repository.Remove(r.Name)
+	_, raw, err := auth.NewUserToken("alice-laptop", "alice", []string{auth.WriteScope})
+	c.Assert(err, check.IsNil)
+	defer auth.Revoke("alice-laptop")
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("DELETE", "/repository/bobrepo", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "Bearer "+raw)
+	n := negroni.New()
+	n.Use(NewAuthMiddleware())
+	n.UseHandler(s.router)
+	n.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	_, err = repository.Get("bobrepo")
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestRequiredScope(c *check.C) {
+	var tests = []struct {
+		method string
+		path   string
+		scope  string
+	}{
+		{"GET", "/repository/myrepo", auth.ReadScope},
+		{"POST", "/repository", auth.WriteScope},
+		{"DELETE", "/user/myuser", auth.WriteScope},
+		{"GET", "/token", auth.AdminScope},
+		{"DELETE", "/token/tsuru", auth.AdminScope},
+		{"GET", "/audit", auth.AdminScope},
+		{"POST", "/admin/keys/sync", auth.AdminScope},
+		{"POST", "/hook/pre-receive", auth.AdminScope},
+		{"GET", "/hook", auth.AdminScope},
+		{"POST", "/webhook", auth.AdminScope},
+		{"DELETE", "/webhook/abc", auth.AdminScope},
+		{"GET", "/repository/myrepo/hooks", auth.ReadScope},
+	}
+	for _, t := range tests {
+		request, err := http.NewRequest(t.method, t.path, nil)
+		c.Assert(err, check.IsNil)
+		c.Check(requiredScope(request), check.Equals, t.scope)
+	}
+}
+
+func (s *S) TestRequestActor(c *check.C) {
+	request, err := http.NewRequest("GET", "/repository/myrepo", nil)
+	c.Assert(err, check.IsNil)
+	request.RemoteAddr = "10.0.0.2:43210"
+	c.Assert(requestActor(request), check.Equals, "10.0.0.2")
+	request = request.WithContext(context.WithValue(request.Context(), clientKey, "tsuru"))
+	c.Assert(requestActor(request), check.Equals, "tsuru")
+	request.Header.Set(userHeader, "alice")
+	c.Assert(requestActor(request), check.Equals, "tsuru")
+}
+
+func (s *S) TestAuthMiddlewareSetsClient(c *check.C) {
+	config.Set("auth:enabled", true)
+	config.Set("auth:admin-token", "s3cr3t")
+	defer config.Unset("auth:enabled")
+	defer config.Unset("auth:admin-token")
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("DELETE", "/repository/myrepo", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "Bearer s3cr3t")
+	var actor string
+	middle := NewAuthMiddleware()
+	middle.ServeHTTP(negroni.NewResponseWriter(recorder), request, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor = requestActor(r)
+	}))
+	c.Assert(actor, check.Equals, auth.AdminTokenName)
+}
@@ -5,6 +5,7 @@
 package api
 
 import (
+	"path/filepath"
 	"testing"
 
 	"github.com/globalsign/mgo/bson"
@@ -35,6 +36,8 @@ func (s *S) SetUpSuite(c *check.C) {
 	config.Set("database:name", "gandalf_api_tests")
 	s.tmpdir, err = commandmocker.Add("git", "")
 	c.Assert(err, check.IsNil)
+	// allows importing from the directories created by c.MkDir
+	config.Set("import:location", filepath.Dir(c.MkDir()))
 	s.router = SetupRouter()
 }
 
@@ -0,0 +1,141 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package audit keeps a persistent trail of the operations performed on
+// users, keys, repositories and hooks, and of git pushes and fetches.
+package audit
+
+import (
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/tsuru/log"
+)
+
+// Actions recorded in the audit log.
+const (
+	UserCreate        = "user.create"
+	UserRemove        = "user.remove"
+	UserRename        = "user.rename"
+	KeyAdd            = "key.add"
+	KeyUpdate         = "key.update"
+	KeyRemove         = "key.remove"
+	KeySync           = "key.sync"
+	ConsistencyFix    = "consistency.fix"
+	RepositoryCreate  = "repository.create"
+	RepositoryUpdate  = "repository.update"
+	RepositoryRemove  = "repository.remove"
+	RepositoryRestore = "repository.restore"
+	RepositoryFork    = "repository.fork"
+	RepositoryImport  = "repository.import"
+	RepositoryQuota   = "repository.quota"
+	MaintenanceStart  = "repository.maintenance"
+	AccessGrant       = "repository.grant"
+	AccessRevoke      = "repository.revoke"
+	MemberSet         = "member.set"
+	MemberRemove      = "member.remove"
+	ProtectionAdd     = "protection.add"
+	ProtectionRemove  = "protection.remove"
+	GroupCreate       = "group.create"
+	GroupRemove       = "group.remove"
+	GroupAddMembers   = "group.add-members"
+	GroupDelMembers   = "group.remove-members"
+	HookAdd           = "hook.add"
+	HookRemove        = "hook.remove"
+	HookApply         = "hook.apply"
+	WebhookAdd        = "webhook.add"
+	WebhookRemove     = "webhook.remove"
+	TokenCreate       = "token.create"
+	TokenRevoke       = "token.revoke"
+	GitPush           = "git.push"
+	GitFetch          = "git.fetch"
+)
+
+// DefaultLimit is the maximum number of entries returned by List when the
+// filter does not define a limit.
+const DefaultLimit = 100
+
+// Entry is a record of an operation. Actor identifies who performed the
+// operation: the API client, or the user for git operations. User is the user
+// on behalf of whom an API client performed the operation, if any.
+// Repositories and Users identify the targets of the operation.
+type Entry struct {
+	ID           bson.ObjectId `bson:"_id" json:"id"`
+	Action       string        `json:"action"`
+	Actor        string        `json:"actor"`
+	User         string        `bson:",omitempty" json:"user,omitempty"`
+	Repositories []string      `json:"repositories,omitempty"`
+	Users        []string      `json:"users,omitempty"`
+	Details      string        `json:"details,omitempty"`
+	Timestamp    time.Time     `json:"timestamp"`
+}
+
+// Filter defines the criteria for listing entries. Empty fields are ignored.
+// User matches the actor, the user on behalf of whom the operation was
+// performed and the target users of the entries.
+type Filter struct {
+	Repository string
+	User       string
+	Action     string
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+}
+
+func (f *Filter) query() bson.M {
+	query := bson.M{}
+	if f.Repository != "" {
+		query["repositories"] = f.Repository
+	}
+	if f.User != "" {
+		query["$or"] = []bson.M{{"actor": f.User}, {"user": f.User}, {"users": f.User}}
+	}
+	if f.Action != "" {
+		query["action"] = f.Action
+	}
+	timestamp := bson.M{}
+	if !f.Since.IsZero() {
+		timestamp["$gte"] = f.Since
+	}
+	if !f.Until.IsZero() {
+		timestamp["$lte"] = f.Until
+	}
+	if len(timestamp) > 0 {
+		query["timestamp"] = timestamp
+	}
+	return query
+}
+
+// Record stores the given entry, setting its id and timestamp. Failures are
+// logged and otherwise ignored, so they never prevent the operation itself.
+func Record(e Entry) {
+	e.ID = bson.NewObjectId()
+	e.Timestamp = time.Now().UTC()
+	conn, err := db.Conn()
+	if err != nil {
+		log.Errorf("audit: failed to record %s by %q: %s", e.Action, e.Actor, err)
+		return
+	}
+	defer conn.Close()
+	if err = conn.Audit().Insert(&e); err != nil {
+		log.Errorf("audit: failed to record %s by %q: %s", e.Action, e.Actor, err)
+	}
+}
+
+// List returns the entries matching the given filter, the most recent first.
+func List(f Filter) ([]Entry, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	entries := []Entry{}
+	err = conn.Audit().Find(f.query()).Sort("-timestamp", "-_id").Limit(limit).All(&entries)
+	return entries, err
+}
@@ -0,0 +1,109 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package audit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct{}
+
+var _ = check.Suite(&S{})
+
+func (s *S) SetUpSuite(c *check.C) {
+	err := config.ReadConfigFile("../etc/gandalf.conf")
+	c.Assert(err, check.IsNil)
+	config.Set("database:url", "127.0.0.1:27017")
+	config.Set("database:name", "gandalf_audit_tests")
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	conn.Audit().RemoveAll(nil)
+}
+
+func (s *S) TearDownSuite(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	conn.User().Database.DropDatabase()
+}
+
+func (s *S) TestFilterQuery(c *check.C) {
+	since := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := Filter{Repository: "myrepo", User: "bob", Action: GitPush, Since: since}
+	expected := bson.M{
+		"repositories": "myrepo",
+		"$or":          []bson.M{{"actor": "bob"}, {"user": "bob"}, {"users": "bob"}},
+		"action":       GitPush,
+		"timestamp":    bson.M{"$gte": since},
+	}
+	c.Assert(f.query(), check.DeepEquals, expected)
+}
+
+func (s *S) TestFilterQueryEmpty(c *check.C) {
+	f := Filter{}
+	c.Assert(f.query(), check.DeepEquals, bson.M{})
+}
+
+func (s *S) TestRecord(c *check.C) {
+	Record(Entry{Action: RepositoryCreate, Actor: "tsuru", Repositories: []string{"myrepo"}})
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	var entries []Entry
+	err = conn.Audit().Find(nil).All(&entries)
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 1)
+	c.Assert(entries[0].Action, check.Equals, RepositoryCreate)
+	c.Assert(entries[0].Actor, check.Equals, "tsuru")
+	c.Assert(entries[0].Repositories, check.DeepEquals, []string{"myrepo"})
+	c.Assert(entries[0].ID.Valid(), check.Equals, true)
+	c.Assert(time.Since(entries[0].Timestamp) < time.Minute, check.Equals, true)
+}
+
+func (s *S) TestListOnBehalfOfUser(c *check.C) {
+	Record(Entry{Action: RepositoryCreate, Actor: "tsuru", User: "alice", Repositories: []string{"myrepo"}})
+	Record(Entry{Action: RepositoryRemove, Actor: "tsuru", Repositories: []string{"otherrepo"}})
+	entries, err := List(Filter{User: "alice"})
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 1)
+	c.Assert(entries[0].Action, check.Equals, RepositoryCreate)
+	c.Assert(entries[0].Actor, check.Equals, "tsuru")
+	c.Assert(entries[0].User, check.Equals, "alice")
+}
+
+func (s *S) TestList(c *check.C) {
+	Record(Entry{Action: RepositoryCreate, Actor: "tsuru", Repositories: []string{"myrepo"}})
+	Record(Entry{Action: GitPush, Actor: "bob", Repositories: []string{"myrepo"}})
+	Record(Entry{Action: UserRemove, Actor: "tsuru", Users: []string{"bob"}})
+	Record(Entry{Action: GitPush, Actor: "alice", Repositories: []string{"otherrepo"}})
+	entries, err := List(Filter{Repository: "myrepo"})
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 2)
+	c.Assert(entries[0].Action, check.Equals, GitPush)
+	c.Assert(entries[1].Action, check.Equals, RepositoryCreate)
+	entries, err = List(Filter{User: "bob"})
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 2)
+	c.Assert(entries[0].Action, check.Equals, UserRemove)
+	entries, err = List(Filter{Action: GitPush, Limit: 1})
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 1)
+	c.Assert(entries[0].Actor, check.Equals, "alice")
+	entries, err = List(Filter{Until: time.Now().Add(-time.Hour)})
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 0)
+}
@@ -0,0 +1,223 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package auth manages the tokens used by clients of the gandalf API.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/tsuru/log"
+)
+
+// Scopes that may be granted to a token. Each scope includes the ones before
+// it: a token with the write scope may also read, and a token with the admin
+// scope may do anything.
+const (
+	ReadScope  = "read"
+	WriteScope = "write"
+	AdminScope = "admin"
+)
+
+// AdminTokenName is the name of the client authenticated by the token set in
+// the "auth:admin-token" setting.
+const AdminTokenName = "admin"
+
+var (
+	ErrTokenAlreadyExists = errors.New("token already exists")
+	ErrTokenNotFound      = errors.New("token not found")
+	ErrInvalidToken       = errors.New("invalid token")
+
+	scopeLevels = map[string]int{
+		ReadScope:  1,
+		WriteScope: 2,
+		AdminScope: 3,
+	}
+	tokenNameRegexp = regexp.MustCompile(`^[\w-+.@]+$`)
+)
+
+// Token identifies a client of the API. Only the SHA-256 hash of the token
+// is stored, the token itself is returned once, when it is created.
+//
+// A token may belong to a gandalf user, which is the only one it identifies
+// in git over HTTP, see IdentifiesUser.
+type Token struct {
+	Name      string    `bson:"_id" json:"name"`
+	Hash      string    `json:"-"`
+	Scopes    []string  `json:"scopes"`
+	User      string    `json:"user,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Enabled returns whether the API requires clients to authenticate.
+func Enabled() bool {
+	enabled, _ := config.GetBool("auth:enabled")
+	return enabled
+}
+
+func hash(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func generate() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// Allows returns whether the token may be used for operations that require
+// the given scope.
+func (t *Token) Allows(scope string) bool {
+	required, ok := scopeLevels[scope]
+	if !ok {
+		return false
+	}
+	for _, s := range t.Scopes {
+		if scopeLevels[s] >= required {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Token) isValid() (bool, error) {
+	if t.Name == AdminTokenName || !tokenNameRegexp.MatchString(t.Name) {
+		return false, &InvalidTokenError{message: "token name is not valid"}
+	}
+	if len(t.Scopes) == 0 {
+		return false, &InvalidTokenError{message: "token should have at least one scope"}
+	}
+	for _, s := range t.Scopes {
+		if _, ok := scopeLevels[s]; !ok {
+			return false, &InvalidTokenError{message: "invalid scope: " + s}
+		}
+	}
+	return true, nil
+}
+
+// IdentifiesUser returns whether the token may be used as the password of
+// the given user. Tokens with the admin scope act on behalf of any user, and
+// other tokens only identify the user they belong to.
+func (t *Token) IdentifiesUser(userName string) bool {
+	return t.Allows(AdminScope) || (t.User != "" && t.User == userName)
+}
+
+// New creates a token for the client with the given name and returns it,
+// along with the raw value that clients must send to authenticate.
+func New(name string, scopes []string) (*Token, string, error) {
+	return NewUserToken(name, "", scopes)
+}
+
+// NewUserToken creates a token belonging to the given user, see New.
+func NewUserToken(name, userName string, scopes []string) (*Token, string, error) {
+	log.Debugf("Creating token %q", name)
+	t := &Token{Name: name, Scopes: scopes, User: userName, CreatedAt: time.Now()}
+	if v, err := t.isValid(); !v {
+		log.Errorf("auth.New: Invalid token %q: %s", name, err)
+		return nil, "", err
+	}
+	raw, err := generate()
+	if err != nil {
+		return nil, "", err
+	}
+	t.Hash = hash(raw)
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, "", err
+	}
+	defer conn.Close()
+	if err = conn.Token().Insert(t); err != nil {
+		if mgo.IsDup(err) {
+			return nil, "", ErrTokenAlreadyExists
+		}
+		return nil, "", err
+	}
+	return t, raw, nil
+}
+
+// List returns all tokens.
+func List() ([]Token, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	tokens := []Token{}
+	err = conn.Token().Find(nil).Sort("_id").All(&tokens)
+	return tokens, err
+}
+
+// Revoke removes the token with the given name.
+func Revoke(name string) error {
+	log.Debugf("Revoking token %q", name)
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err = conn.Token().RemoveId(name); err == mgo.ErrNotFound {
+		return ErrTokenNotFound
+	}
+	return err
+}
+
+// RevokeUserTokens removes all the tokens belonging to the given user.
+func RevokeUserTokens(userName string) error {
+	log.Debugf("Revoking tokens of user %q", userName)
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Token().RemoveAll(bson.M{"user": userName})
+	return err
+}
+
+// Authenticate returns the token matching the given raw value. The token
+// defined in the "auth:admin-token" setting is always accepted, with the
+// admin scope, so the first tokens can be created.
+func Authenticate(raw string) (*Token, error) {
+	if raw == "" {
+		return nil, ErrInvalidToken
+	}
+	if admin, _ := config.GetString("auth:admin-token"); admin != "" {
+		if subtle.ConstantTimeCompare([]byte(admin), []byte(raw)) == 1 {
+			return &Token{Name: AdminTokenName, Scopes: []string{AdminScope}}, nil
+		}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var t Token
+	if err = conn.Token().Find(bson.M{"hash": hash(raw)}).One(&t); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+type InvalidTokenError struct {
+	message string
+}
+
+func (err *InvalidTokenError) Error() string {
+	return err.message
+}
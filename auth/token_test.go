@@ -0,0 +1,193 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct{}
+
+var _ = check.Suite(&S{})
+
+func (s *S) SetUpSuite(c *check.C) {
+	err := config.ReadConfigFile("../etc/gandalf.conf")
+	c.Assert(err, check.IsNil)
+	config.Set("database:url", "127.0.0.1:27017")
+	config.Set("database:name", "gandalf_auth_tests")
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	config.Unset("auth:admin-token")
+}
+
+func (s *S) TearDownSuite(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	conn.User().Database.DropDatabase()
+}
+
+func (s *S) TestEnabled(c *check.C) {
+	defer config.Unset("auth:enabled")
+	c.Assert(Enabled(), check.Equals, false)
+	config.Set("auth:enabled", true)
+	c.Assert(Enabled(), check.Equals, true)
+}
+
+func (s *S) TestTokenAllows(c *check.C) {
+	t := Token{Name: "tsuru", Scopes: []string{WriteScope}}
+	c.Assert(t.Allows(ReadScope), check.Equals, true)
+	c.Assert(t.Allows(WriteScope), check.Equals, true)
+	c.Assert(t.Allows(AdminScope), check.Equals, false)
+	c.Assert(t.Allows("root"), check.Equals, false)
+}
+
+func (s *S) TestTokenAllowsAdmin(c *check.C) {
+	t := Token{Name: "tsuru", Scopes: []string{ReadScope, AdminScope}}
+	c.Assert(t.Allows(WriteScope), check.Equals, true)
+	c.Assert(t.Allows(AdminScope), check.Equals, true)
+}
+
+func (s *S) TestTokenIdentifiesUser(c *check.C) {
+	t := Token{Name: "bob-laptop", Scopes: []string{WriteScope}, User: "bob"}
+	c.Assert(t.IdentifiesUser("bob"), check.Equals, true)
+	c.Assert(t.IdentifiesUser("alice"), check.Equals, false)
+	t = Token{Name: "ci", Scopes: []string{WriteScope}}
+	c.Assert(t.IdentifiesUser("bob"), check.Equals, false)
+	c.Assert(t.IdentifiesUser(""), check.Equals, false)
+	t = Token{Name: "tsuru", Scopes: []string{AdminScope}}
+	c.Assert(t.IdentifiesUser("bob"), check.Equals, true)
+}
+
+func (s *S) TestNewUserToken(c *check.C) {
+	t, raw, err := NewUserToken("bob-laptop", "bob", []string{WriteScope})
+	c.Assert(err, check.IsNil)
+	defer Revoke("bob-laptop")
+	c.Assert(t.User, check.Equals, "bob")
+	authenticated, err := Authenticate(raw)
+	c.Assert(err, check.IsNil)
+	c.Assert(authenticated.User, check.Equals, "bob")
+}
+
+func (s *S) TestNew(c *check.C) {
+	t, raw, err := New("tsuru", []string{WriteScope})
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Token().RemoveId("tsuru")
+	c.Assert(t.Name, check.Equals, "tsuru")
+	c.Assert(raw, check.HasLen, 64)
+	c.Assert(t.Hash, check.Equals, hash(raw))
+	var stored Token
+	err = conn.Token().FindId("tsuru").One(&stored)
+	c.Assert(err, check.IsNil)
+	c.Assert(stored.Scopes, check.DeepEquals, []string{WriteScope})
+	c.Assert(stored.Hash, check.Not(check.Equals), raw)
+}
+
+func (s *S) TestNewDuplicate(c *check.C) {
+	_, _, err := New("tsuru", []string{ReadScope})
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Token().RemoveId("tsuru")
+	_, _, err = New("tsuru", []string{ReadScope})
+	c.Assert(err, check.Equals, ErrTokenAlreadyExists)
+}
+
+func (s *S) TestNewInvalid(c *check.C) {
+	_, _, err := New("", []string{ReadScope})
+	c.Assert(err, check.ErrorMatches, "token name is not valid")
+	_, _, err = New(AdminTokenName, []string{ReadScope})
+	c.Assert(err, check.ErrorMatches, "token name is not valid")
+	_, _, err = New("tsuru", nil)
+	c.Assert(err, check.ErrorMatches, "token should have at least one scope")
+	_, _, err = New("tsuru", []string{"root"})
+	c.Assert(err, check.ErrorMatches, "invalid scope: root")
+	_, ok := err.(*InvalidTokenError)
+	c.Assert(ok, check.Equals, true)
+}
+
+func (s *S) TestList(c *check.C) {
+	_, _, err := New("b-client", []string{ReadScope})
+	c.Assert(err, check.IsNil)
+	_, _, err = New("a-client", []string{AdminScope})
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Token().RemoveAll(bson.M{"_id": bson.M{"$in": []string{"a-client", "b-client"}}})
+	tokens, err := List()
+	c.Assert(err, check.IsNil)
+	c.Assert(tokens, check.HasLen, 2)
+	c.Assert(tokens[0].Name, check.Equals, "a-client")
+	c.Assert(tokens[1].Name, check.Equals, "b-client")
+}
+
+func (s *S) TestRevoke(c *check.C) {
+	_, raw, err := New("tsuru", []string{ReadScope})
+	c.Assert(err, check.IsNil)
+	err = Revoke("tsuru")
+	c.Assert(err, check.IsNil)
+	_, err = Authenticate(raw)
+	c.Assert(err, check.Equals, ErrInvalidToken)
+}
+
+func (s *S) TestRevokeUserTokens(c *check.C) {
+	_, raw, err := NewUserToken("alice-laptop", "alice", []string{WriteScope})
+	c.Assert(err, check.IsNil)
+	_, other, err := New("tsuru", []string{WriteScope})
+	c.Assert(err, check.IsNil)
+	defer Revoke("tsuru")
+	err = RevokeUserTokens("alice")
+	c.Assert(err, check.IsNil)
+	_, err = Authenticate(raw)
+	c.Assert(err, check.Equals, ErrInvalidToken)
+	_, err = Authenticate(other)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestRevokeNotFound(c *check.C) {
+	err := Revoke("ghost")
+	c.Assert(err, check.Equals, ErrTokenNotFound)
+}
+
+func (s *S) TestAuthenticate(c *check.C) {
+	_, raw, err := New("tsuru", []string{WriteScope})
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Token().RemoveId("tsuru")
+	t, err := Authenticate(raw)
+	c.Assert(err, check.IsNil)
+	c.Assert(t.Name, check.Equals, "tsuru")
+	_, err = Authenticate("wrong")
+	c.Assert(err, check.Equals, ErrInvalidToken)
+}
+
+func (s *S) TestAuthenticateAdminToken(c *check.C) {
+	config.Set("auth:admin-token", "s3cr3t")
+	t, err := Authenticate("s3cr3t")
+	c.Assert(err, check.IsNil)
+	c.Assert(t.Name, check.Equals, AdminTokenName)
+	c.Assert(t.Allows(AdminScope), check.Equals, true)
+}
+
+func (s *S) TestAuthenticateEmpty(c *check.C) {
+	config.Set("auth:admin-token", "")
+	_, err := Authenticate("")
+	c.Assert(err, check.Equals, ErrInvalidToken)
+}
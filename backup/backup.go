@@ -0,0 +1,467 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package backup creates and restores backups of a Gandalf installation,
+// including its database and all its git repositories.
+//
+// A backup is a gzipped tar archive containing:
+//
+//   - manifest.json, describing the backup;
+//   - db/<collection>.bson, with the documents of the repository, user, key,
+//     group, webhook and redirect collections, in the format used by
+//     mongodump;
+//   - repositories/<name>.bundle, with a git bundle of all the refs of each
+//     repository. Empty repositories have no bundle;
+//   - lfs/<name>/<oid>, with each Git LFS object of each repository.
+//
+// Repositories in the trash are not included in backups.
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/gandalf/lfs"
+	"github.com/tsuru/gandalf/repository"
+	"github.com/tsuru/gandalf/user"
+	"github.com/tsuru/tsuru/log"
+)
+
+// Version is the version of the backup format generated by Create. Version 2
+// added the redirect collection and the LFS objects.
+const Version = 2
+
+const (
+	manifestName    = "manifest.json"
+	dbDir           = "db"
+	repositoriesDir = "repositories"
+	lfsDir          = "lfs"
+)
+
+// collections are the database collections included in backups.
+var collections = []string{"repository", "user", "key", "group", "webhook", "redirect"}
+
+var ErrInvalidBackup = errors.New("invalid backup archive")
+
+// Manifest describes a backup. Repositories lists all the repositories in
+// the backup, including the empty ones, which have no bundle.
+type Manifest struct {
+	Version      int       `json:"version"`
+	CreatedAt    time.Time `json:"created_at"`
+	Repositories []string  `json:"repositories"`
+}
+
+// Create writes a backup of Gandalf to w. The database is read before the
+// repositories, so the backup may include changes pushed while it runs.
+func Create(w io.Writer) (*Manifest, error) {
+	log.Debugf("Creating backup")
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	dumps := make(map[string][]byte, len(collections))
+	for _, name := range collections {
+		var buf bytes.Buffer
+		var doc bson.Raw
+		iter := conn.Collection(name).Find(nil).Sort("_id").Iter()
+		for iter.Next(&doc) {
+			buf.Write(doc.Data)
+		}
+		if err = iter.Close(); err != nil {
+			return nil, err
+		}
+		dumps[name] = buf.Bytes()
+	}
+	var repos []struct {
+		Name string `bson:"_id"`
+	}
+	if err = conn.Repository().Find(nil).Sort("_id").All(&repos); err != nil {
+		return nil, err
+	}
+	manifest := Manifest{Version: Version, CreatedAt: time.Now().UTC(), Repositories: []string{}}
+	for _, r := range repos {
+		manifest.Repositories = append(manifest.Repositories, r.Name)
+	}
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	data, err := json.Marshal(&manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err = writeFile(tw, manifestName, data); err != nil {
+		return nil, err
+	}
+	for _, name := range collections {
+		if err = writeFile(tw, path.Join(dbDir, name+".bson"), dumps[name]); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range manifest.Repositories {
+		if err = writeBundle(tw, name); err != nil {
+			return nil, err
+		}
+		if err = writeObjects(tw, name); err != nil {
+			return nil, err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return nil, err
+	}
+	return &manifest, gw.Close()
+}
+
+func writeFile(tw *tar.Writer, name string, data []byte) error {
+	hdr := tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: time.Now()}
+	if err := tw.WriteHeader(&hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// writeBundle writes a bundle of the repository to the archive, unless the
+// repository is empty. The bundle is stored in a temporary file, as the size
+// of each file must be known before writing it.
+func writeBundle(tw *tar.Writer, name string) error {
+	refs, err := repository.GetForEachRef(name, "")
+	if err != nil {
+		return err
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+	bundle, err := repository.GetBundle(name, nil)
+	if err != nil {
+		return err
+	}
+	defer bundle.Close()
+	f, err := ioutil.TempFile("", "gandalf_backup")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	size, err := io.Copy(f, bundle)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Seek(0, 0); err != nil {
+		return err
+	}
+	hdr := tar.Header{Name: path.Join(repositoriesDir, name+".bundle"), Mode: 0600, Size: size, ModTime: time.Now()}
+	if err = tw.WriteHeader(&hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// writeObjects writes the LFS objects of the repository to the archive.
+func writeObjects(tw *tar.Writer, name string) error {
+	oids, err := lfs.Objects(name)
+	if err != nil {
+		return err
+	}
+	for _, oid := range oids {
+		if err = writeObject(tw, name, oid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeObject(tw *tar.Writer, name, oid string) error {
+	size, err := lfs.Size(name, oid)
+	if err != nil {
+		return err
+	}
+	f, err := lfs.Open(name, oid)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hdr := tar.Header{Name: path.Join(lfsDir, name, oid), Mode: 0600, Size: size, ModTime: time.Now()}
+	if err = tw.WriteHeader(&hdr); err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, f, size)
+	return err
+}
+
+// Restore restores the backup read from r. The whole archive is read and
+// validated before anything is changed, so invalid or truncated backups are
+// rejected without touching the installation. Then the collections in the
+// backup replace the ones in the database, the refs of the repositories are
+// updated to the ones in the backup, creating the bare repositories when
+// needed, the LFS objects are stored and the authorized_keys file is rebuilt
+// from the restored keys. Refs and LFS objects missing from the backup are
+// kept, as well as the bare repositories missing from it. Collections missing
+// from the backup, like the redirect collection in backups of version 1, are
+// left untouched.
+func Restore(r io.Reader) (*Manifest, error) {
+	log.Debugf("Restoring backup")
+	dir, err := ioutil.TempDir("", "gandalf_restore")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	a, err := readArchive(r, dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range collections {
+		if docs, ok := a.collections[name]; ok {
+			if err = restoreCollection(name, docs); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, name := range a.manifest.Repositories {
+		if err = repository.InitBare(name); err != nil {
+			return nil, err
+		}
+		if bundle, ok := a.bundles[name]; ok {
+			if err = restoreBundle(name, bundle); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, o := range a.objects {
+		if err = restoreObject(o); err != nil {
+			return nil, err
+		}
+	}
+	if _, err = user.SyncAuthorizedKeys(); err != nil && err != user.ErrAuthorizedKeysDisabled {
+		return nil, err
+	}
+	return &a.manifest, nil
+}
+
+// contents are the contents of a backup, read by readArchive.
+type contents struct {
+	manifest    Manifest
+	collections map[string][]bson.Raw
+	// bundles maps the name of each repository with a bundle to the
+	// temporary file holding it.
+	bundles map[string]string
+	objects []object
+}
+
+// object is a LFS object read from a backup.
+type object struct {
+	repository string
+	oid        string
+	// file is the temporary file holding the content of the object.
+	file string
+}
+
+// readArchive reads and validates the backup read from r, storing its bundles
+// in the given directory.
+func readArchive(r io.Reader, dir string) (*contents, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, ErrInvalidBackup
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != manifestName {
+		return nil, ErrInvalidBackup
+	}
+	a := contents{collections: map[string][]bson.Raw{}, bundles: map[string]string{}}
+	if err = json.NewDecoder(tr).Decode(&a.manifest); err != nil {
+		return nil, ErrInvalidBackup
+	}
+	if a.manifest.Version < 1 || a.manifest.Version > Version {
+		return nil, fmt.Errorf("unsupported backup version: %d", a.manifest.Version)
+	}
+	repos := make(map[string]bool, len(a.manifest.Repositories))
+	for _, name := range a.manifest.Repositories {
+		if !repository.ValidName(name) {
+			return nil, fmt.Errorf("invalid repository in backup: %s", name)
+		}
+		repos[name] = true
+	}
+	for {
+		hdr, err = tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, ErrInvalidBackup
+		}
+		switch parent, file := path.Split(hdr.Name); {
+		case parent == dbDir+"/" && strings.HasSuffix(file, ".bson"):
+			name := strings.TrimSuffix(file, ".bson")
+			if !isCollection(name) {
+				return nil, fmt.Errorf("unexpected collection in backup: %s", name)
+			}
+			if _, ok := a.collections[name]; ok {
+				return nil, fmt.Errorf("duplicate collection in backup: %s", name)
+			}
+			if a.collections[name], err = readCollection(tr); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(parent, lfsDir+"/"):
+			name := strings.TrimSuffix(strings.TrimPrefix(parent, lfsDir+"/"), "/")
+			if !repos[name] {
+				return nil, fmt.Errorf("unexpected repository in backup: %s", name)
+			}
+			if !lfs.ValidOid(file) {
+				return nil, fmt.Errorf("invalid LFS object in backup: %s", hdr.Name)
+			}
+			o := object{repository: name, oid: file}
+			if o.file, err = saveObject(tr, dir, file); err != nil {
+				return nil, err
+			}
+			a.objects = append(a.objects, o)
+		case strings.HasPrefix(hdr.Name, repositoriesDir+"/") && strings.HasSuffix(file, ".bundle"):
+			name := strings.TrimSuffix(strings.TrimPrefix(hdr.Name, repositoriesDir+"/"), ".bundle")
+			if !repos[name] {
+				return nil, fmt.Errorf("unexpected repository in backup: %s", name)
+			}
+			if _, ok := a.bundles[name]; ok {
+				return nil, fmt.Errorf("duplicate repository in backup: %s", name)
+			}
+			if a.bundles[name], err = saveBundle(tr, dir); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unexpected file in backup: %s", hdr.Name)
+		}
+	}
+	return &a, nil
+}
+
+// readCollection reads the documents of a collection, checking that all of
+// them are valid BSON documents.
+func readCollection(r io.Reader) ([]bson.Raw, error) {
+	docs := []bson.Raw{}
+	for {
+		doc, err := readDocument(r)
+		if err == io.EOF {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, ErrInvalidBackup
+		}
+		var m bson.M
+		if err = doc.Unmarshal(&m); err != nil {
+			return nil, ErrInvalidBackup
+		}
+		docs = append(docs, doc)
+	}
+}
+
+// saveBundle stores the bundle read from r in a temporary file in dir,
+// returning its path.
+func saveBundle(r io.Reader, dir string) (string, error) {
+	f, err := ioutil.TempFile(dir, "bundle")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err = io.Copy(f, r); err != nil {
+		return "", ErrInvalidBackup
+	}
+	return f.Name(), nil
+}
+
+// saveObject stores the LFS object read from r in a temporary file in dir,
+// returning its path. The content of the object must match its id.
+func saveObject(r io.Reader, dir, oid string) (string, error) {
+	f, err := ioutil.TempFile(dir, "object")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(f, hash), r); err != nil {
+		return "", ErrInvalidBackup
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != oid {
+		return "", fmt.Errorf("invalid LFS object in backup: %s", oid)
+	}
+	return f.Name(), nil
+}
+
+func restoreCollection(name string, docs []bson.Raw) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	coll := conn.Collection(name)
+	if _, err = coll.RemoveAll(nil); err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if err = coll.Insert(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isCollection(name string) bool {
+	for _, c := range collections {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// readDocument reads a BSON document from r, returning io.EOF when there are
+// no more documents.
+func readDocument(r io.Reader) (bson.Raw, error) {
+	var size int32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return bson.Raw{}, err
+	}
+	if size < 5 {
+		return bson.Raw{}, ErrInvalidBackup
+	}
+	data := make([]byte, size)
+	binary.LittleEndian.PutUint32(data, uint32(size))
+	if _, err := io.ReadFull(r, data[4:]); err != nil {
+		return bson.Raw{}, ErrInvalidBackup
+	}
+	return bson.Raw{Kind: 0x03, Data: data}, nil
+}
+
+func restoreBundle(name, bundle string) error {
+	f, err := os.Open(bundle)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = repository.RestoreBundle(name, "", f)
+	return err
+}
+
+func restoreObject(o object) error {
+	f, err := os.Open(o.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return lfs.Store(o.repository, o.oid, -1, f)
+}
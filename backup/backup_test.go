@@ -0,0 +1,312 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/gandalf/fs"
+	"github.com/tsuru/gandalf/lfs"
+	"github.com/tsuru/gandalf/repository"
+	"github.com/tsuru/gandalf/user"
+	"github.com/tsuru/tsuru/fs/fstest"
+	"gopkg.in/check.v1"
+)
+
+// oid is the SHA-256 hash of content.
+const (
+	content = "some content"
+	oid     = "290f493c44f5d63d06b374d0a5abd292fae38b92cab2fae5efefe1b0e9347f56"
+)
+
+const rawKey = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQCaNZSIEyP6FSdCX0WHDcUFTvebNbvqKiiLEiC7NTGvKrT15r2MtCDi4EPi4Ul+UyxWqb2D7FBnK1UmIcEFHd/ZCnBod2/FSplGOIbIb2UVVbqPX5Alv7IBCMyZJD14ex5cFh16zoqOsPOkOD803LMIlNvXPDDwKjY4TVOQV1JtA2tbZXvYUchqhTcKPxt5BDBZbeQkMMgUgHIEz6IueglFB3+dIZfrzlmM8CVSElKZOpucnJ5JOpGh3paSO/px2ZEcvY8WvjFdipvAWsis75GG/04F641I6XmYlo9fib/YytBXS23szqmvOqEqAopFnnGkDEo+LWI0+FXgPE8lc5BD bilbo@shire"
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct {
+	bare string
+	rfs  *fstest.RecordingFs
+}
+
+var _ = check.Suite(&S{})
+
+func (s *S) SetUpSuite(c *check.C) {
+	err := config.ReadConfigFile("../etc/gandalf.conf")
+	c.Assert(err, check.IsNil)
+	config.Set("database:url", "127.0.0.1:27017")
+	config.Set("database:name", "gandalf_backup_tests")
+	config.Unset("git:bare:template")
+	s.bare = c.MkDir()
+	config.Set("git:bare:location", s.bare)
+}
+
+func (s *S) SetUpTest(c *check.C) {
+	s.rfs = &fstest.RecordingFs{}
+	fs.Fsystem = s.rfs
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	fs.Fsystem = nil
+}
+
+func (s *S) TearDownSuite(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	conn.User().Database.DropDatabase()
+}
+
+func archive(c *check.C, files map[string]string) io.Reader {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, name := range []string{manifestName, "db/repository.bson", "db/user.bson", "repositories/the-shire.bundle", "lfs/the-shire/" + oid, "other.txt"} {
+		if data, ok := files[name]; ok {
+			err := writeFile(tw, name, []byte(data))
+			c.Assert(err, check.IsNil)
+		}
+	}
+	c.Assert(tw.Close(), check.IsNil)
+	c.Assert(gw.Close(), check.IsNil)
+	return &buf
+}
+
+func (s *S) TestBackupAndRestore(c *check.C) {
+	_, err := user.New("bilbo", map[string]string{"laptop": rawKey})
+	c.Assert(err, check.IsNil)
+	_, err = repository.New("the-shire", []string{"bilbo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	_, err = repository.New("mordor", []string{"bilbo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	work := c.MkDir()
+	cmds := [][]string{
+		{"clone", "--quiet", path.Join(s.bare, "the-shire.git"), work},
+		{"-C", work, "-c", "user.name=bilbo", "-c", "user.email=bilbo@shire", "commit", "--quiet", "--allow-empty", "-m", "there and back again"},
+		{"-C", work, "push", "--quiet", "origin", "HEAD:refs/heads/master"},
+	}
+	for _, args := range cmds {
+		out, err := exec.Command("git", args...).CombinedOutput()
+		c.Assert(err, check.IsNil, check.Commentf("%s", out))
+	}
+	var buf bytes.Buffer
+	manifest, err := Create(&buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(manifest.Version, check.Equals, Version)
+	c.Assert(manifest.Repositories, check.DeepEquals, []string{"mordor", "the-shire"})
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	for _, name := range collections {
+		conn.Collection(name).RemoveAll(nil)
+	}
+	defer func() {
+		for _, name := range collections {
+			conn.Collection(name).RemoveAll(nil)
+		}
+	}()
+	os.RemoveAll(path.Join(s.bare, "the-shire.git"))
+	os.RemoveAll(path.Join(s.bare, "mordor.git"))
+	restored, err := Restore(&buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(restored.Repositories, check.DeepEquals, manifest.Repositories)
+	r, err := repository.Get("the-shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(r.Users, check.DeepEquals, []string{"bilbo"})
+	branches, err := repository.GetBranches("the-shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(branches, check.HasLen, 1)
+	c.Assert(branches[0].Subject, check.Equals, "there and back again")
+	branches, err = repository.GetBranches("mordor")
+	c.Assert(err, check.IsNil)
+	c.Assert(branches, check.HasLen, 0)
+	keys, err := user.ListKeys("bilbo")
+	c.Assert(err, check.IsNil)
+	c.Assert(keys, check.HasLen, 1)
+	c.Assert(s.rfs.HasAction("rename "+authorizedKeys()+".tmp "+authorizedKeys()), check.Equals, true)
+	f, err := s.rfs.Open(authorizedKeys())
+	c.Assert(err, check.IsNil)
+	defer f.Close()
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, check.IsNil)
+	c.Assert(strings.Contains(string(content), "bilbo@shire"), check.Equals, true)
+}
+
+func (s *S) TestBackupAndRestoreLFSObjectsAndRedirects(c *check.C) {
+	fs.Fsystem = nil
+	config.Set("authorized-keys-command", true)
+	defer config.Unset("authorized-keys-command")
+	location := c.MkDir()
+	config.Set("lfs:location", location)
+	defer config.Unset("lfs:location")
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer func() {
+		for _, name := range collections {
+			conn.Collection(name).RemoveAll(nil)
+		}
+	}()
+	_, err = repository.New("the-shire", []string{"bilbo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	err = lfs.Store("the-shire", oid, -1, strings.NewReader(content))
+	c.Assert(err, check.IsNil)
+	now := time.Now()
+	err = conn.Redirect().Insert(repository.Redirect{Name: "shire", Repository: "the-shire", Created: now, Expires: now.Add(time.Hour)})
+	c.Assert(err, check.IsNil)
+	var buf bytes.Buffer
+	_, err = Create(&buf)
+	c.Assert(err, check.IsNil)
+	for _, name := range collections {
+		conn.Collection(name).RemoveAll(nil)
+	}
+	os.RemoveAll(path.Join(s.bare, "the-shire.git"))
+	os.RemoveAll(path.Join(location, "the-shire"))
+	_, err = Restore(&buf)
+	c.Assert(err, check.IsNil)
+	size, err := lfs.Size("the-shire", oid)
+	c.Assert(err, check.IsNil)
+	c.Assert(size, check.Equals, int64(len(content)))
+	var redirect repository.Redirect
+	err = conn.Redirect().FindId("shire").One(&redirect)
+	c.Assert(err, check.IsNil)
+	c.Assert(redirect.Repository, check.Equals, "the-shire")
+}
+
+func authorizedKeys() string {
+	if p, _ := config.GetString("authorized-keys-path"); p != "" {
+		return p
+	}
+	return path.Join(os.Getenv("HOME"), ".ssh", "authorized_keys")
+}
+
+func (s *S) TestRestoreInvalidArchive(c *check.C) {
+	_, err := Restore(strings.NewReader("not a backup"))
+	c.Assert(err, check.Equals, ErrInvalidBackup)
+}
+
+func (s *S) TestRestoreWithoutManifest(c *check.C) {
+	_, err := Restore(archive(c, map[string]string{"db/repository.bson": ""}))
+	c.Assert(err, check.Equals, ErrInvalidBackup)
+}
+
+func (s *S) TestRestoreUnsupportedVersion(c *check.C) {
+	_, err := Restore(archive(c, map[string]string{manifestName: `{"version": 42}`}))
+	c.Assert(err, check.ErrorMatches, "unsupported backup version: 42")
+}
+
+func (s *S) TestRestoreUnexpectedFile(c *check.C) {
+	_, err := Restore(archive(c, map[string]string{manifestName: `{"version": 1}`, "other.txt": "hi"}))
+	c.Assert(err, check.ErrorMatches, "unexpected file in backup: other.txt")
+}
+
+func (s *S) TestRestoreInvalidRepositoryName(c *check.C) {
+	_, err := Restore(archive(c, map[string]string{manifestName: `{"version": 1, "repositories": ["../mordor"]}`}))
+	c.Assert(err, check.ErrorMatches, "invalid repository in backup: ../mordor")
+}
+
+// restoreFails checks that restoring the given archive fails without changing
+// the database.
+func restoreFails(c *check.C, files map[string]string) error {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(repository.Repository{Name: "mordor"})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveAll(nil)
+	_, err = Restore(archive(c, files))
+	n, countErr := conn.Repository().Find(nil).Count()
+	c.Assert(countErr, check.IsNil)
+	c.Assert(n, check.Equals, 1)
+	_, getErr := repository.Get("mordor")
+	c.Assert(getErr, check.IsNil)
+	return err
+}
+
+func (s *S) TestRestoreInvalidDocumentChangesNothing(c *check.C) {
+	doc, err := bson.Marshal(bson.M{"_id": "the-shire"})
+	c.Assert(err, check.IsNil)
+	err = restoreFails(c, map[string]string{
+		manifestName:         `{"version": 1, "repositories": ["the-shire"]}`,
+		"db/repository.bson": string(doc),
+		"db/user.bson":       "not a document",
+	})
+	c.Assert(err, check.Equals, ErrInvalidBackup)
+}
+
+func (s *S) TestRestoreUnexpectedRepositoryChangesNothing(c *check.C) {
+	doc, err := bson.Marshal(bson.M{"_id": "the-shire"})
+	c.Assert(err, check.IsNil)
+	err = restoreFails(c, map[string]string{
+		manifestName:                    `{"version": 1}`,
+		"db/repository.bson":            string(doc),
+		"repositories/the-shire.bundle": "# v2 git bundle\n",
+	})
+	c.Assert(err, check.ErrorMatches, "unexpected repository in backup: the-shire")
+}
+
+func (s *S) TestReadCollection(c *check.C) {
+	first, err := bson.Marshal(bson.M{"_id": "the-shire"})
+	c.Assert(err, check.IsNil)
+	second, err := bson.Marshal(bson.M{"_id": "mordor"})
+	c.Assert(err, check.IsNil)
+	docs, err := readCollection(bytes.NewReader(append(first, second...)))
+	c.Assert(err, check.IsNil)
+	c.Assert(docs, check.HasLen, 2)
+	c.Assert(docs[1].Data, check.DeepEquals, second)
+}
+
+func (s *S) TestReadCollectionInvalidDocument(c *check.C) {
+	doc, err := bson.Marshal(bson.M{"_id": "the-shire"})
+	c.Assert(err, check.IsNil)
+	doc[4] = 0x42
+	_, err = readCollection(bytes.NewReader(doc))
+	c.Assert(err, check.Equals, ErrInvalidBackup)
+}
+
+func (s *S) TestReadDocument(c *check.C) {
+	first, err := bson.Marshal(bson.M{"_id": "the-shire"})
+	c.Assert(err, check.IsNil)
+	second, err := bson.Marshal(bson.M{"_id": "mordor"})
+	c.Assert(err, check.IsNil)
+	r := bytes.NewReader(append(first, second...))
+	doc, err := readDocument(r)
+	c.Assert(err, check.IsNil)
+	c.Assert(doc.Data, check.DeepEquals, first)
+	doc, err = readDocument(r)
+	c.Assert(err, check.IsNil)
+	var m bson.M
+	c.Assert(doc.Unmarshal(&m), check.IsNil)
+	c.Assert(m["_id"], check.Equals, "mordor")
+	_, err = readDocument(r)
+	c.Assert(err, check.Equals, io.EOF)
+}
+
+func (s *S) TestReadDocumentTruncated(c *check.C) {
+	data, err := bson.Marshal(bson.M{"_id": "the-shire"})
+	c.Assert(err, check.IsNil)
+	_, err = readDocument(bytes.NewReader(data[:len(data)-2]))
+	c.Assert(err, check.Equals, ErrInvalidBackup)
+}
+
+func (s *S) TestRestoreInvalidLFSObjectChangesNothing(c *check.C) {
+	err := restoreFails(c, map[string]string{
+		manifestName:           `{"version": 2, "repositories": ["the-shire"]}`,
+		"lfs/the-shire/" + oid: "other content",
+	})
+	c.Assert(err, check.ErrorMatches, "invalid LFS object in backup: "+oid)
+}
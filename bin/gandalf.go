@@ -12,41 +12,25 @@ import (
 	"os"
 	"os/exec"
 	"path"
-	"regexp"
 	"strings"
 
 	"github.com/globalsign/mgo/bson"
 	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/audit"
 	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/gandalf/hook"
 	"github.com/tsuru/gandalf/repository"
+	"github.com/tsuru/gandalf/sshserver"
 	"github.com/tsuru/gandalf/user"
 	"github.com/tsuru/tsuru/log"
 )
 
 func hasWritePermission(u *user.User, r *repository.Repository) (allowed bool) {
-	for _, userName := range r.Users {
-		if u.Name == userName {
-			return true
-		}
-	}
-	return false
+	return r.HasWritePermission(u.Name)
 }
 
 func hasReadPermission(u *user.User, r *repository.Repository) (allowed bool) {
-	if r.IsPublic {
-		return true
-	}
-	for _, userName := range r.Users {
-		if u.Name == userName {
-			return true
-		}
-	}
-	for _, userName := range r.ReadOnlyUsers {
-		if u.Name == userName {
-			return true
-		}
-	}
-	return false
+	return r.HasReadPermission(u.Name)
 }
 
 // Returns the command being executed by ssh.
@@ -61,7 +45,8 @@ func action() string {
 }
 
 // Get the repository name requested in SSH_ORIGINAL_COMMAND and retrieves
-// the related document on the database and returns it.
+// the related document on the database and returns it. Former names of
+// renamed repositories are resolved to the repository.
 // This function does two distinct things, parses the SSH_ORIGINAL_COMMAND and
 // returns a "validation" error if it doesn't matches the expected format
 // and gets the repository from the database based on the info
@@ -71,39 +56,23 @@ func requestedRepository() (repository.Repository, error) {
 	if err != nil {
 		return repository.Repository{}, err
 	}
-	var repo repository.Repository
-	conn, err := db.Conn()
+	repo, err := repository.Resolve(repoName)
+	if err == repository.ErrRepositoryNotFound {
+		return repository.Repository{}, errors.New("Repository not found")
+	}
 	if err != nil {
 		return repository.Repository{}, err
 	}
-	defer conn.Close()
-	if err := conn.Repository().Find(bson.M{"_id": repoName}).One(&repo); err != nil {
-		return repository.Repository{}, errors.New("Repository not found")
-	}
 	return repo, nil
 }
 
 // Checks whether a command is a valid git command
 // The following format is allowed:
 // (git-[a-z-]+) '/?([\w-+@][\w-+.@]*/)?([\w-]+)\.git'
+// Along with the command sent by Git LFS clients:
+// git-lfs-authenticate '?/?([\w-+@][\w-+.@]*/)?([\w-]+)\.git'? (upload|download)
 func parseGitCommand() (command, name string, err error) {
-	// The following regex validates the git command, which is in the form:
-	//    <git-command> [<namespace>/]<name>
-	// with namespace being optional. If a namespace is used, we validate it
-	// according to the following:
-	//  - a namespace is optional
-	//  - a namespace contains only alphanumerics, underlines, @´s, -´s, +´s
-	//    and periods but it does not start with a period (.)
-	//  - one and exactly one slash (/) separates namespace and the actual name
-	r, err := regexp.Compile(`(git-[a-z-]+) '/?([\w-+@][\w-+.@]*/)?([\w-]+)\.git'`)
-	if err != nil {
-		panic(err)
-	}
-	m := r.FindStringSubmatch(os.Getenv("SSH_ORIGINAL_COMMAND"))
-	if len(m) != 4 {
-		return "", "", errors.New("You've tried to execute some weird command, I'm deliberately denying you to do that, get over it.")
-	}
-	return m[1], m[2] + m[3], nil
+	return sshserver.ParseCommand(os.Getenv("SSH_ORIGINAL_COMMAND"))
 }
 
 // Executes the SSH_ORIGINAL_COMMAND based on the condition
@@ -131,20 +100,35 @@ func executeAction(f func(*user.User, *repository.Repository) bool, errMsg strin
 		c, err := formatCommand()
 		if err != nil {
 			log.Error(err)
+			return
 		}
+		// the repository may have been requested by a former name.
+		c[1] = repository.BarePath(repo.Name)
 		log.GetStdLogger().Println("Executing " + strings.Join(c, " "))
 		cmd := exec.Command(c[0], c[1:]...)
 		cmd.Stdin = os.Stdin
 		cmd.Stdout = stdout
 		baseEnv := os.Environ()
 		baseEnv = append(baseEnv, "TSURU_USER="+u.Name)
+		if action() == "git-receive-pack" {
+			baseEnv, err = hook.ReceiveEnv(repo.Name, u.Name)
+			if err != nil {
+				log.Errorf("Error installing built-in hooks: %s", err)
+				return
+			}
+		}
 		cmd.Env = baseEnv
 		stderr := &bytes.Buffer{}
 		cmd.Stderr = stderr
 		err = cmd.Run()
 		if err != nil {
 			log.Errorf("Got error while executing original command: %v", err)
-			log.Errorf(stderr.String())
+			log.Errorf("%s", stderr.String())
+			return
+		}
+		// pushes are recorded by the built-in post-receive hook.
+		if action() == "git-upload-pack" {
+			audit.Record(audit.Entry{Action: audit.GitFetch, Actor: u.Name, Repositories: []string{repo.Name}, Details: "ssh"})
 		}
 		return
 	}
@@ -173,23 +157,45 @@ func formatCommand() ([]string, error) {
 	return cmdList, nil
 }
 
+// configFile returns the configuration file to read, defined by the
+// environment of the built-in hooks or the default one.
+func configFile() string {
+	if file := os.Getenv(hook.ConfigEnv); file != "" {
+		return file
+	}
+	return "/etc/gandalf.conf"
+}
+
 func main() {
 	var err error
-	err = config.ReadConfigFile("/etc/gandalf.conf")
+	file := configFile()
+	err = config.ReadConfigFile(file)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	hook.SetConfigFile(file)
 	if err = log.Init(); err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
+	if name := os.Getenv("GANDALF_HOOK"); name != "" {
+		os.Exit(runHook(name, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+	}
 	_, _, err = parseGitCommand()
 	if err != nil {
 		log.Error(err)
 		return
 	}
 	a := action()
+	if a == "git-lfs-authenticate" {
+		if err = sshserver.LFSAuthenticate(os.Args[1], os.Getenv("SSH_ORIGINAL_COMMAND"), os.Stdout); err != nil {
+			log.Error(err)
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 	if a == "git-receive-pack" {
 		executeAction(hasWritePermission, "You don't have access to write in this repository.", os.Stdout)
 		return
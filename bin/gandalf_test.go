@@ -9,6 +9,7 @@ import (
 	"os"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/globalsign/mgo/bson"
 	"github.com/tsuru/commandmocker"
@@ -37,6 +38,7 @@ func (s *S) SetUpSuite(c *check.C) {
 	err = log.Init()
 	c.Check(err, check.IsNil)
 	config.Set("database:name", "gandalf_bin_tests")
+	config.Set("git:hooks:location", c.MkDir())
 	s.user, err = user.New("testuser", map[string]string{})
 	c.Check(err, check.IsNil)
 	// does not uses repository.New to avoid creation of bare git repo
@@ -55,6 +57,14 @@ func (s *S) TearDownSuite(c *check.C) {
 	conn.User().Database.DropDatabase()
 }
 
+func (s *S) TestConfigFile(c *check.C) {
+	os.Unsetenv("GANDALF_CONFIG")
+	c.Assert(configFile(), check.Equals, "/etc/gandalf.conf")
+	os.Setenv("GANDALF_CONFIG", "/etc/gandalf/gandalf.conf")
+	defer os.Unsetenv("GANDALF_CONFIG")
+	c.Assert(configFile(), check.Equals, "/etc/gandalf/gandalf.conf")
+}
+
 func (s *S) TestHasWritePermissionSholdReturnTrueWhenUserCanWriteInRepo(c *check.C) {
 	allowed := hasWritePermission(s.user, s.repo)
 	c.Assert(allowed, check.Equals, true)
@@ -71,6 +81,13 @@ func (s *S) TestHasWritePermissionShouldReturnFalseWhenUserCannotWriteinRepo(c *
 	c.Assert(allowed, check.Equals, false)
 }
 
+func (s *S) TestHasWritePermissionShouldReturnTrueForMaintainersAndAdmins(c *check.C) {
+	r := &repository.Repository{Name: "myotherapp", Maintainers: []string{s.user.Name}}
+	c.Assert(hasWritePermission(s.user, r), check.Equals, true)
+	r = &repository.Repository{Name: "myotherapp", Admins: []string{s.user.Name}}
+	c.Assert(hasWritePermission(s.user, r), check.Equals, true)
+}
+
 func (s *S) TestHasReadPermissionShouldReturnTrueWhenRepositoryIsPublic(c *check.C) {
 	r := &repository.Repository{Name: "myotherapp", IsPublic: true}
 	conn, err := db.Conn()
@@ -152,6 +169,24 @@ func (s *S) TestRequestedRepositoryShouldDeduceCorrectlyRepositoryNameWithDash(c
 	c.Assert(repo.Name, check.Equals, r.Name)
 }
 
+func (s *S) TestRequestedRepositoryShouldResolveFormerName(c *check.C) {
+	r := repository.Repository{Name: "foo-bar"}
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&r)
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().Remove(bson.M{"_id": r.Name})
+	err = conn.Redirect().Insert(&repository.Redirect{Name: "foo", Repository: r.Name, Expires: time.Now().Add(time.Hour)})
+	c.Assert(err, check.IsNil)
+	defer conn.Redirect().RemoveId("foo")
+	os.Setenv("SSH_ORIGINAL_COMMAND", "git-receive-pack 'foo.git'")
+	defer os.Setenv("SSH_ORIGINAL_COMMAND", "")
+	repo, err := requestedRepository()
+	c.Assert(err, check.IsNil)
+	c.Assert(repo.Name, check.Equals, r.Name)
+}
+
 func (s *S) TestRequestedRepositoryShouldReturnErrorWhenCommandDoesNotPassesWhatIsExpected(c *check.C) {
 	os.Setenv("SSH_ORIGINAL_COMMAND", "rm -rf /")
 	defer os.Setenv("SSH_ORIGINAL_COMMAND", "")
@@ -209,6 +244,15 @@ func (s *S) TestParseGitCommandShouldReturnErrorWhenTheresNoMatch(c *check.C) {
 	c.Assert(name, check.Equals, "")
 }
 
+func (s *S) TestParseGitCommandLFSAuthenticate(c *check.C) {
+	os.Setenv("SSH_ORIGINAL_COMMAND", "git-lfs-authenticate foobar.git download")
+	defer os.Setenv("SSH_ORIGINAL_COMMAND", "")
+	command, name, err := parseGitCommand()
+	c.Assert(err, check.IsNil)
+	c.Assert(command, check.Equals, "git-lfs-authenticate")
+	c.Assert(name, check.Equals, "foobar")
+}
+
 func (s *S) TestParseGitCommandReturnsErrorWhenSSH_ORIGINAL_COMMANDIsNotAGitCommand(c *check.C) {
 	os.Setenv("SSH_ORIGINAL_COMMAND", "rm -rf /")
 	defer os.Setenv("SSH_ORIGINAL_COMMAND", "")
@@ -247,7 +291,10 @@ func (s *S) TestExecuteActionShouldExecuteGitReceivePackWhenUserHasWritePermissi
 	c.Assert(err, check.IsNil)
 	expected := path.Join(p, "myapp.git")
 	c.Assert(stdout.String(), check.Equals, expected)
-	c.Assert(commandmocker.Envs(dir), check.Matches, `(?s).*TSURU_USER=testuser.*`)
+	envs := commandmocker.Envs(dir)
+	c.Assert(envs, check.Matches, `(?s).*TSURU_USER=testuser.*`)
+	c.Assert(envs, check.Matches, `(?s).*GANDALF_REPOSITORY=myapp.*`)
+	c.Assert(envs, check.Matches, `(?s).*GIT_CONFIG_KEY_0=core.hooksPath.*`)
 }
 
 func (s *S) TestExecuteActionShouldNotCallSSH_ORIGINAL_COMMANDWhenUserDoesNotExist(c *check.C) {
@@ -0,0 +1,195 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/audit"
+	"github.com/tsuru/gandalf/hook"
+	"github.com/tsuru/gandalf/repository"
+	"github.com/tsuru/gandalf/webhook"
+	"github.com/tsuru/tsuru/log"
+)
+
+// checkProtections reads the ref updates sent by git to the pre-receive hook
+// and checks them against the protection rules of the repository.
+func checkProtections(repoName, userName string, input []byte) error {
+	repo, err := repository.Get(repoName)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		if err = repo.CheckRefUpdate(userName, fields[2], fields[0], fields[1]); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// checkQuota checks whether the repository fits in its quota after receiving
+// the objects of a push. Pushes only deleting refs are always accepted, so
+// users may clean up repositories exceeding their quota.
+func checkQuota(repoName string, input []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && strings.Trim(fields[1], "0") != "" {
+			return repository.CheckQuota(repoName)
+		}
+	}
+	return scanner.Err()
+}
+
+// formatRefUpdates formats the ref updates sent by git to the pre-receive and
+// post-receive hooks, for example "refs/heads/master 1a2b3c4..5d6e7f8".
+func formatRefUpdates(input []byte) string {
+	var updates []string
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		updates = append(updates, fmt.Sprintf("%s %.7s..%.7s", fields[2], fields[0], fields[1]))
+	}
+	return strings.Join(updates, ", ")
+}
+
+// repositoryScripts returns the paths of the executable scripts of the hook
+// with the given name installed in the repository, in the order they run: the
+// main script followed by the named scripts, sorted by name.
+func repositoryScripts(repoName, name string) ([]string, error) {
+	bare, err := config.GetString("git:bare:location")
+	if err != nil {
+		return nil, err
+	}
+	dir := path.Join(bare, repoName+".git", "hooks")
+	var scripts []string
+	if info, err := os.Stat(path.Join(dir, name)); err == nil && isExecutable(info) {
+		scripts = append(scripts, path.Join(dir, name))
+	}
+	if !hook.Chainable(name) {
+		return scripts, nil
+	}
+	infos, err := ioutil.ReadDir(path.Join(dir, hook.ScriptDir(name)))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, info := range infos {
+		if isExecutable(info) {
+			scripts = append(scripts, path.Join(dir, hook.ScriptDir(name), info.Name()))
+		}
+	}
+	return scripts, nil
+}
+
+func isExecutable(info os.FileInfo) bool {
+	return info.Mode().IsRegular() && info.Mode()&0111 != 0
+}
+
+// runScript runs a hook script, returning its exit status.
+func runScript(script string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	cmd := exec.Command(script, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode()
+		}
+		log.Errorf("Got error while executing hook %q: %s", script, err)
+		return 1
+	}
+	return 0
+}
+
+// runHook runs the built-in hook with the given name, invoked by git with
+// gandalf-ssh installed as core.hooksPath, and then the scripts of the hook
+// installed in the repository, if any. Each script gets the same arguments
+// and input. It returns the exit status of the hook.
+//
+// The scripts of post-receive and post-update hooks can not reject the push,
+// so all of them run, and the status is the one of the first failing script.
+// For the other hooks, the first failing script stops the chain. proc-receive
+// hooks talk to git through their input and output, so they have a single
+// script, which gets the input as it comes.
+//
+// The pre-receive hook checks the protection rules and the quota of the
+// repository. The post-receive hook records the push in the audit log, queues
+// its deliveries to the webhooks of the repository and updates the disk usage
+// recorded for it.
+func runHook(name string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	repoName := os.Getenv("GANDALF_REPOSITORY")
+	scripts, err := repositoryScripts(repoName, name)
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+	if !hook.Chainable(name) {
+		if len(scripts) == 0 {
+			return 0
+		}
+		return runScript(scripts[0], args, stdin, stdout, stderr)
+	}
+	input, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+	if name == "pre-receive" {
+		if err = checkProtections(repoName, os.Getenv("TSURU_USER"), input); err == nil {
+			err = checkQuota(repoName, input)
+		}
+		if err != nil {
+			log.Errorf("Push to repository %q denied: %s", repoName, err)
+			fmt.Fprintf(stderr, "error: %s\n", err)
+			return 1
+		}
+	}
+	if name == "post-receive" {
+		audit.Record(audit.Entry{
+			Action:       audit.GitPush,
+			Actor:        os.Getenv("TSURU_USER"),
+			Repositories: []string{repoName},
+			Details:      formatRefUpdates(input),
+		})
+		if err = webhook.Notify(repoName, os.Getenv("TSURU_USER"), webhook.ParseRefUpdates(input)); err != nil {
+			log.Errorf("Failed to queue webhook deliveries for repository %q: %s", repoName, err)
+		}
+		if _, err = repository.UpdateDiskUsage(repoName); err != nil {
+			log.Errorf("Failed to update the disk usage of repository %q: %s", repoName, err)
+		}
+	}
+	runAll := name == "post-receive" || name == "post-update"
+	status := 0
+	for _, script := range scripts {
+		code := runScript(script, args, bytes.NewReader(input), stdout, stderr)
+		if code == 0 {
+			continue
+		}
+		if !runAll {
+			return code
+		}
+		if status == 0 {
+			status = code
+		}
+	}
+	return status
+}
@@ -0,0 +1,219 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/audit"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/gandalf/repository"
+	"github.com/tsuru/gandalf/webhook"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) setUpRepositoryHook(c *check.C, name, content string) func() {
+	bare := c.MkDir()
+	oldBare, err := config.GetString("git:bare:location")
+	c.Assert(err, check.IsNil)
+	config.Set("git:bare:location", bare)
+	os.Setenv("GANDALF_REPOSITORY", "myapp")
+	if content != "" {
+		dir := path.Join(bare, "myapp.git", "hooks")
+		err = os.MkdirAll(dir, 0755)
+		c.Assert(err, check.IsNil)
+		err = ioutil.WriteFile(path.Join(dir, name), []byte(content), 0755)
+		c.Assert(err, check.IsNil)
+	}
+	return func() {
+		config.Set("git:bare:location", oldBare)
+		os.Unsetenv("GANDALF_REPOSITORY")
+	}
+}
+
+func (s *S) TestRunHookChainsRepositoryHook(c *check.C) {
+	cleanup := s.setUpRepositoryHook(c, "post-receive", "#!/bin/sh\ncat\necho \"$@\"\n")
+	defer cleanup()
+	stdout := &bytes.Buffer{}
+	status := runHook("post-receive", []string{"arg"}, strings.NewReader("old new refs/heads/master\n"), stdout, &bytes.Buffer{})
+	c.Assert(status, check.Equals, 0)
+	c.Assert(stdout.String(), check.Equals, "old new refs/heads/master\narg\n")
+}
+
+func (s *S) TestRunHookReturnsRepositoryHookStatus(c *check.C) {
+	cleanup := s.setUpRepositoryHook(c, "update", "#!/bin/sh\nexit 3\n")
+	defer cleanup()
+	status := runHook("update", nil, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{})
+	c.Assert(status, check.Equals, 3)
+}
+
+func (s *S) TestRunHookWithoutRepositoryHook(c *check.C) {
+	cleanup := s.setUpRepositoryHook(c, "post-receive", "")
+	defer cleanup()
+	status := runHook("post-receive", nil, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{})
+	c.Assert(status, check.Equals, 0)
+}
+
+func (s *S) addRepositoryScript(c *check.C, name, script, content string) {
+	bare, err := config.GetString("git:bare:location")
+	c.Assert(err, check.IsNil)
+	dir := path.Join(bare, "myapp.git", "hooks", name+".d")
+	err = os.MkdirAll(dir, 0755)
+	c.Assert(err, check.IsNil)
+	err = ioutil.WriteFile(path.Join(dir, script), []byte(content), 0755)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestRunHookRunsScriptsInOrder(c *check.C) {
+	cleanup := s.setUpRepositoryHook(c, "update", "#!/bin/sh\necho main \"$@\"\n")
+	defer cleanup()
+	s.addRepositoryScript(c, "update", "20-second", "#!/bin/sh\necho second\n")
+	s.addRepositoryScript(c, "update", "10-first", "#!/bin/sh\necho first\n")
+	s.addRepositoryScript(c, "update", "15-disabled", "#!/bin/sh\necho disabled\n")
+	bare, _ := config.GetString("git:bare:location")
+	err := os.Chmod(path.Join(bare, "myapp.git", "hooks", "update.d", "15-disabled"), 0644)
+	c.Assert(err, check.IsNil)
+	stdout := &bytes.Buffer{}
+	status := runHook("update", []string{"refs/heads/master"}, strings.NewReader(""), stdout, &bytes.Buffer{})
+	c.Assert(status, check.Equals, 0)
+	c.Assert(stdout.String(), check.Equals, "main refs/heads/master\nfirst\nsecond\n")
+}
+
+func (s *S) TestRunHookReplaysInputToEachScript(c *check.C) {
+	cleanup := s.setUpRepositoryHook(c, "post-receive", "")
+	defer cleanup()
+	s.addRepositoryScript(c, "post-receive", "deploy", "#!/bin/sh\ncat\n")
+	s.addRepositoryScript(c, "post-receive", "notify", "#!/bin/sh\ncat\n")
+	stdout := &bytes.Buffer{}
+	status := runHook("post-receive", nil, strings.NewReader("old new refs/heads/master\n"), stdout, &bytes.Buffer{})
+	c.Assert(status, check.Equals, 0)
+	c.Assert(stdout.String(), check.Equals, "old new refs/heads/master\nold new refs/heads/master\n")
+}
+
+func (s *S) TestRunHookStopsAtFirstFailingScript(c *check.C) {
+	cleanup := s.setUpRepositoryHook(c, "update", "")
+	defer cleanup()
+	s.addRepositoryScript(c, "update", "a", "#!/bin/sh\nexit 2\n")
+	s.addRepositoryScript(c, "update", "b", "#!/bin/sh\necho b\n")
+	stdout := &bytes.Buffer{}
+	status := runHook("update", nil, strings.NewReader(""), stdout, &bytes.Buffer{})
+	c.Assert(status, check.Equals, 2)
+	c.Assert(stdout.String(), check.Equals, "")
+}
+
+func (s *S) TestRunHookPostUpdateRunsAllScripts(c *check.C) {
+	cleanup := s.setUpRepositoryHook(c, "post-update", "")
+	defer cleanup()
+	s.addRepositoryScript(c, "post-update", "a", "#!/bin/sh\nexit 2\n")
+	s.addRepositoryScript(c, "post-update", "b", "#!/bin/sh\nexit 3\n")
+	s.addRepositoryScript(c, "post-update", "c", "#!/bin/sh\necho c\n")
+	stdout := &bytes.Buffer{}
+	status := runHook("post-update", nil, strings.NewReader(""), stdout, &bytes.Buffer{})
+	c.Assert(status, check.Equals, 2)
+	c.Assert(stdout.String(), check.Equals, "c\n")
+}
+
+func (s *S) TestRunHookProcReceive(c *check.C) {
+	cleanup := s.setUpRepositoryHook(c, "proc-receive", "#!/bin/sh\ncat\n")
+	defer cleanup()
+	stdout := &bytes.Buffer{}
+	status := runHook("proc-receive", nil, strings.NewReader("version=1\n"), stdout, &bytes.Buffer{})
+	c.Assert(status, check.Equals, 0)
+	c.Assert(stdout.String(), check.Equals, "version=1\n")
+}
+
+func (s *S) TestRunHookPreReceiveDeniesProtectedBranch(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	repo := repository.Repository{
+		Name:        "protectedapp",
+		Users:       []string{s.user.Name},
+		Protections: []repository.Protection{{Pattern: "master"}},
+	}
+	err = conn.Repository().Insert(&repo)
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId(repo.Name)
+	cleanup := s.setUpRepositoryHook(c, "pre-receive", "#!/bin/sh\necho chained\n")
+	defer cleanup()
+	os.Setenv("GANDALF_REPOSITORY", repo.Name)
+	os.Setenv("TSURU_USER", s.user.Name)
+	defer os.Unsetenv("TSURU_USER")
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	input := "1f2e3d4c5b6a79881f2e3d4c5b6a79881f2e3d4c 0000000000000000000000000000000000000000 refs/heads/master\n"
+	status := runHook("pre-receive", nil, strings.NewReader(input), stdout, stderr)
+	c.Assert(status, check.Equals, 1)
+	c.Assert(stderr.String(), check.Equals, "error: refs/heads/master is protected and can not be deleted\n")
+	c.Assert(stdout.String(), check.Equals, "")
+}
+
+func (s *S) TestRunHookPreReceiveAllowsUnprotectedBranch(c *check.C) {
+	cleanup := s.setUpRepositoryHook(c, "pre-receive", "#!/bin/sh\necho chained\n")
+	defer cleanup()
+	os.Setenv("TSURU_USER", s.user.Name)
+	defer os.Unsetenv("TSURU_USER")
+	stdout := &bytes.Buffer{}
+	input := "1f2e3d4c5b6a79881f2e3d4c5b6a79881f2e3d4c 0000000000000000000000000000000000000000 refs/heads/feature\n"
+	status := runHook("pre-receive", nil, strings.NewReader(input), stdout, &bytes.Buffer{})
+	c.Assert(status, check.Equals, 0)
+	c.Assert(stdout.String(), check.Equals, "chained\n")
+}
+
+func (s *S) TestCheckQuotaAcceptsDeletions(c *check.C) {
+	input := "1f2e3d4c5b6a79881f2e3d4c5b6a79881f2e3d4c 0000000000000000000000000000000000000000 refs/heads/feature\n"
+	c.Assert(checkQuota("ghost", []byte(input)), check.IsNil)
+}
+
+func (s *S) TestCheckQuotaChecksUpdates(c *check.C) {
+	input := "1f2e3d4c5b6a79881f2e3d4c5b6a79881f2e3d4c 0000000000000000000000000000000000000000 refs/heads/feature\n" +
+		"1f2e3d4c5b6a79881f2e3d4c5b6a79881f2e3d4c 9a8b7c6d5e4f30219a8b7c6d5e4f30219a8b7c6d refs/heads/master\n"
+	c.Assert(checkQuota("ghost", []byte(input)), check.Equals, repository.ErrRepositoryNotFound)
+}
+
+func (s *S) TestFormatRefUpdates(c *check.C) {
+	input := "1f2e3d4c5b6a79881f2e3d4c5b6a79881f2e3d4c 9a8b7c6d5e4f30219a8b7c6d5e4f30219a8b7c6d refs/heads/master\n" +
+		"0000000000000000000000000000000000000000 9a8b7c6d5e4f30219a8b7c6d5e4f30219a8b7c6d refs/tags/v1\n"
+	c.Assert(formatRefUpdates([]byte(input)), check.Equals, "refs/heads/master 1f2e3d4..9a8b7c6, refs/tags/v1 0000000..9a8b7c6")
+}
+
+func (s *S) TestRunHookPostReceiveRecordsPush(c *check.C) {
+	cleanup := s.setUpRepositoryHook(c, "post-receive", "")
+	defer cleanup()
+	os.Setenv("TSURU_USER", s.user.Name)
+	defer os.Unsetenv("TSURU_USER")
+	input := "1f2e3d4c5b6a79881f2e3d4c5b6a79881f2e3d4c 9a8b7c6d5e4f30219a8b7c6d5e4f30219a8b7c6d refs/heads/master\n"
+	status := runHook("post-receive", nil, strings.NewReader(input), &bytes.Buffer{}, &bytes.Buffer{})
+	c.Assert(status, check.Equals, 0)
+	entries, err := audit.List(audit.Filter{Repository: "myapp", Action: audit.GitPush})
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 1)
+	c.Assert(entries[0].Actor, check.Equals, s.user.Name)
+	c.Assert(entries[0].Details, check.Equals, "refs/heads/master 1f2e3d4..9a8b7c6")
+}
+
+func (s *S) TestRunHookPostReceiveNotifiesWebhooks(c *check.C) {
+	cleanup := s.setUpRepositoryHook(c, "post-receive", "")
+	defer cleanup()
+	os.Setenv("TSURU_USER", s.user.Name)
+	defer os.Unsetenv("TSURU_USER")
+	h := webhook.Webhook{URL: "https://tsuru.example.com/hook"}
+	err := webhook.Create(&h)
+	c.Assert(err, check.IsNil)
+	defer webhook.Remove(h.ID.Hex())
+	input := "1f2e3d4c5b6a79881f2e3d4c5b6a79881f2e3d4c 9a8b7c6d5e4f30219a8b7c6d5e4f30219a8b7c6d refs/heads/master\n"
+	status := runHook("post-receive", nil, strings.NewReader(input), &bytes.Buffer{}, &bytes.Buffer{})
+	c.Assert(status, check.Equals, 0)
+	deliveries, err := webhook.ListDeliveries(h.ID.Hex(), 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(deliveries, check.HasLen, 1)
+	c.Assert(deliveries[0].Payload.Repository, check.Equals, "myapp")
+	c.Assert(deliveries[0].Payload.Pusher, check.Equals, s.user.Name)
+	c.Assert(deliveries[0].Payload.Event, check.Equals, webhook.EventPush)
+}
@@ -0,0 +1,278 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package consistency checks whether the database of Gandalf matches the bare
+// repositories in the filesystem, and fixes the problems found.
+//
+// The following problems are detected:
+//
+//   - repositories in the database without a bare repository;
+//   - bare repositories without a repository in the database;
+//   - users referenced by repositories that do not exist;
+//   - keys of users that do not exist.
+package consistency
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/gandalf/repository"
+	"github.com/tsuru/gandalf/user"
+	"github.com/tsuru/tsuru/log"
+)
+
+// UserReference is a reference to a user in a repository.
+type UserReference struct {
+	Repository string `json:"repository"`
+	User       string `json:"user"`
+}
+
+// KeyReference identifies a key of a user.
+type KeyReference struct {
+	User string `json:"user"`
+	Name string `json:"name"`
+}
+
+// Report lists the problems found by Check. When Fixed is true, the problems
+// were fixed by Fix.
+type Report struct {
+	MissingBare  []string        `json:"missing_bare"`
+	OrphanBare   []string        `json:"orphan_bare"`
+	MissingUsers []UserReference `json:"missing_users"`
+	OrphanKeys   []KeyReference  `json:"orphan_keys"`
+	Fixed        bool            `json:"fixed"`
+}
+
+// Problems returns the number of problems in the report.
+func (r *Report) Problems() int {
+	return len(r.MissingBare) + len(r.OrphanBare) + len(r.MissingUsers) + len(r.OrphanKeys)
+}
+
+// Check compares the database with the bare repositories in the filesystem,
+// returning the problems found.
+func Check() (*Report, error) {
+	log.Debugf("Checking the consistency of the database and the bare repositories")
+	report := Report{
+		MissingBare:  []string{},
+		OrphanBare:   []string{},
+		MissingUsers: []UserReference{},
+		OrphanKeys:   []KeyReference{},
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var repos []repository.Repository
+	if err = conn.Repository().Find(nil).Sort("_id").All(&repos); err != nil {
+		return nil, err
+	}
+	var users []user.User
+	if err = conn.User().Find(nil).All(&users); err != nil {
+		return nil, err
+	}
+	userSet := make(map[string]bool, len(users))
+	for _, u := range users {
+		userSet[u.Name] = true
+	}
+	bareSet := map[string]bool{}
+	bareLocation, err := config.GetString("git:bare:location")
+	if err != nil {
+		return nil, err
+	}
+	bares, err := bareRepositories(bareLocation)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range bares {
+		bareSet[name] = true
+	}
+	repoSet := make(map[string]bool, len(repos))
+	for _, r := range repos {
+		repoSet[r.Name] = true
+		if !bareSet[r.Name] {
+			report.MissingBare = append(report.MissingBare, r.Name)
+		}
+		for _, name := range referencedUsers(&r) {
+			if !userSet[name] {
+				report.MissingUsers = append(report.MissingUsers, UserReference{Repository: r.Name, User: name})
+			}
+		}
+	}
+	for _, name := range bares {
+		if !repoSet[name] {
+			report.OrphanBare = append(report.OrphanBare, name)
+		}
+	}
+	var keys []user.Key
+	if err = conn.Key().Find(nil).Sort("username", "name").All(&keys); err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if !userSet[k.UserName] {
+			report.OrphanKeys = append(report.OrphanKeys, KeyReference{User: k.UserName, Name: k.Name})
+		}
+	}
+	return &report, nil
+}
+
+// Fix checks the consistency of the database and the bare repositories, and
+// fixes the problems found:
+//
+//   - empty bare repositories are created for the repositories without one;
+//   - bare repositories without a repository are added to the database,
+//     without users, so they may be inspected, renamed or removed through the
+//     API. Bare repositories with invalid names are left untouched;
+//   - users that do not exist are removed from the repositories;
+//   - keys of users that do not exist are removed from the database and from
+//     the authorized_keys file.
+func Fix() (*Report, error) {
+	report, err := Check()
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Fixing %d consistency problems", report.Problems())
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	for _, name := range report.MissingBare {
+		if err = repository.InitBare(name); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range report.OrphanBare {
+		if !repository.ValidName(name) {
+			log.Errorf("consistency.Fix: ignoring bare repository with invalid name %q", name)
+			continue
+		}
+		if err = conn.Repository().Insert(&repository.Repository{Name: name}); err != nil {
+			return nil, err
+		}
+	}
+	missing := map[string][]string{}
+	for _, ref := range report.MissingUsers {
+		missing[ref.Repository] = append(missing[ref.Repository], ref.User)
+	}
+	for name, users := range missing {
+		if err = removeUsers(name, users); err != nil {
+			return nil, err
+		}
+	}
+	for _, k := range report.OrphanKeys {
+		if err = conn.Key().Remove(bson.M{"username": k.User, "name": k.Name}); err != nil {
+			return nil, err
+		}
+	}
+	if len(report.OrphanKeys) > 0 {
+		if _, err = user.SyncAuthorizedKeys(); err != nil && err != user.ErrAuthorizedKeysDisabled {
+			return nil, err
+		}
+	}
+	report.Fixed = true
+	return report, nil
+}
+
+// bareRepositories returns the names of the bare repositories in the given
+// directory, including the ones in namespaces, sorted by name. Directories
+// starting with a dot, such as the one of the built-in hooks, are skipped.
+func bareRepositories(location string) ([]string, error) {
+	entries, err := ioutil.ReadDir(location)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if strings.HasSuffix(entry.Name(), ".git") {
+			names = append(names, strings.TrimSuffix(entry.Name(), ".git"))
+			continue
+		}
+		namespaced, err := ioutil.ReadDir(path.Join(location, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range namespaced {
+			if n.IsDir() && strings.HasSuffix(n.Name(), ".git") {
+				names = append(names, entry.Name()+"/"+strings.TrimSuffix(n.Name(), ".git"))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// referencedUsers returns the users referenced by the repository, in its
+// members and protection rules, without duplicates.
+func referencedUsers(r *repository.Repository) []string {
+	lists := [][]string{r.Users, r.ReadOnlyUsers, r.Maintainers, r.Admins}
+	for _, p := range r.Protections {
+		lists = append(lists, p.Users)
+	}
+	seen := map[string]bool{}
+	var names []string
+	for _, list := range lists {
+		for _, name := range list {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// removeUsers removes the given users from the members and protection rules
+// of the repository.
+func removeUsers(name string, users []string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var r repository.Repository
+	if err = conn.Repository().FindId(name).One(&r); err != nil {
+		return err
+	}
+	for i := range r.Protections {
+		r.Protections[i].Users = without(r.Protections[i].Users, users)
+	}
+	update := bson.M{
+		"users":         without(r.Users, users),
+		"readonlyusers": without(r.ReadOnlyUsers, users),
+		"maintainers":   without(r.Maintainers, users),
+		"admins":        without(r.Admins, users),
+		"protections":   r.Protections,
+	}
+	return conn.Repository().UpdateId(name, bson.M{"$set": update})
+}
+
+func without(list, removed []string) []string {
+	result := []string{}
+	for _, item := range list {
+		keep := true
+		for _, r := range removed {
+			if item == r {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			result = append(result, item)
+		}
+	}
+	return result
+}
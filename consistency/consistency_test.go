@@ -0,0 +1,188 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package consistency
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/gandalf/fs"
+	"github.com/tsuru/gandalf/repository"
+	"github.com/tsuru/gandalf/user"
+	"github.com/tsuru/tsuru/fs/fstest"
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct {
+	bare string
+}
+
+var _ = check.Suite(&S{})
+
+func (s *S) SetUpSuite(c *check.C) {
+	err := config.ReadConfigFile("../etc/gandalf.conf")
+	c.Assert(err, check.IsNil)
+	config.Set("database:url", "127.0.0.1:27017")
+	config.Set("database:name", "gandalf_consistency_tests")
+	config.Unset("git:bare:template")
+	s.bare = c.MkDir()
+	config.Set("git:bare:location", s.bare)
+}
+
+func (s *S) SetUpTest(c *check.C) {
+	fs.Fsystem = &fstest.RecordingFs{}
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	fs.Fsystem = nil
+	entries, err := ioutil.ReadDir(s.bare)
+	c.Assert(err, check.IsNil)
+	for _, entry := range entries {
+		os.RemoveAll(path.Join(s.bare, entry.Name()))
+	}
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	conn.Repository().RemoveAll(nil)
+	conn.User().RemoveAll(nil)
+	conn.Key().RemoveAll(nil)
+}
+
+func (s *S) TearDownSuite(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	conn.User().Database.DropDatabase()
+}
+
+func (s *S) mkdir(c *check.C, names ...string) {
+	for _, name := range names {
+		err := os.MkdirAll(path.Join(s.bare, name), 0755)
+		c.Assert(err, check.IsNil)
+	}
+}
+
+func (s *S) TestBareRepositories(c *check.C) {
+	s.mkdir(c, "shire.git", "mordor/orodruin.git", "mordor/notes", ".hooks", ".lfs/shire", "misc")
+	err := ioutil.WriteFile(path.Join(s.bare, "readme.git"), nil, 0644)
+	c.Assert(err, check.IsNil)
+	names, err := bareRepositories(s.bare)
+	c.Assert(err, check.IsNil)
+	c.Assert(names, check.DeepEquals, []string{"mordor/orodruin", "shire"})
+}
+
+func (s *S) TestBareRepositoriesWithoutLocation(c *check.C) {
+	names, err := bareRepositories(path.Join(s.bare, "nothere"))
+	c.Assert(err, check.IsNil)
+	c.Assert(names, check.HasLen, 0)
+}
+
+func (s *S) TestReferencedUsers(c *check.C) {
+	r := repository.Repository{
+		Users:         []string{"frodo", "sam"},
+		ReadOnlyUsers: []string{"gollum"},
+		Maintainers:   []string{"sam"},
+		Admins:        []string{"gandalf"},
+		Protections:   []repository.Protection{{Pattern: "master", Users: []string{"frodo", "aragorn"}}},
+	}
+	c.Assert(referencedUsers(&r), check.DeepEquals, []string{"frodo", "sam", "gollum", "gandalf", "aragorn"})
+}
+
+func (s *S) TestWithout(c *check.C) {
+	c.Assert(without([]string{"frodo", "sam", "gollum"}, []string{"gollum", "sauron"}), check.DeepEquals, []string{"frodo", "sam"})
+	c.Assert(without(nil, []string{"gollum"}), check.DeepEquals, []string{})
+}
+
+func (s *S) insertFixtures(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	for _, name := range []string{"frodo", "sam"} {
+		err = conn.User().Insert(&user.User{Name: name})
+		c.Assert(err, check.IsNil)
+	}
+	repos := []repository.Repository{
+		{Name: "shire", Users: []string{"frodo", "sam"}},
+		{Name: "moria", Users: []string{"frodo", "balrog"}, Protections: []repository.Protection{{Pattern: "master", Users: []string{"durin"}}}},
+	}
+	for _, r := range repos {
+		err = conn.Repository().Insert(&r)
+		c.Assert(err, check.IsNil)
+	}
+	keys := []user.Key{
+		{Name: "laptop", UserName: "frodo", Body: "ssh-rsa frodo"},
+		{Name: "palantir", UserName: "sauron", Body: "ssh-rsa sauron"},
+	}
+	for _, k := range keys {
+		err = conn.Key().Insert(&k)
+		c.Assert(err, check.IsNil)
+	}
+	s.mkdir(c, "shire.git", "isengard/orthanc.git", "invalid name.git")
+}
+
+func (s *S) TestCheck(c *check.C) {
+	s.insertFixtures(c)
+	report, err := Check()
+	c.Assert(err, check.IsNil)
+	c.Assert(report, check.DeepEquals, &Report{
+		MissingBare:  []string{"moria"},
+		OrphanBare:   []string{"invalid name", "isengard/orthanc"},
+		MissingUsers: []UserReference{{Repository: "moria", User: "balrog"}, {Repository: "moria", User: "durin"}},
+		OrphanKeys:   []KeyReference{{User: "sauron", Name: "palantir"}},
+	})
+	c.Assert(report.Problems(), check.Equals, 5)
+}
+
+func (s *S) TestCheckWithoutProblems(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.User().Insert(&user.User{Name: "frodo"})
+	c.Assert(err, check.IsNil)
+	err = conn.Repository().Insert(&repository.Repository{Name: "shire", Users: []string{"frodo"}})
+	c.Assert(err, check.IsNil)
+	s.mkdir(c, "shire.git", ".hooks")
+	report, err := Check()
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Problems(), check.Equals, 0)
+	c.Assert(report.Fixed, check.Equals, false)
+}
+
+func (s *S) TestFix(c *check.C) {
+	config.Set("authorized-keys-command", true)
+	defer config.Unset("authorized-keys-command")
+	s.insertFixtures(c)
+	report, err := Fix()
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Fixed, check.Equals, true)
+	c.Assert(report.Problems(), check.Equals, 5)
+	_, err = os.Stat(path.Join(s.bare, "moria.git", "HEAD"))
+	c.Assert(err, check.IsNil)
+	orthanc, err := repository.Get("isengard/orthanc")
+	c.Assert(err, check.IsNil)
+	c.Assert(orthanc.Users, check.HasLen, 0)
+	_, err = repository.Get("invalid name")
+	c.Assert(err, check.Equals, repository.ErrRepositoryNotFound)
+	moria, err := repository.Get("moria")
+	c.Assert(err, check.IsNil)
+	c.Assert(moria.Users, check.DeepEquals, []string{"frodo"})
+	c.Assert(moria.Protections[0].Users, check.DeepEquals, []string{})
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	count, err := conn.Key().Find(nil).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 1)
+	report, err = Check()
+	c.Assert(err, check.IsNil)
+	c.Assert(report.OrphanBare, check.DeepEquals, []string{"invalid name"})
+	c.Assert(report.Problems(), check.Equals, 1)
+}
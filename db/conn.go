@@ -6,6 +6,8 @@
 package db
 
 import (
+	"time"
+
 	"github.com/globalsign/mgo"
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/db/storage"
@@ -60,11 +62,104 @@ func (s *Storage) User() *storage.Collection {
 	return s.Collection("user")
 }
 
+// Group returns a reference to the "group" collection in MongoDB.
+func (s *Storage) Group() *storage.Collection {
+	return s.Collection("group")
+}
+
 func (s *Storage) Key() *storage.Collection {
 	bodyIndex := mgo.Index{Key: []string{"body"}, Unique: true}
 	nameIndex := mgo.Index{Key: []string{"username", "name"}, Unique: true}
+	fingerprintIndex := mgo.Index{Key: []string{"fingerprint"}}
 	c := s.Collection("key")
 	c.EnsureIndex(bodyIndex)
 	c.EnsureIndex(nameIndex)
+	c.EnsureIndex(fingerprintIndex)
+	return c
+}
+
+// Audit returns a reference to the "audit" collection in MongoDB.
+func (s *Storage) Audit() *storage.Collection {
+	timestampIndex := mgo.Index{Key: []string{"-timestamp"}}
+	repositoryIndex := mgo.Index{Key: []string{"repositories", "-timestamp"}}
+	c := s.Collection("audit")
+	c.EnsureIndex(timestampIndex)
+	c.EnsureIndex(repositoryIndex)
+	return c
+}
+
+// Token returns a reference to the "token" collection in MongoDB.
+func (s *Storage) Token() *storage.Collection {
+	hashIndex := mgo.Index{Key: []string{"hash"}, Unique: true}
+	c := s.Collection("token")
+	c.EnsureIndex(hashIndex)
+	return c
+}
+
+// ImportJob returns a reference to the "import_job" collection in MongoDB.
+func (s *Storage) ImportJob() *storage.Collection {
+	return s.Collection("import_job")
+}
+
+// Maintenance returns a reference to the "maintenance" collection in MongoDB.
+func (s *Storage) Maintenance() *storage.Collection {
+	return s.Collection("maintenance")
+}
+
+// Webhook returns a reference to the "webhook" collection in MongoDB.
+func (s *Storage) Webhook() *storage.Collection {
+	repositoryIndex := mgo.Index{Key: []string{"repository"}}
+	c := s.Collection("webhook")
+	c.EnsureIndex(repositoryIndex)
+	return c
+}
+
+// WebhookDelivery returns a reference to the "webhook_delivery" collection in
+// MongoDB.
+func (s *Storage) WebhookDelivery() *storage.Collection {
+	pendingIndex := mgo.Index{Key: []string{"status", "nextattempt"}}
+	webhookIndex := mgo.Index{Key: []string{"webhook", "-_id"}}
+	c := s.Collection("webhook_delivery")
+	c.EnsureIndex(pendingIndex)
+	c.EnsureIndex(webhookIndex)
+	return c
+}
+
+// Hook returns a reference to the "hook" collection in MongoDB.
+func (s *Storage) Hook() *storage.Collection {
+	locationIndex := mgo.Index{Key: []string{"name", "script", "repository"}, Unique: true}
+	c := s.Collection("hook")
+	c.EnsureIndex(locationIndex)
+	return c
+}
+
+// LFSToken returns a reference to the "lfs_token" collection in MongoDB.
+// Expired tokens are removed through a TTL index.
+func (s *Storage) LFSToken() *storage.Collection {
+	expirationIndex := mgo.Index{Key: []string{"expiresat"}, ExpireAfter: time.Second}
+	c := s.Collection("lfs_token")
+	c.EnsureIndex(expirationIndex)
+	return c
+}
+
+// Redirect returns a reference to the "redirect" collection in MongoDB.
+// Expired redirects are removed through a TTL index.
+func (s *Storage) Redirect() *storage.Collection {
+	expirationIndex := mgo.Index{Key: []string{"expires"}, ExpireAfter: time.Second}
+	repositoryIndex := mgo.Index{Key: []string{"repository"}}
+	c := s.Collection("redirect")
+	c.EnsureIndex(expirationIndex)
+	c.EnsureIndex(repositoryIndex)
+	return c
+}
+
+// DeletedRepository returns a reference to the "deleted_repository"
+// collection in MongoDB.
+func (s *Storage) DeletedRepository() *storage.Collection {
+	nameIndex := mgo.Index{Key: []string{"repository._id", "-deleted"}}
+	purgeIndex := mgo.Index{Key: []string{"purge"}}
+	c := s.Collection("deleted_repository")
+	c.EnsureIndex(nameIndex)
+	c.EnsureIndex(purgeIndex)
 	return c
 }
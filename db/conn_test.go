@@ -6,6 +6,7 @@ package db
 
 import (
 	"testing"
+	"time"
 
 	"github.com/tsuru/config"
 	"gopkg.in/check.v1"
@@ -47,6 +48,15 @@ func (s *S) TestSessionUserShouldReturnAMongoCollection(c *check.C) {
 	c.Assert(usr, check.DeepEquals, cUsr)
 }
 
+func (s *S) TestSessionGroupShouldReturnGroupCollection(c *check.C) {
+	conn, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	group := conn.Group()
+	cGroup := conn.Collection("group")
+	c.Assert(group, check.DeepEquals, cGroup)
+}
+
 func (s *S) TestSessionKeyShouldReturnKeyCollection(c *check.C) {
 	conn, err := Conn()
 	c.Assert(err, check.IsNil)
@@ -63,11 +73,113 @@ func (s *S) TestSessionKeyIndexes(c *check.C) {
 	key := conn.Key()
 	indexes, err := key.Indexes()
 	c.Assert(err, check.IsNil)
-	c.Check(indexes, check.HasLen, 3)
+	c.Check(indexes, check.HasLen, 4)
 	c.Check(indexes[1].Key, check.DeepEquals, []string{"body"})
 	c.Check(indexes[1].Unique, check.DeepEquals, true)
-	c.Check(indexes[2].Key, check.DeepEquals, []string{"username", "name"})
-	c.Check(indexes[2].Unique, check.DeepEquals, true)
+	c.Check(indexes[2].Key, check.DeepEquals, []string{"fingerprint"})
+	c.Check(indexes[2].Unique, check.DeepEquals, false)
+	c.Check(indexes[3].Key, check.DeepEquals, []string{"username", "name"})
+	c.Check(indexes[3].Unique, check.DeepEquals, true)
+}
+
+func (s *S) TestSessionAuditShouldReturnAuditCollection(c *check.C) {
+	conn, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	audit := conn.Audit()
+	cAudit := conn.Collection("audit")
+	c.Assert(audit, check.DeepEquals, cAudit)
+}
+
+func (s *S) TestSessionAuditIndexes(c *check.C) {
+	conn, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	indexes, err := conn.Audit().Indexes()
+	c.Assert(err, check.IsNil)
+	c.Check(indexes, check.HasLen, 3)
+	c.Check(indexes[1].Key, check.DeepEquals, []string{"repositories", "-timestamp"})
+	c.Check(indexes[2].Key, check.DeepEquals, []string{"-timestamp"})
+}
+
+func (s *S) TestSessionTokenShouldReturnTokenCollection(c *check.C) {
+	conn, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	token := conn.Token()
+	cToken := conn.Collection("token")
+	c.Assert(token, check.DeepEquals, cToken)
+}
+
+func (s *S) TestSessionTokenIndexes(c *check.C) {
+	conn, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	indexes, err := conn.Token().Indexes()
+	c.Assert(err, check.IsNil)
+	c.Check(indexes, check.HasLen, 2)
+	c.Check(indexes[1].Key, check.DeepEquals, []string{"hash"})
+	c.Check(indexes[1].Unique, check.DeepEquals, true)
+}
+
+func (s *S) TestSessionImportJobShouldReturnImportJobCollection(c *check.C) {
+	conn, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	job := conn.ImportJob()
+	cJob := conn.Collection("import_job")
+	c.Assert(job, check.DeepEquals, cJob)
+}
+
+func (s *S) TestSessionMaintenanceShouldReturnMaintenanceCollection(c *check.C) {
+	conn, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	maintenance := conn.Maintenance()
+	cMaintenance := conn.Collection("maintenance")
+	c.Assert(maintenance, check.DeepEquals, cMaintenance)
+}
+
+func (s *S) TestSessionWebhookShouldReturnWebhookCollection(c *check.C) {
+	conn, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	webhook := conn.Webhook()
+	cWebhook := conn.Collection("webhook")
+	c.Assert(webhook, check.DeepEquals, cWebhook)
+}
+
+func (s *S) TestSessionWebhookDeliveryIndexes(c *check.C) {
+	conn, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	indexes, err := conn.WebhookDelivery().Indexes()
+	c.Assert(err, check.IsNil)
+	c.Check(indexes, check.HasLen, 3)
+	c.Check(indexes[1].Key, check.DeepEquals, []string{"status", "nextattempt"})
+	c.Check(indexes[2].Key, check.DeepEquals, []string{"webhook", "-_id"})
+}
+
+func (s *S) TestSessionHookIndexes(c *check.C) {
+	conn, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	indexes, err := conn.Hook().Indexes()
+	c.Assert(err, check.IsNil)
+	c.Check(indexes, check.HasLen, 2)
+	c.Check(indexes[1].Key, check.DeepEquals, []string{"name", "script", "repository"})
+	c.Check(indexes[1].Unique, check.DeepEquals, true)
+}
+
+func (s *S) TestSessionLFSTokenIndexes(c *check.C) {
+	conn, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	indexes, err := conn.LFSToken().Indexes()
+	c.Assert(err, check.IsNil)
+	c.Check(indexes, check.HasLen, 2)
+	c.Check(indexes[1].Key, check.DeepEquals, []string{"expiresat"})
+	c.Check(indexes[1].ExpireAfter, check.Equals, time.Second)
 }
 
 func (s *S) TestConnect(c *check.C) {
@@ -107,3 +219,26 @@ func (s *S) TestDbConfig(c *check.C) {
 	c.Assert(url, check.Equals, "127.0.0.1:27017")
 	c.Assert(dbname, check.Equals, "gandalf")
 }
+
+func (s *S) TestSessionRedirectIndexes(c *check.C) {
+	conn, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	indexes, err := conn.Redirect().Indexes()
+	c.Assert(err, check.IsNil)
+	c.Check(indexes, check.HasLen, 3)
+	c.Check(indexes[1].Key, check.DeepEquals, []string{"expires"})
+	c.Check(indexes[1].ExpireAfter, check.Equals, time.Second)
+	c.Check(indexes[2].Key, check.DeepEquals, []string{"repository"})
+}
+
+func (s *S) TestSessionDeletedRepositoryIndexes(c *check.C) {
+	conn, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	indexes, err := conn.DeletedRepository().Indexes()
+	c.Assert(err, check.IsNil)
+	c.Check(indexes, check.HasLen, 3)
+	c.Check(indexes[1].Key, check.DeepEquals, []string{"repository._id", "-deleted"})
+	c.Check(indexes[2].Key, check.DeepEquals, []string{"purge"})
+}
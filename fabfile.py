@@ -21,6 +21,9 @@ def build():
     local("go clean ./...")
     local("go build -a -o dist/gandalf-webserver ./webserver")
     local("go build -a -o dist/gandalf ./bin")
+    local("go build -a -o dist/gandalf-admin ./admin")
+    local("go build -a -o dist/gandalf-ssh-keys ./ssh-keys")
+    local("go build -a -o dist/gandalf-sshd ./sshd")
 
 
 def clean():
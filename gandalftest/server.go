@@ -11,6 +11,9 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/gorilla/pat"
@@ -229,6 +232,7 @@ func (s *GandalfServer) buildMuxer() {
 	s.muxer.Delete("/repository/{name}", http.HandlerFunc(s.removeRepository))
 	s.muxer.Get("/repository/{name}/logs", http.HandlerFunc(s.getLogs))
 	s.muxer.Get("/repository/{name}", http.HandlerFunc(s.getRepository))
+	s.muxer.Get("/repository", http.HandlerFunc(s.listRepositories))
 	s.muxer.Get("/healthcheck", http.HandlerFunc(s.healthcheck))
 }
 
@@ -330,6 +334,79 @@ func (s *GandalfServer) getRepository(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// listRepositories lists the repositories like the real server does. As the
+// fake repositories have no disk usage, the disk_usage orders sort them by
+// name.
+func (s *GandalfServer) listRepositories(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	sortOrder := query.Get("sort")
+	switch sortOrder {
+	case "", "name", "disk_usage":
+		sortOrder = "name"
+	case "-name", "-disk_usage":
+		sortOrder = "-name"
+	default:
+		http.Error(w, fmt.Sprintf("invalid sort %q, valid options are: name, -name, disk_usage, -disk_usage", sortOrder), http.StatusBadRequest)
+		return
+	}
+	var public *bool
+	if value := query.Get("public"); value != "" {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "Invalid value for public, expected true or false: "+value, http.StatusBadRequest)
+			return
+		}
+		public = &b
+	}
+	limit := repository.DefaultListLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit: "+value, http.StatusBadRequest)
+			return
+		}
+	}
+	namespace, user, search, cursor := query.Get("namespace"), query.Get("user"), query.Get("search"), query.Get("cursor")
+	page := struct {
+		Repositories []Repository `json:"repositories"`
+		Next         string       `json:"next"`
+	}{Repositories: []Repository{}}
+	s.repoLock.RLock()
+	for _, repo := range s.repos {
+		if namespace != "" && !strings.HasPrefix(repo.Name, namespace+"/") {
+			continue
+		}
+		if search != "" && !strings.Contains(strings.ToLower(repo.Name), strings.ToLower(search)) {
+			continue
+		}
+		if public != nil && repo.IsPublic != *public {
+			continue
+		}
+		if user != "" && s.checkUserAccess(repo, user, false) < 0 && s.checkUserAccess(repo, user, true) < 0 {
+			continue
+		}
+		if cursor != "" && (sortOrder == "name" && repo.Name <= cursor || sortOrder == "-name" && repo.Name >= cursor) {
+			continue
+		}
+		page.Repositories = append(page.Repositories, repo)
+	}
+	s.repoLock.RUnlock()
+	sort.Slice(page.Repositories, func(i, j int) bool {
+		if sortOrder == "-name" {
+			return page.Repositories[i].Name > page.Repositories[j].Name
+		}
+		return page.Repositories[i].Name < page.Repositories[j].Name
+	})
+	if len(page.Repositories) > limit {
+		page.Repositories = page.Repositories[:limit]
+		page.Next = page.Repositories[limit-1].Name
+	}
+	err := json.NewEncoder(w).Encode(page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 func (s *GandalfServer) getLogs(w http.ResponseWriter, r *http.Request) {
 	name := r.URL.Query().Get(":name")
 	repo, index := s.findRepository(name)
@@ -304,6 +304,59 @@ func (s *S) TestGetRepository(c *check.C) {
 	c.Assert(got, check.DeepEquals, repo)
 }
 
+func (s *S) TestListRepositories(c *check.C) {
+	server, err := NewServer("127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer server.Stop()
+	server.repos = []Repository{
+		{Name: "team/myapp", Users: []string{"bob"}},
+		{Name: "other", ReadOnlyUsers: []string{"bob"}, IsPublic: true},
+		{Name: "team/yourapp", Users: []string{"alice"}},
+	}
+	var page struct {
+		Repositories []Repository
+		Next         string
+	}
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/repository?user=bob&limit=1", nil)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	err = json.NewDecoder(recorder.Body).Decode(&page)
+	c.Assert(err, check.IsNil)
+	c.Assert(page.Repositories, check.HasLen, 1)
+	c.Assert(page.Repositories[0].Name, check.Equals, "other")
+	c.Assert(page.Next, check.Not(check.Equals), "")
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "/repository?user=bob&limit=1&cursor="+page.Next, nil)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	page.Repositories = nil
+	err = json.NewDecoder(recorder.Body).Decode(&page)
+	c.Assert(err, check.IsNil)
+	c.Assert(page.Repositories, check.HasLen, 1)
+	c.Assert(page.Repositories[0].Name, check.Equals, "team/myapp")
+	c.Assert(page.Next, check.Equals, "")
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "/repository?namespace=team&search=YOUR&public=false", nil)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	page.Repositories = nil
+	err = json.NewDecoder(recorder.Body).Decode(&page)
+	c.Assert(err, check.IsNil)
+	c.Assert(page.Repositories, check.HasLen, 1)
+	c.Assert(page.Repositories[0].Name, check.Equals, "team/yourapp")
+}
+
+func (s *S) TestListRepositoriesInvalidSort(c *check.C) {
+	server, err := NewServer("127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer server.Stop()
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/repository?sort=size", nil)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
 func (s *S) TestGetRepositoryNotFound(c *check.C) {
 	server, err := NewServer("127.0.0.1:0")
 	c.Assert(err, check.IsNil)
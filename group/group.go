@@ -0,0 +1,208 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package group manages groups of users, which can be granted access to
+// repositories as a whole.
+package group
+
+import (
+	"errors"
+	"regexp"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/tsuru/log"
+)
+
+var (
+	ErrGroupAlreadyExists = errors.New("group already exists")
+	ErrGroupNotFound      = errors.New("group not found")
+
+	groupNameRegexp = regexp.MustCompile(`^[\w-+.@]+$`)
+)
+
+// Group is a named set of users. Repositories keep the names of the groups
+// with access to them, in the same way they keep the names of users.
+type Group struct {
+	Name    string   `bson:"_id" json:"name"`
+	Members []string `json:"members"`
+}
+
+func (g *Group) isValid() (bool, error) {
+	if !groupNameRegexp.MatchString(g.Name) {
+		return false, &InvalidGroupError{message: "group name is not valid"}
+	}
+	return true, nil
+}
+
+// New creates a group with the given members.
+func New(name string, members []string) (*Group, error) {
+	log.Debugf("Creating group %q", name)
+	if members == nil {
+		members = []string{}
+	}
+	g := &Group{Name: name, Members: members}
+	if v, err := g.isValid(); !v {
+		log.Errorf("group.New: Invalid group %q: %s", name, err)
+		return nil, err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err = conn.Group().Insert(g); err != nil {
+		if mgo.IsDup(err) {
+			return nil, ErrGroupAlreadyExists
+		}
+		return nil, err
+	}
+	return g, nil
+}
+
+// Get finds a group by name.
+func Get(name string) (Group, error) {
+	var g Group
+	conn, err := db.Conn()
+	if err != nil {
+		return g, err
+	}
+	defer conn.Close()
+	err = conn.Group().FindId(name).One(&g)
+	if err == mgo.ErrNotFound {
+		return g, ErrGroupNotFound
+	}
+	return g, err
+}
+
+// List returns all groups, sorted by name.
+func List() ([]Group, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	groups := []Group{}
+	err = conn.Group().Find(nil).Sort("_id").All(&groups)
+	return groups, err
+}
+
+// Remove deletes the group and revokes its access from all repositories.
+func Remove(name string) error {
+	log.Debugf("Removing group %q", name)
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err = conn.Group().RemoveId(name); err != nil {
+		if err == mgo.ErrNotFound {
+			return ErrGroupNotFound
+		}
+		return err
+	}
+	_, err = conn.Repository().UpdateAll(
+		bson.M{"$or": []bson.M{{"groups": name}, {"readonlygroups": name}}},
+		bson.M{"$pull": bson.M{"groups": name, "readonlygroups": name}},
+	)
+	return err
+}
+
+// AddMembers adds the given users to the group.
+func AddMembers(name string, members []string) error {
+	log.Debugf("Adding %v to group %q", members, name)
+	return updateMembers(name, bson.M{"$addToSet": bson.M{"members": bson.M{"$each": members}}})
+}
+
+// RemoveMembers removes the given users from the group.
+func RemoveMembers(name string, members []string) error {
+	log.Debugf("Removing %v from group %q", members, name)
+	return updateMembers(name, bson.M{"$pullAll": bson.M{"members": members}})
+}
+
+func updateMembers(name string, update bson.M) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Group().UpdateId(name, update)
+	if err == mgo.ErrNotFound {
+		return ErrGroupNotFound
+	}
+	return err
+}
+
+// RemoveUser removes the given user from all groups.
+func RemoveUser(userName string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Group().UpdateAll(bson.M{"members": userName}, bson.M{"$pull": bson.M{"members": userName}})
+	return err
+}
+
+// IsMember returns whether the user belongs to at least one of the given
+// groups.
+func IsMember(groups []string, userName string) bool {
+	if len(groups) == 0 || userName == "" {
+		return false
+	}
+	return countGroups(bson.M{"_id": bson.M{"$in": groups}, "members": userName}) > 0
+}
+
+// GroupsOf returns the names of the groups the user belongs to, sorted by
+// name.
+func GroupsOf(userName string) ([]string, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var groups []Group
+	err = conn.Group().Find(bson.M{"members": userName}).Select(bson.M{"_id": 1}).Sort("_id").All(&groups)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(groups))
+	for i, g := range groups {
+		names[i] = g.Name
+	}
+	return names, nil
+}
+
+// HasOtherMembers returns whether at least one of the given groups has a
+// member other than the given user.
+func HasOtherMembers(groups []string, userName string) bool {
+	if len(groups) == 0 {
+		return false
+	}
+	return countGroups(bson.M{"_id": bson.M{"$in": groups}, "members": bson.M{"$elemMatch": bson.M{"$ne": userName}}}) > 0
+}
+
+func countGroups(query bson.M) int {
+	conn, err := db.Conn()
+	if err != nil {
+		log.Errorf("group: failed to connect to the database: %s", err)
+		return 0
+	}
+	defer conn.Close()
+	n, err := conn.Group().Find(query).Count()
+	if err != nil {
+		log.Errorf("group: failed to find groups: %s", err)
+		return 0
+	}
+	return n
+}
+
+type InvalidGroupError struct {
+	message string
+}
+
+func (err *InvalidGroupError) Error() string {
+	return err.message
+}
@@ -0,0 +1,204 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package group
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct{}
+
+var _ = check.Suite(&S{})
+
+func (s *S) SetUpSuite(c *check.C) {
+	err := config.ReadConfigFile("../etc/gandalf.conf")
+	c.Assert(err, check.IsNil)
+	config.Set("database:url", "127.0.0.1:27017")
+	config.Set("database:name", "gandalf_group_tests")
+}
+
+func (s *S) TearDownSuite(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	conn.User().Database.DropDatabase()
+}
+
+func (s *S) TestNew(c *check.C) {
+	g, err := New("devs", []string{"bob", "alice"})
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Group().RemoveId("devs")
+	c.Assert(g.Name, check.Equals, "devs")
+	var stored Group
+	err = conn.Group().FindId("devs").One(&stored)
+	c.Assert(err, check.IsNil)
+	c.Assert(stored.Members, check.DeepEquals, []string{"bob", "alice"})
+}
+
+func (s *S) TestNewDuplicate(c *check.C) {
+	_, err := New("devs", nil)
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Group().RemoveId("devs")
+	_, err = New("devs", nil)
+	c.Assert(err, check.Equals, ErrGroupAlreadyExists)
+}
+
+func (s *S) TestNewInvalidName(c *check.C) {
+	_, err := New("dev team", nil)
+	c.Assert(err, check.ErrorMatches, "group name is not valid")
+	_, ok := err.(*InvalidGroupError)
+	c.Assert(ok, check.Equals, true)
+}
+
+func (s *S) TestGetNotFound(c *check.C) {
+	_, err := Get("ghost")
+	c.Assert(err, check.Equals, ErrGroupNotFound)
+}
+
+func (s *S) TestList(c *check.C) {
+	_, err := New("qa", nil)
+	c.Assert(err, check.IsNil)
+	_, err = New("devs", nil)
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Group().RemoveAll(bson.M{"_id": bson.M{"$in": []string{"devs", "qa"}}})
+	groups, err := List()
+	c.Assert(err, check.IsNil)
+	c.Assert(groups, check.HasLen, 2)
+	c.Assert(groups[0].Name, check.Equals, "devs")
+	c.Assert(groups[1].Name, check.Equals, "qa")
+}
+
+func (s *S) TestRemove(c *check.C) {
+	_, err := New("devs", nil)
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	repo := bson.M{"_id": "myrepo", "groups": []string{"devs", "ops"}, "readonlygroups": []string{"devs"}}
+	err = conn.Repository().Insert(repo)
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("myrepo")
+	err = Remove("devs")
+	c.Assert(err, check.IsNil)
+	_, err = Get("devs")
+	c.Assert(err, check.Equals, ErrGroupNotFound)
+	var stored bson.M
+	err = conn.Repository().FindId("myrepo").One(&stored)
+	c.Assert(err, check.IsNil)
+	c.Assert(stored["groups"], check.DeepEquals, []interface{}{"ops"})
+	c.Assert(stored["readonlygroups"], check.DeepEquals, []interface{}{})
+}
+
+func (s *S) TestRemoveNotFound(c *check.C) {
+	err := Remove("ghost")
+	c.Assert(err, check.Equals, ErrGroupNotFound)
+}
+
+func (s *S) TestAddAndRemoveMembers(c *check.C) {
+	_, err := New("devs", []string{"bob"})
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Group().RemoveId("devs")
+	err = AddMembers("devs", []string{"alice", "bob"})
+	c.Assert(err, check.IsNil)
+	g, err := Get("devs")
+	c.Assert(err, check.IsNil)
+	c.Assert(g.Members, check.DeepEquals, []string{"bob", "alice"})
+	err = RemoveMembers("devs", []string{"bob"})
+	c.Assert(err, check.IsNil)
+	g, err = Get("devs")
+	c.Assert(err, check.IsNil)
+	c.Assert(g.Members, check.DeepEquals, []string{"alice"})
+}
+
+func (s *S) TestAddMembersGroupNotFound(c *check.C) {
+	err := AddMembers("ghost", []string{"bob"})
+	c.Assert(err, check.Equals, ErrGroupNotFound)
+}
+
+func (s *S) TestRemoveUser(c *check.C) {
+	_, err := New("devs", []string{"bob", "alice"})
+	c.Assert(err, check.IsNil)
+	_, err = New("qa", []string{"bob"})
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Group().RemoveAll(bson.M{"_id": bson.M{"$in": []string{"devs", "qa"}}})
+	err = RemoveUser("bob")
+	c.Assert(err, check.IsNil)
+	g, err := Get("devs")
+	c.Assert(err, check.IsNil)
+	c.Assert(g.Members, check.DeepEquals, []string{"alice"})
+	g, err = Get("qa")
+	c.Assert(err, check.IsNil)
+	c.Assert(g.Members, check.HasLen, 0)
+}
+
+func (s *S) TestIsMember(c *check.C) {
+	_, err := New("devs", []string{"bob"})
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Group().RemoveId("devs")
+	c.Assert(IsMember([]string{"qa", "devs"}, "bob"), check.Equals, true)
+	c.Assert(IsMember([]string{"qa", "devs"}, "alice"), check.Equals, false)
+	c.Assert(IsMember([]string{"qa"}, "bob"), check.Equals, false)
+}
+
+func (s *S) TestIsMemberWithoutGroups(c *check.C) {
+	c.Assert(IsMember(nil, "bob"), check.Equals, false)
+	c.Assert(IsMember([]string{"devs"}, ""), check.Equals, false)
+}
+
+func (s *S) TestGroupsOf(c *check.C) {
+	_, err := New("qa", []string{"bob", "alice"})
+	c.Assert(err, check.IsNil)
+	_, err = New("devs", []string{"bob"})
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Group().RemoveAll(bson.M{"_id": bson.M{"$in": []string{"devs", "qa"}}})
+	groups, err := GroupsOf("bob")
+	c.Assert(err, check.IsNil)
+	c.Assert(groups, check.DeepEquals, []string{"devs", "qa"})
+	groups, err = GroupsOf("mallory")
+	c.Assert(err, check.IsNil)
+	c.Assert(groups, check.HasLen, 0)
+}
+
+func (s *S) TestHasOtherMembers(c *check.C) {
+	_, err := New("devs", []string{"bob", "alice"})
+	c.Assert(err, check.IsNil)
+	_, err = New("qa", []string{"bob"})
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Group().RemoveAll(bson.M{"_id": bson.M{"$in": []string{"devs", "qa"}}})
+	c.Assert(HasOtherMembers([]string{"devs"}, "bob"), check.Equals, true)
+	c.Assert(HasOtherMembers([]string{"qa"}, "bob"), check.Equals, false)
+	c.Assert(HasOtherMembers(nil, "bob"), check.Equals, false)
+}
@@ -0,0 +1,107 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hook
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/fs"
+	"github.com/tsuru/gandalf/repository"
+)
+
+// ConfigEnv is the environment variable defining the configuration file read
+// by gandalf-ssh, so the built-in hooks use the configuration of the server
+// running git.
+const ConfigEnv = "GANDALF_CONFIG"
+
+var configFile string
+
+// SetConfigFile records the configuration file read by the running server,
+// which is passed to the built-in hooks by ReceiveEnv.
+func SetConfigFile(file string) {
+	if abs, err := filepath.Abs(file); err == nil && file != "" {
+		file = abs
+	}
+	configFile = file
+}
+
+// builtinHooks are the hooks dispatched through gandalf-ssh when pushing.
+// gandalf-ssh runs the built-in checks of the hook (if any) and then the
+// hooks installed in the repository, so custom hooks keep working.
+var builtinHooks = names
+
+// BuiltinLocation returns the directory where the built-in hooks are
+// installed. It's defined by the "git:hooks:location" setting and defaults to
+// the .hooks directory inside "git:bare:location".
+func BuiltinLocation() (string, error) {
+	if location, err := config.GetString("git:hooks:location"); err == nil {
+		return location, nil
+	}
+	bare, err := config.GetString("git:bare:location")
+	if err != nil {
+		return "", err
+	}
+	return path.Join(bare, ".hooks"), nil
+}
+
+// InstallBuiltin writes the scripts of the built-in hooks, which invoke
+// gandalf-ssh in hook mode, and returns the directory containing them.
+func InstallBuiltin() (string, error) {
+	location, err := BuiltinLocation()
+	if err != nil {
+		return "", err
+	}
+	binPath, err := config.GetString("bin-path")
+	if err != nil {
+		return "", err
+	}
+	if err = fs.Filesystem().MkdirAll(location, 0755); err != nil {
+		return "", err
+	}
+	for _, name := range builtinHooks {
+		content := fmt.Sprintf("#!/bin/sh\nGANDALF_HOOK=%s exec %s \"$@\"\n", name, binPath)
+		if err = createHookFile(path.Join(location, name), []byte(content)); err != nil {
+			return "", err
+		}
+	}
+	return location, nil
+}
+
+// ReceiveEnv returns the environment for running git-receive-pack on the given
+// repository on behalf of the given user, so the built-in hooks are executed
+// with the configuration file of the running server, see SetConfigFile.
+// When the "quota:max-push-size" setting is defined, git-receive-pack also
+// rejects packs bigger than it.
+func ReceiveEnv(repo, userName string) ([]string, error) {
+	location, err := InstallBuiltin()
+	if err != nil {
+		return nil, err
+	}
+	settings := [][2]string{{"core.hooksPath", location}}
+	if maxSize := repository.MaxPushSize(); maxSize > 0 {
+		settings = append(settings, [2]string{"receive.maxInputSize", strconv.FormatInt(maxSize, 10)})
+	}
+	env := os.Environ()
+	if configFile != "" {
+		env = append(env, ConfigEnv+"="+configFile)
+	}
+	env = append(env,
+		"TSURU_USER="+userName,
+		"GANDALF_REPOSITORY="+repo,
+		"GIT_CONFIG_COUNT="+strconv.Itoa(len(settings)),
+	)
+	for i, setting := range settings {
+		env = append(env,
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, setting[0]),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, setting[1]),
+		)
+	}
+	return env, nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hook
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/fs"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestBuiltinLocation(c *check.C) {
+	location, err := BuiltinLocation()
+	c.Assert(err, check.IsNil)
+	c.Assert(location, check.Equals, "/var/lib/gandalf/repositories/.hooks")
+}
+
+func (s *S) TestBuiltinLocationFromConfig(c *check.C) {
+	config.Set("git:hooks:location", "/var/lib/gandalf/hooks")
+	defer config.Unset("git:hooks:location")
+	location, err := BuiltinLocation()
+	c.Assert(err, check.IsNil)
+	c.Assert(location, check.Equals, "/var/lib/gandalf/hooks")
+}
+
+func (s *S) TestInstallBuiltin(c *check.C) {
+	location, err := InstallBuiltin()
+	c.Assert(err, check.IsNil)
+	c.Assert(location, check.Equals, "/var/lib/gandalf/repositories/.hooks")
+	for _, name := range builtinHooks {
+		file, err := fs.Filesystem().OpenFile(location+"/"+name, os.O_RDONLY, 0755)
+		c.Assert(err, check.IsNil)
+		content, err := ioutil.ReadAll(file)
+		file.Close()
+		c.Assert(err, check.IsNil)
+		c.Assert(string(content), check.Equals, "#!/bin/sh\nGANDALF_HOOK="+name+" exec /usr/bin/gandalf-ssh \"$@\"\n")
+	}
+}
+
+func (s *S) TestReceiveEnv(c *check.C) {
+	env, err := ReceiveEnv("myrepo", "bob")
+	c.Assert(err, check.IsNil)
+	expected := []string{
+		"TSURU_USER=bob",
+		"GANDALF_REPOSITORY=myrepo",
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=core.hooksPath",
+		"GIT_CONFIG_VALUE_0=/var/lib/gandalf/repositories/.hooks",
+	}
+	c.Assert(env[len(env)-len(expected):], check.DeepEquals, expected)
+}
+
+func (s *S) TestReceiveEnvWithConfigFile(c *check.C) {
+	SetConfigFile("/etc/gandalf/gandalf.conf")
+	defer SetConfigFile("")
+	env, err := ReceiveEnv("myrepo", "bob")
+	c.Assert(err, check.IsNil)
+	expected := []string{"GANDALF_CONFIG=/etc/gandalf/gandalf.conf", "TSURU_USER=bob"}
+	var found []string
+	for _, e := range env {
+		if e == expected[0] || e == expected[1] {
+			found = append(found, e)
+		}
+	}
+	c.Assert(found, check.DeepEquals, expected)
+}
+
+func (s *S) TestReceiveEnvWithMaxPushSize(c *check.C) {
+	config.Set("quota:max-push-size", "10M")
+	defer config.Unset("quota:max-push-size")
+	env, err := ReceiveEnv("myrepo", "bob")
+	c.Assert(err, check.IsNil)
+	expected := []string{
+		"GIT_CONFIG_COUNT=2",
+		"GIT_CONFIG_KEY_0=core.hooksPath",
+		"GIT_CONFIG_VALUE_0=/var/lib/gandalf/repositories/.hooks",
+		"GIT_CONFIG_KEY_1=receive.maxInputSize",
+		"GIT_CONFIG_VALUE_1=10485760",
+	}
+	c.Assert(env[len(env)-len(expected):], check.DeepEquals, expected)
+}
@@ -5,13 +5,95 @@
 package hook
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
 	"os"
+	"path"
+	"regexp"
 	"strings"
+	"time"
 
+	"github.com/globalsign/mgo/bson"
 	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
 	"github.com/tsuru/gandalf/fs"
+	"github.com/tsuru/tsuru/log"
 )
 
+// names lists the hooks that may be installed through Add.
+var names = []string{"pre-receive", "update", "post-receive", "post-update", "push-to-checkout", "reference-transaction", "proc-receive"}
+
+// scriptRegexp matches the valid names of chained scripts.
+var scriptRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+var (
+	ErrUnsupportedHook = errors.New("Unsupported hook, valid options are: pre-receive, update, post-receive, post-update, push-to-checkout, reference-transaction or proc-receive")
+	ErrInvalidScript   = errors.New("Invalid script name, it may only contain letters, numbers, dots, dashes and underscores")
+	ErrNotChainable    = errors.New("proc-receive hooks can not be chained")
+	ErrHookNotFound    = errors.New("hook not found")
+)
+
+// Hook describes a hook script installed in the bare template, when
+// Repository is empty, or in a repository. Content is only filled by Get.
+//
+// Each hook may have a main script, with an empty Script, and many named
+// scripts, installed in the <name>.d directory. The main script runs first,
+// followed by the named scripts in the lexical order of their names.
+type Hook struct {
+	Name        string    `json:"name"`
+	Script      string    `json:"script,omitempty"`
+	Repository  string    `json:"repository,omitempty"`
+	Checksum    string    `json:"checksum"`
+	InstalledAt time.Time `json:"installed_at"`
+	InstalledBy string    `json:"installed_by"`
+	Content     string    `bson:"-" json:"content,omitempty"`
+}
+
+// Supported returns whether a hook with the given name may be installed.
+func Supported(name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Chainable returns whether named scripts may be installed for the hook with
+// the given name. proc-receive hooks talk to git through a protocol in their
+// standard input and output, so only the main script is supported.
+func Chainable(name string) bool {
+	return name != "proc-receive"
+}
+
+// ScriptDir returns the name of the directory of the named scripts of the
+// hook with the given name, relative to the hooks directory.
+func ScriptDir(name string) string {
+	return name + ".d"
+}
+
+// validateScript checks whether the script with the given name may be
+// installed for the hook.
+func validateScript(name, script string) error {
+	if script == "" {
+		return nil
+	}
+	if !Chainable(name) {
+		return ErrNotChainable
+	}
+	if !scriptRegexp.MatchString(script) {
+		return ErrInvalidScript
+	}
+	return nil
+}
+
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
 func createHookFile(path string, content []byte) error {
 	file, err := fs.Filesystem().OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
 	if err != nil {
@@ -25,36 +107,235 @@ func createHookFile(path string, content []byte) error {
 	return nil
 }
 
+// hookDir returns the directory of the hooks of the given repository, or of
+// the bare template when the repository is empty.
+func hookDir(repo string) (string, error) {
+	if repo == "" {
+		path, err := config.GetString("git:bare:template")
+		if err != nil {
+			return "", err
+		}
+		return strings.Join([]string{path, "hooks"}, "/"), nil
+	}
+	path, err := config.GetString("git:bare:location")
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{path, repo + ".git", "hooks"}, "/"), nil
+}
+
+// scriptPath returns the path of the script of the hook in the given
+// directory of hooks.
+func scriptPath(dirPath, name, script string) string {
+	if script == "" {
+		return strings.Join([]string{dirPath, name}, "/")
+	}
+	return strings.Join([]string{dirPath, ScriptDir(name), script}, "/")
+}
+
 // Adds a hook script.
-func Add(name string, repos []string, content []byte) error {
-	configParam := "git:bare:template"
-	if len(repos) > 0 {
-		configParam = "git:bare:location"
+//
+// The hook is written to each of the given repositories, or to the bare
+// template when there are no repositories, and its metadata is recorded on
+// behalf of installedBy. When script is empty, the main script of the hook is
+// replaced, otherwise the named script is added to the chain of the hook,
+// replacing the script with the same name.
+func Add(name, script string, repos []string, content []byte, installedBy string) error {
+	if err := validateScript(name, script); err != nil {
+		return err
+	}
+	if len(repos) == 0 {
+		_, err := install(name, script, "", content, installedBy)
+		return err
+	}
+	for _, repo := range repos {
+		if _, err := install(name, script, repo, content, installedBy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func install(name, script, repo string, content []byte, installedBy string) (*Hook, error) {
+	log.Debugf("Installing script %q of hook %q in %q", script, name, repo)
+	dirPath, err := hookDir(repo)
+	if err != nil {
+		return nil, err
+	}
+	if repo != "" || script != "" {
+		if err = fs.Filesystem().MkdirAll(path.Dir(scriptPath(dirPath, name, script)), 0755); err != nil {
+			return nil, err
+		}
+	}
+	if err = createHookFile(scriptPath(dirPath, name, script), content); err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	h := Hook{
+		Name:        name,
+		Script:      script,
+		Repository:  repo,
+		Checksum:    checksum(content),
+		InstalledAt: time.Now().UTC(),
+		InstalledBy: installedBy,
+	}
+	_, err = conn.Hook().Upsert(bson.M{"name": name, "script": script, "repository": repo}, h)
+	return &h, err
+}
+
+// List returns the metadata of all hooks installed through Add, sorted by
+// name, repository and script. Template hooks come before the ones of
+// repositories.
+func List() ([]Hook, error) {
+	return list(nil)
+}
+
+// ListRepository returns the metadata of the hooks installed in the given
+// repository through Add.
+func ListRepository(repo string) ([]Hook, error) {
+	return list(bson.M{"repository": repo})
+}
+
+func list(query bson.M) ([]Hook, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	hooks := []Hook{}
+	err = conn.Hook().Find(query).Sort("name", "repository", "script").All(&hooks)
+	return hooks, err
+}
+
+// Get returns the script of the hook with the given name installed in the
+// repository, or in the bare template when the repository is empty, including
+// its content. The checksum is the one of the installed file, which may have
+// been changed after being installed through Add.
+func Get(name, script, repo string) (*Hook, error) {
+	if err := validateScript(name, script); err != nil {
+		return nil, err
+	}
+	dirPath, err := hookDir(repo)
+	if err != nil {
+		return nil, err
+	}
+	file, err := fs.Filesystem().Open(scriptPath(dirPath, name, script))
+	if os.IsNotExist(err) {
+		return nil, ErrHookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	h := Hook{Name: name, Script: script, Repository: repo}
+	conn.Hook().Find(bson.M{"name": name, "script": script, "repository": repo}).One(&h)
+	h.Checksum = checksum(content)
+	h.Content = string(content)
+	return &h, nil
+}
+
+// Remove removes the script of the hook with the given name from each of the
+// given repositories, or from the bare template when there are no
+// repositories, along with its metadata.
+func Remove(name, script string, repos []string) error {
+	if err := validateScript(name, script); err != nil {
+		return err
 	}
-	path, err := config.GetString(configParam)
+	if len(repos) == 0 {
+		repos = []string{""}
+	}
+	conn, err := db.Conn()
 	if err != nil {
 		return err
 	}
-	if len(repos) > 0 {
+	defer conn.Close()
+	for _, repo := range repos {
+		log.Debugf("Removing script %q of hook %q from %q", script, name, repo)
+		dirPath, err := hookDir(repo)
+		if err != nil {
+			return err
+		}
+		filePath := scriptPath(dirPath, name, script)
+		if _, err = fs.Filesystem().Stat(filePath); os.IsNotExist(err) {
+			return ErrHookNotFound
+		}
+		if err = fs.Filesystem().Remove(filePath); err != nil {
+			return err
+		}
+		if _, err = conn.Hook().RemoveAll(bson.M{"name": name, "script": script, "repository": repo}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyTemplate installs the hooks of the bare template in the given
+// repositories, or in all repositories when there are none, replacing the
+// scripts of the same name. New repositories get the hooks of the template
+// when created, so this is only needed after changing the template. Named
+// scripts are only applied when installed in the template through Add.
+//
+// It returns the metadata of the installed hooks.
+func ApplyTemplate(repos []string, installedBy string) ([]Hook, error) {
+	if len(repos) == 0 {
+		conn, err := db.Conn()
+		if err != nil {
+			return nil, err
+		}
+		var all []struct {
+			Name string `bson:"_id"`
+		}
+		err = conn.Repository().Find(nil).Select(bson.M{"_id": 1}).Sort("_id").All(&all)
+		conn.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range all {
+			repos = append(repos, r.Name)
+		}
+	}
+	templateHooks, err := ListRepository("")
+	if err != nil {
+		return nil, err
+	}
+	scripts := make([]Hook, 0, len(names)+len(templateHooks))
+	for _, name := range names {
+		scripts = append(scripts, Hook{Name: name})
+	}
+	for _, h := range templateHooks {
+		if h.Script != "" {
+			scripts = append(scripts, h)
+		}
+	}
+	installed := []Hook{}
+	for _, s := range scripts {
+		h, err := Get(s.Name, s.Script, "")
+		if err == ErrHookNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
 		for _, repo := range repos {
-			repo += ".git"
-			s := []string{path, repo, "hooks"}
-			dirPath := strings.Join(s, "/")
-			err = fs.Filesystem().MkdirAll(dirPath, 0755)
+			repoHook, err := install(h.Name, h.Script, repo, []byte(h.Content), installedBy)
 			if err != nil {
-				return err
-			}
-			s = []string{dirPath, name}
-			scriptPath := strings.Join(s, "/")
-			err = createHookFile(scriptPath, content)
-			if err != nil {
-				return err
+				return nil, err
 			}
+			installed = append(installed, *repoHook)
 		}
-	} else {
-		s := []string{path, "hooks", name}
-		scriptPath := strings.Join(s, "/")
-		return createHookFile(scriptPath, content)
 	}
-	return nil
+	return installed, nil
 }
@@ -9,6 +9,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/globalsign/mgo/bson"
 	"github.com/tsuru/commandmocker"
 	"github.com/tsuru/config"
 	"github.com/tsuru/gandalf/db"
@@ -44,6 +45,10 @@ func (s *S) SetUpTest(c *check.C) {
 
 func (s *S) TearDownTest(c *check.C) {
 	fs.Fsystem = nil
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	conn.Hook().RemoveAll(nil)
 }
 
 func (s *S) TearDownSuite(c *check.C) {
@@ -67,7 +72,7 @@ func (s *S) TestCanCreateHookFile(c *check.C) {
 
 func (s *S) TestCanAddNewHook(c *check.C) {
 	hookContent := []byte("some content")
-	err := Add("test-can-add-new-hook", []string{}, hookContent)
+	err := Add("test-can-add-new-hook", "", []string{}, hookContent, "tsuru")
 	c.Assert(err, check.IsNil)
 	file, err := fs.Filesystem().OpenFile("/home/git/bare-template/hooks/test-can-add-new-hook", os.O_RDONLY, 0755)
 	defer file.Close()
@@ -83,7 +88,7 @@ func (s *S) TestCanAddNewHookInOldRepository(c *check.C) {
 	err := fs.Fsystem.RemoveAll(bareTemplate + "/hooks")
 	c.Assert(err, check.IsNil)
 	hookContent := []byte("some content")
-	err = Add("test-can-add-new-hook", []string{}, hookContent)
+	err = Add("test-can-add-new-hook", "", []string{}, hookContent, "tsuru")
 	c.Assert(err, check.IsNil)
 	file, err := fs.Filesystem().OpenFile("/home/git/bare-template/hooks/test-can-add-new-hook", os.O_RDONLY, 0755)
 	defer file.Close()
@@ -94,7 +99,7 @@ func (s *S) TestCanAddNewHookInOldRepository(c *check.C) {
 
 func (s *S) TestCanAddNewRepository(c *check.C) {
 	hookContent := []byte("some content")
-	err := Add("test-can-add-new-repository-hook", []string{"some-repo"}, hookContent)
+	err := Add("test-can-add-new-repository-hook", "", []string{"some-repo"}, hookContent, "tsuru")
 	c.Assert(err, check.IsNil)
 	file, err := fs.Filesystem().OpenFile("/var/lib/gandalf/repositories/some-repo.git/hooks/test-can-add-new-repository-hook", os.O_RDONLY, 0755)
 	c.Assert(err, check.IsNil)
@@ -103,3 +108,184 @@ func (s *S) TestCanAddNewRepository(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(string(content), check.Equals, "some content")
 }
+
+func (s *S) TestSupported(c *check.C) {
+	c.Assert(Supported("post-receive"), check.Equals, true)
+	c.Assert(Supported("pre-receive"), check.Equals, true)
+	c.Assert(Supported("update"), check.Equals, true)
+	c.Assert(Supported("post-update"), check.Equals, true)
+	c.Assert(Supported("push-to-checkout"), check.Equals, true)
+	c.Assert(Supported("reference-transaction"), check.Equals, true)
+	c.Assert(Supported("proc-receive"), check.Equals, true)
+	c.Assert(Supported("pre-commit"), check.Equals, false)
+}
+
+func (s *S) TestAddScript(c *check.C) {
+	err := Add("post-receive", "10-deploy", []string{"some-repo"}, []byte("deploy"), "tsuru")
+	c.Assert(err, check.IsNil)
+	err = Add("post-receive", "20-notify", []string{"some-repo"}, []byte("notify"), "tsuru")
+	c.Assert(err, check.IsNil)
+	for script, expected := range map[string]string{"10-deploy": "deploy", "20-notify": "notify"} {
+		h, err := Get("post-receive", script, "some-repo")
+		c.Assert(err, check.IsNil)
+		c.Check(h.Content, check.Equals, expected)
+	}
+	c.Assert(s.rfs.HasAction("openfile /var/lib/gandalf/repositories/some-repo.git/hooks/post-receive.d/10-deploy with mode 0755"), check.Equals, true)
+	_, err = Get("post-receive", "", "some-repo")
+	c.Assert(err, check.Equals, ErrHookNotFound)
+	hooks, err := ListRepository("some-repo")
+	c.Assert(err, check.IsNil)
+	c.Assert(hooks, check.HasLen, 2)
+	c.Assert(hooks[0].Script, check.Equals, "10-deploy")
+	c.Assert(hooks[1].Script, check.Equals, "20-notify")
+}
+
+func (s *S) TestAddInvalidScript(c *check.C) {
+	for _, script := range []string{"../post-receive", ".hidden", "with space"} {
+		err := Add("post-receive", script, []string{"some-repo"}, []byte("content"), "tsuru")
+		c.Check(err, check.Equals, ErrInvalidScript)
+	}
+	err := Add("proc-receive", "10-deploy", []string{"some-repo"}, []byte("content"), "tsuru")
+	c.Assert(err, check.Equals, ErrNotChainable)
+}
+
+func (s *S) TestRemoveScript(c *check.C) {
+	err := Add("update", "", []string{"some-repo"}, []byte("main"), "tsuru")
+	c.Assert(err, check.IsNil)
+	err = Add("update", "check", []string{"some-repo"}, []byte("check"), "tsuru")
+	c.Assert(err, check.IsNil)
+	err = Remove("update", "check", []string{"some-repo"})
+	c.Assert(err, check.IsNil)
+	c.Assert(s.rfs.HasAction("remove /var/lib/gandalf/repositories/some-repo.git/hooks/update.d/check"), check.Equals, true)
+	hooks, err := ListRepository("some-repo")
+	c.Assert(err, check.IsNil)
+	c.Assert(hooks, check.HasLen, 1)
+	c.Assert(hooks[0].Script, check.Equals, "")
+}
+
+func (s *S) TestAddRecordsMetadata(c *check.C) {
+	err := Add("post-receive", "", []string{"some-repo", "other-repo"}, []byte("some content"), "tsuru")
+	c.Assert(err, check.IsNil)
+	err = Add("post-receive", "", nil, []byte("template content"), "admin")
+	c.Assert(err, check.IsNil)
+	hooks, err := List()
+	c.Assert(err, check.IsNil)
+	c.Assert(hooks, check.HasLen, 3)
+	c.Assert(hooks[0].Repository, check.Equals, "")
+	c.Assert(hooks[0].InstalledBy, check.Equals, "admin")
+	c.Assert(hooks[1].Repository, check.Equals, "other-repo")
+	c.Assert(hooks[2].Repository, check.Equals, "some-repo")
+	c.Assert(hooks[2].Name, check.Equals, "post-receive")
+	c.Assert(hooks[2].Checksum, check.Equals, "290f493c44f5d63d06b374d0a5abd292fae38b92cab2fae5efefe1b0e9347f56")
+	c.Assert(hooks[2].InstalledBy, check.Equals, "tsuru")
+	c.Assert(hooks[2].InstalledAt.IsZero(), check.Equals, false)
+}
+
+func (s *S) TestAddReplacesMetadata(c *check.C) {
+	err := Add("update", "", []string{"some-repo"}, []byte("some content"), "tsuru")
+	c.Assert(err, check.IsNil)
+	err = Add("update", "", []string{"some-repo"}, []byte("other content"), "admin")
+	c.Assert(err, check.IsNil)
+	hooks, err := ListRepository("some-repo")
+	c.Assert(err, check.IsNil)
+	c.Assert(hooks, check.HasLen, 1)
+	c.Assert(hooks[0].InstalledBy, check.Equals, "admin")
+	c.Assert(hooks[0].Checksum, check.Equals, checksum([]byte("other content")))
+}
+
+func (s *S) TestListRepository(c *check.C) {
+	err := Add("update", "", []string{"some-repo"}, []byte("some content"), "tsuru")
+	c.Assert(err, check.IsNil)
+	err = Add("post-receive", "", []string{"other-repo"}, []byte("some content"), "tsuru")
+	c.Assert(err, check.IsNil)
+	hooks, err := ListRepository("some-repo")
+	c.Assert(err, check.IsNil)
+	c.Assert(hooks, check.HasLen, 1)
+	c.Assert(hooks[0].Name, check.Equals, "update")
+	hooks, err = ListRepository("nothere")
+	c.Assert(err, check.IsNil)
+	c.Assert(hooks, check.HasLen, 0)
+}
+
+func (s *S) TestGet(c *check.C) {
+	err := Add("pre-receive", "", []string{"some-repo"}, []byte("some content"), "tsuru")
+	c.Assert(err, check.IsNil)
+	h, err := Get("pre-receive", "", "some-repo")
+	c.Assert(err, check.IsNil)
+	c.Assert(h.Content, check.Equals, "some content")
+	c.Assert(h.Checksum, check.Equals, checksum([]byte("some content")))
+	c.Assert(h.InstalledBy, check.Equals, "tsuru")
+}
+
+func (s *S) TestGetNotFound(c *check.C) {
+	_, err := Get("pre-receive", "", "some-repo")
+	c.Assert(err, check.Equals, ErrHookNotFound)
+}
+
+func (s *S) TestRemove(c *check.C) {
+	err := Add("post-receive", "", []string{"some-repo"}, []byte("some content"), "tsuru")
+	c.Assert(err, check.IsNil)
+	err = Remove("post-receive", "", []string{"some-repo"})
+	c.Assert(err, check.IsNil)
+	c.Assert(s.rfs.HasAction("remove /var/lib/gandalf/repositories/some-repo.git/hooks/post-receive"), check.Equals, true)
+	hooks, err := List()
+	c.Assert(err, check.IsNil)
+	c.Assert(hooks, check.HasLen, 0)
+	err = Remove("post-receive", "", []string{"some-repo"})
+	c.Assert(err, check.Equals, ErrHookNotFound)
+}
+
+func (s *S) TestRemoveTemplate(c *check.C) {
+	err := Add("post-receive", "", nil, []byte("some content"), "tsuru")
+	c.Assert(err, check.IsNil)
+	err = Remove("post-receive", "", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.rfs.HasAction("remove /home/git/bare-template/hooks/post-receive"), check.Equals, true)
+}
+
+func (s *S) TestApplyTemplate(c *check.C) {
+	err := Add("post-receive", "", nil, []byte("template content"), "admin")
+	c.Assert(err, check.IsNil)
+	hooks, err := ApplyTemplate([]string{"some-repo"}, "tsuru")
+	c.Assert(err, check.IsNil)
+	c.Assert(hooks, check.HasLen, 1)
+	c.Assert(hooks[0].Name, check.Equals, "post-receive")
+	c.Assert(hooks[0].Repository, check.Equals, "some-repo")
+	c.Assert(hooks[0].InstalledBy, check.Equals, "tsuru")
+	h, err := Get("post-receive", "", "some-repo")
+	c.Assert(err, check.IsNil)
+	c.Assert(h.Content, check.Equals, "template content")
+}
+
+func (s *S) TestApplyTemplateAllRepositories(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	for _, name := range []string{"some-repo", "other-repo"} {
+		err = conn.Repository().Insert(bson.M{"_id": name})
+		c.Assert(err, check.IsNil)
+		defer conn.Repository().RemoveId(name)
+	}
+	err = Add("update", "", nil, []byte("template content"), "admin")
+	c.Assert(err, check.IsNil)
+	hooks, err := ApplyTemplate(nil, "tsuru")
+	c.Assert(err, check.IsNil)
+	c.Assert(hooks, check.HasLen, 2)
+	c.Assert(hooks[0].Repository, check.Equals, "other-repo")
+	c.Assert(hooks[1].Repository, check.Equals, "some-repo")
+}
+
+func (s *S) TestApplyTemplateScripts(c *check.C) {
+	err := Add("post-receive", "", nil, []byte("main"), "admin")
+	c.Assert(err, check.IsNil)
+	err = Add("post-receive", "deploy", nil, []byte("deploy"), "admin")
+	c.Assert(err, check.IsNil)
+	hooks, err := ApplyTemplate([]string{"some-repo"}, "tsuru")
+	c.Assert(err, check.IsNil)
+	c.Assert(hooks, check.HasLen, 2)
+	c.Assert(hooks[0].Script, check.Equals, "")
+	c.Assert(hooks[1].Script, check.Equals, "deploy")
+	h, err := Get("post-receive", "deploy", "some-repo")
+	c.Assert(err, check.IsNil)
+	c.Assert(h.Content, check.Equals, "deploy")
+}
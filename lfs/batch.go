@@ -0,0 +1,104 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lfs
+
+import (
+	"errors"
+	"net/http"
+)
+
+// MediaType is the content type of the requests and responses of the batch
+// API.
+const MediaType = "application/vnd.git-lfs+json"
+
+// BasicTransfer is the only transfer adapter supported by Gandalf, in which
+// objects are uploaded and downloaded through single HTTP requests.
+const BasicTransfer = "basic"
+
+var ErrUnsupportedTransfer = errors.New("unsupported transfer, only the basic transfer is supported")
+
+// Pointer identifies an object in the requests of the batch API.
+type Pointer struct {
+	Oid  string `json:"oid"`
+	Size int64  `json:"size"`
+}
+
+// BatchRequest is the request sent by LFS clients before uploading or
+// downloading objects.
+type BatchRequest struct {
+	Operation string    `json:"operation"`
+	Transfers []string  `json:"transfers,omitempty"`
+	Objects   []Pointer `json:"objects"`
+}
+
+// Action describes the request LFS clients must send to transfer an object.
+type Action struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header,omitempty"`
+}
+
+// ObjectError describes why an object can not be transferred. Codes follow
+// the HTTP status codes.
+type ObjectError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Object is the result of the batch API for a requested object. Objects
+// without actions and errors do not need to be transferred.
+type Object struct {
+	Oid     string            `json:"oid"`
+	Size    int64             `json:"size"`
+	Actions map[string]Action `json:"actions,omitempty"`
+	Error   *ObjectError      `json:"error,omitempty"`
+}
+
+// BatchResponse is the response of the batch API.
+type BatchResponse struct {
+	Transfer string   `json:"transfer"`
+	Objects  []Object `json:"objects"`
+}
+
+// Batch returns the actions LFS clients must take to upload or download the
+// requested objects of the given repository. Actions point to the objects
+// under baseURL, and are sent with the given headers.
+func Batch(repo string, req *BatchRequest, baseURL string, header map[string]string) (*BatchResponse, error) {
+	if !ValidOperation(req.Operation) {
+		return nil, ErrInvalidOperation
+	}
+	if len(req.Transfers) > 0 && !contains(req.Transfers, BasicTransfer) {
+		return nil, ErrUnsupportedTransfer
+	}
+	resp := BatchResponse{Transfer: BasicTransfer, Objects: make([]Object, 0, len(req.Objects))}
+	for _, p := range req.Objects {
+		obj := Object{Oid: p.Oid, Size: p.Size}
+		size, err := Size(repo, p.Oid)
+		switch {
+		case err == ErrInvalidOid || p.Size < 0:
+			obj.Error = &ObjectError{Code: http.StatusUnprocessableEntity, Message: "invalid object"}
+		case err != nil && err != ErrObjectNotFound:
+			return nil, err
+		case req.Operation == OperationDownload && err == ErrObjectNotFound:
+			obj.Error = &ObjectError{Code: http.StatusNotFound, Message: ErrObjectNotFound.Error()}
+		case req.Operation == OperationDownload && size != p.Size:
+			obj.Error = &ObjectError{Code: http.StatusUnprocessableEntity, Message: "object size does not match the expected size"}
+		case req.Operation == OperationDownload || err == ErrObjectNotFound:
+			obj.Actions = map[string]Action{
+				req.Operation: {Href: baseURL + "/objects/" + p.Oid, Header: header},
+			}
+		}
+		resp.Objects = append(resp.Objects, obj)
+	}
+	return &resp, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lfs
+
+import (
+	"net/http"
+	"strings"
+
+	"gopkg.in/check.v1"
+)
+
+const baseURL = "http://gandalf.example.com/repository/myapp.git/info/lfs"
+
+func (s *S) TestBatchDownload(c *check.C) {
+	err := Store("myapp", oid, -1, strings.NewReader(content))
+	c.Assert(err, check.IsNil)
+	missing := strings.Repeat("a", 64)
+	req := BatchRequest{
+		Operation: OperationDownload,
+		Objects:   []Pointer{{Oid: oid, Size: int64(len(content))}, {Oid: missing, Size: 10}, {Oid: "invalid", Size: 10}},
+	}
+	header := map[string]string{"Authorization": "Basic Ym9iOg=="}
+	resp, err := Batch("myapp", &req, baseURL, header)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.Transfer, check.Equals, BasicTransfer)
+	c.Assert(resp.Objects, check.HasLen, 3)
+	c.Assert(resp.Objects[0].Actions, check.DeepEquals, map[string]Action{
+		"download": {Href: baseURL + "/objects/" + oid, Header: header},
+	})
+	c.Assert(resp.Objects[1].Error, check.DeepEquals, &ObjectError{Code: http.StatusNotFound, Message: "object does not exist"})
+	c.Assert(resp.Objects[2].Error.Code, check.Equals, http.StatusUnprocessableEntity)
+}
+
+func (s *S) TestBatchUpload(c *check.C) {
+	err := Store("myapp", oid, -1, strings.NewReader(content))
+	c.Assert(err, check.IsNil)
+	missing := strings.Repeat("a", 64)
+	req := BatchRequest{
+		Operation: OperationUpload,
+		Transfers: []string{"tus", BasicTransfer},
+		Objects:   []Pointer{{Oid: oid, Size: int64(len(content))}, {Oid: missing, Size: 10}},
+	}
+	resp, err := Batch("myapp", &req, baseURL, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.Objects, check.HasLen, 2)
+	c.Assert(resp.Objects[0].Actions, check.IsNil)
+	c.Assert(resp.Objects[0].Error, check.IsNil)
+	c.Assert(resp.Objects[1].Actions["upload"].Href, check.Equals, baseURL+"/objects/"+missing)
+}
+
+func (s *S) TestBatchInvalid(c *check.C) {
+	_, err := Batch("myapp", &BatchRequest{Operation: "verify"}, baseURL, nil)
+	c.Assert(err, check.Equals, ErrInvalidOperation)
+	_, err = Batch("myapp", &BatchRequest{Operation: OperationUpload, Transfers: []string{"tus"}}, baseURL, nil)
+	c.Assert(err, check.Equals, ErrUnsupportedTransfer)
+}
@@ -0,0 +1,212 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package lfs stores the Git LFS objects of Gandalf repositories and
+// implements the batch API used by LFS clients to transfer them.
+//
+// Objects are stored in the "lfs:location" directory, or in the lfs/objects
+// directory inside each bare repository when it's not set, following the
+// layout used by git-lfs: <oid[0:2]>/<oid[2:4]>/<oid>.
+package lfs
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/fs"
+	tsurufs "github.com/tsuru/tsuru/fs"
+	"github.com/tsuru/tsuru/log"
+)
+
+// Operations of the batch API.
+const (
+	OperationUpload   = "upload"
+	OperationDownload = "download"
+)
+
+var oidRegexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+var (
+	ErrInvalidOid       = errors.New("invalid object id, it must be a SHA-256 hash")
+	ErrInvalidOperation = errors.New("invalid operation, valid options are: upload or download")
+	ErrObjectNotFound   = errors.New("object does not exist")
+)
+
+// InvalidObjectError is returned when the content of an uploaded object does
+// not match its id or size.
+type InvalidObjectError struct {
+	message string
+}
+
+func (err *InvalidObjectError) Error() string {
+	return err.message
+}
+
+// ValidOperation returns whether the given operation is supported by the
+// batch API.
+func ValidOperation(operation string) bool {
+	return operation == OperationUpload || operation == OperationDownload
+}
+
+// ValidOid returns whether the given object id is a valid SHA-256 hash.
+func ValidOid(oid string) bool {
+	return oidRegexp.MatchString(oid)
+}
+
+// objectsDir returns the directory of the objects of the given repository.
+func objectsDir(repo string) (string, error) {
+	if location, err := config.GetString("lfs:location"); err == nil {
+		return path.Join(location, repo), nil
+	}
+	bare, err := config.GetString("git:bare:location")
+	if err != nil {
+		return "", err
+	}
+	return path.Join(bare, repo+".git", "lfs", "objects"), nil
+}
+
+func objectPath(repo, oid string) (string, error) {
+	if !ValidOid(oid) {
+		return "", ErrInvalidOid
+	}
+	dir, err := objectsDir(repo)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(dir, oid[0:2], oid[2:4], oid), nil
+}
+
+// Size returns the size of the object with the given id in the repository.
+func Size(repo, oid string) (int64, error) {
+	p, err := objectPath(repo, oid)
+	if err != nil {
+		return 0, err
+	}
+	info, err := fs.Filesystem().Stat(p)
+	if os.IsNotExist(err) {
+		return 0, ErrObjectNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Open opens the object with the given id in the repository for reading.
+func Open(repo, oid string) (tsurufs.File, error) {
+	p, err := objectPath(repo, oid)
+	if err != nil {
+		return nil, err
+	}
+	file, err := fs.Filesystem().Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrObjectNotFound
+	}
+	return file, err
+}
+
+// Store stores the object with the given id in the repository, reading its
+// content from r. The content is written to a temporary file and only moved
+// into place after checking that its SHA-256 hash matches the id and, when
+// size is not negative, that it has the given size.
+func Store(repo, oid string, size int64, r io.Reader) error {
+	log.Debugf("Storing LFS object %s of repository %q", oid, repo)
+	p, err := objectPath(repo, oid)
+	if err != nil {
+		return err
+	}
+	if err = fs.Filesystem().MkdirAll(path.Dir(p), 0755); err != nil {
+		return err
+	}
+	var suffix [8]byte
+	if _, err = rand.Read(suffix[:]); err != nil {
+		return err
+	}
+	tmp := p + ".tmp-" + hex.EncodeToString(suffix[:])
+	file, err := fs.Filesystem().OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(file, hash), r)
+	file.Close()
+	if err == nil {
+		switch {
+		case hex.EncodeToString(hash.Sum(nil)) != oid:
+			err = &InvalidObjectError{message: "object content does not match its id"}
+		case size >= 0 && written != size:
+			err = &InvalidObjectError{message: "object size does not match the expected size"}
+		}
+	}
+	if err != nil {
+		fs.Filesystem().Remove(tmp)
+		return err
+	}
+	return fs.Filesystem().Rename(tmp, p)
+}
+
+// Objects returns the ids of the objects stored for the given repository,
+// sorted. The objects are listed from the disk, as the filesystem abstraction
+// can not list directories, and only files following the layout of objects
+// are considered, skipping temporary files and the objects of repositories in
+// the namespace with the same name.
+func Objects(repo string) ([]string, error) {
+	dir, err := objectsDir(repo)
+	if err != nil {
+		return nil, err
+	}
+	oids := []string{}
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		oid := info.Name()
+		if info.Mode().IsRegular() && ValidOid(oid) && p == path.Join(dir, oid[0:2], oid[2:4], oid) {
+			oids = append(oids, oid)
+		}
+		return nil
+	})
+	sort.Strings(oids)
+	return oids, err
+}
+
+// RemoveRepository removes the objects of the given repository when they are
+// stored outside of the bare repository, in the "lfs:location" directory.
+func RemoveRepository(repo string) error {
+	location, err := config.GetString("lfs:location")
+	if err != nil {
+		return nil
+	}
+	return fs.Filesystem().RemoveAll(path.Join(location, repo))
+}
+
+// RenameRepository moves the objects of a renamed repository when they are
+// stored outside of the bare repository, in the "lfs:location" directory.
+func RenameRepository(oldName, newName string) error {
+	location, err := config.GetString("lfs:location")
+	if err != nil {
+		return nil
+	}
+	oldPath := path.Join(location, oldName)
+	if _, err = fs.Filesystem().Stat(oldPath); os.IsNotExist(err) {
+		return nil
+	}
+	newPath := path.Join(location, newName)
+	if err = fs.Filesystem().MkdirAll(path.Dir(newPath), 0755); err != nil {
+		return err
+	}
+	return fs.Filesystem().Rename(oldPath, newPath)
+}
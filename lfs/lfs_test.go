@@ -0,0 +1,142 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lfs
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/gandalf/fs"
+	"github.com/tsuru/tsuru/fs/fstest"
+	"gopkg.in/check.v1"
+)
+
+// oid is the SHA-256 hash of content.
+const (
+	content = "some content"
+	oid     = "290f493c44f5d63d06b374d0a5abd292fae38b92cab2fae5efefe1b0e9347f56"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct {
+	rfs *fstest.RecordingFs
+}
+
+var _ = check.Suite(&S{})
+
+func (s *S) SetUpSuite(c *check.C) {
+	err := config.ReadConfigFile("../etc/gandalf.conf")
+	c.Assert(err, check.IsNil)
+	config.Set("database:url", "127.0.0.1:27017")
+	config.Set("database:name", "gandalf_lfs_tests")
+}
+
+func (s *S) SetUpTest(c *check.C) {
+	s.rfs = &fstest.RecordingFs{}
+	fs.Fsystem = s.rfs
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	fs.Fsystem = nil
+}
+
+func (s *S) TearDownSuite(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	conn.User().Database.DropDatabase()
+}
+
+func (s *S) TestValidOid(c *check.C) {
+	c.Assert(ValidOid(oid), check.Equals, true)
+	c.Assert(ValidOid(strings.ToUpper(oid)), check.Equals, false)
+	c.Assert(ValidOid("../../etc/passwd"), check.Equals, false)
+	c.Assert(ValidOid(oid[:63]), check.Equals, false)
+}
+
+func (s *S) TestObjectPath(c *check.C) {
+	p, err := objectPath("myapp", oid)
+	c.Assert(err, check.IsNil)
+	c.Assert(p, check.Equals, "/var/lib/gandalf/repositories/myapp.git/lfs/objects/29/0f/"+oid)
+	config.Set("lfs:location", "/var/lib/gandalf/lfs")
+	defer config.Unset("lfs:location")
+	p, err = objectPath("me/myapp", oid)
+	c.Assert(err, check.IsNil)
+	c.Assert(p, check.Equals, "/var/lib/gandalf/lfs/me/myapp/29/0f/"+oid)
+	_, err = objectPath("myapp", "invalid")
+	c.Assert(err, check.Equals, ErrInvalidOid)
+}
+
+func (s *S) TestStore(c *check.C) {
+	err := Store("myapp", oid, int64(len(content)), strings.NewReader(content))
+	c.Assert(err, check.IsNil)
+	size, err := Size("myapp", oid)
+	c.Assert(err, check.IsNil)
+	c.Assert(size, check.Equals, int64(len(content)))
+	file, err := Open("myapp", oid)
+	c.Assert(err, check.IsNil)
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, content)
+}
+
+func (s *S) TestStoreInvalidContent(c *check.C) {
+	err := Store("myapp", oid, -1, strings.NewReader("other content"))
+	c.Assert(err, check.FitsTypeOf, &InvalidObjectError{})
+	c.Assert(err, check.ErrorMatches, "object content does not match its id")
+	err = Store("myapp", oid, 3, strings.NewReader(content))
+	c.Assert(err, check.ErrorMatches, "object size does not match the expected size")
+	_, err = Size("myapp", oid)
+	c.Assert(err, check.Equals, ErrObjectNotFound)
+}
+
+func (s *S) TestObjects(c *check.C) {
+	fs.Fsystem = nil
+	config.Set("lfs:location", c.MkDir())
+	defer config.Unset("lfs:location")
+	oids, err := Objects("me/myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(oids, check.HasLen, 0)
+	err = Store("me/myapp", oid, -1, strings.NewReader(content))
+	c.Assert(err, check.IsNil)
+	oids, err = Objects("me/myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(oids, check.DeepEquals, []string{oid})
+	oids, err = Objects("me")
+	c.Assert(err, check.IsNil)
+	c.Assert(oids, check.HasLen, 0)
+}
+
+func (s *S) TestOpenNotFound(c *check.C) {
+	_, err := Open("myapp", oid)
+	c.Assert(err, check.Equals, ErrObjectNotFound)
+}
+
+func (s *S) TestRemoveRepository(c *check.C) {
+	err := RemoveRepository("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(s.rfs.HasAction("removeall /lfs/myapp"), check.Equals, false)
+	config.Set("lfs:location", "/lfs")
+	defer config.Unset("lfs:location")
+	err = RemoveRepository("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(s.rfs.HasAction("removeall /lfs/myapp"), check.Equals, true)
+}
+
+func (s *S) TestRenameRepository(c *check.C) {
+	config.Set("lfs:location", "/lfs")
+	defer config.Unset("lfs:location")
+	err := Store("myapp", oid, -1, strings.NewReader(content))
+	c.Assert(err, check.IsNil)
+	s.rfs.MkdirAll("/lfs/myapp", 0755)
+	err = RenameRepository("myapp", "me/otherapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(s.rfs.HasAction("rename /lfs/myapp /lfs/me/otherapp"), check.Equals, true)
+}
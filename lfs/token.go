@@ -0,0 +1,128 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lfs
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/tsuru/log"
+)
+
+const defaultTokenExpiration = 15 * time.Minute
+
+var ErrInvalidToken = errors.New("invalid LFS token")
+
+// token grants a user access to the objects of a repository through the
+// batch API for a short time. Only the SHA-256 hash of the token is stored,
+// and expired tokens are removed by MongoDB.
+type token struct {
+	Hash       string `bson:"_id"`
+	User       string
+	Repository string
+	Operation  string
+	ExpiresAt  time.Time
+}
+
+// Authentication is the response of the git-lfs-authenticate command, which
+// tells LFS clients connecting through SSH where the batch API is and how to
+// authenticate to it.
+type Authentication struct {
+	Href      string            `json:"href"`
+	Header    map[string]string `json:"header"`
+	ExpiresIn int               `json:"expires_in"`
+}
+
+func tokenExpiration() time.Duration {
+	if d, err := config.GetDuration("lfs:token-expiration"); err == nil && d > 0 {
+		return d
+	}
+	return defaultTokenExpiration
+}
+
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticate creates a token granting the user access to the objects of
+// the repository for the given operation, returning the response of the
+// git-lfs-authenticate command. Tokens for uploads also allow downloads.
+//
+// The URL of the batch API is built from the "lfs:url" setting.
+func Authenticate(user, repo, operation string) (*Authentication, error) {
+	log.Debugf("Authenticating user %q for LFS %s in repository %q", user, operation, repo)
+	if !ValidOperation(operation) {
+		return nil, ErrInvalidOperation
+	}
+	baseURL, err := config.GetString("lfs:url")
+	if err != nil {
+		return nil, errors.New("LFS over SSH is not available, the lfs:url setting is not defined")
+	}
+	var b [32]byte
+	if _, err = rand.Read(b[:]); err != nil {
+		return nil, err
+	}
+	raw := hex.EncodeToString(b[:])
+	expiration := tokenExpiration()
+	t := token{
+		Hash:       hashToken(raw),
+		User:       user,
+		Repository: repo,
+		Operation:  operation,
+		ExpiresAt:  time.Now().UTC().Add(expiration),
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err = conn.LFSToken().Insert(t); err != nil {
+		return nil, err
+	}
+	credentials := base64.StdEncoding.EncodeToString([]byte(user + ":" + raw))
+	return &Authentication{
+		Href:      strings.TrimRight(baseURL, "/") + "/repository/" + repo + ".git/info/lfs",
+		Header:    map[string]string{"Authorization": "Basic " + credentials},
+		ExpiresIn: int(expiration / time.Second),
+	}, nil
+}
+
+// CheckToken checks whether the given raw token, created by Authenticate,
+// grants the user access to the objects of the repository for the given
+// operation.
+func CheckToken(raw, user, repo, operation string) error {
+	if raw == "" {
+		return ErrInvalidToken
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var t token
+	err = conn.LFSToken().FindId(hashToken(raw)).One(&t)
+	if err == mgo.ErrNotFound {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+	if t.User != user || t.Repository != repo || time.Now().After(t.ExpiresAt) {
+		return ErrInvalidToken
+	}
+	if t.Operation != operation && t.Operation != OperationUpload {
+		return ErrInvalidToken
+	}
+	return nil
+}
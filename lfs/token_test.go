@@ -0,0 +1,73 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lfs
+
+import (
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
+	"gopkg.in/check.v1"
+)
+
+func rawToken(c *check.C, a *Authentication) string {
+	credentials, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(a.Header["Authorization"], "Basic "))
+	c.Assert(err, check.IsNil)
+	parts := strings.SplitN(string(credentials), ":", 2)
+	c.Assert(parts, check.HasLen, 2)
+	return parts[1]
+}
+
+func (s *S) TestAuthenticate(c *check.C) {
+	config.Set("lfs:url", "https://gandalf.example.com/")
+	defer config.Unset("lfs:url")
+	a, err := Authenticate("bilbo", "the-shire", OperationDownload)
+	c.Assert(err, check.IsNil)
+	c.Assert(a.Href, check.Equals, "https://gandalf.example.com/repository/the-shire.git/info/lfs")
+	c.Assert(a.ExpiresIn, check.Equals, 900)
+	raw := rawToken(c, a)
+	c.Assert(CheckToken(raw, "bilbo", "the-shire", OperationDownload), check.IsNil)
+	c.Assert(CheckToken(raw, "bilbo", "the-shire", OperationUpload), check.Equals, ErrInvalidToken)
+	c.Assert(CheckToken(raw, "frodo", "the-shire", OperationDownload), check.Equals, ErrInvalidToken)
+	c.Assert(CheckToken(raw, "bilbo", "mordor", OperationDownload), check.Equals, ErrInvalidToken)
+	c.Assert(CheckToken("", "bilbo", "the-shire", OperationDownload), check.Equals, ErrInvalidToken)
+}
+
+func (s *S) TestAuthenticateUploadAllowsDownload(c *check.C) {
+	config.Set("lfs:url", "https://gandalf.example.com")
+	defer config.Unset("lfs:url")
+	a, err := Authenticate("bilbo", "the-shire", OperationUpload)
+	c.Assert(err, check.IsNil)
+	raw := rawToken(c, a)
+	c.Assert(CheckToken(raw, "bilbo", "the-shire", OperationUpload), check.IsNil)
+	c.Assert(CheckToken(raw, "bilbo", "the-shire", OperationDownload), check.IsNil)
+}
+
+func (s *S) TestCheckTokenExpired(c *check.C) {
+	config.Set("lfs:url", "https://gandalf.example.com")
+	defer config.Unset("lfs:url")
+	a, err := Authenticate("bilbo", "the-shire", OperationUpload)
+	c.Assert(err, check.IsNil)
+	raw := rawToken(c, a)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.LFSToken().UpdateId(hashToken(raw), bson.M{"$set": bson.M{"expiresat": time.Now().Add(-time.Minute)}})
+	c.Assert(err, check.IsNil)
+	c.Assert(CheckToken(raw, "bilbo", "the-shire", OperationUpload), check.Equals, ErrInvalidToken)
+}
+
+func (s *S) TestAuthenticateWithoutURL(c *check.C) {
+	_, err := Authenticate("bilbo", "the-shire", OperationUpload)
+	c.Assert(err, check.ErrorMatches, "LFS over SSH is not available, the lfs:url setting is not defined")
+}
+
+func (s *S) TestAuthenticateInvalidOperation(c *check.C) {
+	_, err := Authenticate("bilbo", "the-shire", "delete")
+	c.Assert(err, check.Equals, ErrInvalidOperation)
+}
@@ -22,3 +22,6 @@ echo "ok"
 
 build_and_package bin
 build_and_package webserver
+build_and_package admin
+build_and_package ssh-keys
+build_and_package sshd
@@ -0,0 +1,225 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// bundleReader reads a bundle from the output of "git bundle create".
+type bundleReader struct {
+	*bufio.Reader
+	stdout io.Closer
+	cmd    *exec.Cmd
+}
+
+// Close stops the bundle creation, if it is still running.
+func (r *bundleReader) Close() error {
+	r.stdout.Close()
+	return r.cmd.Wait()
+}
+
+// GetBundle starts the creation of a git bundle of the given refs of the
+// repository, or of all its refs when refs is empty, returning a reader for
+// the bundle. The caller must close the reader.
+func (*GitContentRetriever) GetBundle(repo string, refs []string) (io.ReadCloser, error) {
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		return nil, fmt.Errorf("Error when trying to obtain bundle of repository %s (%s).", repo, err)
+	}
+	cwd := barePath(repo)
+	repoExists, err := exists(cwd)
+	if err != nil || !repoExists {
+		return nil, fmt.Errorf("Error when trying to obtain bundle of repository %s (Repository does not exist).", repo)
+	}
+	args := []string{"bundle", "create", "-"}
+	if len(refs) == 0 {
+		args = append(args, "--all")
+	}
+	for _, ref := range refs {
+		if ref == "" || strings.HasPrefix(ref, "-") {
+			return nil, fmt.Errorf("Error when trying to obtain bundle of repository %s (Invalid ref %q).", repo, ref)
+		}
+		args = append(args, ref)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(gitPath, args...)
+	cmd.Dir = cwd
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("Error when trying to obtain bundle of repository %s (%s).", repo, err)
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("Error when trying to obtain bundle of repository %s (%s).", repo, err)
+	}
+	reader := &bundleReader{Reader: bufio.NewReader(stdout), stdout: stdout, cmd: cmd}
+	// git only writes the bundle after resolving all refs, so errors are
+	// reported before the first byte.
+	if _, err = reader.Peek(1); err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("Error when trying to obtain bundle of repository %s (%s).", repo, strings.TrimSpace(stderr.String()))
+	}
+	return reader, nil
+}
+
+// RefUpdate is the update of a ref made by restoring a bundle. Before is the
+// zero SHA for created refs.
+type RefUpdate struct {
+	Ref    string
+	Before string
+	After  string
+}
+
+// RestoreBundle updates the branches and tags of the repository to the ones
+// in the given git bundle, returning the updates of the refs in the bundle,
+// including the ones already pointing to the revision in the bundle. The
+// bundle must be complete, or its prerequisite commits must be present in the
+// repository.
+//
+// When userName is not empty, the bundle is restored on behalf of the user,
+// like a push: the ref updates are checked against the protection rules of
+// the repository, and the repository must fit in its quota after receiving
+// the objects of the bundle. Then only the checked refs are updated, in a
+// single transaction that fails when any of them was changed meanwhile, for
+// example by a concurrent push. Otherwise, as in restores made by API clients
+// on their own behalf and by backups, the refs are updated unconditionally.
+func (*GitContentRetriever) RestoreBundle(repo, userName string, bundle io.Reader) ([]RefUpdate, error) {
+	cwd := barePath(repo)
+	repoExists, err := exists(cwd)
+	if err != nil || !repoExists {
+		return nil, fmt.Errorf("Error when trying to restore bundle into repository %s (Repository does not exist).", repo)
+	}
+	f, err := ioutil.TempFile(tempDirLocation(), "gandalf_bundle")
+	if err != nil {
+		return nil, fmt.Errorf("Error when trying to restore bundle into repository %s (Could not create temporary file).", repo)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err = io.Copy(f, bundle); err != nil {
+		return nil, fmt.Errorf("Error when trying to restore bundle into repository %s (%s).", repo, err)
+	}
+	cmd := exec.Command("git", "bundle", "verify", "--quiet", f.Name())
+	cmd.Dir = cwd
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("Error when trying to restore bundle into repository %s (%s).", repo, strings.TrimSpace(string(out)))
+	}
+	cmd = exec.Command("git", "bundle", "list-heads", f.Name())
+	cmd.Dir = cwd
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Error when trying to restore bundle into repository %s (%s).", repo, err)
+	}
+	current, err := currentRefs(repo)
+	if err != nil {
+		return nil, fmt.Errorf("Error when trying to restore bundle into repository %s (%s).", repo, err)
+	}
+	updates := []RefUpdate{}
+	var changed []RefUpdate
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && (strings.HasPrefix(fields[1], "refs/heads/") || strings.HasPrefix(fields[1], "refs/tags/")) {
+			u := RefUpdate{Ref: fields[1], Before: nullRev, After: fields[0]}
+			if rev, ok := current[u.Ref]; ok {
+				u.Before = rev
+			}
+			updates = append(updates, u)
+			if u.Before != u.After {
+				changed = append(changed, u)
+			}
+		}
+	}
+	if userName == "" {
+		err = fetchInto(context.Background(), repo, f.Name())
+	} else if len(changed) > 0 {
+		err = checkRestore(repo, userName, f.Name(), changed)
+		if err == nil {
+			err = updateRefs(repo, changed)
+		}
+		if err == nil {
+			err = pointHead(repo)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error when trying to restore bundle into repository %s (%s).", repo, err)
+	}
+	return updates, nil
+}
+
+// currentRefs returns the revisions of the branches and tags of the
+// repository, by ref name.
+func currentRefs(name string) (map[string]string, error) {
+	cmd := exec.Command("git", "for-each-ref", "--format=%(objectname) %(refname)", "refs/heads", "refs/tags")
+	cmd.Dir = barePath(name)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	current := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			current[fields[1]] = fields[0]
+		}
+	}
+	return current, nil
+}
+
+// checkRestore checks the ref updates of a bundle restored on behalf of the
+// given user, as the pre-receive hook does for pushes. The objects of the
+// bundle are unpacked into the repository before the checks, so the
+// protection rules can inspect the new commits and the quota includes them.
+// When the restore is rejected, they're left unreferenced until the next
+// prune.
+func checkRestore(name, userName, bundle string, updates []RefUpdate) error {
+	r, err := Get(name)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("git", "bundle", "unbundle", bundle)
+	cmd.Dir = barePath(name)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.New(strings.TrimSpace(string(out)))
+	}
+	for _, u := range updates {
+		if err = r.CheckRefUpdate(userName, u.Ref, u.Before, u.After); err != nil {
+			return err
+		}
+	}
+	return CheckQuota(name)
+}
+
+// updateRefs applies the given ref updates to the repository atomically,
+// failing without changing any ref when one of them does not point to its
+// Before revision anymore.
+func updateRefs(name string, updates []RefUpdate) error {
+	var stdin bytes.Buffer
+	for _, u := range updates {
+		fmt.Fprintf(&stdin, "update %s %s %s\n", u.Ref, u.After, u.Before)
+	}
+	cmd := exec.Command("git", "update-ref", "--stdin")
+	cmd.Dir = barePath(name)
+	cmd.Stdin = &stdin
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.New(strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func GetBundle(repo string, refs []string) (io.ReadCloser, error) {
+	return retriever().GetBundle(repo, refs)
+}
+
+func RestoreBundle(repo, userName string, bundle io.Reader) ([]RefUpdate, error) {
+	return retriever().RestoreBundle(repo, userName, bundle)
+}
@@ -0,0 +1,244 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repository
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/tsuru/gandalf/db"
+	"gopkg.in/check.v1"
+)
+
+func bundleHeads(c *check.C, bundle []byte) string {
+	file := path.Join(c.MkDir(), "repo.bundle")
+	err := ioutil.WriteFile(file, bundle, 0644)
+	c.Assert(err, check.IsNil)
+	out, err := exec.Command("git", "bundle", "list-heads", file).CombinedOutput()
+	c.Assert(err, check.IsNil, check.Commentf("%s", out))
+	var refs []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		refs = append(refs, strings.Fields(line)[1])
+	}
+	return strings.Join(refs, " ")
+}
+
+func (s *S) TestGetBundleIntegration(c *check.C) {
+	oldBare := bare
+	bare = c.MkDir()
+	defer func() { bare = oldBare }()
+	cleanUp, err := CreateTestRepository(bare, "gandalf-test-repo", "README", "much WOW")
+	defer cleanUp()
+	c.Assert(err, check.IsNil)
+	err = CreateTag(barePath("gandalf-test-repo"), "v1")
+	c.Assert(err, check.IsNil)
+	bundle, err := GetBundle("gandalf-test-repo", nil)
+	c.Assert(err, check.IsNil)
+	contents, err := ioutil.ReadAll(bundle)
+	c.Assert(err, check.IsNil)
+	c.Assert(bundle.Close(), check.IsNil)
+	c.Assert(bundleHeads(c, contents), check.Equals, "refs/heads/master refs/tags/v1 HEAD")
+}
+
+func (s *S) TestGetBundleIntegrationWithRefs(c *check.C) {
+	oldBare := bare
+	bare = c.MkDir()
+	defer func() { bare = oldBare }()
+	cleanUp, err := CreateTestRepository(bare, "gandalf-test-repo", "README", "much WOW")
+	defer cleanUp()
+	c.Assert(err, check.IsNil)
+	err = CreateTag(barePath("gandalf-test-repo"), "v1")
+	c.Assert(err, check.IsNil)
+	bundle, err := GetBundle("gandalf-test-repo", []string{"v1"})
+	c.Assert(err, check.IsNil)
+	defer bundle.Close()
+	contents, err := ioutil.ReadAll(bundle)
+	c.Assert(err, check.IsNil)
+	c.Assert(bundleHeads(c, contents), check.Equals, "refs/tags/v1")
+}
+
+func (s *S) TestGetBundleIntegrationInvalidRef(c *check.C) {
+	oldBare := bare
+	bare = c.MkDir()
+	defer func() { bare = oldBare }()
+	cleanUp, err := CreateTestRepository(bare, "gandalf-test-repo", "README", "much WOW")
+	defer cleanUp()
+	c.Assert(err, check.IsNil)
+	_, err = GetBundle("gandalf-test-repo", []string{"nothere"})
+	c.Assert(err, check.ErrorMatches, "(?s)^Error when trying to obtain bundle of repository gandalf-test-repo .*")
+	_, err = GetBundle("gandalf-test-repo", []string{"--all"})
+	c.Assert(err, check.ErrorMatches, `^Error when trying to obtain bundle of repository gandalf-test-repo \(Invalid ref "--all"\)\.$`)
+}
+
+func (s *S) TestGetBundleRepositoryDoesNotExist(c *check.C) {
+	_, err := GetBundle("nothere", nil)
+	c.Assert(err, check.ErrorMatches, `^Error when trying to obtain bundle of repository nothere \(Repository does not exist\)\.$`)
+}
+
+func (s *S) TestRestoreBundleIntegration(c *check.C) {
+	oldBare := bare
+	bare = c.MkDir()
+	defer func() { bare = oldBare }()
+	cleanUp, err := CreateTestRepository(bare, "gandalf-test-repo", "README", "much WOW")
+	defer cleanUp()
+	c.Assert(err, check.IsNil)
+	err = CreateTag(barePath("gandalf-test-repo"), "v1")
+	c.Assert(err, check.IsNil)
+	cleanUp, err = CreateEmptyTestBareRepository(bare, "gandalf-restored-repo")
+	defer cleanUp()
+	c.Assert(err, check.IsNil)
+	bundle, err := GetBundle("gandalf-test-repo", nil)
+	c.Assert(err, check.IsNil)
+	defer bundle.Close()
+	updates, err := RestoreBundle("gandalf-restored-repo", "", bundle)
+	c.Assert(err, check.IsNil)
+	c.Assert(updates, check.HasLen, 2)
+	c.Assert(updates[0].Ref, check.Equals, "refs/heads/master")
+	c.Assert(updates[0].Before, check.Equals, nullRev)
+	c.Assert(updates[1].Ref, check.Equals, "refs/tags/v1")
+	branches, err := GetBranches("gandalf-restored-repo")
+	c.Assert(err, check.IsNil)
+	c.Assert(branches, check.HasLen, 1)
+	c.Assert(branches[0].Name, check.Equals, "master")
+	c.Assert(updates[0].After, check.Equals, branches[0].Ref)
+}
+
+func (s *S) TestRestoreBundleOnBehalfOfUserIntegration(c *check.C) {
+	oldBare := bare
+	bare = c.MkDir()
+	defer func() { bare = oldBare }()
+	cleanUp, err := CreateTestRepository(bare, "gandalf-test-repo", "README", "much WOW")
+	defer cleanUp()
+	c.Assert(err, check.IsNil)
+	cleanUp, err = CreateEmptyTestBareRepository(bare, "gandalf-restored-repo")
+	defer cleanUp()
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	r := Repository{Name: "gandalf-restored-repo", Users: []string{"bob"}}
+	err = conn.Repository().Insert(&r)
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId(r.Name)
+	bundle, err := GetBundle("gandalf-test-repo", nil)
+	c.Assert(err, check.IsNil)
+	defer bundle.Close()
+	updates, err := RestoreBundle("gandalf-restored-repo", "bob", bundle)
+	c.Assert(err, check.IsNil)
+	c.Assert(updates, check.HasLen, 1)
+	head, err := GetLastHashCommit(bare, "gandalf-test-repo")
+	c.Assert(err, check.IsNil)
+	c.Assert(updates[0], check.Equals, RefUpdate{Ref: "refs/heads/master", Before: nullRev, After: string(head)})
+	branches, err := GetBranches("gandalf-restored-repo")
+	c.Assert(err, check.IsNil)
+	c.Assert(branches, check.HasLen, 1)
+	c.Assert(branches[0].Ref, check.Equals, string(head))
+}
+
+func (s *S) TestUpdateRefsFailsWhenRefChanged(c *check.C) {
+	oldBare := bare
+	bare = c.MkDir()
+	defer func() { bare = oldBare }()
+	cleanUp, err := CreateTestRepository(bare, "gandalf-test-repo", "README", "much WOW")
+	defer cleanUp()
+	c.Assert(err, check.IsNil)
+	old, err := GetLastHashCommit(bare, "gandalf-test-repo")
+	c.Assert(err, check.IsNil)
+	err = CreateCommit(bare, "gandalf-test-repo", "README", "so WOW")
+	c.Assert(err, check.IsNil)
+	head, err := GetLastHashCommit(bare, "gandalf-test-repo")
+	c.Assert(err, check.IsNil)
+	updates := []RefUpdate{
+		{Ref: "refs/heads/other", Before: nullRev, After: string(old)},
+		{Ref: "refs/heads/master", Before: string(old), After: string(old)},
+	}
+	err = updateRefs("gandalf-test-repo", updates)
+	c.Assert(err, check.NotNil)
+	current, err := currentRefs("gandalf-test-repo")
+	c.Assert(err, check.IsNil)
+	c.Assert(current, check.DeepEquals, map[string]string{"refs/heads/master": string(head)})
+}
+
+func (s *S) TestRestoreBundleOverProtectedBranchIntegration(c *check.C) {
+	oldBare := bare
+	bare = c.MkDir()
+	defer func() { bare = oldBare }()
+	cleanUp, err := CreateTestRepository(bare, "gandalf-test-repo", "README", "much WOW")
+	defer cleanUp()
+	c.Assert(err, check.IsNil)
+	bundle, err := GetBundle("gandalf-test-repo", nil)
+	c.Assert(err, check.IsNil)
+	old, err := ioutil.ReadAll(bundle)
+	bundle.Close()
+	c.Assert(err, check.IsNil)
+	err = CreateCommit(bare, "gandalf-test-repo", "README", "so WOW")
+	c.Assert(err, check.IsNil)
+	head, err := GetLastHashCommit(bare, "gandalf-test-repo")
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	r := Repository{Name: "gandalf-test-repo", Users: []string{"bob"}, Protections: []Protection{{Pattern: "master"}}}
+	err = conn.Repository().Insert(&r)
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId(r.Name)
+	_, err = RestoreBundle("gandalf-test-repo", "bob", bytes.NewReader(old))
+	c.Assert(err, check.ErrorMatches, `^Error when trying to restore bundle into repository gandalf-test-repo \(refs/heads/master is protected and can not be force-pushed\)\.$`)
+	branches, err := GetBranches("gandalf-test-repo")
+	c.Assert(err, check.IsNil)
+	c.Assert(branches, check.HasLen, 1)
+	c.Assert(branches[0].Ref, check.Equals, string(head))
+	_, err = RestoreBundle("gandalf-test-repo", "", bytes.NewReader(old))
+	c.Assert(err, check.IsNil)
+	branches, err = GetBranches("gandalf-test-repo")
+	c.Assert(err, check.IsNil)
+	c.Assert(branches[0].Ref, check.Not(check.Equals), string(head))
+}
+
+func (s *S) TestRestoreBundleExceedingQuotaIntegration(c *check.C) {
+	oldBare := bare
+	bare = c.MkDir()
+	defer func() { bare = oldBare }()
+	cleanUp, err := CreateTestRepository(bare, "gandalf-test-repo", "README", "much WOW")
+	defer cleanUp()
+	c.Assert(err, check.IsNil)
+	cleanUp, err = CreateEmptyTestBareRepository(bare, "gandalf-restored-repo")
+	defer cleanUp()
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	r := Repository{Name: "gandalf-restored-repo", Users: []string{"bob"}, Quota: 1}
+	err = conn.Repository().Insert(&r)
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId(r.Name)
+	bundle, err := GetBundle("gandalf-test-repo", nil)
+	c.Assert(err, check.IsNil)
+	defer bundle.Close()
+	_, err = RestoreBundle("gandalf-restored-repo", "bob", bundle)
+	c.Assert(err, check.ErrorMatches, `^Error when trying to restore bundle into repository gandalf-restored-repo \(repository "gandalf-restored-repo" would use .*, exceeding its quota of 1 B\)\.$`)
+	branches, err := GetBranches("gandalf-restored-repo")
+	c.Assert(err, check.IsNil)
+	c.Assert(branches, check.HasLen, 0)
+}
+
+func (s *S) TestRestoreBundleInvalidBundle(c *check.C) {
+	oldBare := bare
+	bare = c.MkDir()
+	defer func() { bare = oldBare }()
+	cleanUp, err := CreateEmptyTestBareRepository(bare, "gandalf-restored-repo")
+	defer cleanUp()
+	c.Assert(err, check.IsNil)
+	_, err = RestoreBundle("gandalf-restored-repo", "", strings.NewReader("not a bundle"))
+	c.Assert(err, check.ErrorMatches, "^Error when trying to restore bundle into repository gandalf-restored-repo .*")
+}
+
+func (s *S) TestRestoreBundleRepositoryDoesNotExist(c *check.C) {
+	_, err := RestoreBundle("nothere", "", strings.NewReader("not a bundle"))
+	c.Assert(err, check.ErrorMatches, `^Error when trying to restore bundle into repository nothere \(Repository does not exist\)\.$`)
+}
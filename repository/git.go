@@ -31,6 +31,12 @@ func barePath(name string) string {
 	return path.Join(bareLocation(), name+".git")
 }
 
+// BarePath returns the path of the bare repository with the given name in
+// the filesystem.
+func BarePath(name string) string {
+	return barePath(name)
+}
+
 func newBare(name string) error {
 	args := []string{"init", barePath(name), "--bare"}
 	if bareTempl, err := config.GetString("git:bare:template"); err == nil {
@@ -44,6 +50,34 @@ func newBare(name string) error {
 	return nil
 }
 
+// InitBare creates the bare repository with the given name, unless it already
+// exists.
+func InitBare(name string) error {
+	if ok, err := exists(barePath(name)); err != nil || ok {
+		return err
+	}
+	return newBare(name)
+}
+
+// cloneBare creates the bare repository with the given name as a clone of the
+// bare repository of source.
+func cloneBare(source, name string) error {
+	args := []string{"clone", "--bare"}
+	if bareTempl, err := config.GetString("git:bare:template"); err == nil {
+		args = append(args, "--template="+bareTempl)
+	}
+	args = append(args, barePath(source), barePath(name))
+	out, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Could not clone git bare repository: %s. %s", err, string(out))
+	}
+	out, err = exec.Command("git", "--git-dir="+barePath(name), "remote", "remove", "origin").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Could not remove origin of git bare repository: %s. %s", err, string(out))
+	}
+	return nil
+}
+
 func removeBare(name string) error {
 	err := fs.Filesystem().RemoveAll(barePath(name))
 	if err != nil {
@@ -96,3 +96,23 @@ func (s *S) TestRemoveBareShouldReturnDescriptiveErrorWhenRemovalFails(c *check.
 	err := removeBare("fooo")
 	c.Assert(err, check.ErrorMatches, "^Could not remove git bare repository: .*")
 }
+
+func (s *S) TestCloneBareShouldCloneTheSourceAndRemoveOrigin(c *check.C) {
+	dir, err := commandmocker.Add("git", "$*")
+	c.Assert(err, check.IsNil)
+	defer commandmocker.Remove(dir)
+	config.Unset("git:bare:template")
+	err = cloneBare("source", "fork")
+	c.Assert(err, check.IsNil)
+	expected := fmt.Sprintf("clone --bare %s %s--git-dir=%s remote remove origin", barePath("source"), barePath("fork"), barePath("fork"))
+	c.Assert(commandmocker.Output(dir), check.Equals, expected)
+}
+
+func (s *S) TestCloneBareShouldReturnMeaningfulErrorWhenCloneFails(c *check.C) {
+	dir, err := commandmocker.Error("git", "cmd output", 1)
+	c.Assert(err, check.IsNil)
+	defer commandmocker.Remove(dir)
+	err = cloneBare("source", "fork")
+	c.Assert(err, check.NotNil)
+	c.Assert(err.Error(), check.Equals, "Could not clone git bare repository: exit status 1. cmd output")
+}
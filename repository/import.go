@@ -0,0 +1,285 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/tsuru/log"
+)
+
+// Status of an import job.
+const (
+	ImportPending = "pending"
+	ImportRunning = "running"
+	ImportDone    = "done"
+	ImportFailed  = "failed"
+)
+
+// defaultImportTimeout is the default value of the "import:timeout" setting.
+const defaultImportTimeout = time.Hour
+
+var (
+	ErrImportInProgress   = errors.New("import already in progress")
+	ErrImportNotFound     = errors.New("import not found")
+	ErrRepositoryNotEmpty = errors.New("repository is not empty")
+)
+
+// imports tracks the running import jobs, allowing tests to wait for them.
+var imports sync.WaitGroup
+
+// ImportJob tracks the import of the history of another git repository into
+// a gandalf repository. There is at most one job for each repository, the
+// last one started.
+//
+// A pending or running job locks the repository until Expires, when the job
+// is assumed to have been interrupted, for example by a restart of the
+// server, and is reported as failed.
+type ImportJob struct {
+	Repository string     `bson:"_id" json:"repository"`
+	Source     string     `json:"source"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	Started    time.Time  `json:"started"`
+	Finished   *time.Time `json:"finished,omitempty"`
+	Expires    time.Time  `json:"-"`
+}
+
+func importTimeout() time.Duration {
+	if d, err := config.GetDuration("import:timeout"); err == nil && d > 0 {
+		return d
+	}
+	return defaultImportTimeout
+}
+
+// Import starts a background job that fetches all branches and tags from
+// source into the bare repository of the repository with the given name,
+// which must be empty. The source may be the path to a git repository or git
+// bundle in the local filesystem, or a file:// URL, inside the directory
+// defined by the "import:location" setting, see checkImportSource.
+func Import(name, source string) (*ImportJob, error) {
+	log.Debugf("Importing %q into repository %q", source, name)
+	location, err := checkImportSource(source)
+	if err != nil {
+		return nil, err
+	}
+	return startImport(name, source, location, nil)
+}
+
+// ImportBundle is like Import, but reads the history from the given git
+// bundle, as generated by "git bundle create".
+func ImportBundle(name string, bundle io.Reader) (*ImportJob, error) {
+	log.Debugf("Importing bundle into repository %q", name)
+	f, err := ioutil.TempFile(tempDirLocation(), "gandalf_import")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cleanUp := func() { os.Remove(f.Name()) }
+	if _, err = io.Copy(f, bundle); err != nil {
+		cleanUp()
+		return nil, err
+	}
+	job, err := startImport(name, "bundle", f.Name(), cleanUp)
+	if err != nil {
+		cleanUp()
+	}
+	return job, err
+}
+
+// GetImport returns the last import job of the repository with the given
+// name.
+func GetImport(name string) (*ImportJob, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var job ImportJob
+	err = conn.ImportJob().FindId(name).One(&job)
+	if err == mgo.ErrNotFound {
+		return nil, ErrImportNotFound
+	}
+	if err == nil && (job.Status == ImportPending || job.Status == ImportRunning) && !job.Expires.After(time.Now()) {
+		job.Status = ImportFailed
+		job.Error = "import interrupted"
+	}
+	return &job, err
+}
+
+// checkImportSource returns the path of the given import source in the local
+// filesystem. Sources are only accepted inside the "import:location"
+// directory, and never inside the bare or LFS locations (including their
+// trash), so the history of other repositories can't be imported through
+// them.
+func checkImportSource(source string) (string, error) {
+	invalid := &InvalidRepositoryError{message: "import source is not valid"}
+	location := source
+	if strings.HasPrefix(source, "file://") {
+		u, err := url.Parse(source)
+		if err != nil || u.Path == "" {
+			return "", invalid
+		}
+		location = u.Path
+	}
+	if !filepath.IsAbs(location) {
+		return "", invalid
+	}
+	location, err := filepath.EvalSymlinks(location)
+	if err != nil {
+		return "", invalid
+	}
+	importDir, _ := config.GetString("import:location")
+	if importDir == "" {
+		return "", &InvalidRepositoryError{message: "importing from the filesystem is disabled"}
+	}
+	if !insideDir(location, importDir) {
+		return "", &InvalidRepositoryError{message: "import source must be inside " + importDir}
+	}
+	forbidden := []string{bareLocation()}
+	if lfsLocation, err := config.GetString("lfs:location"); err == nil {
+		forbidden = append(forbidden, lfsLocation)
+	}
+	for _, dir := range forbidden {
+		if insideDir(location, dir) {
+			return "", &InvalidRepositoryError{message: "import source must not be a repository managed by gandalf"}
+		}
+	}
+	return location, nil
+}
+
+// insideDir returns whether the given path, with symlinks already resolved,
+// is the directory dir or is inside it.
+func insideDir(location, dir string) bool {
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	rel, err := filepath.Rel(filepath.Clean(dir), location)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+func startImport(name, source, location string, cleanUp func()) (*ImportJob, error) {
+	if _, err := Get(name); err != nil {
+		return nil, err
+	}
+	out, err := exec.Command("git", "--git-dir="+barePath(name), "for-each-ref", "--count=1").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("Could not list refs of repository %q: %s. %s", name, err, string(out))
+	}
+	if len(strings.TrimSpace(string(out))) > 0 {
+		return nil, ErrRepositoryNotEmpty
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	now := time.Now().UTC()
+	job := ImportJob{Repository: name, Source: source, Status: ImportPending, Started: now, Expires: now.Add(importTimeout())}
+	unlocked := bson.M{"_id": name, "$or": []bson.M{
+		{"status": bson.M{"$in": []string{ImportDone, ImportFailed}}},
+		{"expires": bson.M{"$lte": now}},
+	}}
+	err = conn.ImportJob().Update(unlocked, &job)
+	if err == mgo.ErrNotFound {
+		err = conn.ImportJob().Insert(&job)
+		if mgo.IsDup(err) {
+			return nil, ErrImportInProgress
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	imports.Add(1)
+	go func() {
+		defer imports.Done()
+		if cleanUp != nil {
+			defer cleanUp()
+		}
+		runImport(job, location)
+	}()
+	return &job, nil
+}
+
+func runImport(job ImportJob, location string) {
+	setImportStatus(&job, ImportRunning, nil)
+	ctx, cancel := context.WithDeadline(context.Background(), job.Expires)
+	defer cancel()
+	err := fetchInto(ctx, job.Repository, location)
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", importTimeout())
+	}
+	if err != nil {
+		log.Errorf("repository.Import: could not import %q into %q: %s", job.Source, job.Repository, err)
+		setImportStatus(&job, ImportFailed, err)
+		return
+	}
+	setImportStatus(&job, ImportDone, nil)
+}
+
+func setImportStatus(job *ImportJob, status string, importErr error) {
+	job.Status = status
+	if importErr != nil {
+		job.Error = importErr.Error()
+	}
+	if status == ImportDone || status == ImportFailed {
+		now := time.Now().UTC()
+		job.Finished = &now
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		log.Errorf("repository.Import: could not update import of %q: %s", job.Repository, err)
+		return
+	}
+	defer conn.Close()
+	if err = conn.ImportJob().UpdateId(job.Repository, job); err != nil {
+		log.Errorf("repository.Import: could not update import of %q: %s", job.Repository, err)
+	}
+}
+
+// fetchInto fetches all branches and tags from location into the bare
+// repository with the given name, pointing HEAD to the first branch when the
+// current HEAD does not exist in the imported history. The fetch is killed
+// when the context is done.
+func fetchInto(ctx context.Context, name, location string) error {
+	gitDir := "--git-dir=" + barePath(name)
+	out, err := exec.CommandContext(ctx, "git", gitDir, "fetch", "--quiet", location, "+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s. %s", err, strings.TrimSpace(string(out)))
+	}
+	return pointHead(name)
+}
+
+// pointHead points the HEAD of the bare repository with the given name to its
+// first branch, when the current HEAD does not exist.
+func pointHead(name string) error {
+	gitDir := "--git-dir=" + barePath(name)
+	if exec.Command("git", gitDir, "rev-parse", "--verify", "--quiet", "HEAD").Run() == nil {
+		return nil
+	}
+	out, err := exec.Command("git", gitDir, "for-each-ref", "--count=1", "--format=%(refname)", "refs/heads").Output()
+	if err != nil {
+		return err
+	}
+	if head := strings.TrimSpace(string(out)); head != "" {
+		return exec.Command("git", gitDir, "symbolic-ref", "HEAD", head).Run()
+	}
+	return nil
+}
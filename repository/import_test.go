@@ -0,0 +1,265 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repository
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
+	"gopkg.in/check.v1"
+)
+
+// setUpImportBare points the bare location to a temporary directory, allowing
+// imports from the other temporary directories of the test, and returns a
+// function restoring it.
+func setUpImportBare(c *check.C) func() {
+	configBare, err := config.GetString("git:bare:location")
+	c.Assert(err, check.IsNil)
+	oldBare := bare
+	bare = c.MkDir()
+	config.Set("git:bare:location", bare)
+	config.Set("import:location", filepath.Dir(bare))
+	return func() {
+		config.Unset("import:location")
+		config.Set("git:bare:location", configBare)
+		bare = oldBare
+	}
+}
+
+// sourceRepository creates a git repository with a commit in the main branch
+// and a tag, returning its path.
+func sourceRepository(c *check.C) string {
+	dir := c.MkDir()
+	cmds := [][]string{
+		{"init", "--quiet", "-b", "main", dir},
+		{"-C", dir, "-c", "user.name=bilbo", "-c", "user.email=bilbo@shire.me", "commit", "--quiet", "--allow-empty", "-m", "there and back again"},
+		{"-C", dir, "tag", "v1"},
+	}
+	for _, args := range cmds {
+		out, err := exec.Command("git", args...).CombinedOutput()
+		c.Assert(err, check.IsNil, check.Commentf("%s", out))
+	}
+	return dir
+}
+
+func refsOf(c *check.C, name string) string {
+	out, err := exec.Command("git", "--git-dir="+barePath(name), "for-each-ref", "--format=%(refname)").CombinedOutput()
+	c.Assert(err, check.IsNil, check.Commentf("%s", out))
+	return strings.TrimSpace(string(out))
+}
+
+func (s *S) TestCheckImportSource(c *check.C) {
+	defer setUpImportBare(c)()
+	dir := c.MkDir()
+	location, err := checkImportSource(dir)
+	c.Check(err, check.IsNil)
+	c.Check(location, check.Equals, dir)
+	location, err = checkImportSource("file://" + dir)
+	c.Check(err, check.IsNil)
+	c.Check(location, check.Equals, dir)
+	invalid := []string{
+		"file://",
+		path.Join(dir, "nothere"),
+		"relative/path",
+		"https://github.com/tsuru/gandalf.git",
+		"git@github.com:tsuru/gandalf.git",
+	}
+	for _, source := range invalid {
+		_, err = checkImportSource(source)
+		c.Check(err, check.ErrorMatches, "import source is not valid", check.Commentf("%s", source))
+	}
+}
+
+func (s *S) TestCheckImportSourceOutsideImportLocation(c *check.C) {
+	defer setUpImportBare(c)()
+	config.Set("import:location", c.MkDir())
+	_, err := checkImportSource(c.MkDir())
+	c.Assert(err, check.FitsTypeOf, &InvalidRepositoryError{})
+	c.Assert(err, check.ErrorMatches, "import source must be inside .*")
+	_, err = checkImportSource("/etc")
+	c.Assert(err, check.ErrorMatches, "import source must be inside .*")
+}
+
+func (s *S) TestCheckImportSourceSymlinkOutsideImportLocation(c *check.C) {
+	defer setUpImportBare(c)()
+	importDir := c.MkDir()
+	config.Set("import:location", importDir)
+	link := path.Join(importDir, "link")
+	err := os.Symlink(c.MkDir(), link)
+	c.Assert(err, check.IsNil)
+	_, err = checkImportSource(link)
+	c.Assert(err, check.ErrorMatches, "import source must be inside .*")
+}
+
+func (s *S) TestCheckImportSourceWithoutImportLocation(c *check.C) {
+	defer setUpImportBare(c)()
+	config.Unset("import:location")
+	_, err := checkImportSource(c.MkDir())
+	c.Assert(err, check.FitsTypeOf, &InvalidRepositoryError{})
+	c.Assert(err, check.ErrorMatches, "importing from the filesystem is disabled")
+}
+
+func (s *S) TestCheckImportSourceManagedRepository(c *check.C) {
+	defer setUpImportBare(c)()
+	lfsLocation := c.MkDir()
+	config.Set("lfs:location", lfsLocation)
+	defer config.Unset("lfs:location")
+	sources := []string{
+		bare,
+		path.Join(bare, "the-shire.git"),
+		"file://" + path.Join(bare, trashDir, "the-shire.git"),
+		path.Join(lfsLocation, "the-shire"),
+	}
+	for _, source := range sources {
+		err := os.MkdirAll(strings.TrimPrefix(source, "file://"), 0755)
+		c.Assert(err, check.IsNil)
+		_, err = checkImportSource(source)
+		c.Check(err, check.ErrorMatches, "import source must not be a repository managed by gandalf", check.Commentf("%s", source))
+	}
+}
+
+func (s *S) TestImportIntegration(c *check.C) {
+	defer setUpImportBare(c)()
+	source := sourceRepository(c)
+	_, err := New("the-shire", []string{"bilbo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	defer Remove("the-shire")
+	job, err := Import("the-shire", "file://"+source)
+	c.Assert(err, check.IsNil)
+	c.Assert(job.Status, check.Equals, ImportPending)
+	imports.Wait()
+	job, err = GetImport("the-shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(job.Status, check.Equals, ImportDone)
+	c.Assert(job.Source, check.Equals, "file://"+source)
+	c.Assert(job.Finished, check.NotNil)
+	c.Assert(refsOf(c, "the-shire"), check.Equals, "refs/heads/main\nrefs/tags/v1")
+	out, err := exec.Command("git", "--git-dir="+barePath("the-shire"), "symbolic-ref", "HEAD").CombinedOutput()
+	c.Assert(err, check.IsNil)
+	c.Assert(string(out), check.Equals, "refs/heads/main\n")
+}
+
+func (s *S) TestImportBundleIntegration(c *check.C) {
+	defer setUpImportBare(c)()
+	source := sourceRepository(c)
+	bundle := path.Join(c.MkDir(), "source.bundle")
+	out, err := exec.Command("git", "-C", source, "bundle", "create", bundle, "--all").CombinedOutput()
+	c.Assert(err, check.IsNil, check.Commentf("%s", out))
+	f, err := os.Open(bundle)
+	c.Assert(err, check.IsNil)
+	defer f.Close()
+	_, err = New("the-shire", []string{"bilbo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	defer Remove("the-shire")
+	oldTempDir := tempDir
+	tempDir = c.MkDir()
+	defer func() { tempDir = oldTempDir }()
+	job, err := ImportBundle("the-shire", f)
+	c.Assert(err, check.IsNil)
+	c.Assert(job.Source, check.Equals, "bundle")
+	imports.Wait()
+	job, err = GetImport("the-shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(job.Status, check.Equals, ImportDone)
+	c.Assert(refsOf(c, "the-shire"), check.Equals, "refs/heads/main\nrefs/tags/v1")
+	files, err := ioutil.ReadDir(tempDir)
+	c.Assert(err, check.IsNil)
+	c.Assert(files, check.HasLen, 0)
+}
+
+func (s *S) TestImportFailure(c *check.C) {
+	defer setUpImportBare(c)()
+	_, err := New("the-shire", []string{"bilbo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	defer Remove("the-shire")
+	_, err = Import("the-shire", c.MkDir())
+	c.Assert(err, check.IsNil)
+	imports.Wait()
+	job, err := GetImport("the-shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(job.Status, check.Equals, ImportFailed)
+	c.Assert(job.Error, check.Not(check.Equals), "")
+	_, err = Import("the-shire", "file://"+sourceRepository(c))
+	c.Assert(err, check.IsNil)
+	imports.Wait()
+	job, err = GetImport("the-shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(job.Status, check.Equals, ImportDone)
+	c.Assert(job.Error, check.Equals, "")
+}
+
+func (s *S) TestImportRepositoryNotEmpty(c *check.C) {
+	defer setUpImportBare(c)()
+	source := sourceRepository(c)
+	_, err := New("the-shire", []string{"bilbo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	defer Remove("the-shire")
+	_, err = Import("the-shire", source)
+	c.Assert(err, check.IsNil)
+	imports.Wait()
+	_, err = Import("the-shire", source)
+	c.Assert(err, check.Equals, ErrRepositoryNotEmpty)
+}
+
+func (s *S) TestImportInProgress(c *check.C) {
+	defer setUpImportBare(c)()
+	_, err := New("the-shire", []string{"bilbo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	defer Remove("the-shire")
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.ImportJob().Insert(ImportJob{Repository: "the-shire", Status: ImportRunning, Expires: time.Now().Add(time.Hour)})
+	c.Assert(err, check.IsNil)
+	_, err = Import("the-shire", c.MkDir())
+	c.Assert(err, check.Equals, ErrImportInProgress)
+}
+
+func (s *S) TestImportAfterInterruptedJob(c *check.C) {
+	defer setUpImportBare(c)()
+	source := sourceRepository(c)
+	_, err := New("the-shire", []string{"bilbo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	defer Remove("the-shire")
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.ImportJob().Insert(ImportJob{Repository: "the-shire", Status: ImportRunning, Expires: time.Now().Add(-time.Minute)})
+	c.Assert(err, check.IsNil)
+	job, err := GetImport("the-shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(job.Status, check.Equals, ImportFailed)
+	c.Assert(job.Error, check.Equals, "import interrupted")
+	_, err = Import("the-shire", source)
+	c.Assert(err, check.IsNil)
+	imports.Wait()
+	job, err = GetImport("the-shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(job.Status, check.Equals, ImportDone)
+	c.Assert(refsOf(c, "the-shire"), check.Equals, "refs/heads/main\nrefs/tags/v1")
+}
+
+func (s *S) TestImportInvalidSource(c *check.C) {
+	_, err := Import("the-shire", "https://github.com/tsuru/gandalf.git")
+	c.Assert(err, check.FitsTypeOf, &InvalidRepositoryError{})
+}
+
+func (s *S) TestImportRepositoryNotFound(c *check.C) {
+	defer setUpImportBare(c)()
+	_, err := Import("mordor", c.MkDir())
+	c.Assert(err, check.Equals, ErrRepositoryNotFound)
+}
+
+func (s *S) TestGetImportNotFound(c *check.C) {
+	_, err := GetImport("mordor")
+	c.Assert(err, check.Equals, ErrImportNotFound)
+}
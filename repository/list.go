@@ -0,0 +1,186 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/gandalf/group"
+)
+
+// DefaultListLimit is the number of repositories returned by List when the
+// filter does not define a limit, and MaxListLimit is the largest limit
+// allowed.
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
+
+// Orders of the repositories returned by List. A leading "-" reverses the
+// order.
+const (
+	SortName      = "name"
+	SortDiskUsage = "disk_usage"
+)
+
+var sortOrders = map[string][]string{
+	SortName:            {"_id"},
+	"-" + SortName:      {"-_id"},
+	SortDiskUsage:       {"diskusage", "_id"},
+	"-" + SortDiskUsage: {"-diskusage", "-_id"},
+}
+
+var ErrInvalidCursor = &InvalidRepositoryError{message: "invalid cursor"}
+
+// ListFilter defines the criteria for listing repositories. Empty fields are
+// ignored.
+//
+// Namespace matches the repositories in the namespace, such as "team" for
+// "team/myapp". User matches the repositories the user is a member of, with
+// any role, directly or through groups. Search matches the repositories whose
+// name contains it, ignoring case. Cursor is the Next field of the previous
+// page.
+type ListFilter struct {
+	Namespace string
+	User      string
+	Public    *bool
+	Search    string
+	Sort      string
+	Cursor    string
+	Limit     int
+}
+
+// RepositoryPage is a page of the repositories returned by List. Next is the
+// cursor of the following page, empty in the last one.
+type RepositoryPage struct {
+	Repositories []Repository `json:"repositories"`
+	Next         string       `json:"next"`
+}
+
+// cursor identifies the last repository of a page, by the fields the
+// repositories are sorted by.
+type cursor struct {
+	Name      string `json:"n"`
+	DiskUsage int64  `json:"u,omitempty"`
+}
+
+func encodeCursor(r *Repository) string {
+	data, _ := json.Marshal(cursor{Name: r.Name, DiskUsage: r.DiskUsage})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err = json.Unmarshal(data, &c); err != nil || c.Name == "" {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+func (f *ListFilter) query() (bson.M, error) {
+	var conditions []bson.M
+	if f.Namespace != "" {
+		conditions = append(conditions, bson.M{"_id": bson.RegEx{Pattern: "^" + regexp.QuoteMeta(f.Namespace) + "/"}})
+	}
+	if f.Search != "" {
+		conditions = append(conditions, bson.M{"_id": bson.RegEx{Pattern: regexp.QuoteMeta(f.Search), Options: "i"}})
+	}
+	if f.Public != nil {
+		conditions = append(conditions, bson.M{"ispublic": *f.Public})
+	}
+	if f.User != "" {
+		member := []bson.M{}
+		for _, role := range roles {
+			member = append(member, bson.M{roleFields[role]: f.User})
+		}
+		groups, err := group.GroupsOf(f.User)
+		if err != nil {
+			return nil, err
+		}
+		if len(groups) > 0 {
+			member = append(member, bson.M{"groups": bson.M{"$in": groups}}, bson.M{"readonlygroups": bson.M{"$in": groups}})
+		}
+		conditions = append(conditions, bson.M{"$or": member})
+	}
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, f.after(c))
+	}
+	switch len(conditions) {
+	case 0:
+		return bson.M{}, nil
+	case 1:
+		return conditions[0], nil
+	}
+	return bson.M{"$and": conditions}, nil
+}
+
+// after returns the condition matching the repositories following the one
+// identified by the cursor, in the order of the filter.
+func (f *ListFilter) after(c *cursor) bson.M {
+	op := "$gt"
+	if strings.HasPrefix(f.Sort, "-") {
+		op = "$lt"
+	}
+	if strings.TrimPrefix(f.Sort, "-") != SortDiskUsage {
+		return bson.M{"_id": bson.M{op: c.Name}}
+	}
+	return bson.M{"$or": []bson.M{
+		{"diskusage": bson.M{op: c.DiskUsage}},
+		{"diskusage": c.DiskUsage, "_id": bson.M{op: c.Name}},
+	}}
+}
+
+// List returns a page of the repositories matching the given filter, sorted
+// by name unless the filter defines another order.
+func List(f ListFilter) (*RepositoryPage, error) {
+	if f.Sort == "" {
+		f.Sort = SortName
+	}
+	order, ok := sortOrders[f.Sort]
+	if !ok {
+		return nil, &InvalidRepositoryError{
+			message: fmt.Sprintf("invalid sort %q, valid options are: name, -name, disk_usage, -disk_usage", f.Sort),
+		}
+	}
+	if f.Limit <= 0 {
+		f.Limit = DefaultListLimit
+	}
+	if f.Limit > MaxListLimit {
+		f.Limit = MaxListLimit
+	}
+	query, err := f.query()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	page := RepositoryPage{Repositories: []Repository{}}
+	err = conn.Repository().Find(query).Sort(order...).Limit(f.Limit + 1).All(&page.Repositories)
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Repositories) > f.Limit {
+		page.Repositories = page.Repositories[:f.Limit]
+		page.Next = encodeCursor(&page.Repositories[f.Limit-1])
+	}
+	return &page, nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repository
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/gandalf/db"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) insertListRepositories(c *check.C) func() {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	repos := []Repository{
+		{Name: "hobbits/shire", Users: []string{"bilbo"}, DiskUsage: 30},
+		{Name: "hobbits/bag-end", ReadOnlyUsers: []string{"bilbo"}, IsPublic: true, DiskUsage: 10},
+		{Name: "elves/rivendell", Groups: []string{"fellowship"}, DiskUsage: 30},
+		{Name: "mordor", Admins: []string{"sauron"}, DiskUsage: 20},
+	}
+	for _, repo := range repos {
+		err = conn.Repository().Insert(repo)
+		c.Assert(err, check.IsNil)
+	}
+	err = conn.Group().Insert(bson.M{"_id": "fellowship", "members": []string{"bilbo"}})
+	c.Assert(err, check.IsNil)
+	return func() {
+		conn.Repository().RemoveAll(nil)
+		conn.Group().RemoveId("fellowship")
+		conn.Close()
+	}
+}
+
+func listNames(page *RepositoryPage) []string {
+	names := make([]string, len(page.Repositories))
+	for i, repo := range page.Repositories {
+		names[i] = repo.Name
+	}
+	return names
+}
+
+func (s *S) TestList(c *check.C) {
+	defer s.insertListRepositories(c)()
+	page, err := List(ListFilter{})
+	c.Assert(err, check.IsNil)
+	c.Assert(listNames(page), check.DeepEquals, []string{"elves/rivendell", "hobbits/bag-end", "hobbits/shire", "mordor"})
+	c.Assert(page.Next, check.Equals, "")
+}
+
+func (s *S) TestListFilters(c *check.C) {
+	defer s.insertListRepositories(c)()
+	public := true
+	tests := []struct {
+		filter   ListFilter
+		expected []string
+	}{
+		{ListFilter{Namespace: "hobbits"}, []string{"hobbits/bag-end", "hobbits/shire"}},
+		{ListFilter{User: "bilbo"}, []string{"elves/rivendell", "hobbits/bag-end", "hobbits/shire"}},
+		{ListFilter{User: "sauron"}, []string{"mordor"}},
+		{ListFilter{Public: &public}, []string{"hobbits/bag-end"}},
+		{ListFilter{Search: "END"}, []string{"elves/rivendell", "hobbits/bag-end"}},
+		{ListFilter{Namespace: "hobbits", Search: "end"}, []string{"hobbits/bag-end"}},
+		{ListFilter{Search: ".*"}, []string{}},
+	}
+	for _, t := range tests {
+		page, err := List(t.filter)
+		c.Assert(err, check.IsNil)
+		c.Check(listNames(page), check.DeepEquals, t.expected, check.Commentf("%#v", t.filter))
+	}
+}
+
+func (s *S) TestListPagination(c *check.C) {
+	defer s.insertListRepositories(c)()
+	for _, t := range []struct {
+		sort     string
+		expected []string
+	}{
+		{"", []string{"elves/rivendell", "hobbits/bag-end", "hobbits/shire", "mordor"}},
+		{"-name", []string{"mordor", "hobbits/shire", "hobbits/bag-end", "elves/rivendell"}},
+		{"disk_usage", []string{"hobbits/bag-end", "mordor", "elves/rivendell", "hobbits/shire"}},
+		{"-disk_usage", []string{"hobbits/shire", "elves/rivendell", "mordor", "hobbits/bag-end"}},
+	} {
+		var names []string
+		filter := ListFilter{Sort: t.sort, Limit: 3}
+		for {
+			page, err := List(filter)
+			c.Assert(err, check.IsNil)
+			names = append(names, listNames(page)...)
+			if page.Next == "" {
+				break
+			}
+			filter.Cursor = page.Next
+		}
+		c.Check(names, check.DeepEquals, t.expected, check.Commentf("sort %q", t.sort))
+	}
+}
+
+func (s *S) TestListInvalidSort(c *check.C) {
+	_, err := List(ListFilter{Sort: "size"})
+	c.Assert(err, check.FitsTypeOf, &InvalidRepositoryError{})
+}
+
+func (s *S) TestListInvalidCursor(c *check.C) {
+	for _, cursor := range []string{"not base64!", "bm90IGpzb24"} {
+		_, err := List(ListFilter{Cursor: cursor})
+		c.Check(err, check.Equals, ErrInvalidCursor)
+	}
+}
+
+func (s *S) TestCursor(c *check.C) {
+	value := encodeCursor(&Repository{Name: "hobbits/shire", DiskUsage: 30})
+	got, err := decodeCursor(value)
+	c.Assert(err, check.IsNil)
+	c.Assert(*got, check.Equals, cursor{Name: "hobbits/shire", DiskUsage: 30})
+}
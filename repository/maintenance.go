@@ -0,0 +1,322 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/tsuru/log"
+)
+
+// Status of a maintenance run.
+const (
+	MaintenanceRunning = "running"
+	MaintenanceDone    = "done"
+	MaintenanceFailed  = "failed"
+)
+
+// Maintenance tasks.
+const (
+	TaskGC          = "gc"
+	TaskRepack      = "repack"
+	TaskPrune       = "prune"
+	TaskCommitGraph = "commit-graph"
+	TaskFsck        = "fsck"
+)
+
+// Defaults of the maintenance settings.
+const (
+	defaultTaskTimeout      = time.Hour
+	defaultPruneExpire      = "2.weeks.ago"
+	maintenancePollInterval = time.Minute
+)
+
+var (
+	ErrMaintenanceInProgress = errors.New("maintenance already in progress")
+	ErrMaintenanceNotFound   = errors.New("maintenance not found")
+)
+
+// taskNames lists the maintenance tasks, in the order they run by default.
+var taskNames = []string{TaskGC, TaskRepack, TaskPrune, TaskCommitGraph, TaskFsck}
+
+// defaultTasks are the tasks run when neither the request nor the
+// "maintenance:tasks" setting define them.
+var defaultTasks = []string{TaskGC, TaskCommitGraph}
+
+// maintenances tracks the maintenance runs started in background, allowing
+// tests to wait for them.
+var maintenances sync.WaitGroup
+
+// TaskResult is the result of a task of a maintenance run. Output holds the
+// output of git when the task fails.
+type TaskResult struct {
+	Name     string    `json:"name"`
+	Error    string    `json:"error,omitempty"`
+	Output   string    `json:"output,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+}
+
+// MaintenanceRun tracks the maintenance of the bare repository of a gandalf
+// repository. There is at most one run for each repository, the last one
+// started. Scheduled tells whether the run was started by the scheduler.
+//
+// A running maintenance locks the repository, so only one run at a time
+// happens in it, until Expires, when the run is assumed to have been
+// interrupted.
+type MaintenanceRun struct {
+	Repository string       `bson:"_id" json:"repository"`
+	Tasks      []string     `json:"tasks"`
+	Results    []TaskResult `json:"results"`
+	Scheduled  bool         `json:"scheduled"`
+	Status     string       `json:"status"`
+	Started    time.Time    `json:"started"`
+	Finished   *time.Time   `json:"finished,omitempty"`
+	Expires    time.Time    `json:"-"`
+}
+
+func taskTimeout() time.Duration {
+	if d, err := config.GetDuration("maintenance:task-timeout"); err == nil && d > 0 {
+		return d
+	}
+	return defaultTaskTimeout
+}
+
+// taskArgs returns the arguments of the git command running the task with
+// the given name.
+func taskArgs(task string) []string {
+	switch task {
+	case TaskGC:
+		return []string{"gc", "--quiet"}
+	case TaskRepack:
+		return []string{"repack", "-a", "-d", "-q"}
+	case TaskPrune:
+		expire, err := config.GetString("maintenance:prune-expire")
+		if err != nil {
+			expire = defaultPruneExpire
+		}
+		return []string{"prune", "--expire=" + expire}
+	case TaskCommitGraph:
+		return []string{"commit-graph", "write", "--reachable"}
+	case TaskFsck:
+		return []string{"fsck", "--no-progress"}
+	}
+	return nil
+}
+
+// maintenanceTasks validates the given tasks, returning the ones defined by
+// the "maintenance:tasks" setting when there are none.
+func maintenanceTasks(tasks []string) ([]string, error) {
+	if len(tasks) == 0 {
+		var err error
+		if tasks, err = config.GetList("maintenance:tasks"); err != nil || len(tasks) == 0 {
+			tasks = defaultTasks
+		}
+	}
+	for _, task := range tasks {
+		if taskArgs(task) == nil {
+			return nil, &InvalidRepositoryError{
+				message: fmt.Sprintf("invalid maintenance task %q, valid options are: %s", task, strings.Join(taskNames, ", ")),
+			}
+		}
+	}
+	return tasks, nil
+}
+
+// StartMaintenance starts a background run of the given maintenance tasks in
+// the bare repository of the repository with the given name. The tasks
+// defined by the "maintenance:tasks" setting run when there are none.
+func StartMaintenance(name string, tasks []string) (*MaintenanceRun, error) {
+	log.Debugf("Starting maintenance of repository %q", name)
+	run, err := claimMaintenance(name, tasks, false)
+	if err != nil {
+		return nil, err
+	}
+	maintenances.Add(1)
+	go func() {
+		defer maintenances.Done()
+		runMaintenance(run)
+	}()
+	return run, nil
+}
+
+// GetMaintenance returns the last maintenance run of the repository with the
+// given name.
+func GetMaintenance(name string) (*MaintenanceRun, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var run MaintenanceRun
+	err = conn.Maintenance().FindId(name).One(&run)
+	if err == mgo.ErrNotFound {
+		return nil, ErrMaintenanceNotFound
+	}
+	return &run, err
+}
+
+// claimMaintenance records a new maintenance run of the repository, failing
+// when another run is in progress.
+func claimMaintenance(name string, tasks []string, scheduled bool) (*MaintenanceRun, error) {
+	tasks, err := maintenanceTasks(tasks)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = Get(name); err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	now := time.Now().UTC()
+	run := MaintenanceRun{
+		Repository: name,
+		Tasks:      tasks,
+		Results:    []TaskResult{},
+		Scheduled:  scheduled,
+		Status:     MaintenanceRunning,
+		Started:    now,
+		Expires:    now.Add(taskTimeout() * time.Duration(len(tasks))),
+	}
+	unlocked := bson.M{"_id": name, "$or": []bson.M{
+		{"status": bson.M{"$ne": MaintenanceRunning}},
+		{"expires": bson.M{"$lte": now}},
+	}}
+	err = conn.Maintenance().Update(unlocked, &run)
+	if err == mgo.ErrNotFound {
+		err = conn.Maintenance().Insert(&run)
+		if mgo.IsDup(err) {
+			return nil, ErrMaintenanceInProgress
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// runMaintenance runs the tasks of the maintenance run, stopping at the first
+// failing task, and records the result. The disk usage of the repository is
+// updated afterwards.
+func runMaintenance(run *MaintenanceRun) {
+	run.Status = MaintenanceDone
+	for _, task := range run.Tasks {
+		result := runTask(run.Repository, task)
+		run.Results = append(run.Results, result)
+		if result.Error != "" {
+			log.Errorf("repository.Maintenance: task %q failed in %q: %s", task, run.Repository, result.Error)
+			run.Status = MaintenanceFailed
+			break
+		}
+	}
+	now := time.Now().UTC()
+	run.Finished = &now
+	conn, err := db.Conn()
+	if err != nil {
+		log.Errorf("repository.Maintenance: could not update maintenance of %q: %s", run.Repository, err)
+		return
+	}
+	defer conn.Close()
+	if err = conn.Maintenance().UpdateId(run.Repository, run); err != nil {
+		log.Errorf("repository.Maintenance: could not update maintenance of %q: %s", run.Repository, err)
+	}
+	if _, err = UpdateDiskUsage(run.Repository); err != nil {
+		log.Errorf("repository.Maintenance: could not update the disk usage of %q: %s", run.Repository, err)
+	}
+}
+
+func runTask(name, task string) TaskResult {
+	result := TaskResult{Name: task, Started: time.Now().UTC()}
+	ctx, cancel := context.WithTimeout(context.Background(), taskTimeout())
+	defer cancel()
+	args := append([]string{"--git-dir=" + barePath(name)}, taskArgs(task)...)
+	out, err := exec.CommandContext(ctx, "git", args...).CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", taskTimeout())
+	}
+	if err != nil {
+		result.Error = err.Error()
+		result.Output = strings.TrimSpace(string(out))
+	}
+	result.Finished = time.Now().UTC()
+	return result
+}
+
+// RunScheduledMaintenance runs the maintenance of the repositories whose last
+// run started longer ago than the "maintenance:interval" setting, or that
+// never had one, one at a time. Repositories being maintained elsewhere are
+// skipped. It returns the number of repositories maintained.
+func RunScheduledMaintenance() (int, error) {
+	interval, err := config.GetDuration("maintenance:interval")
+	if err != nil || interval <= 0 {
+		return 0, nil
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return 0, err
+	}
+	var all, recent []struct {
+		Name string `bson:"_id"`
+	}
+	err = conn.Repository().Find(nil).Select(bson.M{"_id": 1}).All(&all)
+	if err == nil {
+		since := time.Now().UTC().Add(-interval)
+		err = conn.Maintenance().Find(bson.M{"started": bson.M{"$gt": since}}).Select(bson.M{"_id": 1}).All(&recent)
+	}
+	conn.Close()
+	if err != nil {
+		return 0, err
+	}
+	skip := make(map[string]bool, len(recent))
+	for _, r := range recent {
+		skip[r.Name] = true
+	}
+	var names []string
+	for _, r := range all {
+		if !skip[r.Name] {
+			names = append(names, r.Name)
+		}
+	}
+	sort.Strings(names)
+	var count int
+	for _, name := range names {
+		run, err := claimMaintenance(name, nil, true)
+		if err == ErrMaintenanceInProgress || err == ErrRepositoryNotFound {
+			continue
+		}
+		if err != nil {
+			return count, err
+		}
+		runMaintenance(run)
+		count++
+	}
+	return count, nil
+}
+
+// StartMaintenanceScheduler starts a goroutine that periodically runs the
+// scheduled maintenance of the repositories, see RunScheduledMaintenance.
+func StartMaintenanceScheduler() {
+	go func() {
+		for range time.Tick(maintenancePollInterval) {
+			if _, err := RunScheduledMaintenance(); err != nil {
+				log.Errorf("repository.Maintenance: failed to run scheduled maintenance: %s", err)
+			}
+		}
+	}()
+}
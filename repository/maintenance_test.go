@@ -0,0 +1,163 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repository
+
+import (
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestMaintenanceTasksDefault(c *check.C) {
+	tasks, err := maintenanceTasks(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(tasks, check.DeepEquals, []string{TaskGC, TaskCommitGraph})
+}
+
+func (s *S) TestMaintenanceTasksFromConfig(c *check.C) {
+	config.Set("maintenance:tasks", []interface{}{"repack", "prune"})
+	defer config.Unset("maintenance:tasks")
+	tasks, err := maintenanceTasks(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(tasks, check.DeepEquals, []string{TaskRepack, TaskPrune})
+	tasks, err = maintenanceTasks([]string{"fsck"})
+	c.Assert(err, check.IsNil)
+	c.Assert(tasks, check.DeepEquals, []string{TaskFsck})
+}
+
+func (s *S) TestMaintenanceTasksInvalid(c *check.C) {
+	_, err := maintenanceTasks([]string{"gc", "rm"})
+	c.Assert(err, check.FitsTypeOf, &InvalidRepositoryError{})
+	c.Assert(err, check.ErrorMatches, `invalid maintenance task "rm", valid options are: gc, repack, prune, commit-graph, fsck`)
+}
+
+func (s *S) TestTaskArgsPruneExpire(c *check.C) {
+	c.Assert(taskArgs(TaskPrune), check.DeepEquals, []string{"prune", "--expire=2.weeks.ago"})
+	config.Set("maintenance:prune-expire", "now")
+	defer config.Unset("maintenance:prune-expire")
+	c.Assert(taskArgs(TaskPrune), check.DeepEquals, []string{"prune", "--expire=now"})
+}
+
+func (s *S) TestStartMaintenanceIntegration(c *check.C) {
+	defer setUpImportBare(c)()
+	_, err := New("the-shire", []string{"bilbo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	defer Remove("the-shire")
+	tasks := []string{TaskGC, TaskRepack, TaskPrune, TaskCommitGraph, TaskFsck}
+	run, err := StartMaintenance("the-shire", tasks)
+	c.Assert(err, check.IsNil)
+	c.Assert(run.Status, check.Equals, MaintenanceRunning)
+	c.Assert(run.Scheduled, check.Equals, false)
+	maintenances.Wait()
+	run, err = GetMaintenance("the-shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(run.Status, check.Equals, MaintenanceDone)
+	c.Assert(run.Finished, check.NotNil)
+	c.Assert(run.Results, check.HasLen, len(tasks))
+	for i, result := range run.Results {
+		c.Check(result.Name, check.Equals, tasks[i])
+		c.Check(result.Error, check.Equals, "")
+	}
+	repo, err := Get("the-shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(repo.DiskUsage > 0, check.Equals, true)
+}
+
+func (s *S) TestStartMaintenanceFailedTask(c *check.C) {
+	defer setUpImportBare(c)()
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&Repository{Name: "mordor"})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("mordor")
+	defer conn.Maintenance().RemoveId("mordor")
+	_, err = StartMaintenance("mordor", []string{TaskGC, TaskFsck})
+	c.Assert(err, check.IsNil)
+	maintenances.Wait()
+	run, err := GetMaintenance("mordor")
+	c.Assert(err, check.IsNil)
+	c.Assert(run.Status, check.Equals, MaintenanceFailed)
+	c.Assert(run.Results, check.HasLen, 1)
+	c.Assert(run.Results[0].Name, check.Equals, TaskGC)
+	c.Assert(run.Results[0].Error, check.Not(check.Equals), "")
+	c.Assert(run.Results[0].Output, check.Matches, "(?s).*not a git repository.*")
+}
+
+func (s *S) TestStartMaintenanceInProgress(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&Repository{Name: "the-shire"})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("the-shire")
+	running := MaintenanceRun{Repository: "the-shire", Status: MaintenanceRunning, Expires: time.Now().Add(time.Hour)}
+	err = conn.Maintenance().Insert(&running)
+	c.Assert(err, check.IsNil)
+	defer conn.Maintenance().RemoveId("the-shire")
+	_, err = StartMaintenance("the-shire", nil)
+	c.Assert(err, check.Equals, ErrMaintenanceInProgress)
+}
+
+func (s *S) TestStartMaintenanceExpiredLock(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&Repository{Name: "the-shire"})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("the-shire")
+	running := MaintenanceRun{Repository: "the-shire", Status: MaintenanceRunning, Expires: time.Now().Add(-time.Minute)}
+	err = conn.Maintenance().Insert(&running)
+	c.Assert(err, check.IsNil)
+	defer conn.Maintenance().RemoveId("the-shire")
+	run, err := claimMaintenance("the-shire", nil, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(run.Status, check.Equals, MaintenanceRunning)
+}
+
+func (s *S) TestStartMaintenanceRepositoryNotFound(c *check.C) {
+	_, err := StartMaintenance("ghost", nil)
+	c.Assert(err, check.Equals, ErrRepositoryNotFound)
+}
+
+func (s *S) TestGetMaintenanceNotFound(c *check.C) {
+	_, err := GetMaintenance("ghost")
+	c.Assert(err, check.Equals, ErrMaintenanceNotFound)
+}
+
+func (s *S) TestRunScheduledMaintenance(c *check.C) {
+	defer setUpImportBare(c)()
+	config.Set("maintenance:interval", "24h")
+	defer config.Unset("maintenance:interval")
+	for _, name := range []string{"the-shire", "rivendell"} {
+		_, err := New(name, []string{"bilbo"}, nil, false)
+		c.Assert(err, check.IsNil)
+		defer Remove(name)
+	}
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	recent := MaintenanceRun{Repository: "rivendell", Status: MaintenanceDone, Started: time.Now().UTC().Add(-time.Hour)}
+	err = conn.Maintenance().Insert(&recent)
+	c.Assert(err, check.IsNil)
+	count, err := RunScheduledMaintenance()
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 1)
+	run, err := GetMaintenance("the-shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(run.Status, check.Equals, MaintenanceDone)
+	c.Assert(run.Scheduled, check.Equals, true)
+	count, err = RunScheduledMaintenance()
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 0)
+}
+
+func (s *S) TestRunScheduledMaintenanceWithoutInterval(c *check.C) {
+	count, err := RunScheduledMaintenance()
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 0)
+}
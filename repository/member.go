@@ -0,0 +1,165 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repository
+
+import (
+	"errors"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/gandalf/group"
+	"github.com/tsuru/tsuru/log"
+)
+
+// Roles of the members of a repository. Each role includes the permissions of
+// the ones before it:
+//
+//   - read members may fetch from the repository;
+//   - write members may push to it;
+//   - maintainers may also push to protected branches restricted to other
+//     users;
+//   - admins may also change the settings of the repository, manage its
+//     members and remove it.
+const (
+	RoleRead       = "read"
+	RoleWrite      = "write"
+	RoleMaintainer = "maintainer"
+	RoleAdmin      = "admin"
+)
+
+// roles lists the roles from the least to the most privileged.
+var roles = []string{RoleRead, RoleWrite, RoleMaintainer, RoleAdmin}
+
+// roleFields maps each role to the field of the repository document listing
+// its members.
+var roleFields = map[string]string{
+	RoleRead:       "readonlyusers",
+	RoleWrite:      "users",
+	RoleMaintainer: "maintainers",
+	RoleAdmin:      "admins",
+}
+
+var (
+	ErrInvalidRole    = &InvalidRepositoryError{message: "role is not valid, valid roles are read, write, maintainer and admin"}
+	ErrMemberNotFound = errors.New("member not found")
+)
+
+// Member is a user with access to a repository.
+type Member struct {
+	User string `json:"user"`
+	Role string `json:"role"`
+}
+
+func roleLevel(role string) int {
+	for i, r := range roles {
+		if r == role {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Role returns the role of the user in the repository, considering the
+// groups with access to it, or an empty string if the user is not a member.
+func (r *Repository) Role(userName string) string {
+	switch {
+	case contains(r.Admins, userName):
+		return RoleAdmin
+	case contains(r.Maintainers, userName):
+		return RoleMaintainer
+	case contains(r.Users, userName) || group.IsMember(r.Groups, userName):
+		return RoleWrite
+	case contains(r.ReadOnlyUsers, userName) || group.IsMember(r.ReadOnlyGroups, userName):
+		return RoleRead
+	}
+	return ""
+}
+
+// HasRole returns whether the user has the given role in the repository, or
+// a more privileged one.
+func (r *Repository) HasRole(userName, role string) bool {
+	level := roleLevel(role)
+	return level > 0 && roleLevel(r.Role(userName)) >= level
+}
+
+// Members returns the users with access to the repository, from the most to
+// the least privileged. Users listed in more than one role are returned with
+// the most privileged one. Members of groups are not included.
+func (r *Repository) Members() []Member {
+	members := []Member{}
+	seen := make(map[string]bool)
+	for i := len(roles) - 1; i >= 0; i-- {
+		for _, u := range r.roleMembers(roles[i]) {
+			if !seen[u] {
+				seen[u] = true
+				members = append(members, Member{User: u, Role: roles[i]})
+			}
+		}
+	}
+	return members
+}
+
+func (r *Repository) roleMembers(role string) []string {
+	switch role {
+	case RoleAdmin:
+		return r.Admins
+	case RoleMaintainer:
+		return r.Maintainers
+	case RoleWrite:
+		return r.Users
+	}
+	return r.ReadOnlyUsers
+}
+
+// SetMember sets the role of the user in the repository, replacing any role
+// the user had before.
+func SetMember(name, userName, role string) error {
+	log.Debugf("Setting role of user %q in repository %q to %q", userName, name, role)
+	field, ok := roleFields[role]
+	if !ok {
+		return ErrInvalidRole
+	}
+	pull := bson.M{}
+	for _, f := range roleFields {
+		if f != field {
+			pull[f] = userName
+		}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Repository().UpdateId(name, bson.M{"$pull": pull, "$addToSet": bson.M{field: userName}})
+	if err == mgo.ErrNotFound {
+		return ErrRepositoryNotFound
+	}
+	return err
+}
+
+// RemoveMember removes the user from all the roles of the repository.
+func RemoveMember(name, userName string) error {
+	log.Debugf("Removing user %q from repository %q", userName, name)
+	pull := bson.M{}
+	var or []bson.M
+	for _, f := range roleFields {
+		pull[f] = userName
+		or = append(or, bson.M{f: userName})
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Repository().Update(bson.M{"_id": name, "$or": or}, bson.M{"$pull": pull})
+	if err == mgo.ErrNotFound {
+		if _, err = Get(name); err != nil {
+			return err
+		}
+		return ErrMemberNotFound
+	}
+	return err
+}
@@ -0,0 +1,125 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repository
+
+import (
+	"github.com/tsuru/gandalf/db"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestRole(c *check.C) {
+	r := Repository{
+		Name:          "myrepo",
+		Users:         []string{"bob"},
+		ReadOnlyUsers: []string{"alice", "dave"},
+		Maintainers:   []string{"carol"},
+		Admins:        []string{"dave"},
+	}
+	c.Check(r.Role("alice"), check.Equals, RoleRead)
+	c.Check(r.Role("bob"), check.Equals, RoleWrite)
+	c.Check(r.Role("carol"), check.Equals, RoleMaintainer)
+	c.Check(r.Role("dave"), check.Equals, RoleAdmin)
+	c.Check(r.Role("mallory"), check.Equals, "")
+	c.Check(r.Role(""), check.Equals, "")
+}
+
+func (s *S) TestHasRole(c *check.C) {
+	r := Repository{Name: "myrepo", Users: []string{"bob"}, Maintainers: []string{"carol"}, Admins: []string{"dave"}}
+	c.Check(r.HasRole("bob", RoleRead), check.Equals, true)
+	c.Check(r.HasRole("bob", RoleWrite), check.Equals, true)
+	c.Check(r.HasRole("bob", RoleMaintainer), check.Equals, false)
+	c.Check(r.HasRole("carol", RoleMaintainer), check.Equals, true)
+	c.Check(r.HasRole("carol", RoleAdmin), check.Equals, false)
+	c.Check(r.HasRole("dave", RoleAdmin), check.Equals, true)
+	c.Check(r.HasRole("dave", "owner"), check.Equals, false)
+	c.Check(r.HasRole("mallory", RoleRead), check.Equals, false)
+}
+
+func (s *S) TestWritePermissionOfMaintainersAndAdmins(c *check.C) {
+	r := Repository{Name: "myrepo", Maintainers: []string{"carol"}, Admins: []string{"dave"}}
+	c.Check(r.HasWritePermission("carol"), check.Equals, true)
+	c.Check(r.HasWritePermission("dave"), check.Equals, true)
+	c.Check(r.HasReadPermission("carol"), check.Equals, true)
+	c.Check(r.HasReadPermission("dave"), check.Equals, true)
+}
+
+func (s *S) TestMembers(c *check.C) {
+	r := Repository{
+		Name:          "myrepo",
+		Users:         []string{"bob", "carol"},
+		ReadOnlyUsers: []string{"alice"},
+		Maintainers:   []string{"carol"},
+		Admins:        []string{"dave"},
+	}
+	expected := []Member{
+		{User: "dave", Role: RoleAdmin},
+		{User: "carol", Role: RoleMaintainer},
+		{User: "bob", Role: RoleWrite},
+		{User: "alice", Role: RoleRead},
+	}
+	c.Assert(r.Members(), check.DeepEquals, expected)
+	c.Assert((&Repository{}).Members(), check.DeepEquals, []Member{})
+}
+
+func (s *S) TestIsValidWithAdminsOnly(c *check.C) {
+	r := Repository{Name: "myrepo", Admins: []string{"dave"}}
+	v, err := r.isValid()
+	c.Assert(err, check.IsNil)
+	c.Assert(v, check.Equals, true)
+}
+
+func (s *S) TestSetMember(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	r := Repository{Name: "myrepo", Users: []string{"bob", "carol"}, ReadOnlyUsers: []string{"alice"}}
+	err = conn.Repository().Insert(r)
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId(r.Name)
+	err = SetMember("myrepo", "carol", RoleMaintainer)
+	c.Assert(err, check.IsNil)
+	err = SetMember("myrepo", "alice", RoleAdmin)
+	c.Assert(err, check.IsNil)
+	err = SetMember("myrepo", "erin", RoleRead)
+	c.Assert(err, check.IsNil)
+	r, err = Get("myrepo")
+	c.Assert(err, check.IsNil)
+	c.Assert(r.Users, check.DeepEquals, []string{"bob"})
+	c.Assert(r.ReadOnlyUsers, check.DeepEquals, []string{"erin"})
+	c.Assert(r.Maintainers, check.DeepEquals, []string{"carol"})
+	c.Assert(r.Admins, check.DeepEquals, []string{"alice"})
+}
+
+func (s *S) TestSetMemberInvalidRole(c *check.C) {
+	err := SetMember("myrepo", "bob", "owner")
+	c.Assert(err, check.Equals, ErrInvalidRole)
+}
+
+func (s *S) TestSetMemberRepositoryNotFound(c *check.C) {
+	err := SetMember("mordor", "bob", RoleAdmin)
+	c.Assert(err, check.Equals, ErrRepositoryNotFound)
+}
+
+func (s *S) TestRemoveMember(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	r := Repository{Name: "myrepo", Users: []string{"bob", "carol"}, ReadOnlyUsers: []string{"carol"}, Admins: []string{"carol"}}
+	err = conn.Repository().Insert(r)
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId(r.Name)
+	err = RemoveMember("myrepo", "carol")
+	c.Assert(err, check.IsNil)
+	r, err = Get("myrepo")
+	c.Assert(err, check.IsNil)
+	c.Assert(r.Members(), check.DeepEquals, []Member{{User: "bob", Role: RoleWrite}})
+	err = RemoveMember("myrepo", "carol")
+	c.Assert(err, check.Equals, ErrMemberNotFound)
+}
+
+func (s *S) TestRemoveMemberRepositoryNotFound(c *check.C) {
+	err := RemoveMember("mordor", "bob")
+	c.Assert(err, check.Equals, ErrRepositoryNotFound)
+}
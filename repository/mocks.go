@@ -5,7 +5,9 @@
 package repository
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"mime/multipart"
 	"os"
@@ -17,7 +19,9 @@ import (
 type MockContentRetriever struct {
 	LastFormat     ArchiveFormat
 	LastRef        string
+	LastRefs       []string
 	LastPath       string
+	LastUser       string
 	ResultContents []byte
 	Tree           []map[string]string
 	Ref            Ref
@@ -401,13 +405,14 @@ func (r *MockContentRetriever) Push(cloneDir, branch string) error {
 	return nil
 }
 
-func (r *MockContentRetriever) CommitZip(repo string, z *multipart.FileHeader, c GitCommit) (*Ref, error) {
+func (r *MockContentRetriever) CommitZip(repo, userName string, z *multipart.FileHeader, c GitCommit) (*Ref, error) {
 	if r.LookPathError != nil {
 		return nil, r.LookPathError
 	}
 	if r.OutputError != nil {
 		return nil, r.OutputError
 	}
+	r.LastUser = userName
 	return &r.Ref, nil
 }
 
@@ -420,3 +425,30 @@ func (r *MockContentRetriever) GetLogs(repo, hash string, total int, path string
 	}
 	return &r.History, nil
 }
+
+func (r *MockContentRetriever) GetBundle(repo string, refs []string) (io.ReadCloser, error) {
+	if r.LookPathError != nil {
+		return nil, r.LookPathError
+	}
+	if r.OutputError != nil {
+		return nil, r.OutputError
+	}
+	r.LastRefs = refs
+	return ioutil.NopCloser(bytes.NewReader(r.ResultContents)), nil
+}
+
+func (r *MockContentRetriever) RestoreBundle(repo, userName string, bundle io.Reader) ([]RefUpdate, error) {
+	if r.LookPathError != nil {
+		return nil, r.LookPathError
+	}
+	if r.OutputError != nil {
+		return nil, r.OutputError
+	}
+	r.LastUser = userName
+	r.ResultContents, _ = ioutil.ReadAll(bundle)
+	var updates []RefUpdate
+	for _, ref := range r.Refs {
+		updates = append(updates, RefUpdate{Ref: ref.Name, Before: nullRev, After: ref.Ref})
+	}
+	return updates, nil
+}
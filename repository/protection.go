@@ -0,0 +1,134 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/tsuru/log"
+)
+
+const nullRev = "0000000000000000000000000000000000000000"
+
+var (
+	ErrProtectionAlreadyExists = errors.New("protection already exists")
+	ErrProtectionNotFound      = errors.New("protection not found")
+)
+
+// Protection is a rule protecting the branches matching Pattern, which uses
+// the syntax of path.Match (for example, "master" or "release/*"). Protected
+// branches can not be deleted nor force-pushed. When Users is not empty, only
+// the listed users may push to the branches.
+type Protection struct {
+	Pattern string   `json:"pattern"`
+	Users   []string `json:"users"`
+}
+
+func (p *Protection) isValid() (bool, error) {
+	if p.Pattern == "" || strings.HasPrefix(p.Pattern, "refs/") {
+		return false, &InvalidRepositoryError{message: "protection pattern is not valid"}
+	}
+	if _, err := path.Match(p.Pattern, ""); err != nil {
+		return false, &InvalidRepositoryError{message: "protection pattern is not valid"}
+	}
+	return true, nil
+}
+
+// Matches returns whether the given ref is protected by the rule. Only
+// branches (refs under refs/heads) may be protected.
+func (p *Protection) Matches(ref string) bool {
+	if !strings.HasPrefix(ref, "refs/heads/") {
+		return false
+	}
+	matched, _ := path.Match(p.Pattern, strings.TrimPrefix(ref, "refs/heads/"))
+	return matched
+}
+
+// AddProtection adds the given protection rule to the repository.
+func AddProtection(name string, p Protection) error {
+	log.Debugf("Protecting %q in repository %q", p.Pattern, name)
+	if v, err := p.isValid(); !v {
+		log.Errorf("repository.AddProtection: Invalid protection %q: %s", p.Pattern, err)
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	selector := bson.M{"_id": name, "protections.pattern": bson.M{"$ne": p.Pattern}}
+	err = conn.Repository().Update(selector, bson.M{"$push": bson.M{"protections": p}})
+	if err == mgo.ErrNotFound {
+		if _, err = Get(name); err != nil {
+			return err
+		}
+		return ErrProtectionAlreadyExists
+	}
+	return err
+}
+
+// RemoveProtection removes the protection rule with the given pattern from
+// the repository.
+func RemoveProtection(name, pattern string) error {
+	log.Debugf("Removing protection of %q from repository %q", pattern, name)
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	selector := bson.M{"_id": name, "protections.pattern": pattern}
+	err = conn.Repository().Update(selector, bson.M{"$pull": bson.M{"protections": bson.M{"pattern": pattern}}})
+	if err == mgo.ErrNotFound {
+		if _, err = Get(name); err != nil {
+			return err
+		}
+		return ErrProtectionNotFound
+	}
+	return err
+}
+
+// CheckRefUpdate checks whether the given user may update ref from oldRev to
+// newRev, according to the protection rules of the repository. Maintainers
+// and admins of the repository may push to protected branches restricted to
+// other users. It returns nil when the update is allowed.
+func (r *Repository) CheckRefUpdate(userName, ref, oldRev, newRev string) error {
+	for _, p := range r.Protections {
+		if !p.Matches(ref) {
+			continue
+		}
+		if len(p.Users) > 0 && !contains(p.Users, userName) && !r.HasRole(userName, RoleMaintainer) {
+			return fmt.Errorf("%s is protected, user %q is not allowed to push to it", ref, userName)
+		}
+		if newRev == nullRev {
+			return fmt.Errorf("%s is protected and can not be deleted", ref)
+		}
+		if oldRev != nullRev && !isAncestor(r.Name, oldRev, newRev) {
+			return fmt.Errorf("%s is protected and can not be force-pushed", ref)
+		}
+	}
+	return nil
+}
+
+func isAncestor(repo, ancestor, rev string) bool {
+	cmd := exec.Command("git", "merge-base", "--is-ancestor", ancestor, rev)
+	cmd.Dir = barePath(repo)
+	return cmd.Run() == nil
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
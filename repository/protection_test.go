@@ -0,0 +1,138 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repository
+
+import (
+	"github.com/tsuru/gandalf/db"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestProtectionMatches(c *check.C) {
+	p := Protection{Pattern: "release/*"}
+	c.Assert(p.Matches("refs/heads/release/1.0"), check.Equals, true)
+	c.Assert(p.Matches("refs/heads/release"), check.Equals, false)
+	c.Assert(p.Matches("refs/heads/master"), check.Equals, false)
+	c.Assert(p.Matches("refs/tags/release/1.0"), check.Equals, false)
+}
+
+func (s *S) TestAddProtection(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&Repository{Name: "protected"})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("protected")
+	err = AddProtection("protected", Protection{Pattern: "master", Users: []string{"bob"}})
+	c.Assert(err, check.IsNil)
+	repo, err := Get("protected")
+	c.Assert(err, check.IsNil)
+	c.Assert(repo.Protections, check.DeepEquals, []Protection{{Pattern: "master", Users: []string{"bob"}}})
+}
+
+func (s *S) TestAddProtectionDuplicate(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&Repository{Name: "protected", Protections: []Protection{{Pattern: "master"}}})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("protected")
+	err = AddProtection("protected", Protection{Pattern: "master"})
+	c.Assert(err, check.Equals, ErrProtectionAlreadyExists)
+}
+
+func (s *S) TestAddProtectionRepositoryNotFound(c *check.C) {
+	err := AddProtection("ghost", Protection{Pattern: "master"})
+	c.Assert(err, check.Equals, ErrRepositoryNotFound)
+}
+
+func (s *S) TestAddProtectionInvalidPattern(c *check.C) {
+	for _, pattern := range []string{"", "refs/heads/master", "release/["} {
+		err := AddProtection("protected", Protection{Pattern: pattern})
+		c.Check(err, check.ErrorMatches, "protection pattern is not valid")
+	}
+}
+
+func (s *S) TestRemoveProtection(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	protections := []Protection{{Pattern: "master"}, {Pattern: "release/*"}}
+	err = conn.Repository().Insert(&Repository{Name: "protected", Protections: protections})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("protected")
+	err = RemoveProtection("protected", "master")
+	c.Assert(err, check.IsNil)
+	repo, err := Get("protected")
+	c.Assert(err, check.IsNil)
+	c.Assert(repo.Protections, check.DeepEquals, []Protection{{Pattern: "release/*"}})
+	err = RemoveProtection("protected", "master")
+	c.Assert(err, check.Equals, ErrProtectionNotFound)
+}
+
+func (s *S) TestRemoveProtectionRepositoryNotFound(c *check.C) {
+	err := RemoveProtection("ghost", "master")
+	c.Assert(err, check.Equals, ErrRepositoryNotFound)
+}
+
+func (s *S) TestCheckRefUpdateUnprotected(c *check.C) {
+	r := Repository{Name: "myrepo", Protections: []Protection{{Pattern: "master"}}}
+	err := r.CheckRefUpdate("bob", "refs/heads/feature", "1f2e3d4c5b6a79881f2e3d4c5b6a79881f2e3d4c", nullRev)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestCheckRefUpdateDeletion(c *check.C) {
+	r := Repository{Name: "myrepo", Protections: []Protection{{Pattern: "master"}}}
+	err := r.CheckRefUpdate("bob", "refs/heads/master", "1f2e3d4c5b6a79881f2e3d4c5b6a79881f2e3d4c", nullRev)
+	c.Assert(err, check.ErrorMatches, "refs/heads/master is protected and can not be deleted")
+}
+
+func (s *S) TestCheckRefUpdateRestrictedUsers(c *check.C) {
+	r := Repository{Name: "myrepo", Protections: []Protection{{Pattern: "master", Users: []string{"alice"}}}}
+	err := r.CheckRefUpdate("bob", "refs/heads/master", nullRev, "1f2e3d4c5b6a79881f2e3d4c5b6a79881f2e3d4c")
+	c.Assert(err, check.ErrorMatches, `refs/heads/master is protected, user "bob" is not allowed to push to it`)
+	err = r.CheckRefUpdate("alice", "refs/heads/master", nullRev, "1f2e3d4c5b6a79881f2e3d4c5b6a79881f2e3d4c")
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestCheckRefUpdateRestrictedUsersMaintainer(c *check.C) {
+	r := Repository{
+		Name:        "myrepo",
+		Users:       []string{"bob"},
+		Maintainers: []string{"carol"},
+		Admins:      []string{"dave"},
+		Protections: []Protection{{Pattern: "master", Users: []string{"alice"}}},
+	}
+	for _, user := range []string{"carol", "dave"} {
+		err := r.CheckRefUpdate(user, "refs/heads/master", nullRev, "1f2e3d4c5b6a79881f2e3d4c5b6a79881f2e3d4c")
+		c.Check(err, check.IsNil)
+		err = r.CheckRefUpdate(user, "refs/heads/master", "1f2e3d4c5b6a79881f2e3d4c5b6a79881f2e3d4c", nullRev)
+		c.Check(err, check.ErrorMatches, "refs/heads/master is protected and can not be deleted")
+	}
+	err := r.CheckRefUpdate("bob", "refs/heads/master", nullRev, "1f2e3d4c5b6a79881f2e3d4c5b6a79881f2e3d4c")
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestCheckRefUpdateForcePushIntegration(c *check.C) {
+	oldBare := bare
+	bare = "/tmp"
+	repo := "gandalf-test-repo"
+	cleanUp, errCreate := CreateTestRepository(bare, repo, "README", "much WOW")
+	defer func() {
+		cleanUp()
+		bare = oldBare
+	}()
+	c.Assert(errCreate, check.IsNil)
+	first, err := GetLastHashCommit(bare, repo)
+	c.Assert(err, check.IsNil)
+	err = CreateCommit(bare, repo, "README", "so WOW")
+	c.Assert(err, check.IsNil)
+	second, err := GetLastHashCommit(bare, repo)
+	c.Assert(err, check.IsNil)
+	r := Repository{Name: repo, Protections: []Protection{{Pattern: "master"}}}
+	err = r.CheckRefUpdate("bob", "refs/heads/master", string(first), string(second))
+	c.Assert(err, check.IsNil)
+	err = r.CheckRefUpdate("bob", "refs/heads/master", string(second), string(first))
+	c.Assert(err, check.ErrorMatches, "refs/heads/master is protected and can not be force-pushed")
+}
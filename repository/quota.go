@@ -0,0 +1,272 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repository
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/tsuru/log"
+)
+
+var sizeUnits = map[string]int64{
+	"":  1,
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+	"T": 1 << 40,
+}
+
+// QuotaExceededError is returned when a repository uses, or would use, more
+// space than allowed by its quota or by the total quota of all repositories.
+type QuotaExceededError struct {
+	message string
+}
+
+func (err *QuotaExceededError) Error() string {
+	return err.message
+}
+
+// ParseSize parses a size in bytes, optionally followed by one of the K, M, G
+// or T units (for example, "512M"). Units are powers of 1024.
+func ParseSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	number, unit := value, ""
+	if n := len(value); n > 0 && (value[n-1] < '0' || value[n-1] > '9') {
+		number, unit = value[:n-1], value[n-1:]
+	}
+	multiplier, ok := sizeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return size * multiplier, nil
+}
+
+// FormatSize formats a size in bytes for humans, for example "1.5 MiB".
+func FormatSize(size int64) string {
+	units := []string{"KiB", "MiB", "GiB", "TiB"}
+	if size < 1024 {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size) / 1024
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+// sizeSetting returns the size defined by the given setting, which may be a
+// number of bytes or a string parsed by ParseSize. It returns 0 when the
+// setting is not defined or is not valid.
+func sizeSetting(key string) int64 {
+	value, err := config.Get(key)
+	if err != nil {
+		return 0
+	}
+	var size int64
+	switch v := value.(type) {
+	case int:
+		size = int64(v)
+	case int64:
+		size = v
+	case string:
+		size, err = ParseSize(v)
+	default:
+		err = fmt.Errorf("invalid size %v", v)
+	}
+	if err != nil || size < 0 {
+		log.Errorf("Ignoring the %q setting: %s", key, err)
+		return 0
+	}
+	return size
+}
+
+// MaxPushSize returns the maximum size of the pack sent in a single push,
+// defined by the "quota:max-push-size" setting. Zero means unlimited.
+func MaxPushSize() int64 {
+	return sizeSetting("quota:max-push-size")
+}
+
+// TotalQuota returns the maximum disk usage of all repositories together,
+// defined by the "quota:total" setting. Zero means unlimited.
+func TotalQuota() int64 {
+	return sizeSetting("quota:total")
+}
+
+// EffectiveQuota returns the maximum disk usage of the repository: its own
+// quota or, when it's not defined, the "quota:repository" setting. Zero means
+// unlimited.
+func (r *Repository) EffectiveQuota() int64 {
+	if r.Quota > 0 {
+		return r.Quota
+	}
+	return sizeSetting("quota:repository")
+}
+
+// SetQuota changes the quota of the repository, in bytes. Zero removes the
+// quota of the repository, which becomes limited by the "quota:repository"
+// setting.
+func SetQuota(name string, quota int64) error {
+	log.Debugf("Setting the quota of repository %q to %d bytes", name, quota)
+	if quota < 0 {
+		return &InvalidRepositoryError{message: "quota must not be negative"}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Repository().UpdateId(name, bson.M{"$set": bson.M{"quota": quota}})
+	if err == mgo.ErrNotFound {
+		return ErrRepositoryNotFound
+	}
+	return err
+}
+
+// DiskUsage returns the space used by the bare repository with the given
+// name, in bytes, computed from the size of its files.
+func DiskUsage(name string) (int64, error) {
+	root := barePath(name)
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return 0, ErrRepositoryNotFound
+	}
+	var usage int64
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// git may remove temporary files while the repository is walked.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			usage += info.Size()
+		}
+		return nil
+	})
+	return usage, err
+}
+
+// UpdateDiskUsage computes the space used by the bare repository with the
+// given name and records it in the database, returning it.
+func UpdateDiskUsage(name string) (int64, error) {
+	usage, err := DiskUsage(name)
+	if err != nil {
+		return 0, err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	err = conn.Repository().UpdateId(name, bson.M{"$set": bson.M{"diskusage": usage}})
+	if err == mgo.ErrNotFound {
+		return 0, ErrRepositoryNotFound
+	}
+	return usage, err
+}
+
+// RefreshDiskUsage updates the disk usage recorded for all repositories.
+func RefreshDiskUsage() error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	var all []Repository
+	err = conn.Repository().Find(nil).Select(bson.M{"_id": 1}).All(&all)
+	conn.Close()
+	if err != nil {
+		return err
+	}
+	for _, r := range all {
+		if _, err = UpdateDiskUsage(r.Name); err != nil && err != ErrRepositoryNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// TotalDiskUsage returns the sum of the disk usage recorded for all
+// repositories.
+func TotalDiskUsage() (int64, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	var result struct {
+		Total int64
+	}
+	pipeline := []bson.M{{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": "$diskusage"}}}}
+	err = conn.Repository().Pipe(pipeline).One(&result)
+	if err == mgo.ErrNotFound {
+		return 0, nil
+	}
+	return result.Total, err
+}
+
+// Largest returns the repositories using more disk space, according to the
+// usage recorded for them, sorted by their usage. At most limit repositories
+// are returned.
+func Largest(limit int) ([]Repository, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	repos := []Repository{}
+	err = conn.Repository().Find(nil).Sort("-diskusage", "_id").Limit(limit).All(&repos)
+	return repos, err
+}
+
+// CheckQuota checks whether the repository with the given name fits in its
+// quota and in the total quota of all repositories. The usage of the
+// repository is computed from its files, so during a push it includes the
+// received objects, and the recorded usage of the other repositories is used
+// for the total quota.
+func CheckQuota(name string) error {
+	repo, err := Get(name)
+	if err != nil {
+		return err
+	}
+	quota, total := repo.EffectiveQuota(), TotalQuota()
+	if quota == 0 && total == 0 {
+		return nil
+	}
+	usage, err := DiskUsage(name)
+	if err != nil {
+		return err
+	}
+	if quota > 0 && usage > quota {
+		return &QuotaExceededError{
+			message: fmt.Sprintf("repository %q would use %s, exceeding its quota of %s", name, FormatSize(usage), FormatSize(quota)),
+		}
+	}
+	if total > 0 {
+		others, err := TotalDiskUsage()
+		if err != nil {
+			return err
+		}
+		if others-repo.DiskUsage+usage > total {
+			return &QuotaExceededError{
+				message: fmt.Sprintf("repositories would use %s, exceeding the total quota of %s", FormatSize(others-repo.DiskUsage+usage), FormatSize(total)),
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repository
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
+	"gopkg.in/check.v1"
+)
+
+// createBareFiles creates a fake bare repository with files of the given
+// sizes.
+func createBareFiles(c *check.C, name string, sizes ...int) {
+	dir := path.Join(barePath(name), "objects", "pack")
+	err := os.MkdirAll(dir, 0755)
+	c.Assert(err, check.IsNil)
+	for i, size := range sizes {
+		err = ioutil.WriteFile(path.Join(dir, string(rune('a'+i))), make([]byte, size), 0644)
+		c.Assert(err, check.IsNil)
+	}
+}
+
+func (s *S) TestParseSize(c *check.C) {
+	tests := map[string]int64{
+		"0":     0,
+		"100":   100,
+		"2K":    2048,
+		"512m":  512 << 20,
+		"10G":   10 << 30,
+		" 1T ":  1 << 40,
+		"1024 ": 1024,
+	}
+	for value, expected := range tests {
+		size, err := ParseSize(value)
+		c.Check(err, check.IsNil)
+		c.Check(size, check.Equals, expected)
+	}
+}
+
+func (s *S) TestParseSizeInvalid(c *check.C) {
+	for _, value := range []string{"", "G", "10X", "-1", "1.5G", "ten"} {
+		_, err := ParseSize(value)
+		c.Check(err, check.ErrorMatches, "invalid size .*")
+	}
+}
+
+func (s *S) TestFormatSize(c *check.C) {
+	c.Assert(FormatSize(100), check.Equals, "100 B")
+	c.Assert(FormatSize(1536), check.Equals, "1.5 KiB")
+	c.Assert(FormatSize(10<<20), check.Equals, "10.0 MiB")
+	c.Assert(FormatSize(3<<40), check.Equals, "3.0 TiB")
+}
+
+func (s *S) TestSizeSetting(c *check.C) {
+	defer config.Unset("quota:repository")
+	config.Set("quota:repository", 4096)
+	c.Assert(sizeSetting("quota:repository"), check.Equals, int64(4096))
+	config.Set("quota:repository", "1G")
+	c.Assert(sizeSetting("quota:repository"), check.Equals, int64(1<<30))
+	config.Set("quota:repository", "lots")
+	c.Assert(sizeSetting("quota:repository"), check.Equals, int64(0))
+	config.Unset("quota:repository")
+	c.Assert(sizeSetting("quota:repository"), check.Equals, int64(0))
+}
+
+func (s *S) TestEffectiveQuota(c *check.C) {
+	config.Set("quota:repository", "1M")
+	defer config.Unset("quota:repository")
+	repo := Repository{Name: "big"}
+	c.Assert(repo.EffectiveQuota(), check.Equals, int64(1<<20))
+	repo.Quota = 2048
+	c.Assert(repo.EffectiveQuota(), check.Equals, int64(2048))
+}
+
+func (s *S) TestMarshalJSONWithQuota(c *check.C) {
+	repo := Repository{Name: "somerepo", Quota: 2048, DiskUsage: 1024}
+	data, err := json.Marshal(&repo)
+	c.Assert(err, check.IsNil)
+	var result map[string]interface{}
+	err = json.Unmarshal(data, &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result["quota"], check.Equals, float64(2048))
+	c.Assert(result["disk_usage"], check.Equals, float64(1024))
+}
+
+func (s *S) TestDiskUsage(c *check.C) {
+	oldBare := bare
+	bare = c.MkDir()
+	defer func() { bare = oldBare }()
+	createBareFiles(c, "big", 100, 200, 300)
+	usage, err := DiskUsage("big")
+	c.Assert(err, check.IsNil)
+	c.Assert(usage, check.Equals, int64(600))
+}
+
+func (s *S) TestDiskUsageNotFound(c *check.C) {
+	oldBare := bare
+	bare = c.MkDir()
+	defer func() { bare = oldBare }()
+	_, err := DiskUsage("ghost")
+	c.Assert(err, check.Equals, ErrRepositoryNotFound)
+}
+
+func (s *S) TestSetQuota(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&Repository{Name: "big"})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("big")
+	err = SetQuota("big", 1<<20)
+	c.Assert(err, check.IsNil)
+	repo, err := Get("big")
+	c.Assert(err, check.IsNil)
+	c.Assert(repo.Quota, check.Equals, int64(1<<20))
+}
+
+func (s *S) TestSetQuotaNegative(c *check.C) {
+	err := SetQuota("big", -1)
+	c.Assert(err, check.ErrorMatches, "quota must not be negative")
+}
+
+func (s *S) TestSetQuotaRepositoryNotFound(c *check.C) {
+	err := SetQuota("ghost", 1024)
+	c.Assert(err, check.Equals, ErrRepositoryNotFound)
+}
+
+func (s *S) TestUpdateDiskUsage(c *check.C) {
+	oldBare := bare
+	bare = c.MkDir()
+	defer func() { bare = oldBare }()
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&Repository{Name: "big"})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("big")
+	createBareFiles(c, "big", 100, 200)
+	usage, err := UpdateDiskUsage("big")
+	c.Assert(err, check.IsNil)
+	c.Assert(usage, check.Equals, int64(300))
+	repo, err := Get("big")
+	c.Assert(err, check.IsNil)
+	c.Assert(repo.DiskUsage, check.Equals, int64(300))
+}
+
+func (s *S) TestTotalDiskUsageAndLargest(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	for name, usage := range map[string]int64{"small": 10, "big": 1000, "medium": 100} {
+		err = conn.Repository().Insert(&Repository{Name: name, DiskUsage: usage})
+		c.Assert(err, check.IsNil)
+		defer conn.Repository().RemoveId(name)
+	}
+	total, err := TotalDiskUsage()
+	c.Assert(err, check.IsNil)
+	c.Assert(total, check.Equals, int64(1110))
+	repos, err := Largest(2)
+	c.Assert(err, check.IsNil)
+	c.Assert(repos, check.HasLen, 2)
+	c.Assert(repos[0].Name, check.Equals, "big")
+	c.Assert(repos[1].Name, check.Equals, "medium")
+}
+
+func (s *S) TestCheckQuota(c *check.C) {
+	oldBare := bare
+	bare = c.MkDir()
+	defer func() { bare = oldBare }()
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&Repository{Name: "big", Quota: 1024})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("big")
+	createBareFiles(c, "big", 1000)
+	c.Assert(CheckQuota("big"), check.IsNil)
+	createBareFiles(c, "big", 1000, 100)
+	err = CheckQuota("big")
+	c.Assert(err, check.FitsTypeOf, &QuotaExceededError{})
+	c.Assert(err, check.ErrorMatches, `repository "big" would use 1.1 KiB, exceeding its quota of 1.0 KiB`)
+}
+
+func (s *S) TestCheckQuotaDefault(c *check.C) {
+	oldBare := bare
+	bare = c.MkDir()
+	defer func() { bare = oldBare }()
+	config.Set("quota:repository", 500)
+	defer config.Unset("quota:repository")
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&Repository{Name: "big"})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("big")
+	createBareFiles(c, "big", 1000)
+	c.Assert(CheckQuota("big"), check.FitsTypeOf, &QuotaExceededError{})
+}
+
+func (s *S) TestCheckQuotaTotal(c *check.C) {
+	oldBare := bare
+	bare = c.MkDir()
+	defer func() { bare = oldBare }()
+	config.Set("quota:total", 2000)
+	defer config.Unset("quota:total")
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&Repository{Name: "other", DiskUsage: 1500})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("other")
+	err = conn.Repository().Insert(&Repository{Name: "big", DiskUsage: 100})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("big")
+	createBareFiles(c, "big", 400)
+	c.Assert(CheckQuota("big"), check.IsNil)
+	createBareFiles(c, "big", 400, 200)
+	err = CheckQuota("big")
+	c.Assert(err, check.FitsTypeOf, &QuotaExceededError{})
+	c.Assert(err, check.ErrorMatches, "repositories would use 2.1 KiB, exceeding the total quota of 2.0 KiB")
+}
+
+func (s *S) TestCheckQuotaWithoutQuotas(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&Repository{Name: "big"})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("big")
+	c.Assert(CheckQuota("big"), check.IsNil)
+}
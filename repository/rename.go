@@ -0,0 +1,231 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repository
+
+import (
+	"path"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/gandalf/fs"
+	"github.com/tsuru/gandalf/lfs"
+	"github.com/tsuru/tsuru/db/storage"
+	"github.com/tsuru/tsuru/log"
+)
+
+// defaultRedirectTTL is how long the former names of renamed repositories are
+// kept when the "redirect:ttl" setting is not defined.
+const defaultRedirectTTL = 30 * 24 * time.Hour
+
+// Redirect is a former name of a renamed repository. Until it expires, git
+// clients and API requests using the former name reach the repository, see
+// Resolve.
+type Redirect struct {
+	Name       string    `bson:"_id" json:"name"`
+	Repository string    `json:"repository"`
+	Created    time.Time `json:"created"`
+	Expires    time.Time `json:"expires"`
+}
+
+func redirectTTL() time.Duration {
+	if d, err := config.GetDuration("redirect:ttl"); err == nil && d > 0 {
+		return d
+	}
+	return defaultRedirectTTL
+}
+
+// Resolve returns the repository with the given name or, when there's none,
+// the repository formerly known by this name, as long as its redirect didn't
+// expire.
+func Resolve(name string) (Repository, error) {
+	repo, err := Get(name)
+	if err != ErrRepositoryNotFound {
+		return repo, err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return repo, err
+	}
+	defer conn.Close()
+	var redirect Redirect
+	query := bson.M{"_id": name, "expires": bson.M{"$gt": time.Now().UTC()}}
+	if err = conn.Redirect().Find(query).One(&redirect); err != nil {
+		if err == mgo.ErrNotFound {
+			return repo, ErrRepositoryNotFound
+		}
+		return repo, err
+	}
+	log.Debugf("Resolving repository %q to %q", name, redirect.Repository)
+	return Get(redirect.Repository)
+}
+
+// rename renames the repository, moving its bare repository, its LFS objects
+// and the records referring to it, and keeps the former name as a redirect.
+// When a step fails, the previous ones are undone, so the repository is left
+// as it was.
+func rename(conn *db.Storage, oldName string, newData Repository) error {
+	newName := newData.Name
+	log.Debugf("Renaming repository %q to %q", oldName, newName)
+	if !ValidName(newName) {
+		return &InvalidRepositoryError{message: "repository name is not valid"}
+	}
+	if err := checkMaintenance(conn, oldName); err != nil {
+		return err
+	}
+	var undo undoer
+	if err := conn.Repository().Insert(newData); err != nil {
+		log.Errorf("repository.Rename: Error adding new repository %q: %s", newName, err)
+		return err
+	}
+	undo.add(func() error { return conn.Repository().RemoveId(newName) })
+	if err := fs.Filesystem().MkdirAll(path.Dir(barePath(newName)), 0755); err != nil {
+		log.Errorf("repository.Rename: Error creating namespace of repository %q: %s", newName, err)
+		return undo.rollback("Rename", err)
+	}
+	if err := fs.Filesystem().Rename(barePath(oldName), barePath(newName)); err != nil {
+		log.Errorf("repository.Rename: Error renaming old repository in filesystem %q: %s", oldName, err)
+		return undo.rollback("Rename", err)
+	}
+	undo.add(func() error { return fs.Filesystem().Rename(barePath(newName), barePath(oldName)) })
+	if err := lfs.RenameRepository(oldName, newName); err != nil {
+		log.Errorf("repository.Rename: Error renaming LFS objects of repository %q: %s", oldName, err)
+		return undo.rollback("Rename", err)
+	}
+	undo.add(func() error { return lfs.RenameRepository(newName, oldName) })
+	if err := moveRecords(conn, oldName, newName); err != nil {
+		log.Errorf("repository.Rename: Error moving records of repository %q: %s", oldName, err)
+		return undo.rollback("Rename", err)
+	}
+	undo.add(func() error { return moveRecords(conn, newName, oldName) })
+	change, err := addRedirect(conn, oldName, newName)
+	if err != nil {
+		log.Errorf("repository.Rename: Error adding redirect from %q to %q: %s", oldName, newName, err)
+		return undo.rollback("Rename", err)
+	}
+	undo.add(func() error { return removeRedirect(conn, change) })
+	if err := conn.Repository().RemoveId(oldName); err != nil {
+		log.Errorf("repository.Rename: Error removing old repository %q: %s", oldName, err)
+		return undo.rollback("Rename", err)
+	}
+	return nil
+}
+
+// undoer keeps the functions undoing the steps of an operation, which run in
+// reverse order when a later step fails.
+type undoer []func() error
+
+func (u *undoer) add(f func() error) {
+	*u = append(*u, f)
+}
+
+// rollback undoes the steps done so far, logging the errors, and returns the
+// error of the failing step.
+func (u undoer) rollback(operation string, err error) error {
+	for i := len(u) - 1; i >= 0; i-- {
+		if uerr := u[i](); uerr != nil {
+			log.Errorf("repository.%s: Error undoing changes: %s", operation, uerr)
+		}
+	}
+	return err
+}
+
+// checkMaintenance fails when a maintenance of the repository is running, as
+// its bare repository can not be moved in the meantime.
+func checkMaintenance(conn *db.Storage, name string) error {
+	running := bson.M{"_id": name, "status": MaintenanceRunning, "expires": bson.M{"$gt": time.Now().UTC()}}
+	n, err := conn.Maintenance().Find(running).Count()
+	if err == nil && n > 0 {
+		err = ErrMaintenanceInProgress
+	}
+	return err
+}
+
+// moveRecords moves the records of the repository kept in other collections,
+// such as its last maintenance run, its hooks and its webhooks, from one name
+// to another, and points its forks to the new name.
+func moveRecords(conn *db.Storage, from, to string) error {
+	for _, c := range []*storage.Collection{conn.Maintenance(), conn.ImportJob()} {
+		var record bson.M
+		err := c.FindId(from).One(&record)
+		if err == mgo.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		record["_id"] = to
+		if err = c.Insert(record); err != nil {
+			return err
+		}
+		if err = c.RemoveId(from); err != nil {
+			return err
+		}
+	}
+	for _, c := range []*storage.Collection{conn.Hook(), conn.Webhook()} {
+		if _, err := c.UpdateAll(bson.M{"repository": from}, bson.M{"$set": bson.M{"repository": to}}); err != nil {
+			return err
+		}
+	}
+	_, err := conn.Repository().UpdateAll(bson.M{"parent": from}, bson.M{"$set": bson.M{"parent": to}})
+	return err
+}
+
+// redirectChange records the changes made by addRedirect, so they can be
+// undone by removeRedirect.
+type redirectChange struct {
+	redirect Redirect
+	// previous is the redirect of the former name replaced by addRedirect,
+	// if any.
+	previous *Redirect
+	// moved are the names of the redirects pointed to the new name.
+	moved []string
+}
+
+// addRedirect keeps the former name of a renamed repository, and points the
+// redirects of the former name to the new one.
+func addRedirect(conn *db.Storage, oldName, newName string) (redirectChange, error) {
+	now := time.Now().UTC()
+	change := redirectChange{redirect: Redirect{Name: oldName, Repository: newName, Created: now, Expires: now.Add(redirectTTL())}}
+	var previous Redirect
+	err := conn.Redirect().FindId(oldName).One(&previous)
+	if err == nil {
+		change.previous = &previous
+	} else if err != mgo.ErrNotFound {
+		return change, err
+	}
+	var redirects []Redirect
+	if err = conn.Redirect().Find(bson.M{"repository": oldName}).Select(bson.M{"_id": 1}).All(&redirects); err != nil {
+		return change, err
+	}
+	change.moved = make([]string, len(redirects))
+	for i, r := range redirects {
+		change.moved[i] = r.Name
+	}
+	if _, err = conn.Redirect().UpsertId(oldName, &change.redirect); err != nil {
+		return change, err
+	}
+	_, err = conn.Redirect().UpdateAll(bson.M{"_id": bson.M{"$in": change.moved}, "repository": oldName}, bson.M{"$set": bson.M{"repository": newName}})
+	return change, err
+}
+
+// removeRedirect undoes the changes made by addRedirect, leaving alone the
+// redirects it did not change.
+func removeRedirect(conn *db.Storage, change redirectChange) error {
+	oldName, newName := change.redirect.Name, change.redirect.Repository
+	var err error
+	if change.previous != nil {
+		_, err = conn.Redirect().UpsertId(oldName, change.previous)
+	} else {
+		err = conn.Redirect().RemoveId(oldName)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = conn.Redirect().UpdateAll(bson.M{"_id": bson.M{"$in": change.moved}, "repository": newName}, bson.M{"$set": bson.M{"repository": oldName}})
+	return err
+}
@@ -0,0 +1,227 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repository
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestRedirectTTL(c *check.C) {
+	c.Assert(redirectTTL(), check.Equals, 30*24*time.Hour)
+	config.Set("redirect:ttl", "48h")
+	defer config.Unset("redirect:ttl")
+	c.Assert(redirectTTL(), check.Equals, 48*time.Hour)
+}
+
+func (s *S) TestRenameKeepsRedirect(c *check.C) {
+	defer setUpImportBare(c)()
+	_, err := New("the-shire", []string{"bilbo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	err = Update("the-shire", Repository{Name: "hobbits/shire", Users: []string{"bilbo"}})
+	c.Assert(err, check.IsNil)
+	defer Remove("hobbits/shire")
+	_, err = os.Stat(path.Join(bare, "hobbits", "shire.git", "HEAD"))
+	c.Assert(err, check.IsNil)
+	_, err = Get("the-shire")
+	c.Assert(err, check.Equals, ErrRepositoryNotFound)
+	repo, err := Resolve("the-shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(repo.Name, check.Equals, "hobbits/shire")
+	repo, err = Resolve("hobbits/shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(repo.Name, check.Equals, "hobbits/shire")
+}
+
+func (s *S) TestRenameTwicePointsRedirectsToNewName(c *check.C) {
+	defer setUpImportBare(c)()
+	_, err := New("the-shire", []string{"bilbo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	err = Update("the-shire", Repository{Name: "shire", Users: []string{"bilbo"}})
+	c.Assert(err, check.IsNil)
+	err = Update("shire", Repository{Name: "bag-end", Users: []string{"bilbo"}})
+	c.Assert(err, check.IsNil)
+	defer Remove("bag-end")
+	for _, name := range []string{"the-shire", "shire"} {
+		repo, err := Resolve(name)
+		c.Check(err, check.IsNil)
+		c.Check(repo.Name, check.Equals, "bag-end")
+	}
+}
+
+func (s *S) TestRemoveRedirectUndoesOnlyItsChanges(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Redirect().RemoveAll(nil)
+	expires := time.Now().UTC().Add(time.Hour)
+	for _, r := range []Redirect{
+		{Name: "shire", Repository: "the-shire", Expires: expires},
+		{Name: "hobbiton", Repository: "bag-end", Expires: expires},
+		{Name: "the-shire", Repository: "mordor", Expires: expires},
+	} {
+		err = conn.Redirect().Insert(&r)
+		c.Assert(err, check.IsNil)
+	}
+	change, err := addRedirect(conn, "the-shire", "bag-end")
+	c.Assert(err, check.IsNil)
+	c.Assert(change.moved, check.DeepEquals, []string{"shire"})
+	var redirect Redirect
+	for _, name := range []string{"shire", "hobbiton", "the-shire"} {
+		err = conn.Redirect().FindId(name).One(&redirect)
+		c.Assert(err, check.IsNil)
+		c.Check(redirect.Repository, check.Equals, "bag-end")
+	}
+	err = removeRedirect(conn, change)
+	c.Assert(err, check.IsNil)
+	expected := map[string]string{"shire": "the-shire", "hobbiton": "bag-end", "the-shire": "mordor"}
+	for name, repo := range expected {
+		err = conn.Redirect().FindId(name).One(&redirect)
+		c.Assert(err, check.IsNil)
+		c.Check(redirect.Repository, check.Equals, repo)
+	}
+}
+
+func (s *S) TestResolveExpiredRedirect(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&Repository{Name: "bag-end"})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("bag-end")
+	redirect := Redirect{Name: "the-shire", Repository: "bag-end", Expires: time.Now().UTC().Add(-time.Minute)}
+	err = conn.Redirect().Insert(&redirect)
+	c.Assert(err, check.IsNil)
+	defer conn.Redirect().RemoveId("the-shire")
+	_, err = Resolve("the-shire")
+	c.Assert(err, check.Equals, ErrRepositoryNotFound)
+}
+
+func (s *S) TestResolveNotFound(c *check.C) {
+	_, err := Resolve("mordor")
+	c.Assert(err, check.Equals, ErrRepositoryNotFound)
+}
+
+func (s *S) TestRenameMovesRecords(c *check.C) {
+	defer setUpImportBare(c)()
+	_, err := New("the-shire", []string{"bilbo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Maintenance().Insert(&MaintenanceRun{Repository: "the-shire", Status: MaintenanceDone})
+	c.Assert(err, check.IsNil)
+	err = conn.Hook().Insert(bson.M{"name": "pre-receive", "script": "check", "repository": "the-shire"})
+	c.Assert(err, check.IsNil)
+	defer conn.Hook().RemoveAll(bson.M{"name": "pre-receive"})
+	err = Update("the-shire", Repository{Name: "shire", Users: []string{"bilbo"}})
+	c.Assert(err, check.IsNil)
+	defer Remove("shire")
+	run, err := GetMaintenance("shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(run.Status, check.Equals, MaintenanceDone)
+	_, err = GetMaintenance("the-shire")
+	c.Assert(err, check.Equals, ErrMaintenanceNotFound)
+	n, err := conn.Hook().Find(bson.M{"repository": "shire"}).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 1)
+}
+
+func (s *S) TestRenameUpdatesParentOfForks(c *check.C) {
+	defer setUpImportBare(c)()
+	_, err := New("the-shire", []string{"bilbo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&Repository{Name: "bag-end", Users: []string{"frodo"}, Parent: "the-shire"})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("bag-end")
+	err = Update("the-shire", Repository{Name: "shire", Users: []string{"bilbo"}})
+	c.Assert(err, check.IsNil)
+	defer Remove("shire")
+	fork, err := Get("bag-end")
+	c.Assert(err, check.IsNil)
+	c.Assert(fork.Parent, check.Equals, "shire")
+	forks, err := ListForks("shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(forks, check.HasLen, 1)
+	err = moveRecords(conn, "shire", "the-shire")
+	c.Assert(err, check.IsNil)
+	fork, err = Get("bag-end")
+	c.Assert(err, check.IsNil)
+	c.Assert(fork.Parent, check.Equals, "the-shire")
+}
+
+func (s *S) TestRenameRollsBackWhenLFSFails(c *check.C) {
+	defer setUpImportBare(c)()
+	lfsLocation := c.MkDir()
+	config.Set("lfs:location", lfsLocation)
+	defer config.Unset("lfs:location")
+	err := os.MkdirAll(path.Join(lfsLocation, "the-shire"), 0755)
+	c.Assert(err, check.IsNil)
+	err = ioutil.WriteFile(path.Join(lfsLocation, "hobbits"), nil, 0644)
+	c.Assert(err, check.IsNil)
+	_, err = New("the-shire", []string{"bilbo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	defer Remove("the-shire")
+	err = Update("the-shire", Repository{Name: "hobbits/shire", Users: []string{"bilbo"}})
+	c.Assert(err, check.NotNil)
+	_, err = Get("the-shire")
+	c.Assert(err, check.IsNil)
+	_, err = Get("hobbits/shire")
+	c.Assert(err, check.Equals, ErrRepositoryNotFound)
+	_, err = os.Stat(path.Join(bare, "the-shire.git", "HEAD"))
+	c.Assert(err, check.IsNil)
+	_, err = os.Stat(path.Join(bare, "hobbits", "shire.git"))
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+	_, err = Resolve("hobbits/shire")
+	c.Assert(err, check.Equals, ErrRepositoryNotFound)
+}
+
+func (s *S) TestRenameInvalidName(c *check.C) {
+	defer setUpImportBare(c)()
+	_, err := New("the-shire", []string{"bilbo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	defer Remove("the-shire")
+	err = Update("the-shire", Repository{Name: "../shire", Users: []string{"bilbo"}})
+	c.Assert(err, check.FitsTypeOf, &InvalidRepositoryError{})
+	_, err = Get("the-shire")
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestRenameDuringMaintenance(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&Repository{Name: "the-shire"})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("the-shire")
+	running := MaintenanceRun{Repository: "the-shire", Status: MaintenanceRunning, Expires: time.Now().Add(time.Hour)}
+	err = conn.Maintenance().Insert(&running)
+	c.Assert(err, check.IsNil)
+	defer conn.Maintenance().RemoveId("the-shire")
+	err = Update("the-shire", Repository{Name: "shire"})
+	c.Assert(err, check.Equals, ErrMaintenanceInProgress)
+}
+
+func (s *S) TestRemoveRemovesRedirects(c *check.C) {
+	defer setUpImportBare(c)()
+	_, err := New("the-shire", []string{"bilbo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	err = Update("the-shire", Repository{Name: "shire", Users: []string{"bilbo"}})
+	c.Assert(err, check.IsNil)
+	err = Remove("shire")
+	c.Assert(err, check.IsNil)
+	_, err = Resolve("the-shire")
+	c.Assert(err, check.Equals, ErrRepositoryNotFound)
+}
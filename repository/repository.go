@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"mime/multipart"
 	"os"
@@ -21,6 +22,7 @@ import (
 	"github.com/tsuru/config"
 	"github.com/tsuru/gandalf/db"
 	"github.com/tsuru/gandalf/fs"
+	"github.com/tsuru/gandalf/lfs"
 	"github.com/tsuru/gandalf/multipartzip"
 	"github.com/tsuru/tsuru/log"
 )
@@ -41,11 +43,23 @@ func tempDirLocation() string {
 
 // Repository represents a Git repository. A Git repository is a record in the
 // database and a directory in the filesystem (the bare repository).
+//
+// Quota is the maximum disk usage of the repository in bytes, zero meaning
+// the default quota. DiskUsage is the usage recorded after the last push.
+// Both are changed only through their own functions.
 type Repository struct {
-	Name          string `bson:"_id"`
-	Users         []string
-	ReadOnlyUsers []string
-	IsPublic      bool
+	Name           string `bson:"_id"`
+	Users          []string
+	ReadOnlyUsers  []string
+	Groups         []string
+	ReadOnlyGroups []string
+	Maintainers    []string
+	Admins         []string
+	IsPublic       bool
+	Protections    []Protection
+	Parent         string
+	Quota          int64 `json:"-"`
+	DiskUsage      int64 `json:"-"`
 }
 
 type Links struct {
@@ -110,10 +124,17 @@ func exists(path string) (bool, error) {
 // MarshalJSON marshals the Repository in json format.
 func (r *Repository) MarshalJSON() ([]byte, error) {
 	data := map[string]interface{}{
-		"name":    r.Name,
-		"public":  r.IsPublic,
-		"ssh_url": r.ReadWriteURL(),
-		"git_url": r.ReadOnlyURL(),
+		"name":       r.Name,
+		"public":     r.IsPublic,
+		"ssh_url":    r.ReadWriteURL(),
+		"git_url":    r.ReadOnlyURL(),
+		"disk_usage": r.DiskUsage,
+	}
+	if r.Parent != "" {
+		data["parent"] = r.Parent
+	}
+	if r.Quota > 0 {
+		data["quota"] = r.Quota
 	}
 	return json.Marshal(&data)
 }
@@ -124,6 +145,41 @@ func (r *Repository) MarshalJSON() ([]byte, error) {
 func New(name string, users, readOnlyUsers []string, isPublic bool) (*Repository, error) {
 	log.Debugf("Creating repository %q", name)
 	r := &Repository{Name: name, Users: users, ReadOnlyUsers: readOnlyUsers, IsPublic: isPublic}
+	return create(r, func() error { return newBare(name) })
+}
+
+// Fork creates a repository that is a copy of the repository named source.
+// The bare repository of the fork is a local clone of the source, sharing
+// its objects through hard links, and the source is recorded as its parent.
+func Fork(source, name string, users, readOnlyUsers []string, isPublic bool) (*Repository, error) {
+	log.Debugf("Forking repository %q into %q", source, name)
+	if _, err := Get(source); err != nil {
+		return nil, err
+	}
+	r := &Repository{Name: name, Users: users, ReadOnlyUsers: readOnlyUsers, IsPublic: isPublic, Parent: source}
+	return create(r, func() error { return cloneBare(source, name) })
+}
+
+// ListForks returns the repositories forked from the repository with the
+// given name.
+func ListForks(name string) ([]Repository, error) {
+	if _, err := Get(name); err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	forks := []Repository{}
+	err = conn.Repository().Find(bson.M{"parent": name}).Sort("_id").All(&forks)
+	return forks, err
+}
+
+// create validates and saves the repository in the database, and then creates
+// its bare repository using the given function.
+func create(r *Repository, initBare func() error) (*Repository, error) {
+	name, isPublic := r.Name, r.IsPublic
 	if v, err := r.isValid(); !v {
 		log.Errorf("repository.New: Invalid repository %q: %s", name, err)
 		return r, err
@@ -140,7 +196,7 @@ func New(name string, users, readOnlyUsers []string, isPublic bool) (*Repository
 		}
 		return nil, err
 	}
-	if err = newBare(name); err != nil {
+	if err = initBare(); err != nil {
 		log.Errorf("repository.New: Error creating bare repository for %q: %s", name, err)
 		conn.Repository().Remove(bson.M{"_id": r.Name})
 		return r, err
@@ -170,12 +226,15 @@ func Get(name string) (Repository, error) {
 }
 
 // Remove deletes the repository from the database and removes it's bare Git
-// repository.
+// repository. The repository can not be restored, see Delete.
 func Remove(name string) error {
 	log.Debugf("Removing repository %q", name)
 	if err := removeBare(name); err != nil {
 		log.Errorf("repository.Remove: Error removing bare repository %q: %s", name, err)
 	}
+	if err := lfs.RemoveRepository(name); err != nil {
+		log.Errorf("repository.Remove: Error removing LFS objects of repository %q: %s", name, err)
+	}
 	conn, err := db.Conn()
 	if err != nil {
 		return err
@@ -187,10 +246,32 @@ func Remove(name string) error {
 		}
 		return err
 	}
+	removeRecords(conn, name)
 	return nil
 }
 
-// Update update a repository data.
+// removeRecords removes the records of the repository kept in other
+// collections, such as its import job, its last maintenance run, its
+// redirects, its installed hooks and its webhooks, along with their
+// deliveries. Webhooks are looked up by repository name, so a repository
+// created later with the same name doesn't inherit them.
+func removeRecords(conn *db.Storage, name string) {
+	conn.ImportJob().RemoveId(name)
+	conn.Maintenance().RemoveId(name)
+	conn.Redirect().RemoveAll(bson.M{"$or": []bson.M{{"_id": name}, {"repository": name}}})
+	conn.Hook().RemoveAll(bson.M{"repository": name})
+	var webhooks []struct {
+		ID bson.ObjectId `bson:"_id"`
+	}
+	conn.Webhook().Find(bson.M{"repository": name}).Select(bson.M{"_id": 1}).All(&webhooks)
+	for _, h := range webhooks {
+		conn.WebhookDelivery().RemoveAll(bson.M{"webhook": h.ID})
+	}
+	conn.Webhook().RemoveAll(bson.M{"repository": name})
+}
+
+// Update update a repository data. Changing the name renames the
+// repository, keeping the former name as a redirect for a while, see Resolve.
 func Update(name string, newData Repository) error {
 	log.Debugf("Updating repository %q data", name)
 	repo, err := Get(name)
@@ -204,33 +285,28 @@ func Update(name string, newData Repository) error {
 	}
 	defer conn.Close()
 	if len(newData.Name) > 0 && newData.Name != repo.Name {
-		oldName := repo.Name
-		log.Debugf("Renaming repository %q to %q", oldName, newData.Name)
-		err = conn.Repository().Insert(newData)
-		if err != nil {
-			log.Errorf("repository.Rename: Error adding new repository %q: %s", newData.Name, err)
-			return err
-		}
-		err = conn.Repository().RemoveId(oldName)
-		if err != nil {
-			log.Errorf("repository.Rename: Error removing old repository %q: %s", oldName, err)
-			return err
-		}
-		err = fs.Filesystem().Rename(barePath(oldName), barePath(newData.Name))
-		if err != nil {
-			log.Errorf("repository.Rename: Error renaming old repository in filesystem %q: %s", oldName, err)
-			return err
-		}
-	} else {
-		err = conn.Repository().UpdateId(repo.Name, newData)
-		if err != nil {
-			log.Errorf("repository.Update: Error updating repository data %q: %s", repo.Name, err)
-			return err
-		}
+		return rename(conn, repo.Name, newData)
+	}
+	err = conn.Repository().UpdateId(repo.Name, newData)
+	if err != nil {
+		log.Errorf("repository.Update: Error updating repository data %q: %s", repo.Name, err)
+		return err
 	}
 	return nil
 }
 
+// HasWritePermission returns whether the given user is allowed to push to the
+// repository, either directly or as a member of one of its groups.
+func (r *Repository) HasWritePermission(userName string) bool {
+	return r.HasRole(userName, RoleWrite)
+}
+
+// HasReadPermission returns whether the given user is allowed to fetch from
+// the repository. Public repositories may be read by anyone.
+func (r *Repository) HasReadPermission(userName string) bool {
+	return r.IsPublic || r.HasRole(userName, RoleRead)
+}
+
 // ReadWriteURL formats the git ssh url and return it. If no remote is configured in
 // gandalf.conf, this method panics.
 func (r *Repository) ReadWriteURL() string {
@@ -281,6 +357,35 @@ func (r *Repository) ReadOnlyURL() string {
 	return fmt.Sprintf(remote, host, r.Name)
 }
 
+// nameRegexp validates the name of a repository, which may contain a
+// namespace. If a namespace is used, it's validated accordingly (see the
+// comments of isValid).
+var nameRegexp = regexp.MustCompile(`^([\w-+@][\w-+.@]*/)?[\w-]+$`)
+
+// reservedNames are the words following the name of a repository in the
+// routes of the API, which namespaced repositories can not be named after,
+// so "/repository/team/members" is not ambiguous. "revoke" is the route for
+// revoking access to repositories, so it's also reserved without a namespace.
+var reservedNames = map[string]bool{
+	"archive": true, "branches": true, "bundle": true, "commit": true,
+	"contents": true, "diff": true, "fork": true, "forks": true, "hooks": true,
+	"import": true, "logs": true, "maintenance": true, "members": true,
+	"protections": true, "quota": true, "restore": true, "tags": true,
+	"tree": true,
+}
+
+// ValidName returns whether the given name is a valid repository name,
+// optionally including a namespace.
+func ValidName(name string) bool {
+	if !nameRegexp.MatchString(name) || name == "revoke" {
+		return false
+	}
+	if i := strings.Index(name, "/"); i >= 0 && reservedNames[name[i+1:]] {
+		return false
+	}
+	return true
+}
+
 // Validates a repository
 // A valid repository MUST have:
 //  - a name without any special chars only alphanumeric and underlines are allowed.
@@ -291,21 +396,14 @@ func (r *Repository) ReadOnlyURL() string {
 //    periods but it does not start with a period (.)
 //  - one and exactly one slash (/) separates namespace and the actual name
 func (r *Repository) isValid() (bool, error) {
-	// The following regex validates the name of a repository, which may
-	// contain a namespace. If a namespace is used, we validate it
-	// accordingly (see comments above)
-	m, e := regexp.Match(`^([\w-+@][\w-+.@]*/)?[\w-]+$`, []byte(r.Name))
-	if e != nil {
-		panic(e)
-	}
-	if !m {
+	if !ValidName(r.Name) {
 		return false, &InvalidRepositoryError{message: "repository name is not valid"}
 	}
 	absPath, err := filepath.Abs(barePath(r.Name))
 	if err != nil || !strings.HasPrefix(absPath, bare) {
 		return false, &InvalidRepositoryError{message: "repository name is not valid"}
 	}
-	if len(r.Users) == 0 {
+	if len(r.Users) == 0 && len(r.Maintainers) == 0 && len(r.Admins) == 0 {
 		return false, &InvalidRepositoryError{message: "repository should have at least one user"}
 	}
 	return true, nil
@@ -357,6 +455,43 @@ func RevokeAccess(rNames, uNames []string, readOnly bool) error {
 	return nil
 }
 
+// GrantGroupAccess gives full or read-only permission for groups in all
+// specified repositories. If any of the repositories/groups does not exist,
+// GrantGroupAccess just skips it.
+func GrantGroupAccess(rNames, gNames []string, readOnly bool) error {
+	field := "groups"
+	if readOnly {
+		field = "readonlygroups"
+	}
+	return updateAccess(rNames, bson.M{"$addToSet": bson.M{field: bson.M{"$each": gNames}}})
+}
+
+// RevokeGroupAccess revokes full or read-only permission from groups in all
+// specified repositories.
+func RevokeGroupAccess(rNames, gNames []string, readOnly bool) error {
+	field := "groups"
+	if readOnly {
+		field = "readonlygroups"
+	}
+	return updateAccess(rNames, bson.M{"$pullAll": bson.M{field: gNames}})
+}
+
+func updateAccess(rNames []string, update bson.M) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	info, err := conn.Repository().UpdateAll(bson.M{"_id": bson.M{"$in": rNames}}, update)
+	if err != nil {
+		return err
+	}
+	if info.Matched == 0 {
+		return ErrRepositoryNotFound
+	}
+	return nil
+}
+
 func GetArchiveUrl(repo, ref, format string) string {
 	url := "/repository/%s/archive?ref=%s&format=%s"
 	return fmt.Sprintf(url, repo, ref, format)
@@ -383,8 +518,10 @@ type ContentRetriever interface {
 	AddAll(cloneDir string) error
 	Commit(cloneDir, message string, author, committer GitUser) error
 	Push(cloneDir, branch string) error
-	CommitZip(repo string, z *multipart.FileHeader, c GitCommit) (*Ref, error)
+	CommitZip(repo, userName string, z *multipart.FileHeader, c GitCommit) (*Ref, error)
 	GetLogs(repo, hash string, total int, path string) (*GitHistory, error)
+	GetBundle(repo string, refs []string) (io.ReadCloser, error)
+	RestoreBundle(repo, userName string, bundle io.Reader) ([]RefUpdate, error)
 }
 
 var Retriever ContentRetriever
@@ -702,7 +839,7 @@ func (*GitContentRetriever) Push(cloneDir, branch string) error {
 	return nil
 }
 
-func (*GitContentRetriever) CommitZip(repo string, z *multipart.FileHeader, c GitCommit) (*Ref, error) {
+func (*GitContentRetriever) CommitZip(repo, userName string, z *multipart.FileHeader, c GitCommit) (*Ref, error) {
 	cloneDir, cleanUp, err := TempClone(repo)
 	if cleanUp != nil {
 		defer cleanUp()
@@ -729,6 +866,11 @@ func (*GitContentRetriever) CommitZip(repo string, z *multipart.FileHeader, c Gi
 	if err != nil {
 		return nil, fmt.Errorf("Error when trying to commit zip to repository %s, could not commit: %s", repo, err)
 	}
+	if userName != "" {
+		if err = checkCommit(repo, userName, cloneDir, c.Branch); err != nil {
+			return nil, fmt.Errorf("Error when trying to commit zip to repository %s, could not push: %s", repo, err)
+		}
+	}
 	err = Push(cloneDir, c.Branch)
 	if err != nil {
 		return nil, fmt.Errorf("Error when trying to commit zip to repository %s, could not push: %s", repo, err)
@@ -745,6 +887,40 @@ func (*GitContentRetriever) CommitZip(repo string, z *multipart.FileHeader, c Gi
 	return nil, fmt.Errorf("Error when trying to commit zip to repository %s, could not check branch: %s", repo, err)
 }
 
+// checkCommit checks the update of the branch by a commit made on behalf of
+// the given user, as the pre-receive hook does for pushes. Like checkRestore,
+// it fetches the objects of the commit into the repository before the
+// checks, leaving them unreferenced when the commit is rejected.
+func checkCommit(name, userName, cloneDir, branch string) error {
+	r, err := Get(name)
+	if err != nil {
+		return err
+	}
+	ref := "refs/heads/" + branch
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = cloneDir
+	out, err := cmd.Output()
+	if err != nil {
+		return err
+	}
+	newRev := strings.TrimSpace(string(out))
+	oldRev := nullRev
+	cmd = exec.Command("git", "rev-parse", "--verify", "--quiet", ref)
+	cmd.Dir = barePath(name)
+	if out, err = cmd.Output(); err == nil {
+		oldRev = strings.TrimSpace(string(out))
+	}
+	cmd = exec.Command("git", "fetch", "--quiet", "--no-write-fetch-head", cloneDir, ref)
+	cmd.Dir = barePath(name)
+	if out, err = cmd.CombinedOutput(); err != nil {
+		return errors.New(strings.TrimSpace(string(out)))
+	}
+	if err = r.CheckRefUpdate(userName, ref, oldRev, newRev); err != nil {
+		return err
+	}
+	return CheckQuota(name)
+}
+
 func (*GitContentRetriever) GetLogs(repo, hash string, total int, path string) (*GitHistory, error) {
 	if hash == "" {
 		hash = "master"
@@ -893,8 +1069,8 @@ func Push(cloneDir, branch string) error {
 	return retriever().Push(cloneDir, branch)
 }
 
-func CommitZip(repo string, z *multipart.FileHeader, c GitCommit) (*Ref, error) {
-	return retriever().CommitZip(repo, z, c)
+func CommitZip(repo, userName string, z *multipart.FileHeader, c GitCommit) (*Ref, error) {
+	return retriever().CommitZip(repo, userName, z, c)
 }
 
 func GetLogs(repo, hash string, total int, path string) (*GitHistory, error) {
@@ -25,6 +25,7 @@ import (
 	"github.com/tsuru/config"
 	"github.com/tsuru/gandalf/db"
 	"github.com/tsuru/gandalf/fs"
+	"github.com/tsuru/gandalf/group"
 	"github.com/tsuru/gandalf/multipartzip"
 	"github.com/tsuru/tsuru/fs/fstest"
 	"gopkg.in/check.v1"
@@ -138,6 +139,50 @@ func (s *S) TestNewIntegration(c *check.C) {
 	c.Assert(fstat.IsDir(), check.Equals, true)
 }
 
+func (s *S) TestForkIntegration(c *check.C) {
+	configBare, err := config.GetString("git:bare:location")
+	c.Assert(err, check.IsNil)
+	oldBare := bare
+	bare, err = ioutil.TempDir("", "gandalf_repository_test")
+	c.Assert(err, check.IsNil)
+	config.Set("git:bare:location", bare)
+	defer func() {
+		os.RemoveAll(bare)
+		config.Set("git:bare:location", configBare)
+		bare = oldBare
+	}()
+	_, err = New("the-shire", []string{"bilbo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Repository().RemoveId("the-shire")
+	r, err := Fork("the-shire", "bree", []string{"frodo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("bree")
+	c.Assert(r.Parent, check.Equals, "the-shire")
+	c.Assert(r.Users, check.DeepEquals, []string{"frodo"})
+	_, err = os.Stat(path.Join(barePath("bree"), "HEAD"))
+	c.Assert(err, check.IsNil)
+	out, err := exec.Command("git", "--git-dir="+barePath("bree"), "remote").CombinedOutput()
+	c.Assert(err, check.IsNil)
+	c.Assert(string(out), check.Equals, "")
+	forks, err := ListForks("the-shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(forks, check.HasLen, 1)
+	c.Assert(forks[0].Name, check.Equals, "bree")
+}
+
+func (s *S) TestForkSourceNotFound(c *check.C) {
+	_, err := Fork("mordor", "bree", []string{"frodo"}, nil, false)
+	c.Assert(err, check.Equals, ErrRepositoryNotFound)
+}
+
+func (s *S) TestListForksRepositoryNotFound(c *check.C) {
+	_, err := ListForks("mordor")
+	c.Assert(err, check.Equals, ErrRepositoryNotFound)
+}
+
 func (s *S) TestNewIntegrationWithNamespace(c *check.C) {
 	configBare, err := config.GetString("git:bare:location")
 	c.Assert(err, check.IsNil)
@@ -341,6 +386,16 @@ func (s *S) TestRepositoryAcceptsValidNamespaces(c *check.C) {
 	c.Assert(err, check.IsNil)
 }
 
+func (s *S) TestValidNameReservedWords(c *check.C) {
+	c.Check(ValidName("members"), check.Equals, true)
+	c.Check(ValidName("team/membership"), check.Equals, true)
+	c.Check(ValidName("members/myapp"), check.Equals, true)
+	for _, name := range []string{"team/members", "team/hooks", "team/quota", "team/import", "team/fork",
+		"team/forks", "team/maintenance", "team/restore", "team/bundle", "team/protections", "revoke"} {
+		c.Check(ValidName(name), check.Equals, false, check.Commentf("%s", name))
+	}
+}
+
 func (s *S) TestRepositoryShouldBeValidWithoutIsPublic(c *check.C) {
 	r := Repository{Name: "someName", Users: []string{"smeagol"}}
 	v, _ := r.isValid()
@@ -493,6 +548,75 @@ func (s *S) TestUpdateErrsWhenNotFound(c *check.C) {
 	c.Assert(err, check.Equals, ErrRepositoryNotFound)
 }
 
+func (s *S) TestHasWritePermission(c *check.C) {
+	r := Repository{Name: "myrepo", Users: []string{"bob"}, ReadOnlyUsers: []string{"alice"}}
+	c.Assert(r.HasWritePermission("bob"), check.Equals, true)
+	c.Assert(r.HasWritePermission("alice"), check.Equals, false)
+	c.Assert(r.HasWritePermission(""), check.Equals, false)
+}
+
+func (s *S) TestHasReadPermission(c *check.C) {
+	r := Repository{Name: "myrepo", Users: []string{"bob"}, ReadOnlyUsers: []string{"alice"}}
+	c.Assert(r.HasReadPermission("bob"), check.Equals, true)
+	c.Assert(r.HasReadPermission("alice"), check.Equals, true)
+	c.Assert(r.HasReadPermission("mallory"), check.Equals, false)
+	c.Assert(r.HasReadPermission(""), check.Equals, false)
+}
+
+func (s *S) TestHasReadPermissionPublicRepository(c *check.C) {
+	r := Repository{Name: "myrepo", Users: []string{"bob"}, IsPublic: true}
+	c.Assert(r.HasReadPermission("mallory"), check.Equals, true)
+	c.Assert(r.HasReadPermission(""), check.Equals, true)
+	c.Assert(r.HasWritePermission("mallory"), check.Equals, false)
+}
+
+func (s *S) TestPermissionsThroughGroups(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Group().Insert(group.Group{Name: "devs", Members: []string{"bob"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Group().RemoveId("devs")
+	err = conn.Group().Insert(group.Group{Name: "qa", Members: []string{"alice"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Group().RemoveId("qa")
+	r := Repository{Name: "myrepo", Groups: []string{"devs"}, ReadOnlyGroups: []string{"qa"}}
+	c.Assert(r.HasWritePermission("bob"), check.Equals, true)
+	c.Assert(r.HasReadPermission("bob"), check.Equals, true)
+	c.Assert(r.HasWritePermission("alice"), check.Equals, false)
+	c.Assert(r.HasReadPermission("alice"), check.Equals, true)
+	c.Assert(r.HasReadPermission("mallory"), check.Equals, false)
+}
+
+func (s *S) TestGrantGroupAccess(c *check.C) {
+	r := Repository{Name: "proj1", Users: []string{"someuser"}}
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&r)
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId(r.Name)
+	err = GrantGroupAccess([]string{r.Name}, []string{"devs"}, false)
+	c.Assert(err, check.IsNil)
+	err = GrantGroupAccess([]string{r.Name}, []string{"qa"}, true)
+	c.Assert(err, check.IsNil)
+	repo, err := Get(r.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(repo.Groups, check.DeepEquals, []string{"devs"})
+	c.Assert(repo.ReadOnlyGroups, check.DeepEquals, []string{"qa"})
+	err = RevokeGroupAccess([]string{r.Name}, []string{"devs"}, false)
+	c.Assert(err, check.IsNil)
+	repo, err = Get(r.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(repo.Groups, check.HasLen, 0)
+	c.Assert(repo.ReadOnlyGroups, check.DeepEquals, []string{"qa"})
+}
+
+func (s *S) TestGrantGroupAccessRepositoryNotFound(c *check.C) {
+	err := GrantGroupAccess([]string{"ghost"}, []string{"devs"}, false)
+	c.Assert(err, check.Equals, ErrRepositoryNotFound)
+}
+
 func (s *S) TestReadOnlyURL(c *check.C) {
 	host, err := config.GetString("host")
 	c.Assert(err, check.IsNil)
@@ -769,10 +893,11 @@ func (s *S) TestGetNotFound(c *check.C) {
 func (s *S) TestMarshalJSON(c *check.C) {
 	repo := Repository{Name: "somerepo", Users: []string{}}
 	expected := map[string]interface{}{
-		"name":    repo.Name,
-		"public":  repo.IsPublic,
-		"ssh_url": repo.ReadWriteURL(),
-		"git_url": repo.ReadOnlyURL(),
+		"name":       repo.Name,
+		"public":     repo.IsPublic,
+		"ssh_url":    repo.ReadWriteURL(),
+		"git_url":    repo.ReadOnlyURL(),
+		"disk_usage": float64(0),
 	}
 	data, err := json.Marshal(&repo)
 	c.Assert(err, check.IsNil)
@@ -782,6 +907,16 @@ func (s *S) TestMarshalJSON(c *check.C) {
 	c.Assert(result, check.DeepEquals, expected)
 }
 
+func (s *S) TestMarshalJSONWithParent(c *check.C) {
+	repo := Repository{Name: "somerepo", Users: []string{}, Parent: "upstream"}
+	data, err := json.Marshal(&repo)
+	c.Assert(err, check.IsNil)
+	var result map[string]interface{}
+	err = json.Unmarshal(data, &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result["parent"], check.Equals, "upstream")
+}
+
 func (s *S) TestGetFileContentsWhenContentsAvailable(c *check.C) {
 	expected := []byte("something")
 	Retriever = &MockContentRetriever{
@@ -2006,7 +2141,7 @@ func (s *S) TestCommitZipIntegration(c *check.C) {
 		},
 		Branch: "doge_barks",
 	}
-	ref, err := CommitZip(repo, file, commit)
+	ref, err := CommitZip(repo, "", file, commit)
 	c.Assert(err, check.IsNil)
 	c.Assert(ref.Ref, check.Matches, "[a-f0-9]{40}")
 	c.Assert(ref.Name, check.Equals, "doge_barks")
@@ -2058,10 +2193,51 @@ func (s *S) TestCommitZipIntegrationWhenFileEmpty(c *check.C) {
 		Branch: "doge_barks",
 	}
 	expectedErr := fmt.Sprintf("Error when trying to commit zip to repository %s, could not extract: zip: not a valid zip file", repo)
-	_, err = CommitZip(repo, file, commit)
+	_, err = CommitZip(repo, "", file, commit)
 	c.Assert(err.Error(), check.Equals, expectedErr)
 }
 
+func (s *S) TestCommitZipOverProtectedBranchIntegration(c *check.C) {
+	oldBare := bare
+	bare = c.MkDir()
+	defer func() { bare = oldBare }()
+	cleanUp, err := CreateTestRepository(bare, "gandalf-test-repo", "README", "much WOW")
+	defer cleanUp()
+	c.Assert(err, check.IsNil)
+	head, err := GetLastHashCommit(bare, "gandalf-test-repo")
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	r := Repository{Name: "gandalf-test-repo", Users: []string{"alice", "bob"}, Protections: []Protection{{Pattern: "master", Users: []string{"alice"}}}}
+	err = conn.Repository().Insert(&r)
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId(r.Name)
+	buf, err := multipartzip.CreateZipBuffer([]multipartzip.File{{Name: "doge.txt", Body: "Much doge"}})
+	c.Assert(err, check.IsNil)
+	reader, writer := io.Pipe()
+	go multipartzip.StreamWriteMultipartForm(map[string]string{}, "muchfile", "muchfile.zip", "muchBOUNDARY", writer, buf)
+	form, err := multipart.NewReader(reader, "muchBOUNDARY").ReadForm(0)
+	c.Assert(err, check.IsNil)
+	file, err := multipartzip.FileField(form, "muchfile")
+	c.Assert(err, check.IsNil)
+	commit := GitCommit{
+		Message:   "will bark",
+		Author:    GitUser{Name: "author", Email: "author@globo.com"},
+		Committer: GitUser{Name: "committer", Email: "committer@globo.com"},
+		Branch:    "master",
+	}
+	_, err = CommitZip("gandalf-test-repo", "bob", file, commit)
+	c.Assert(err, check.ErrorMatches, `^Error when trying to commit zip to repository gandalf-test-repo, could not push: refs/heads/master is protected, user "bob" is not allowed to push to it$`)
+	branches, err := GetBranches("gandalf-test-repo")
+	c.Assert(err, check.IsNil)
+	c.Assert(branches, check.HasLen, 1)
+	c.Assert(branches[0].Ref, check.Equals, string(head))
+	ref, err := CommitZip("gandalf-test-repo", "alice", file, commit)
+	c.Assert(err, check.IsNil)
+	c.Assert(ref.Ref, check.Not(check.Equals), string(head))
+}
+
 func (s *S) TestGetLogs(c *check.C) {
 	oldBare := bare
 	bare = "/tmp"
@@ -0,0 +1,266 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repository
+
+import (
+	"errors"
+	"path"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/gandalf/fs"
+	"github.com/tsuru/gandalf/lfs"
+	"github.com/tsuru/tsuru/log"
+)
+
+const (
+	// trashDir is the directory, inside the bare and LFS locations, where
+	// deleted repositories are kept. Directories starting with a dot are
+	// not taken as repositories.
+	trashDir = ".trash"
+
+	defaultTrashRetention = 7 * 24 * time.Hour
+	trashPollInterval     = time.Hour
+)
+
+var ErrDeletedRepositoryNotFound = errors.New("deleted repository not found")
+
+// DeletedRepository is a repository moved to the trash by Delete. It may be
+// restored until it's purged, after Purge. The records of its installed hooks
+// and webhooks, and the redirects of its former names, are kept along with
+// it, and restored with it.
+type DeletedRepository struct {
+	ID         bson.ObjectId `bson:"_id" json:"id"`
+	Repository Repository    `json:"repository"`
+	Deleted    time.Time     `json:"deleted"`
+	Purge      time.Time     `json:"purge"`
+	Hooks      []bson.M      `json:"-"`
+	Webhooks   []bson.M      `json:"-"`
+	Redirects  []Redirect    `json:"-"`
+}
+
+// trashName returns the name of the deleted repository in the trash, where
+// its bare repository and LFS objects are kept. A repository may be deleted
+// more than once, so the name is unique for each deletion.
+func (d *DeletedRepository) trashName() string {
+	return path.Join(trashDir, d.ID.Hex())
+}
+
+func trashRetention() time.Duration {
+	if d, err := config.GetDuration("trash:retention"); err == nil && d > 0 {
+		return d
+	}
+	return defaultTrashRetention
+}
+
+// Delete removes the repository with the given name, moving it to the trash,
+// from where it may be restored with Restore until it's purged, after the
+// period defined by the "trash:retention" setting. The name may be used by
+// other repositories in the meantime.
+func Delete(name string) error {
+	log.Debugf("Deleting repository %q", name)
+	repo, err := Get(name)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err = checkMaintenance(conn, name); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	deleted := DeletedRepository{
+		ID:         bson.NewObjectId(),
+		Repository: repo,
+		Deleted:    now,
+		Purge:      now.Add(trashRetention()),
+	}
+	if err = conn.Hook().Find(bson.M{"repository": name}).All(&deleted.Hooks); err != nil {
+		return err
+	}
+	if err = conn.Webhook().Find(bson.M{"repository": name}).All(&deleted.Webhooks); err != nil {
+		return err
+	}
+	if err = conn.Redirect().Find(bson.M{"repository": name}).All(&deleted.Redirects); err != nil {
+		return err
+	}
+	trashName := deleted.trashName()
+	var undo undoer
+	if err = conn.DeletedRepository().Insert(&deleted); err != nil {
+		log.Errorf("repository.Delete: Error adding repository %q to the trash: %s", name, err)
+		return err
+	}
+	undo.add(func() error { return conn.DeletedRepository().RemoveId(deleted.ID) })
+	if err = fs.Filesystem().MkdirAll(path.Join(bareLocation(), trashDir), 0755); err != nil {
+		log.Errorf("repository.Delete: Error creating the trash: %s", err)
+		return undo.rollback("Delete", err)
+	}
+	if err = fs.Filesystem().Rename(barePath(name), barePath(trashName)); err != nil {
+		log.Errorf("repository.Delete: Error moving bare repository %q to the trash: %s", name, err)
+		return undo.rollback("Delete", err)
+	}
+	undo.add(func() error { return fs.Filesystem().Rename(barePath(trashName), barePath(name)) })
+	if err = lfs.RenameRepository(name, trashName); err != nil {
+		log.Errorf("repository.Delete: Error moving LFS objects of repository %q to the trash: %s", name, err)
+		return undo.rollback("Delete", err)
+	}
+	undo.add(func() error { return lfs.RenameRepository(trashName, name) })
+	if err = conn.Repository().RemoveId(name); err != nil {
+		log.Errorf("repository.Delete: Error removing repository %q: %s", name, err)
+		return undo.rollback("Delete", err)
+	}
+	removeRecords(conn, name)
+	return nil
+}
+
+// GetDeleted returns the last repository deleted with the given name.
+func GetDeleted(name string) (*DeletedRepository, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var deleted DeletedRepository
+	err = conn.DeletedRepository().Find(bson.M{"repository._id": name}).Sort("-deleted").One(&deleted)
+	if err == mgo.ErrNotFound {
+		return nil, ErrDeletedRepositoryNotFound
+	}
+	return &deleted, err
+}
+
+// Restore brings back the last repository deleted with the given name, as it
+// was when it was deleted. The deliveries of its webhooks are not kept, and
+// neither are the redirects that expired or whose names were taken by other
+// repositories or redirects in the meantime.
+func Restore(name string) error {
+	log.Debugf("Restoring repository %q", name)
+	deleted, err := GetDeleted(name)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	trashName := deleted.trashName()
+	var undo undoer
+	if err = conn.Repository().Insert(&deleted.Repository); err != nil {
+		if mgo.IsDup(err) {
+			return ErrRepositoryAlreadyExists
+		}
+		return err
+	}
+	undo.add(func() error { return conn.Repository().RemoveId(name) })
+	if err = fs.Filesystem().MkdirAll(path.Dir(barePath(name)), 0755); err != nil {
+		log.Errorf("repository.Restore: Error creating namespace of repository %q: %s", name, err)
+		return undo.rollback("Restore", err)
+	}
+	if err = fs.Filesystem().Rename(barePath(trashName), barePath(name)); err != nil {
+		log.Errorf("repository.Restore: Error moving bare repository %q from the trash: %s", name, err)
+		return undo.rollback("Restore", err)
+	}
+	undo.add(func() error { return fs.Filesystem().Rename(barePath(name), barePath(trashName)) })
+	if err = lfs.RenameRepository(trashName, name); err != nil {
+		log.Errorf("repository.Restore: Error moving LFS objects of repository %q from the trash: %s", name, err)
+		return undo.rollback("Restore", err)
+	}
+	undo.add(func() error { return lfs.RenameRepository(name, trashName) })
+	undo.add(func() error {
+		conn.Hook().RemoveAll(bson.M{"repository": name})
+		conn.Redirect().RemoveAll(bson.M{"repository": name})
+		_, err := conn.Webhook().RemoveAll(bson.M{"repository": name})
+		return err
+	})
+	if err = restoreRecords(conn, deleted); err != nil {
+		log.Errorf("repository.Restore: Error restoring hooks, webhooks and redirects of repository %q: %s", name, err)
+		return undo.rollback("Restore", err)
+	}
+	if err = conn.DeletedRepository().RemoveId(deleted.ID); err != nil {
+		log.Errorf("repository.Restore: Error removing repository %q from the trash: %s", name, err)
+		return undo.rollback("Restore", err)
+	}
+	return nil
+}
+
+// restoreRecords adds back the records of the hooks, webhooks and redirects
+// of the deleted repository.
+func restoreRecords(conn *db.Storage, deleted *DeletedRepository) error {
+	for _, h := range deleted.Hooks {
+		if err := conn.Hook().Insert(h); err != nil {
+			return err
+		}
+	}
+	for _, h := range deleted.Webhooks {
+		if err := conn.Webhook().Insert(h); err != nil {
+			return err
+		}
+	}
+	now := time.Now().UTC()
+	for _, r := range deleted.Redirects {
+		if !r.Expires.After(now) {
+			continue
+		}
+		n, err := conn.Repository().FindId(r.Name).Count()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		if err = conn.Redirect().Insert(&r); err != nil && !mgo.IsDup(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// PurgeDeleted removes for good the deleted repositories whose retention
+// period is over, returning how many were removed.
+func PurgeDeleted() (int, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	var expired []DeletedRepository
+	err = conn.DeletedRepository().Find(bson.M{"purge": bson.M{"$lte": time.Now().UTC()}}).All(&expired)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	for _, deleted := range expired {
+		log.Debugf("Purging deleted repository %q", deleted.Repository.Name)
+		if err = removeBare(deleted.trashName()); err != nil {
+			return count, err
+		}
+		if err = lfs.RemoveRepository(deleted.trashName()); err != nil {
+			return count, err
+		}
+		if err = conn.DeletedRepository().RemoveId(deleted.ID); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// StartTrashPurger starts a goroutine that periodically purges the deleted
+// repositories whose retention period is over, see PurgeDeleted.
+func StartTrashPurger() {
+	go func() {
+		for range time.Tick(trashPollInterval) {
+			if _, err := PurgeDeleted(); err != nil {
+				log.Errorf("repository.Trash: failed to purge deleted repositories: %s", err)
+			}
+		}
+	}()
+}
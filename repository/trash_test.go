@@ -0,0 +1,223 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repository
+
+import (
+	"os"
+	"path"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/tsuru/db/storage"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestTrashRetention(c *check.C) {
+	c.Assert(trashRetention(), check.Equals, 7*24*time.Hour)
+	config.Set("trash:retention", "24h")
+	defer config.Unset("trash:retention")
+	c.Assert(trashRetention(), check.Equals, 24*time.Hour)
+}
+
+func (s *S) TestTrashName(c *check.C) {
+	deleted := DeletedRepository{ID: bson.ObjectIdHex("5a0c8f1e2b3c4d5e6f708192"), Repository: Repository{Name: "team/shire"}}
+	c.Assert(deleted.trashName(), check.Equals, ".trash/5a0c8f1e2b3c4d5e6f708192")
+}
+
+func (s *S) TestDeleteAndRestore(c *check.C) {
+	defer setUpImportBare(c)()
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.DeletedRepository().RemoveAll(nil)
+	_, err = New("hobbits/shire", []string{"bilbo"}, []string{"frodo"}, false)
+	c.Assert(err, check.IsNil)
+	err = Delete("hobbits/shire")
+	c.Assert(err, check.IsNil)
+	_, err = Get("hobbits/shire")
+	c.Assert(err, check.Equals, ErrRepositoryNotFound)
+	_, err = os.Stat(barePath("hobbits/shire"))
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+	deleted, err := GetDeleted("hobbits/shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(deleted.Repository.Users, check.DeepEquals, []string{"bilbo"})
+	c.Assert(deleted.Purge.Sub(deleted.Deleted), check.Equals, defaultTrashRetention)
+	_, err = os.Stat(path.Join(bare, ".trash", deleted.ID.Hex()+".git", "HEAD"))
+	c.Assert(err, check.IsNil)
+	err = Restore("hobbits/shire")
+	c.Assert(err, check.IsNil)
+	defer Remove("hobbits/shire")
+	repo, err := Get("hobbits/shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(repo.ReadOnlyUsers, check.DeepEquals, []string{"frodo"})
+	_, err = os.Stat(path.Join(barePath("hobbits/shire"), "HEAD"))
+	c.Assert(err, check.IsNil)
+	_, err = GetDeleted("hobbits/shire")
+	c.Assert(err, check.Equals, ErrDeletedRepositoryNotFound)
+}
+
+func (s *S) TestDeleteAndRestoreKeepHooksAndWebhooks(c *check.C) {
+	defer setUpImportBare(c)()
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.DeletedRepository().RemoveAll(nil)
+	_, err = New("the-shire", []string{"bilbo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	err = conn.Hook().Insert(bson.M{"name": "pre-receive", "script": "check", "repository": "the-shire"})
+	c.Assert(err, check.IsNil)
+	defer conn.Hook().RemoveAll(nil)
+	err = conn.Webhook().Insert(bson.M{"_id": bson.NewObjectId(), "repository": "the-shire", "url": "https://bilbo.example.com/hook"})
+	c.Assert(err, check.IsNil)
+	defer conn.Webhook().RemoveAll(nil)
+	err = Delete("the-shire")
+	c.Assert(err, check.IsNil)
+	for _, collection := range []*storage.Collection{conn.Hook(), conn.Webhook()} {
+		n, err := collection.Find(bson.M{"repository": "the-shire"}).Count()
+		c.Assert(err, check.IsNil)
+		c.Assert(n, check.Equals, 0)
+	}
+	err = Restore("the-shire")
+	c.Assert(err, check.IsNil)
+	defer Remove("the-shire")
+	for _, collection := range []*storage.Collection{conn.Hook(), conn.Webhook()} {
+		n, err := collection.Find(bson.M{"repository": "the-shire"}).Count()
+		c.Assert(err, check.IsNil)
+		c.Assert(n, check.Equals, 1)
+	}
+}
+
+func (s *S) TestDeleteAndRestoreKeepRedirects(c *check.C) {
+	defer setUpImportBare(c)()
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.DeletedRepository().RemoveAll(nil)
+	defer conn.Redirect().RemoveAll(nil)
+	_, err = New("the-shire", []string{"bilbo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	now := time.Now().UTC()
+	for _, r := range []Redirect{
+		{Name: "shire", Repository: "the-shire", Expires: now.Add(time.Hour)},
+		{Name: "hobbiton", Repository: "the-shire", Expires: now.Add(-time.Minute)},
+		{Name: "mordor", Repository: "the-shire", Expires: now.Add(time.Hour)},
+	} {
+		err = conn.Redirect().Insert(&r)
+		c.Assert(err, check.IsNil)
+	}
+	err = Delete("the-shire")
+	c.Assert(err, check.IsNil)
+	n, err := conn.Redirect().Find(bson.M{"repository": "the-shire"}).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 0)
+	err = conn.Repository().Insert(&Repository{Name: "mordor"})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("mordor")
+	err = Restore("the-shire")
+	c.Assert(err, check.IsNil)
+	defer Remove("the-shire")
+	repo, err := Resolve("shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(repo.Name, check.Equals, "the-shire")
+	var redirects []Redirect
+	err = conn.Redirect().Find(bson.M{"repository": "the-shire"}).All(&redirects)
+	c.Assert(err, check.IsNil)
+	c.Assert(redirects, check.HasLen, 1)
+	c.Assert(redirects[0].Name, check.Equals, "shire")
+}
+
+func (s *S) TestDeleteNotFound(c *check.C) {
+	err := Delete("mordor")
+	c.Assert(err, check.Equals, ErrRepositoryNotFound)
+}
+
+func (s *S) TestDeleteDuringMaintenance(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&Repository{Name: "the-shire"})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("the-shire")
+	running := MaintenanceRun{Repository: "the-shire", Status: MaintenanceRunning, Expires: time.Now().Add(time.Hour)}
+	err = conn.Maintenance().Insert(&running)
+	c.Assert(err, check.IsNil)
+	defer conn.Maintenance().RemoveId("the-shire")
+	err = Delete("the-shire")
+	c.Assert(err, check.Equals, ErrMaintenanceInProgress)
+	_, err = Get("the-shire")
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestDeleteRollsBackWhenBareIsMissing(c *check.C) {
+	defer setUpImportBare(c)()
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&Repository{Name: "the-shire"})
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId("the-shire")
+	err = Delete("the-shire")
+	c.Assert(err, check.NotNil)
+	_, err = Get("the-shire")
+	c.Assert(err, check.IsNil)
+	_, err = GetDeleted("the-shire")
+	c.Assert(err, check.Equals, ErrDeletedRepositoryNotFound)
+}
+
+func (s *S) TestRestoreNameInUse(c *check.C) {
+	defer setUpImportBare(c)()
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.DeletedRepository().RemoveAll(nil)
+	_, err = New("the-shire", []string{"bilbo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	err = Delete("the-shire")
+	c.Assert(err, check.IsNil)
+	_, err = New("the-shire", []string{"sam"}, nil, false)
+	c.Assert(err, check.IsNil)
+	defer Remove("the-shire")
+	err = Restore("the-shire")
+	c.Assert(err, check.Equals, ErrRepositoryAlreadyExists)
+	repo, err := Get("the-shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(repo.Users, check.DeepEquals, []string{"sam"})
+	_, err = GetDeleted("the-shire")
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestRestoreNotFound(c *check.C) {
+	err := Restore("mordor")
+	c.Assert(err, check.Equals, ErrDeletedRepositoryNotFound)
+}
+
+func (s *S) TestPurgeDeleted(c *check.C) {
+	defer setUpImportBare(c)()
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.DeletedRepository().RemoveAll(nil)
+	for _, name := range []string{"the-shire", "rivendell"} {
+		_, err = New(name, []string{"bilbo"}, nil, false)
+		c.Assert(err, check.IsNil)
+		err = Delete(name)
+		c.Assert(err, check.IsNil)
+	}
+	expired, err := GetDeleted("the-shire")
+	c.Assert(err, check.IsNil)
+	err = conn.DeletedRepository().UpdateId(expired.ID, bson.M{"$set": bson.M{"purge": time.Now().UTC().Add(-time.Minute)}})
+	c.Assert(err, check.IsNil)
+	count, err := PurgeDeleted()
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 1)
+	_, err = GetDeleted("the-shire")
+	c.Assert(err, check.Equals, ErrDeletedRepositoryNotFound)
+	_, err = os.Stat(barePath(expired.trashName()))
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+	_, err = GetDeleted("rivendell")
+	c.Assert(err, check.IsNil)
+}
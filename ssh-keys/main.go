@@ -0,0 +1,66 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// gandalf-ssh-keys looks up the keys of gandalf users in the database, to be
+// used as the AuthorizedKeysCommand of sshd instead of the authorized_keys
+// file. It receives a key fingerprint or public key and prints the matching
+// authorized_keys line, or nothing when the key is unknown.
+//
+// A typical sshd configuration is:
+//
+//	Match User git
+//	    AuthorizedKeysCommand /usr/bin/gandalf-ssh-keys %t %k
+//	    AuthorizedKeysCommandUser git
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/user"
+	"github.com/tsuru/tsuru/log"
+)
+
+// run executes gandalf-ssh-keys with the given arguments, returning the exit
+// code.
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("gandalf-ssh-keys", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configFile := flags.String("config", "/etc/gandalf.conf", "Gandalf configuration file")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: gandalf-ssh-keys [options] <fingerprint | [type] key>")
+		fmt.Fprintln(stderr, "\nOptions:")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 || flags.NArg() > 2 {
+		flags.Usage()
+		return 2
+	}
+	if err := config.ReadConfigFile(*configFile); err != nil {
+		fmt.Fprintf(stderr, "Could not open gandalf config file at %s (%s).\n", *configFile, err)
+		return 1
+	}
+	log.Init()
+	key, err := user.LookupKey(strings.Join(flags.Args(), " "))
+	if err == user.ErrKeyNotFound || err == user.ErrInvalidKey {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err)
+		return 1
+	}
+	fmt.Fprint(stdout, key.AuthorizedKeysLine())
+	return 0
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
@@ -0,0 +1,47 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct{}
+
+var _ = check.Suite(&S{})
+
+func (s *S) TestRunWithoutArguments(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	code := run(nil, &stdout, &stderr)
+	c.Assert(code, check.Equals, 2)
+	c.Assert(stderr.String(), check.Matches, "(?s)Usage: gandalf-ssh-keys .*")
+}
+
+func (s *S) TestRunTooManyArguments(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"ssh-rsa", "AAAA", "bilbo@shire"}, &stdout, &stderr)
+	c.Assert(code, check.Equals, 2)
+	c.Assert(stdout.String(), check.Equals, "")
+}
+
+func (s *S) TestRunInvalidConfig(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-config", "/nonexistent/gandalf.conf", "SHA256:abc"}, &stdout, &stderr)
+	c.Assert(code, check.Equals, 1)
+	c.Assert(stderr.String(), check.Matches, "Could not open gandalf config file at /nonexistent/gandalf.conf .*\n")
+}
+
+func (s *S) TestRunInvalidKey(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-config", "../etc/gandalf.conf", "ssh-rsa", "not-a-key"}, &stdout, &stderr)
+	c.Assert(code, check.Equals, 0)
+	c.Assert(stdout.String(), check.Equals, "")
+	c.Assert(stderr.String(), check.Equals, "")
+}
@@ -0,0 +1,46 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// gandalf-sshd runs the built-in SSH server of gandalf without the API, for
+// installations where they run in different hosts.
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/hook"
+	"github.com/tsuru/gandalf/sshserver"
+	"github.com/tsuru/tsuru/log"
+)
+
+const version = "0.7.3"
+
+func main() {
+	configFile := flag.String("config", "/etc/gandalf.conf", "Gandalf configuration file")
+	bind := flag.String("bind", "", "Address to listen on, overriding the ssh:bind setting")
+	gVersion := flag.Bool("version", false, "Print version and exit")
+	flag.Parse()
+	if *gVersion {
+		fmt.Printf("gandalf-sshd version %s\n", version)
+		return
+	}
+	err := config.ReadAndWatchConfigFile(*configFile)
+	if err != nil {
+		log.Fatalf("Could not open gandalf config file at %s (%s).", *configFile, err)
+	}
+	log.Init()
+	hook.SetConfigFile(*configFile)
+	addr := *bind
+	if addr == "" {
+		if addr, err = config.GetString("ssh:bind"); err != nil {
+			log.Fatal("You should configure ssh:bind or use the -bind flag.")
+		}
+	}
+	fmt.Printf("gandalf-sshd %s listening on %s\n", version, addr)
+	if err = sshserver.ListenAndServe(addr); err != nil {
+		log.Fatal(err.Error())
+	}
+}
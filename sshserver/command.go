@@ -0,0 +1,188 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sshserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+
+	"github.com/tsuru/gandalf/audit"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/gandalf/hook"
+	"github.com/tsuru/gandalf/lfs"
+	"github.com/tsuru/gandalf/repository"
+	"github.com/tsuru/gandalf/user"
+	"github.com/tsuru/tsuru/log"
+)
+
+var ErrInvalidCommand = errors.New("You've tried to execute some weird command, I'm deliberately denying you to do that, get over it.")
+
+// The following regex validates the git command, which is in the form:
+//
+//	<git-command> [<namespace>/]<name>
+//
+// with namespace being optional. If a namespace is used, we validate it
+// according to the following:
+//   - a namespace is optional
+//   - a namespace contains only alphanumerics, underlines, @´s, -´s, +´s
+//     and periods but it does not start with a period (.)
+//   - one and exactly one slash (/) separates namespace and the actual name
+var commandRegexp = regexp.MustCompile(`(git-[a-z-]+) '/?([\w-+@][\w-+.@]*/)?([\w-]+)\.git'`)
+
+// lfsCommandRegexp validates the command sent by Git LFS clients to obtain
+// the credentials of the batch API, which is in the form:
+//
+//	git-lfs-authenticate [<namespace>/]<name>.git <operation>
+//
+// with the repository optionally quoted.
+var lfsCommandRegexp = regexp.MustCompile(`^(git-lfs-authenticate) '?/?([\w-+@][\w-+.@]*/)?([\w-]+)\.git'? (upload|download)$`)
+
+const lfsAuthenticate = "git-lfs-authenticate"
+
+// lfsActions maps the LFS operations to the git command requiring the same
+// permission.
+var lfsActions = map[string]string{
+	lfs.OperationDownload: "git-upload-pack",
+	lfs.OperationUpload:   "git-receive-pack",
+}
+
+// gitActions maps the git commands available through SSH to the permission
+// required to run them, along with the message displayed when the user does
+// not have it.
+var gitActions = map[string]struct {
+	allowed func(*repository.Repository, string) bool
+	denied  string
+}{
+	"git-upload-pack":  {(*repository.Repository).HasReadPermission, "You don't have access to read this repository."},
+	"git-receive-pack": {(*repository.Repository).HasWritePermission, "You don't have access to write in this repository."},
+}
+
+// ParseCommand parses the command requested by a git client through SSH, for
+// example "git-receive-pack 'myapp.git'", returning the git command and the
+// name of the repository.
+func ParseCommand(command string) (action, name string, err error) {
+	if m := lfsCommandRegexp.FindStringSubmatch(command); len(m) == 5 {
+		return m[1], m[2] + m[3], nil
+	}
+	m := commandRegexp.FindStringSubmatch(command)
+	if len(m) != 4 {
+		return "", "", ErrInvalidCommand
+	}
+	return m[1], m[2] + m[3], nil
+}
+
+// ParseLFSCommand parses the git-lfs-authenticate command sent by Git LFS
+// clients, for example "git-lfs-authenticate 'myapp.git' download",
+// returning the name of the repository and the LFS operation.
+func ParseLFSCommand(command string) (name, operation string, err error) {
+	m := lfsCommandRegexp.FindStringSubmatch(command)
+	if len(m) != 5 {
+		return "", "", ErrInvalidCommand
+	}
+	return m[2] + m[3], m[4], nil
+}
+
+// authorize checks whether the given user may run the git command on the
+// repository with the given name, returning the repository. Former names of
+// renamed repositories are resolved.
+func authorize(userName, action, name string) (*repository.Repository, error) {
+	permission, ok := gitActions[action]
+	if !ok {
+		return nil, ErrInvalidCommand
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if n, err := conn.User().FindId(userName).Count(); err != nil || n != 1 {
+		log.Errorf("Error obtaining user %q. Gandalf database is probably in an inconsistent state.", userName)
+		return nil, user.ErrUserNotFound
+	}
+	repo, err := repository.Resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	if !permission.allowed(&repo, userName) {
+		return nil, errors.New(permission.denied)
+	}
+	return &repo, nil
+}
+
+// LFSAuthenticate runs the git-lfs-authenticate command on behalf of the
+// given user, writing to stdout the credentials LFS clients use to transfer
+// objects through the batch API. Downloads require read access to the
+// repository, and uploads require write access.
+func LFSAuthenticate(userName, command string, stdout io.Writer) error {
+	name, operation, err := ParseLFSCommand(command)
+	if err != nil {
+		return err
+	}
+	repo, err := authorize(userName, lfsActions[operation], name)
+	if err != nil {
+		return err
+	}
+	resp, err := lfs.Authenticate(userName, repo.Name, operation)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(stdout).Encode(resp)
+}
+
+// Execute runs the git command requested through SSH on behalf of the given
+// user, checking whether the user has access to the repository. env holds
+// additional environment variables for the command, such as GIT_PROTOCOL.
+func Execute(userName, command string, env []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	action, name, err := ParseCommand(command)
+	if err != nil {
+		return err
+	}
+	if action == lfsAuthenticate {
+		return LFSAuthenticate(userName, command, stdout)
+	}
+	repo, err := authorize(userName, action, name)
+	if err != nil {
+		return err
+	}
+	baseEnv := append(os.Environ(), "TSURU_USER="+userName)
+	if action == "git-receive-pack" {
+		if baseEnv, err = hook.ReceiveEnv(repo.Name, userName); err != nil {
+			log.Errorf("Error installing built-in hooks: %s", err)
+			return err
+		}
+	}
+	cmd := exec.Command(action, repository.BarePath(repo.Name))
+	cmd.Env = append(baseEnv, env...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// the input is copied in background, as the client only closes it after
+	// the command exits.
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	log.Debugf("Executing %s on repository %q for user %q", action, repo.Name, userName)
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		io.Copy(in, stdin)
+		in.Close()
+	}()
+	if err = cmd.Wait(); err != nil {
+		log.Errorf("Got error while executing %s on repository %q: %s", action, repo.Name, err)
+		return fmt.Errorf("Could not execute %s on repository %q", action, repo.Name)
+	}
+	// pushes are recorded by the built-in post-receive hook.
+	if action == "git-upload-pack" {
+		audit.Record(audit.Entry{Action: audit.GitFetch, Actor: userName, Repositories: []string{repo.Name}, Details: "ssh"})
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sshserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/audit"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/gandalf/fs"
+	"github.com/tsuru/gandalf/lfs"
+	"github.com/tsuru/gandalf/repository"
+	"github.com/tsuru/gandalf/user"
+	"github.com/tsuru/tsuru/fs/fstest"
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct{}
+
+var _ = check.Suite(&S{})
+
+func (s *S) SetUpSuite(c *check.C) {
+	err := config.ReadConfigFile("../etc/gandalf.conf")
+	c.Assert(err, check.IsNil)
+	config.Set("database:url", "127.0.0.1:27017")
+	config.Set("database:name", "gandalf_sshserver_tests")
+	config.Unset("git:bare:template")
+	config.Set("git:bare:location", c.MkDir())
+	config.Set("git:hooks:location", c.MkDir())
+	fs.Fsystem = &fstest.RecordingFs{}
+}
+
+func (s *S) TearDownSuite(c *check.C) {
+	fs.Fsystem = nil
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	conn.User().Database.DropDatabase()
+}
+
+func (s *S) TestParseCommand(c *check.C) {
+	var tests = []struct {
+		command string
+		action  string
+		name    string
+	}{
+		{"git-receive-pack 'myapp.git'", "git-receive-pack", "myapp"},
+		{"git-upload-pack '/myapp.git'", "git-upload-pack", "myapp"},
+		{"git-upload-pack 'me/my-app.git'", "git-upload-pack", "me/my-app"},
+		{"git-upload-pack '/me@tsuru.io/myapp.git'", "git-upload-pack", "me@tsuru.io/myapp"},
+		{"git-lfs-authenticate 'myapp.git' download", "git-lfs-authenticate", "myapp"},
+		{"git-lfs-authenticate me/myapp.git upload", "git-lfs-authenticate", "me/myapp"},
+	}
+	for _, t := range tests {
+		action, name, err := ParseCommand(t.command)
+		c.Check(err, check.IsNil)
+		c.Check(action, check.Equals, t.action)
+		c.Check(name, check.Equals, t.name)
+	}
+}
+
+func (s *S) TestParseCommandInvalid(c *check.C) {
+	for _, command := range []string{"", "rm -rf /", "git-receive-pack myapp", "git-upload-pack '.me/myapp.git'"} {
+		_, _, err := ParseCommand(command)
+		c.Check(err, check.Equals, ErrInvalidCommand)
+	}
+}
+
+func (s *S) TestParseLFSCommand(c *check.C) {
+	name, operation, err := ParseLFSCommand("git-lfs-authenticate '/me/myapp.git' upload")
+	c.Assert(err, check.IsNil)
+	c.Assert(name, check.Equals, "me/myapp")
+	c.Assert(operation, check.Equals, "upload")
+	for _, command := range []string{"git-lfs-authenticate 'myapp.git'", "git-lfs-authenticate 'myapp.git' delete", "git-upload-pack 'myapp.git'"} {
+		_, _, err = ParseLFSCommand(command)
+		c.Check(err, check.Equals, ErrInvalidCommand)
+	}
+}
+
+func (s *S) TestLFSAuthenticate(c *check.C) {
+	config.Set("lfs:url", "https://gandalf.example.com/")
+	defer config.Unset("lfs:url")
+	u, err := user.New("bilbo", map[string]string{})
+	c.Assert(err, check.IsNil)
+	defer user.Remove(u.Name)
+	_, err = repository.New("the-shire", nil, []string{"bilbo"}, false)
+	c.Assert(err, check.IsNil)
+	defer repository.Remove("the-shire")
+	var stdout, stderr bytes.Buffer
+	err = Execute("bilbo", "git-lfs-authenticate the-shire.git download", nil, strings.NewReader(""), &stdout, &stderr)
+	c.Assert(err, check.IsNil)
+	var resp lfs.Authentication
+	err = json.Unmarshal(stdout.Bytes(), &resp)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.Href, check.Equals, "https://gandalf.example.com/repository/the-shire.git/info/lfs")
+	c.Assert(resp.Header["Authorization"], check.Matches, "Basic .+")
+	err = Execute("bilbo", "git-lfs-authenticate the-shire.git upload", nil, strings.NewReader(""), &stdout, &stderr)
+	c.Assert(err, check.ErrorMatches, "You don't have access to write in this repository.")
+}
+
+func (s *S) TestExecuteInvalidCommand(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	for _, command := range []string{"ls", "git-upload-archive 'myapp.git'"} {
+		err := Execute("bilbo", command, nil, strings.NewReader(""), &stdout, &stderr)
+		c.Check(err, check.Equals, ErrInvalidCommand)
+	}
+	c.Assert(stdout.String(), check.Equals, "")
+}
+
+func (s *S) TestExecuteUploadPack(c *check.C) {
+	u, err := user.New("bilbo", map[string]string{})
+	c.Assert(err, check.IsNil)
+	defer user.Remove(u.Name)
+	_, err = repository.New("the-shire", []string{"bilbo"}, nil, false)
+	c.Assert(err, check.IsNil)
+	defer repository.Remove("the-shire")
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Audit().RemoveAll(nil)
+	var stdout, stderr bytes.Buffer
+	err = Execute("bilbo", "git-upload-pack 'the-shire.git'", nil, strings.NewReader("0000"), &stdout, &stderr)
+	c.Assert(err, check.IsNil, check.Commentf("%s", stderr.String()))
+	c.Assert(stdout.String(), check.Matches, "(?s).*capabilities.*0000")
+	entries, err := audit.List(audit.Filter{Action: audit.GitFetch})
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 1)
+	c.Assert(entries[0].Actor, check.Equals, "bilbo")
+	c.Assert(entries[0].Details, check.Equals, "ssh")
+}
+
+func (s *S) TestExecuteWithoutPermission(c *check.C) {
+	u, err := user.New("bilbo", map[string]string{})
+	c.Assert(err, check.IsNil)
+	defer user.Remove(u.Name)
+	_, err = repository.New("mordor", []string{"sauron"}, nil, false)
+	c.Assert(err, check.IsNil)
+	defer repository.Remove("mordor")
+	var stdout, stderr bytes.Buffer
+	err = Execute("bilbo", "git-receive-pack 'mordor.git'", nil, strings.NewReader(""), &stdout, &stderr)
+	c.Assert(err, check.ErrorMatches, "You don't have access to write in this repository.")
+	err = Execute("bilbo", "git-upload-pack 'mordor.git'", nil, strings.NewReader(""), &stdout, &stderr)
+	c.Assert(err, check.ErrorMatches, "You don't have access to read this repository.")
+	c.Assert(stdout.String(), check.Equals, "")
+}
+
+func (s *S) TestExecuteUnknownUser(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	err := Execute("gollum", "git-upload-pack 'the-shire.git'", nil, strings.NewReader(""), &stdout, &stderr)
+	c.Assert(err, check.Equals, user.ErrUserNotFound)
+}
+
+func (s *S) TestExecuteUnknownRepository(c *check.C) {
+	u, err := user.New("bilbo", map[string]string{})
+	c.Assert(err, check.IsNil)
+	defer user.Remove(u.Name)
+	var stdout, stderr bytes.Buffer
+	err = Execute("bilbo", "git-upload-pack 'erebor.git'", nil, strings.NewReader(""), &stdout, &stderr)
+	c.Assert(err, check.Equals, repository.ErrRepositoryNotFound)
+}
@@ -0,0 +1,195 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package sshserver implements an SSH server for git clients, replacing
+// OpenSSH and the gandalf-ssh forced command. Users are authenticated by
+// their public keys stored in the database.
+package sshserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/user"
+	"github.com/tsuru/tsuru/log"
+	"golang.org/x/crypto/ssh"
+)
+
+// userExtension is the permission extension holding the name of the
+// authenticated gandalf user.
+const userExtension = "gandalf-user"
+
+// Server is an SSH server serving git repositories.
+type Server struct {
+	config *ssh.ServerConfig
+}
+
+// NewServer returns a server using the host key defined by the
+// "ssh:host-key" setting, which is generated if it does not exist.
+func NewServer() (*Server, error) {
+	keyPath, err := hostKeyPath()
+	if err != nil {
+		return nil, err
+	}
+	signer, err := loadHostKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg := ssh.ServerConfig{PublicKeyCallback: authenticate}
+	cfg.AddHostKey(signer)
+	return &Server{config: &cfg}, nil
+}
+
+// ListenAndServe listens on the given TCP address and serves SSH connections.
+func ListenAndServe(addr string) error {
+	s, err := NewServer()
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on the listener, handling each one in a new
+// goroutine.
+func (s *Server) Serve(l net.Listener) error {
+	defer l.Close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func authenticate(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	k, err := user.LookupKey(string(ssh.MarshalAuthorizedKey(key)))
+	if err != nil {
+		return nil, err
+	}
+	return &ssh.Permissions{Extensions: map[string]string{userExtension: k.UserName}}, nil
+}
+
+func (s *Server) handleConn(nConn net.Conn) {
+	conn, chans, reqs, err := ssh.NewServerConn(nConn, s.config)
+	if err != nil {
+		log.Debugf("SSH handshake with %s failed: %s", nConn.RemoteAddr(), err)
+		return
+	}
+	defer conn.Close()
+	go ssh.DiscardRequests(reqs)
+	userName := conn.Permissions.Extensions[userExtension]
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			log.Errorf("Could not accept SSH channel: %s", err)
+			continue
+		}
+		go handleSession(userName, channel, requests)
+	}
+}
+
+// handleSession handles the requests of a session channel. Only the exec
+// request is supported, running the git command in the server, and the
+// GIT_PROTOCOL environment variable, used by git to negotiate the protocol
+// version.
+func handleSession(userName string, channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	var env []string
+	for req := range requests {
+		switch req.Type {
+		case "env":
+			var variable struct{ Name, Value string }
+			ok := ssh.Unmarshal(req.Payload, &variable) == nil && variable.Name == "GIT_PROTOCOL"
+			if ok {
+				env = append(env, variable.Name+"="+variable.Value)
+			}
+			req.Reply(ok, nil)
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			var status uint32
+			if err := Execute(userName, payload.Command, env, channel, channel, channel.Stderr()); err != nil {
+				fmt.Fprintf(channel.Stderr(), "%s\n", err)
+				status = 1
+			}
+			sendExitStatus(channel, status)
+			return
+		case "shell":
+			req.Reply(true, nil)
+			fmt.Fprintf(channel.Stderr(), "Hi %s! You've successfully authenticated, but Gandalf does not provide shell access.\n", userName)
+			sendExitStatus(channel, 1)
+			return
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func sendExitStatus(channel ssh.Channel, status uint32) {
+	payload := struct{ Status uint32 }{status}
+	channel.SendRequest("exit-status", false, ssh.Marshal(&payload))
+}
+
+// hostKeyPath returns the path of the host key of the server. It's defined by
+// the "ssh:host-key" setting and defaults to the .ssh/host_key file inside
+// "git:bare:location".
+func hostKeyPath() (string, error) {
+	if keyPath, err := config.GetString("ssh:host-key"); err == nil {
+		return keyPath, nil
+	}
+	bare, err := config.GetString("git:bare:location")
+	if err != nil {
+		return "", err
+	}
+	return path.Join(bare, ".ssh", "host_key"), nil
+}
+
+// loadHostKey reads the private key in the given path, generating a new ECDSA
+// key if the file does not exist. ECDSA is used because current OpenSSH
+// clients reject ssh-rsa signatures, the only ones supported for RSA keys.
+func loadHostKey(keyPath string) (ssh.Signer, error) {
+	data, err := ioutil.ReadFile(keyPath)
+	if os.IsNotExist(err) {
+		log.Debugf("Generating SSH host key in %s", keyPath)
+		var key *ecdsa.PrivateKey
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return nil, err
+		}
+		var der []byte
+		if der, err = x509.MarshalECPrivateKey(key); err != nil {
+			return nil, err
+		}
+		data = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+		if err = os.MkdirAll(path.Dir(keyPath), 0700); err != nil {
+			return nil, err
+		}
+		err = ioutil.WriteFile(keyPath, data, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(data)
+}
@@ -0,0 +1,137 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sshserver
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path"
+
+	"github.com/tsuru/config"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/check.v1"
+)
+
+// startServer starts a server authenticating every key as the given user,
+// returning its address and host key.
+func startServer(c *check.C, userName string) (string, ssh.PublicKey) {
+	signer, err := loadHostKey(path.Join(c.MkDir(), "host_key"))
+	c.Assert(err, check.IsNil)
+	cfg := ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return &ssh.Permissions{Extensions: map[string]string{userExtension: userName}}, nil
+		},
+	}
+	cfg.AddHostKey(signer)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	go (&Server{config: &cfg}).Serve(l)
+	return l.Addr().String(), signer.PublicKey()
+}
+
+func dial(c *check.C, addr string, hostKey ssh.PublicKey) *ssh.Client {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, check.IsNil)
+	signer, err := ssh.NewSignerFromKey(key)
+	c.Assert(err, check.IsNil)
+	cfg := ssh.ClientConfig{
+		User: "git",
+		Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if !bytes.Equal(key.Marshal(), hostKey.Marshal()) {
+				return errors.New("unexpected host key")
+			}
+			return nil
+		},
+	}
+	client, err := ssh.Dial("tcp", addr, &cfg)
+	c.Assert(err, check.IsNil)
+	return client
+}
+
+func (s *S) TestServerShell(c *check.C) {
+	addr, hostKey := startServer(c, "bilbo")
+	client := dial(c, addr, hostKey)
+	defer client.Close()
+	session, err := client.NewSession()
+	c.Assert(err, check.IsNil)
+	defer session.Close()
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	c.Assert(session.Shell(), check.IsNil)
+	err = session.Wait()
+	c.Assert(err, check.FitsTypeOf, &ssh.ExitError{})
+	c.Assert(err.(*ssh.ExitError).ExitStatus(), check.Equals, 1)
+	c.Assert(stderr.String(), check.Equals, "Hi bilbo! You've successfully authenticated, but Gandalf does not provide shell access.\n")
+}
+
+func (s *S) TestServerInvalidCommand(c *check.C) {
+	addr, hostKey := startServer(c, "bilbo")
+	client := dial(c, addr, hostKey)
+	defer client.Close()
+	session, err := client.NewSession()
+	c.Assert(err, check.IsNil)
+	defer session.Close()
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	err = session.Run("cat /etc/passwd")
+	c.Assert(err, check.FitsTypeOf, &ssh.ExitError{})
+	c.Assert(err.(*ssh.ExitError).ExitStatus(), check.Equals, 1)
+	c.Assert(stdout.String(), check.Equals, "")
+	c.Assert(stderr.String(), check.Equals, ErrInvalidCommand.Error()+"\n")
+}
+
+func (s *S) TestServerRejectsPty(c *check.C) {
+	addr, hostKey := startServer(c, "bilbo")
+	client := dial(c, addr, hostKey)
+	defer client.Close()
+	session, err := client.NewSession()
+	c.Assert(err, check.IsNil)
+	defer session.Close()
+	err = session.RequestPty("xterm", 80, 40, ssh.TerminalModes{})
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestLoadHostKey(c *check.C) {
+	keyPath := path.Join(c.MkDir(), "ssh", "host_key")
+	signer, err := loadHostKey(keyPath)
+	c.Assert(err, check.IsNil)
+	info, err := os.Stat(keyPath)
+	c.Assert(err, check.IsNil)
+	c.Assert(info.Mode().Perm(), check.Equals, os.FileMode(0600))
+	c.Assert(signer.PublicKey().Type(), check.Equals, ssh.KeyAlgoECDSA256)
+	loaded, err := loadHostKey(keyPath)
+	c.Assert(err, check.IsNil)
+	c.Assert(loaded.PublicKey().Marshal(), check.DeepEquals, signer.PublicKey().Marshal())
+}
+
+func (s *S) TestLoadHostKeyInvalid(c *check.C) {
+	keyPath := path.Join(c.MkDir(), "host_key")
+	f, err := os.Create(keyPath)
+	c.Assert(err, check.IsNil)
+	f.WriteString("not a key")
+	f.Close()
+	_, err = loadHostKey(keyPath)
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestHostKeyPath(c *check.C) {
+	bare, err := config.GetString("git:bare:location")
+	c.Assert(err, check.IsNil)
+	keyPath, err := hostKeyPath()
+	c.Assert(err, check.IsNil)
+	c.Assert(keyPath, check.Equals, path.Join(bare, ".ssh", "host_key"))
+	config.Set("ssh:host-key", "/etc/gandalf/host_key")
+	defer config.Unset("ssh:host-key")
+	keyPath, err = hostKeyPath()
+	c.Assert(err, check.IsNil)
+	c.Assert(keyPath, check.Equals, "/etc/gandalf/host_key")
+}
@@ -6,6 +6,8 @@ package user
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -22,6 +24,7 @@ import (
 	"github.com/tsuru/gandalf/db"
 	"github.com/tsuru/gandalf/fs"
 	tsurufs "github.com/tsuru/tsuru/fs"
+	"github.com/tsuru/tsuru/log"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -29,14 +32,20 @@ var (
 	ErrDuplicateKey = errors.New("Duplicate key")
 	ErrInvalidKey   = errors.New("Invalid key")
 	ErrKeyNotFound  = errors.New("Key not found")
+
+	ErrAuthorizedKeysDisabled = errors.New("The authorized_keys file is disabled, keys are served by gandalf-ssh-keys")
 )
 
+// keyOptions are the options of the keys written to the authorized_keys file.
+const keyOptions = "no-port-forwarding,no-X11-forwarding,no-agent-forwarding,no-pty"
+
 type Key struct {
-	Name      string
-	Body      string
-	Comment   string
-	UserName  string
-	CreatedAt time.Time
+	Name        string
+	Body        string
+	Comment     string
+	UserName    string
+	Fingerprint string
+	CreatedAt   time.Time
 }
 
 func newKey(name, user, raw string) (*Key, error) {
@@ -46,15 +55,23 @@ func newKey(name, user, raw string) (*Key, error) {
 	}
 	body := ssh.MarshalAuthorizedKey(key.(ssh.PublicKey))
 	k := Key{
-		Name:      name,
-		Body:      string(body),
-		Comment:   comment,
-		UserName:  user,
-		CreatedAt: time.Now(),
+		Name:        name,
+		Body:        string(body),
+		Comment:     comment,
+		UserName:    user,
+		Fingerprint: keyFingerprint(key),
+		CreatedAt:   time.Now(),
 	}
 	return &k, nil
 }
 
+// keyFingerprint returns the SHA256 fingerprint of the key, in the format
+// used by OpenSSH.
+func keyFingerprint(key ssh.PublicKey) string {
+	sum := sha256.Sum256(key.Marshal())
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
 func (k *Key) String() string {
 	parts := make([]string, 1, 2)
 	parts[0] = strings.TrimSpace(k.Body)
@@ -69,8 +86,8 @@ func (k *Key) format() string {
 	if err != nil {
 		panic(err)
 	}
-	keyFmt := `no-port-forwarding,no-X11-forwarding,no-agent-forwarding,no-pty,command="%s %s" %s` + "\n"
-	return fmt.Sprintf(keyFmt, binPath, k.UserName, k)
+	keyFmt := `%s,command="%s %s" %s` + "\n"
+	return fmt.Sprintf(keyFmt, keyOptions, binPath, k.UserName, k)
 }
 
 func (k *Key) dump(w io.Writer) error {
@@ -85,6 +102,14 @@ func (k *Key) dump(w io.Writer) error {
 	return nil
 }
 
+// writeAuthorizedKeys reports whether keys must be written to the
+// authorized_keys file. When sshd uses gandalf-ssh-keys as its
+// AuthorizedKeysCommand, the file is not used at all.
+func writeAuthorizedKeys() bool {
+	command, _ := config.GetBool("authorized-keys-command")
+	return !command
+}
+
 func authKey() string {
 	if path, _ := config.GetString("authorized-keys-path"); path != "" {
 		return path
@@ -136,17 +161,43 @@ func moveFile(fromPath string) error {
 	return fs.Filesystem().Rename(fromPath, authKey())
 }
 
+// writeKey adds the key to the authorized_keys file. When the file has the
+// section managed by gandalf, see SyncAuthorizedKeys, the key is written at
+// the end of the section, otherwise it's appended to the file.
 func writeKey(k *Key) error {
+	if !writeAuthorizedKeys() {
+		return nil
+	}
 	file, err := copyFile()
 	if err != nil {
 		return err
 	}
 	defer file.Close()
-	file.Seek(0, 2)
-	err = k.dump(file)
+	lines := make([]string, 0, 10)
+	inserted := false
+	reader := bufio.NewReader(file)
+	line, _ := reader.ReadString('\n')
+	for line != "" {
+		if !inserted && strings.TrimSuffix(line, "\n") == endMarker {
+			lines = append(lines, k.format())
+			inserted = true
+		}
+		lines = append(lines, line)
+		line, _ = reader.ReadString('\n')
+	}
+	if !inserted {
+		lines = append(lines, k.format())
+	}
+	file.Truncate(0)
+	file.Seek(0, 0)
+	content := strings.Join(lines, "")
+	n, err := file.WriteString(content)
 	if err != nil {
 		return err
 	}
+	if n != len(content) {
+		return io.ErrShortWrite
+	}
 	return moveFile(file.Name())
 }
 
@@ -208,6 +259,9 @@ func addKeys(keys map[string]string, username string) error {
 }
 
 func remove(k *Key) error {
+	if !writeAuthorizedKeys() {
+		return nil
+	}
 	formatted := k.format()
 	file, err := copyFile()
 	if err != nil {
@@ -271,6 +325,86 @@ func removeKey(name, username string) error {
 	return remove(&k)
 }
 
+// LookupKey finds the key matching the given SHA256 fingerprint, in the
+// format "SHA256:<base64>", or public key. The public key may be in the
+// authorized_keys format or just its base64 encoding.
+//
+// Keys added before fingerprints were stored have none, so when no key
+// matches a fingerprint, the missing fingerprints are filled in and the
+// lookup is retried.
+func LookupKey(key string) (*Key, error) {
+	query := bson.M{}
+	if strings.HasPrefix(key, "SHA256:") {
+		query["fingerprint"] = key
+	} else {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+		if err != nil {
+			data, decodeErr := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+			if decodeErr != nil {
+				return nil, ErrInvalidKey
+			}
+			if pub, err = ssh.ParsePublicKey(data); err != nil {
+				return nil, ErrInvalidKey
+			}
+		}
+		query["body"] = string(ssh.MarshalAuthorizedKey(pub))
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var k Key
+	err = conn.Key().Find(query).One(&k)
+	if err == mgo.ErrNotFound && query["fingerprint"] != nil {
+		n, fillErr := fillFingerprints(conn)
+		if fillErr != nil {
+			return nil, fillErr
+		}
+		if n > 0 {
+			err = conn.Key().Find(query).One(&k)
+		}
+	}
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, ErrKeyNotFound
+		}
+		return nil, err
+	}
+	return &k, nil
+}
+
+// fillFingerprints sets the fingerprint of the keys stored without one,
+// returning the number of updated keys.
+func fillFingerprints(conn *db.Storage) (int, error) {
+	var keys []Key
+	missing := bson.M{"$or": []bson.M{{"fingerprint": bson.M{"$exists": false}}, {"fingerprint": ""}}}
+	if err := conn.Key().Find(missing).All(&keys); err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, k := range keys {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(k.Body))
+		if err != nil {
+			log.Errorf("Could not compute the fingerprint of key %q of user %q: %s", k.Name, k.UserName, err)
+			continue
+		}
+		selector := bson.M{"name": k.Name, "username": k.UserName}
+		err = conn.Key().Update(selector, bson.M{"$set": bson.M{"fingerprint": keyFingerprint(pub)}})
+		if err != nil && err != mgo.ErrNotFound {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// AuthorizedKeysLine returns the line of the key in the authorized_keys
+// format, including the options and the command gandalf uses to run git.
+func (k *Key) AuthorizedKeysLine() string {
+	return k.format()
+}
+
 type KeyList []Key
 
 func (keys KeyList) MarshalJSON() ([]byte, error) {
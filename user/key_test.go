@@ -13,6 +13,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/globalsign/mgo/bson"
@@ -36,6 +37,7 @@ func (failWriter) Write(p []byte) (int, error) {
 const rawKey = "ssh-dss AAAAB3NzaC1kc3MAAACBAIHfSDLpSCfIIVEJ/Is3RFMQhsCi7WZtFQeeyfi+DzVP0NGX4j/rMoQEHgXgNlOKVCJvPk5e00tukSv6iVzJPFcozArvVaoCc5jCoDi5Ef8k3Jil4Q7qNjcoRDDyqjqLcaviJEz5GrtmqAyXEIzJ447BxeEdw3Z7UrIWYcw2YyArAAAAFQD7wiOGZIoxu4XIOoeEe5aToTxN1QAAAIAZNAbJyOnNceGcgRRgBUPfY5ChX+9A29n2MGnyJ/Cxrhuh8d7B0J8UkvEBlfgQICq1UDZbC9q5NQprwD47cGwTjUZ0Z6hGpRmEEZdzsoj9T6vkLiteKH3qLo7IPVx4mV6TTF6PWQbQMUsuxjuDErwS9nhtTM4nkxYSmUbnWb6wfwAAAIB2qm/1J6Jl8bByBaMQ/ptbm4wQCvJ9Ll9u6qtKy18D4ldoXM0E9a1q49swml5CPFGyU+cgPRhEjN5oUr5psdtaY8CHa2WKuyIVH3B8UhNzqkjpdTFSpHs6tGluNVC+SQg1MVwfG2wsZUdkUGyn+6j8ZZarUfpAmbb5qJJpgMFEKQ== f@xikinbook.local"
 const body = "ssh-dss AAAAB3NzaC1kc3MAAACBAIHfSDLpSCfIIVEJ/Is3RFMQhsCi7WZtFQeeyfi+DzVP0NGX4j/rMoQEHgXgNlOKVCJvPk5e00tukSv6iVzJPFcozArvVaoCc5jCoDi5Ef8k3Jil4Q7qNjcoRDDyqjqLcaviJEz5GrtmqAyXEIzJ447BxeEdw3Z7UrIWYcw2YyArAAAAFQD7wiOGZIoxu4XIOoeEe5aToTxN1QAAAIAZNAbJyOnNceGcgRRgBUPfY5ChX+9A29n2MGnyJ/Cxrhuh8d7B0J8UkvEBlfgQICq1UDZbC9q5NQprwD47cGwTjUZ0Z6hGpRmEEZdzsoj9T6vkLiteKH3qLo7IPVx4mV6TTF6PWQbQMUsuxjuDErwS9nhtTM4nkxYSmUbnWb6wfwAAAIB2qm/1J6Jl8bByBaMQ/ptbm4wQCvJ9Ll9u6qtKy18D4ldoXM0E9a1q49swml5CPFGyU+cgPRhEjN5oUr5psdtaY8CHa2WKuyIVH3B8UhNzqkjpdTFSpHs6tGluNVC+SQg1MVwfG2wsZUdkUGyn+6j8ZZarUfpAmbb5qJJpgMFEKQ==\n"
 const comment = "f@xikinbook.local"
+const fingerprint = "SHA256:qjW7csIRoKBgZZJutyAFaO0T775pyGAkv7UnUH6MwdM"
 const otherKey = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQCaNZSIEyP6FSdCX0WHDcUFTvebNbvqKiiLEiC7NTGvKrT15r2MtCDi4EPi4Ul+UyxWqb2D7FBnK1UmIcEFHd/ZCnBod2/FSplGOIbIb2UVVbqPX5Alv7IBCMyZJD14ex5cFh16zoqOsPOkOD803LMIlNvXPDDwKjY4TVOQV1JtA2tbZXvYUchqhTcKPxt5BDBZbeQkMMgUgHIEz6IueglFB3+dIZfrzlmM8CVSElKZOpucnJ5JOpGh3paSO/px2ZEcvY8WvjFdipvAWsis75GG/04F641I6XmYlo9fib/YytBXS23szqmvOqEqAopFnnGkDEo+LWI0+FXgPE8lc5BD"
 
 func (s *S) TestNewKey(c *check.C) {
@@ -45,6 +47,7 @@ func (s *S) TestNewKey(c *check.C) {
 	c.Assert(k.Body, check.Equals, body)
 	c.Assert(k.Comment, check.Equals, comment)
 	c.Assert(k.UserName, check.Equals, "me@tsuru.io")
+	c.Assert(k.Fingerprint, check.Equals, fingerprint)
 }
 
 func (s *S) TestNewKeyInvalidKey(c *check.C) {
@@ -198,6 +201,18 @@ func (s *S) TestWriteKey(c *check.C) {
 	c.Assert(got, check.Equals, key.format())
 }
 
+func (s *S) TestWriteKeyWithAuthorizedKeysCommand(c *check.C) {
+	config.Set("authorized-keys-command", true)
+	defer config.Unset("authorized-keys-command")
+	key, err := newKey("my-key", "me@tsuru.io", rawKey)
+	c.Assert(err, check.IsNil)
+	err = writeKey(key)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.rfs.HasAction("openfile "+authKey()+".tmp with mode 0600"), check.Equals, false)
+	_, err = s.rfs.Open(authKey())
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+}
+
 func (s *S) TestWriteTwoKeys(c *check.C) {
 	key1 := Key{
 		Name:     "my-key",
@@ -351,6 +366,22 @@ func (s *S) TestRemoveKeyRemovesFromAuthorizedKeys(c *check.C) {
 	c.Assert(got, check.Equals, "")
 }
 
+func (s *S) TestRemoveKeyWithAuthorizedKeysCommand(c *check.C) {
+	content := "ssh-rsa AAAAadmin admin@host\n"
+	f, err := s.rfs.Create(authKey())
+	c.Assert(err, check.IsNil)
+	f.Write([]byte(content))
+	f.Close()
+	config.Set("authorized-keys-command", true)
+	defer config.Unset("authorized-keys-command")
+	err = addKey("key1", rawKey, "gopher")
+	c.Assert(err, check.IsNil)
+	err = removeKey("key1", "gopher")
+	c.Assert(err, check.IsNil)
+	c.Assert(s.readAuthorizedKeys(c), check.Equals, content)
+	c.Assert(s.rfs.HasAction("rename "+authKey()+".tmp "+authKey()), check.Equals, false)
+}
+
 func (s *S) TestRemoveKeyKeepOtherKeys(c *check.C) {
 	err := addKey("key1", rawKey, "gopher")
 	c.Assert(err, check.IsNil)
@@ -497,3 +528,54 @@ func (s *S) TestListKeysFromTheUserOnly(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(got, check.DeepEquals, KeyList(expected))
 }
+
+func (s *S) TestLookupKey(c *check.C) {
+	err := addKey("key1", rawKey, "gopher")
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	defer conn.Key().Remove(bson.M{"name": "key1"})
+	parts := strings.Fields(rawKey)
+	for _, key := range []string{fingerprint, rawKey, parts[0] + " " + parts[1], parts[1]} {
+		k, err := LookupKey(key)
+		c.Assert(err, check.IsNil)
+		c.Check(k.Name, check.Equals, "key1")
+		c.Check(k.UserName, check.Equals, "gopher")
+		c.Check(k.AuthorizedKeysLine(), check.Equals, k.format())
+	}
+}
+
+func (s *S) TestLookupKeyWithoutStoredFingerprint(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	k, err := newKey("key1", "gopher", rawKey)
+	c.Assert(err, check.IsNil)
+	err = conn.Key().Insert(bson.M{"name": k.Name, "body": k.Body, "comment": k.Comment, "username": k.UserName})
+	c.Assert(err, check.IsNil)
+	defer conn.Key().Remove(bson.M{"name": "key1"})
+	found, err := LookupKey(fingerprint)
+	c.Assert(err, check.IsNil)
+	c.Assert(found.Name, check.Equals, "key1")
+	c.Assert(found.UserName, check.Equals, "gopher")
+	c.Assert(found.Fingerprint, check.Equals, fingerprint)
+	var stored Key
+	err = conn.Key().Find(bson.M{"name": "key1"}).One(&stored)
+	c.Assert(err, check.IsNil)
+	c.Assert(stored.Fingerprint, check.Equals, fingerprint)
+}
+
+func (s *S) TestLookupKeyNotFound(c *check.C) {
+	_, err := LookupKey(otherKey)
+	c.Assert(err, check.Equals, ErrKeyNotFound)
+	_, err = LookupKey("SHA256:nothere")
+	c.Assert(err, check.Equals, ErrKeyNotFound)
+}
+
+func (s *S) TestLookupKeyInvalidKey(c *check.C) {
+	for _, key := range []string{"", "ssh-rsa not-a-key", "bm90IGEga2V5"} {
+		_, err := LookupKey(key)
+		c.Check(err, check.Equals, ErrInvalidKey)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package user
+
+import (
+	"encoding/base64"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/gandalf/db"
+)
+
+// DefaultListLimit is the number of users returned by List when the filter
+// does not define a limit, and MaxListLimit is the largest limit allowed.
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Info describes a user, with the number of keys it has. Created is nil for
+// the users created before creation times were recorded.
+type Info struct {
+	Name    string     `json:"name"`
+	Keys    int        `json:"keys"`
+	Created *time.Time `json:"created,omitempty"`
+}
+
+// ListFilter defines the criteria for listing users. Search matches the users
+// whose name contains it, ignoring case, and Cursor is the Next field of the
+// previous page.
+type ListFilter struct {
+	Search string
+	Cursor string
+	Limit  int
+}
+
+// UserPage is a page of the users returned by List, sorted by name. Next is
+// the cursor of the following page, empty in the last one.
+type UserPage struct {
+	Users []Info `json:"users"`
+	Next  string `json:"next"`
+}
+
+// Get returns the user with the given name.
+func Get(name string) (*Info, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var u User
+	if err = conn.User().FindId(name).One(&u); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	infos, err := userInfos(conn, []User{u})
+	if err != nil {
+		return nil, err
+	}
+	return &infos[0], nil
+}
+
+// List returns a page of the users matching the given filter.
+func List(f ListFilter) (*UserPage, error) {
+	if f.Limit <= 0 {
+		f.Limit = DefaultListLimit
+	}
+	if f.Limit > MaxListLimit {
+		f.Limit = MaxListLimit
+	}
+	query := bson.M{}
+	if f.Search != "" {
+		query["_id"] = bson.RegEx{Pattern: regexp.QuoteMeta(f.Search), Options: "i"}
+	}
+	if f.Cursor != "" {
+		last, err := base64.RawURLEncoding.DecodeString(f.Cursor)
+		if err != nil || len(last) == 0 {
+			return nil, ErrInvalidCursor
+		}
+		query = bson.M{"$and": []bson.M{query, {"_id": bson.M{"$gt": string(last)}}}}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var users []User
+	if err = conn.User().Find(query).Sort("_id").Limit(f.Limit + 1).All(&users); err != nil {
+		return nil, err
+	}
+	var page UserPage
+	if len(users) > f.Limit {
+		users = users[:f.Limit]
+		page.Next = base64.RawURLEncoding.EncodeToString([]byte(users[f.Limit-1].Name))
+	}
+	if page.Users, err = userInfos(conn, users); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// userInfos returns the info of the given users, counting their keys.
+func userInfos(conn *db.Storage, users []User) ([]Info, error) {
+	names := make([]string, len(users))
+	for i, u := range users {
+		names[i] = u.Name
+	}
+	var counts []struct {
+		Name  string `bson:"_id"`
+		Count int
+	}
+	pipeline := []bson.M{
+		{"$match": bson.M{"username": bson.M{"$in": names}}},
+		{"$group": bson.M{"_id": "$username", "count": bson.M{"$sum": 1}}},
+	}
+	if err := conn.Key().Pipe(pipeline).All(&counts); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]int, len(counts))
+	for _, c := range counts {
+		keys[c.Name] = c.Count
+	}
+	infos := make([]Info, len(users))
+	for i, u := range users {
+		infos[i] = Info{Name: u.Name, Keys: keys[u.Name]}
+		if !u.Created.IsZero() {
+			created := u.Created
+			infos[i].Created = &created
+		}
+	}
+	return infos, nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package user
+
+import (
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/gandalf/db"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) insertListUsers(c *check.C) func() {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	created := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	for _, u := range []User{{Name: "frodo", Created: created}, {Name: "bilbo"}, {Name: "Samwise"}} {
+		err = conn.User().Insert(&u)
+		c.Assert(err, check.IsNil)
+	}
+	for _, name := range []string{"laptop", "desktop"} {
+		err = conn.Key().Insert(&Key{Name: name, Body: name, UserName: "frodo"})
+		c.Assert(err, check.IsNil)
+	}
+	return func() {
+		conn.User().RemoveAll(nil)
+		conn.Key().RemoveAll(bson.M{"username": "frodo"})
+		conn.Close()
+	}
+}
+
+func (s *S) TestGet(c *check.C) {
+	defer s.insertListUsers(c)()
+	u, err := Get("frodo")
+	c.Assert(err, check.IsNil)
+	c.Assert(u.Name, check.Equals, "frodo")
+	c.Assert(u.Keys, check.Equals, 2)
+	c.Assert(u.Created, check.NotNil)
+	c.Assert(u.Created.Equal(time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)), check.Equals, true)
+	u, err = Get("bilbo")
+	c.Assert(err, check.IsNil)
+	c.Assert(u.Keys, check.Equals, 0)
+	c.Assert(u.Created, check.IsNil)
+}
+
+func (s *S) TestGetNotFound(c *check.C) {
+	_, err := Get("gollum")
+	c.Assert(err, check.Equals, ErrUserNotFound)
+}
+
+func (s *S) TestNewRecordsCreationTime(c *check.C) {
+	before := time.Now().UTC().Add(-time.Second)
+	_, err := New("someuser", nil)
+	c.Assert(err, check.IsNil)
+	defer Remove("someuser")
+	u, err := Get("someuser")
+	c.Assert(err, check.IsNil)
+	c.Assert(u.Created, check.NotNil)
+	c.Assert(u.Created.After(before), check.Equals, true)
+}
+
+func (s *S) TestList(c *check.C) {
+	defer s.insertListUsers(c)()
+	var names []string
+	filter := ListFilter{Limit: 2}
+	for {
+		page, err := List(filter)
+		c.Assert(err, check.IsNil)
+		for _, u := range page.Users {
+			names = append(names, u.Name)
+		}
+		if page.Next == "" {
+			break
+		}
+		filter.Cursor = page.Next
+	}
+	c.Assert(names, check.DeepEquals, []string{"Samwise", "bilbo", "frodo"})
+}
+
+func (s *S) TestListSearch(c *check.C) {
+	defer s.insertListUsers(c)()
+	page, err := List(ListFilter{Search: "O"})
+	c.Assert(err, check.IsNil)
+	c.Assert(page.Users, check.HasLen, 2)
+	c.Assert(page.Users[0].Name, check.Equals, "bilbo")
+	c.Assert(page.Users[1].Name, check.Equals, "frodo")
+	c.Assert(page.Users[1].Keys, check.Equals, 2)
+	c.Assert(page.Next, check.Equals, "")
+}
+
+func (s *S) TestListInvalidCursor(c *check.C) {
+	_, err := List(ListFilter{Cursor: "not base64!"})
+	c.Assert(err, check.Equals, ErrInvalidCursor)
+}
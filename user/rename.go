@@ -0,0 +1,173 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package user
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/gandalf/repository"
+	"github.com/tsuru/tsuru/log"
+)
+
+// memberFields are the fields of repository documents listing users.
+var memberFields = []string{"users", "readonlyusers", "maintainers", "admins"}
+
+// Rename renames the user, moving its keys and tokens, rewriting its keys in
+// the authorized_keys file and replacing its name in the repositories, branch
+// protections and groups it is a member of. When a step fails, the previous
+// ones are undone, so the user is left as it was.
+func Rename(oldName, newName string) error {
+	log.Debugf("Renaming user %q to %q", oldName, newName)
+	newUser := User{Name: newName}
+	if v, err := newUser.isValid(); !v {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var oldUser User
+	if err = conn.User().FindId(oldName).One(&oldUser); err != nil {
+		if err == mgo.ErrNotFound {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	var keys []Key
+	if err = conn.Key().Find(bson.M{"username": oldName}).All(&keys); err != nil {
+		return err
+	}
+	var undo []func() error
+	rollback := func(err error) error {
+		for i := len(undo) - 1; i >= 0; i-- {
+			if uerr := undo[i](); uerr != nil {
+				log.Errorf("user.Rename: Error undoing changes: %s", uerr)
+			}
+		}
+		return err
+	}
+	newUser.Created = oldUser.Created
+	if err = conn.User().Insert(&newUser); err != nil {
+		if mgo.IsDup(err) {
+			return ErrUserAlreadyExists
+		}
+		log.Errorf("user.Rename: Error adding new user %q: %s", newName, err)
+		return err
+	}
+	undo = append(undo, func() error { return conn.User().RemoveId(newName) })
+	if err = moveKeys(conn, oldName, newName); err != nil {
+		log.Errorf("user.Rename: Error moving keys of user %q: %s", oldName, err)
+		return rollback(err)
+	}
+	undo = append(undo, func() error { return moveKeys(conn, newName, oldName) })
+	if err = moveTokens(conn, oldName, newName); err != nil {
+		log.Errorf("user.Rename: Error moving tokens of user %q: %s", oldName, err)
+		return rollback(err)
+	}
+	undo = append(undo, func() error { return moveTokens(conn, newName, oldName) })
+	if err = replaceMember(conn, oldName, newName); err != nil {
+		log.Errorf("user.Rename: Error replacing user %q in repositories and groups: %s", oldName, err)
+		return rollback(err)
+	}
+	undo = append(undo, func() error { return replaceMember(conn, newName, oldName) })
+	renamed := make([]Key, len(keys))
+	for i, k := range keys {
+		renamed[i] = k
+		renamed[i].UserName = newName
+	}
+	if err = replaceKeys(keys, renamed); err != nil {
+		log.Errorf("user.Rename: Error rewriting keys of user %q in authorized_keys: %s", oldName, err)
+		return rollback(err)
+	}
+	undo = append(undo, func() error { return replaceKeys(renamed, keys) })
+	if err = conn.User().RemoveId(oldName); err != nil {
+		log.Errorf("user.Rename: Error removing old user %q: %s", oldName, err)
+		return rollback(err)
+	}
+	return nil
+}
+
+func moveKeys(conn *db.Storage, from, to string) error {
+	_, err := conn.Key().UpdateAll(bson.M{"username": from}, bson.M{"$set": bson.M{"username": to}})
+	return err
+}
+
+func moveTokens(conn *db.Storage, from, to string) error {
+	_, err := conn.Token().UpdateAll(bson.M{"user": from}, bson.M{"$set": bson.M{"user": to}})
+	return err
+}
+
+// replaceMember replaces a user by another in the members of repositories,
+// branch protections and groups.
+func replaceMember(conn *db.Storage, from, to string) error {
+	for _, field := range memberFields {
+		_, err := conn.Repository().UpdateAll(bson.M{field: from}, bson.M{"$set": bson.M{field + ".$": to}})
+		if err != nil {
+			return err
+		}
+	}
+	var repos []repository.Repository
+	if err := conn.Repository().Find(bson.M{"protections.users": from}).All(&repos); err != nil {
+		return err
+	}
+	for _, r := range repos {
+		for i := range r.Protections {
+			for j, u := range r.Protections[i].Users {
+				if u == from {
+					r.Protections[i].Users[j] = to
+				}
+			}
+		}
+		if err := conn.Repository().UpdateId(r.Name, bson.M{"$set": bson.M{"protections": r.Protections}}); err != nil {
+			return err
+		}
+	}
+	_, err := conn.Group().UpdateAll(bson.M{"members": from}, bson.M{"$set": bson.M{"members.$": to}})
+	return err
+}
+
+// replaceKeys replaces the lines of the given keys in the authorized_keys
+// file by the lines of the keys at the same positions in the other slice.
+func replaceKeys(from, to []Key) error {
+	if !writeAuthorizedKeys() || len(from) == 0 {
+		return nil
+	}
+	replacements := make(map[string]string, len(from))
+	for i := range from {
+		replacements[from[i].format()] = to[i].format()
+	}
+	file, err := copyFile()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	lines := make([]string, 0, 10)
+	reader := bufio.NewReader(file)
+	line, _ := reader.ReadString('\n')
+	for line != "" {
+		if replacement, ok := replacements[line]; ok {
+			line = replacement
+		}
+		lines = append(lines, line)
+		line, _ = reader.ReadString('\n')
+	}
+	file.Truncate(0)
+	file.Seek(0, 0)
+	content := strings.Join(lines, "")
+	n, err := file.WriteString(content)
+	if err != nil {
+		return err
+	}
+	if n != len(content) {
+		return io.ErrShortWrite
+	}
+	return moveFile(file.Name())
+}
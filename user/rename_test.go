@@ -0,0 +1,138 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package user
+
+import (
+	"errors"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/gandalf/auth"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/gandalf/fs"
+	"github.com/tsuru/gandalf/repository"
+	"github.com/tsuru/tsuru/fs/fstest"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) insertRenameFixtures(c *check.C) func() {
+	_, err := New("frodo", map[string]string{"laptop": rawKey})
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	repos := []repository.Repository{
+		{Name: "shire", Users: []string{"bilbo", "frodo"}, Protections: []repository.Protection{{Pattern: "master", Users: []string{"frodo"}}}},
+		{Name: "rivendell", ReadOnlyUsers: []string{"frodo"}, Admins: []string{"elrond", "frodo"}},
+	}
+	for _, r := range repos {
+		err = conn.Repository().Insert(r)
+		c.Assert(err, check.IsNil)
+	}
+	err = conn.Group().Insert(bson.M{"_id": "fellowship", "members": []string{"frodo", "sam"}})
+	c.Assert(err, check.IsNil)
+	return func() {
+		conn.User().RemoveAll(nil)
+		conn.Key().RemoveAll(nil)
+		conn.Repository().RemoveAll(nil)
+		conn.Group().RemoveAll(nil)
+		conn.Token().RemoveAll(nil)
+		conn.Close()
+	}
+}
+
+func (s *S) TestRename(c *check.C) {
+	defer s.insertRenameFixtures(c)()
+	_, raw, err := auth.NewUserToken("frodo-laptop", "frodo", []string{auth.WriteScope})
+	c.Assert(err, check.IsNil)
+	err = Rename("frodo", "ringbearer")
+	c.Assert(err, check.IsNil)
+	_, err = Get("frodo")
+	c.Assert(err, check.Equals, ErrUserNotFound)
+	u, err := Get("ringbearer")
+	c.Assert(err, check.IsNil)
+	c.Assert(u.Keys, check.Equals, 1)
+	c.Assert(u.Created, check.NotNil)
+	shire, err := repository.Get("shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(shire.Users, check.DeepEquals, []string{"bilbo", "ringbearer"})
+	c.Assert(shire.Protections[0].Users, check.DeepEquals, []string{"ringbearer"})
+	rivendell, err := repository.Get("rivendell")
+	c.Assert(err, check.IsNil)
+	c.Assert(rivendell.ReadOnlyUsers, check.DeepEquals, []string{"ringbearer"})
+	c.Assert(rivendell.Admins, check.DeepEquals, []string{"elrond", "ringbearer"})
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	var g bson.M
+	err = conn.Group().FindId("fellowship").One(&g)
+	c.Assert(err, check.IsNil)
+	c.Assert(g["members"], check.DeepEquals, []interface{}{"ringbearer", "sam"})
+	var k Key
+	err = conn.Key().Find(bson.M{"name": "laptop"}).One(&k)
+	c.Assert(err, check.IsNil)
+	c.Assert(k.UserName, check.Equals, "ringbearer")
+	c.Assert(s.authKeysContent(c), check.Equals, k.format())
+	t, err := auth.Authenticate(raw)
+	c.Assert(err, check.IsNil)
+	c.Assert(t.User, check.Equals, "ringbearer")
+}
+
+func (s *S) TestRenameRollsBackWhenAuthorizedKeysFails(c *check.C) {
+	fs.Fsystem = &fstest.FailureFs{Err: errors.New("disk failure")}
+	defer func() { fs.Fsystem = s.rfs }()
+	defer s.insertRenameFixtures(c)()
+	_, raw, err := auth.NewUserToken("frodo-laptop", "frodo", []string{auth.WriteScope})
+	c.Assert(err, check.IsNil)
+	err = Rename("frodo", "ringbearer")
+	c.Assert(err, check.ErrorMatches, "disk failure")
+	_, err = Get("frodo")
+	c.Assert(err, check.IsNil)
+	_, err = Get("ringbearer")
+	c.Assert(err, check.Equals, ErrUserNotFound)
+	shire, err := repository.Get("shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(shire.Users, check.DeepEquals, []string{"bilbo", "frodo"})
+	c.Assert(shire.Protections[0].Users, check.DeepEquals, []string{"frodo"})
+	keys, err := ListKeys("frodo")
+	c.Assert(err, check.IsNil)
+	c.Assert(keys, check.HasLen, 1)
+	t, err := auth.Authenticate(raw)
+	c.Assert(err, check.IsNil)
+	c.Assert(t.User, check.Equals, "frodo")
+}
+
+func (s *S) TestRenameNotFound(c *check.C) {
+	err := Rename("gollum", "smeagol")
+	c.Assert(err, check.Equals, ErrUserNotFound)
+}
+
+func (s *S) TestRenameAlreadyExists(c *check.C) {
+	defer s.insertRenameFixtures(c)()
+	_, err := New("sam", nil)
+	c.Assert(err, check.IsNil)
+	err = Rename("frodo", "sam")
+	c.Assert(err, check.Equals, ErrUserAlreadyExists)
+	_, err = Get("frodo")
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestRenameInvalidName(c *check.C) {
+	err := Rename("frodo", "ring bearer")
+	c.Assert(err, check.FitsTypeOf, &InvalidUserError{})
+}
+
+func (s *S) TestReplaceKeys(c *check.C) {
+	key, err := newKey("laptop", "frodo", rawKey)
+	c.Assert(err, check.IsNil)
+	other := Key{Name: "desktop", Body: "ssh-dss mykeys-not-secret", UserName: "sam"}
+	err = writeKey(key)
+	c.Assert(err, check.IsNil)
+	err = writeKey(&other)
+	c.Assert(err, check.IsNil)
+	renamed := *key
+	renamed.UserName = "ringbearer"
+	err = replaceKeys([]Key{*key}, []Key{renamed})
+	c.Assert(err, check.IsNil)
+	c.Assert(s.authKeysContent(c), check.Equals, renamed.format()+other.format())
+}
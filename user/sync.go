@@ -0,0 +1,172 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package user
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/gandalf/fs"
+	"github.com/tsuru/tsuru/log"
+)
+
+// Markers of the section of the authorized_keys file managed by gandalf.
+const (
+	beginMarker = "# BEGIN gandalf managed keys"
+	endMarker   = "# END gandalf managed keys"
+)
+
+// KeyChange identifies a key added to or removed from the authorized_keys
+// file.
+type KeyChange struct {
+	UserName string `json:"user"`
+	Key      string `json:"key"`
+}
+
+// SyncReport describes the changes made to the authorized_keys file by
+// SyncAuthorizedKeys. Unmanaged is the number of lines kept because they are
+// not managed by gandalf.
+type SyncReport struct {
+	Added     []KeyChange `json:"added"`
+	Removed   []KeyChange `json:"removed"`
+	Unmanaged int         `json:"unmanaged"`
+}
+
+// SyncAuthorizedKeys rebuilds the authorized_keys file from the keys stored in
+// the database, replacing the file atomically.
+//
+// The keys are written between marker comments. Lines in the format of gandalf
+// keys are replaced wherever they are, so files written by older versions are
+// migrated to the marked section. Other lines outside of the section are kept
+// in place, and the ones inside of it are discarded.
+//
+// It returns ErrAuthorizedKeysDisabled when the authorized_keys file is not
+// used, see the authorized-keys-command setting.
+func SyncAuthorizedKeys() (*SyncReport, error) {
+	if !writeAuthorizedKeys() {
+		return nil, ErrAuthorizedKeysDisabled
+	}
+	log.Debugf("Synchronizing authorized_keys with the database")
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var keys []Key
+	err = conn.Key().Find(nil).Sort("username", "name").All(&keys)
+	if err != nil {
+		return nil, err
+	}
+	before, managed, after, err := readAuthorizedKeys()
+	if err != nil {
+		return nil, err
+	}
+	report := SyncReport{Added: []KeyChange{}, Removed: []KeyChange{}, Unmanaged: len(before) + len(after)}
+	wanted := make(map[string]bool, len(keys))
+	for i := range keys {
+		wanted[strings.TrimSuffix(keys[i].format(), "\n")] = true
+	}
+	kept := make(map[string]bool, len(managed))
+	for _, line := range managed {
+		if wanted[line] && !kept[line] {
+			kept[line] = true
+		} else {
+			report.Removed = append(report.Removed, parseKeyLine(line))
+		}
+	}
+	for i := range keys {
+		if !kept[strings.TrimSuffix(keys[i].format(), "\n")] {
+			report.Added = append(report.Added, KeyChange{UserName: keys[i].UserName, Key: keys[i].String()})
+		}
+	}
+	file, err := fs.Filesystem().OpenFile(authKey()+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	for _, line := range before {
+		w.WriteString(line + "\n")
+	}
+	w.WriteString(beginMarker + "\n")
+	for i := range keys {
+		if err = keys[i].dump(w); err != nil {
+			return nil, err
+		}
+	}
+	w.WriteString(endMarker + "\n")
+	for _, line := range after {
+		w.WriteString(line + "\n")
+	}
+	if err = w.Flush(); err != nil {
+		return nil, err
+	}
+	if err = moveFile(file.Name()); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// readAuthorizedKeys reads the authorized_keys file, splitting it into the
+// unmanaged lines before and after the gandalf section, and the gandalf keys.
+func readAuthorizedKeys() (before, managed, after []string, err error) {
+	file, err := fs.Filesystem().Open(authKey())
+	if os.IsNotExist(err) {
+		return nil, nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer file.Close()
+	prefix := managedPrefix()
+	inSection, sectionSeen := false, false
+	reader := bufio.NewReader(file)
+	for {
+		line, readErr := reader.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return nil, nil, nil, readErr
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == beginMarker:
+			inSection, sectionSeen = true, true
+		case line == endMarker:
+			inSection = false
+		case strings.HasPrefix(line, prefix):
+			managed = append(managed, line)
+		case inSection || (line == "" && readErr == io.EOF):
+			// unmanaged lines in the section are discarded
+		case sectionSeen:
+			after = append(after, line)
+		default:
+			before = append(before, line)
+		}
+		if readErr == io.EOF {
+			return before, managed, after, nil
+		}
+	}
+}
+
+// managedPrefix returns the prefix of the lines of gandalf keys.
+func managedPrefix() string {
+	binPath, err := config.GetString("bin-path")
+	if err != nil {
+		panic(err)
+	}
+	return keyOptions + `,command="` + binPath + " "
+}
+
+func parseKeyLine(line string) KeyChange {
+	line = strings.TrimPrefix(line, managedPrefix())
+	parts := strings.SplitN(line, `" `, 2)
+	if len(parts) != 2 {
+		return KeyChange{Key: line}
+	}
+	return KeyChange{UserName: parts[0], Key: parts[1]}
+}
@@ -0,0 +1,173 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package user
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) writeAuthorizedKeys(c *check.C, content string) {
+	f, err := s.rfs.Create(authKey())
+	c.Assert(err, check.IsNil)
+	defer f.Close()
+	_, err = f.Write([]byte(content))
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) readAuthorizedKeys(c *check.C) string {
+	f, err := s.rfs.Open(authKey())
+	c.Assert(err, check.IsNil)
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	c.Assert(err, check.IsNil)
+	return string(b)
+}
+
+func (s *S) TestSyncAuthorizedKeys(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	glenda, err := newKey("key1", "glenda", rawKey)
+	c.Assert(err, check.IsNil)
+	gopher, err := newKey("key1", "gopher", otherKey)
+	c.Assert(err, check.IsNil)
+	err = conn.Key().Insert(gopher, glenda)
+	c.Assert(err, check.IsNil)
+	defer conn.Key().RemoveAll(nil)
+	stale := Key{Name: "old", UserName: "sauron", Body: "ssh-rsa AAAAstale", Comment: "eye@mordor"}
+	s.writeAuthorizedKeys(c, "ssh-rsa AAAAadmin admin@host\n"+stale.format()+gopher.format())
+	report, err := SyncAuthorizedKeys()
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Added, check.DeepEquals, []KeyChange{{UserName: "glenda", Key: glenda.String()}})
+	c.Assert(report.Removed, check.DeepEquals, []KeyChange{{UserName: "sauron", Key: "ssh-rsa AAAAstale eye@mordor"}})
+	c.Assert(report.Unmanaged, check.Equals, 1)
+	expected := "ssh-rsa AAAAadmin admin@host\n" + beginMarker + "\n" + glenda.format() + gopher.format() + endMarker + "\n"
+	c.Assert(s.readAuthorizedKeys(c), check.Equals, expected)
+	c.Assert(s.rfs.HasAction("rename "+authKey()+".tmp "+authKey()), check.Equals, true)
+}
+
+func (s *S) TestSyncAuthorizedKeysKeepsLinesAroundSection(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	glenda, err := newKey("key1", "glenda", rawKey)
+	c.Assert(err, check.IsNil)
+	gopher, err := newKey("key1", "gopher", otherKey)
+	c.Assert(err, check.IsNil)
+	err = conn.Key().Insert(gopher, glenda)
+	c.Assert(err, check.IsNil)
+	defer conn.Key().RemoveAll(nil)
+	content := "# admin keys\nssh-rsa AAAAadmin admin@host\n" +
+		beginMarker + "\n" + glenda.format() + "garbage\n" + endMarker + "\n" +
+		"ssh-rsa AAAAbackup backup@host\n" + gopher.format()
+	s.writeAuthorizedKeys(c, content)
+	report, err := SyncAuthorizedKeys()
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Added, check.HasLen, 0)
+	c.Assert(report.Removed, check.HasLen, 0)
+	c.Assert(report.Unmanaged, check.Equals, 3)
+	expected := "# admin keys\nssh-rsa AAAAadmin admin@host\n" +
+		beginMarker + "\n" + glenda.format() + gopher.format() + endMarker + "\n" +
+		"ssh-rsa AAAAbackup backup@host\n"
+	c.Assert(s.readAuthorizedKeys(c), check.Equals, expected)
+}
+
+func (s *S) TestAddKeyAfterSyncAuthorizedKeys(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	glenda, err := newKey("key1", "glenda", rawKey)
+	c.Assert(err, check.IsNil)
+	err = conn.Key().Insert(glenda)
+	c.Assert(err, check.IsNil)
+	defer conn.Key().RemoveAll(nil)
+	s.writeAuthorizedKeys(c, "ssh-rsa AAAAadmin admin@host\n")
+	_, err = SyncAuthorizedKeys()
+	c.Assert(err, check.IsNil)
+	err = addKey("key1", otherKey, "gopher")
+	c.Assert(err, check.IsNil)
+	gopher, err := newKey("key1", "gopher", otherKey)
+	c.Assert(err, check.IsNil)
+	expected := "ssh-rsa AAAAadmin admin@host\n" + beginMarker + "\n" + glenda.format() + gopher.format() + endMarker + "\n"
+	c.Assert(s.readAuthorizedKeys(c), check.Equals, expected)
+	report, err := SyncAuthorizedKeys()
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Added, check.HasLen, 0)
+	c.Assert(report.Removed, check.HasLen, 0)
+	c.Assert(report.Unmanaged, check.Equals, 1)
+}
+
+func (s *S) TestSyncAuthorizedKeysRemovesDuplicates(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	glenda, err := newKey("key1", "glenda", rawKey)
+	c.Assert(err, check.IsNil)
+	err = conn.Key().Insert(glenda)
+	c.Assert(err, check.IsNil)
+	defer conn.Key().RemoveAll(nil)
+	s.writeAuthorizedKeys(c, glenda.format()+glenda.format())
+	report, err := SyncAuthorizedKeys()
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Added, check.HasLen, 0)
+	c.Assert(report.Removed, check.DeepEquals, []KeyChange{{UserName: "glenda", Key: glenda.String()}})
+	c.Assert(s.readAuthorizedKeys(c), check.Equals, beginMarker+"\n"+glenda.format()+endMarker+"\n")
+}
+
+func (s *S) TestSyncAuthorizedKeysWithoutFile(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	glenda, err := newKey("key1", "glenda", rawKey)
+	c.Assert(err, check.IsNil)
+	err = conn.Key().Insert(glenda)
+	c.Assert(err, check.IsNil)
+	defer conn.Key().RemoveAll(nil)
+	report, err := SyncAuthorizedKeys()
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Added, check.HasLen, 1)
+	c.Assert(report.Unmanaged, check.Equals, 0)
+	c.Assert(s.readAuthorizedKeys(c), check.Equals, beginMarker+"\n"+glenda.format()+endMarker+"\n")
+}
+
+func (s *S) TestReadAuthorizedKeys(c *check.C) {
+	key := Key{Name: "key1", UserName: "glenda", Body: "ssh-rsa AAAAglenda", Comment: "glenda@plan9"}
+	s.writeAuthorizedKeys(c, "before\n"+beginMarker+"\n"+key.format()+"inside\n"+endMarker+"\nafter\n"+key.format())
+	before, managed, after, err := readAuthorizedKeys()
+	c.Assert(err, check.IsNil)
+	c.Assert(before, check.DeepEquals, []string{"before"})
+	c.Assert(after, check.DeepEquals, []string{"after"})
+	line := key.format()
+	c.Assert(managed, check.DeepEquals, []string{line[:len(line)-1], line[:len(line)-1]})
+}
+
+func (s *S) TestReadAuthorizedKeysWithoutFile(c *check.C) {
+	before, managed, after, err := readAuthorizedKeys()
+	c.Assert(err, check.IsNil)
+	c.Assert(before, check.IsNil)
+	c.Assert(managed, check.IsNil)
+	c.Assert(after, check.IsNil)
+}
+
+func (s *S) TestParseKeyLine(c *check.C) {
+	key := Key{Name: "key1", UserName: "glenda", Body: "ssh-rsa AAAAglenda", Comment: "glenda@plan9"}
+	line := key.format()
+	change := parseKeyLine(line[:len(line)-1])
+	c.Assert(change, check.DeepEquals, KeyChange{UserName: "glenda", Key: "ssh-rsa AAAAglenda glenda@plan9"})
+}
+
+func (s *S) TestSyncAuthorizedKeysWithAuthorizedKeysCommand(c *check.C) {
+	config.Set("authorized-keys-command", true)
+	defer config.Unset("authorized-keys-command")
+	_, err := SyncAuthorizedKeys()
+	c.Assert(err, check.Equals, ErrAuthorizedKeysDisabled)
+	_, err = s.rfs.Open(authKey())
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+}
@@ -8,10 +8,13 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"time"
 
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/gandalf/auth"
 	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/gandalf/group"
 	"github.com/tsuru/gandalf/repository"
 	"github.com/tsuru/tsuru/log"
 )
@@ -24,7 +27,8 @@ var (
 )
 
 type User struct {
-	Name string `bson:"_id"`
+	Name    string    `bson:"_id"`
+	Created time.Time `bson:",omitempty"`
 }
 
 // Creates a new user and write his/her keys into authorized_keys file.
@@ -32,7 +36,7 @@ type User struct {
 // The authorized_keys file belongs to the user running the process.
 func New(name string, keys map[string]string) (*User, error) {
 	log.Debugf(`Creating user "%s"`, name)
-	u := &User{Name: name}
+	u := &User{Name: name, Created: time.Now().UTC()}
 	if v, err := u.isValid(); !v {
 		log.Errorf("user.New: %s", err.Error())
 		return u, err
@@ -67,6 +71,9 @@ func (u *User) isValid() (isValid bool, err error) {
 //     - if he/she is the only one with access to the repository, the removal will stop and return an error
 //     - if there are more than one user with access to the repository, gandalf will first revoke user's access and then remove the user permanently
 // - a user has no repositories: gandalf will simply remove the user
+// Members of the groups with write access to a repository count as users with
+// access to it. The user is also removed from all groups, and its tokens are
+// revoked.
 func Remove(name string) error {
 	var u *User
 	conn, err := db.Conn()
@@ -83,9 +90,15 @@ func Remove(name string) error {
 	if err := u.handleAssociatedRepositories(); err != nil {
 		return err
 	}
+	if err := group.RemoveUser(u.Name); err != nil {
+		return err
+	}
 	if err := conn.User().RemoveId(u.Name); err != nil {
 		return fmt.Errorf("Could not remove user: %s", err.Error())
 	}
+	if err := auth.RevokeUserTokens(u.Name); err != nil {
+		return err
+	}
 	return removeUserKeys(u.Name)
 }
 
@@ -96,28 +109,37 @@ func (u *User) handleAssociatedRepositories() error {
 		return err
 	}
 	defer conn.Close()
-	if err := conn.Repository().Find(bson.M{"users": u.Name}).All(&repos); err != nil {
+	query := bson.M{"$or": []bson.M{{"users": u.Name}, {"maintainers": u.Name}, {"admins": u.Name}}}
+	if err := conn.Repository().Find(query).All(&repos); err != nil {
 		return err
 	}
 	for _, r := range repos {
-		if len(r.Users) == 1 {
+		if !hasOtherWriters(&r, u.Name) && !group.HasOtherMembers(r.Groups, u.Name) {
 			return errors.New("Could not remove user: user is the only one with access to at least one of it's repositories")
 		}
 	}
 	for _, r := range repos {
-		for i, v := range r.Users {
-			if v == u.Name {
-				r.Users[i], r.Users = r.Users[len(r.Users)-1], r.Users[:len(r.Users)-1]
-				if err := conn.Repository().Update(bson.M{"_id": r.Name}, r); err != nil {
-					return err
-				}
-				break
-			}
+		update := bson.M{"$pull": bson.M{"users": u.Name, "maintainers": u.Name, "admins": u.Name}}
+		if err := conn.Repository().UpdateId(r.Name, update); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// hasOtherWriters returns whether any user other than the given one may push
+// to the repository.
+func hasOtherWriters(r *repository.Repository, userName string) bool {
+	for _, users := range [][]string{r.Users, r.Maintainers, r.Admins} {
+		for _, u := range users {
+			if u != userName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // AddKey adds new SSH keys to the list of user keys for the provided username.
 //
 // Returns an error in case the user does not exist.
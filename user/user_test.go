@@ -12,8 +12,10 @@ import (
 
 	"github.com/globalsign/mgo/bson"
 	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/auth"
 	"github.com/tsuru/gandalf/db"
 	"github.com/tsuru/gandalf/fs"
+	"github.com/tsuru/gandalf/group"
 	"github.com/tsuru/gandalf/repository"
 	"github.com/tsuru/tsuru/fs/fstest"
 	"gopkg.in/check.v1"
@@ -276,6 +278,85 @@ func (s *S) TestHandleAssociateRepositoriesReturnsErrorWhenUserIsOnlyOneWithAcce
 	c.Assert(err, check.ErrorMatches, expected)
 }
 
+func (s *S) TestHandleAssociatedRepositoriesWithRoles(c *check.C) {
+	u, err := New("umi", map[string]string{})
+	c.Assert(err, check.IsNil)
+	r := repository.Repository{Name: "proj1", Users: []string{"umi"}, Maintainers: []string{"umi"}, Admins: []string{"bob"}}
+	r2 := repository.Repository{Name: "proj2", Admins: []string{"umi"}}
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&r)
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId(r.Name)
+	defer conn.User().RemoveId(u.Name)
+	err = u.handleAssociatedRepositories()
+	c.Assert(err, check.IsNil)
+	err = conn.Repository().FindId(r.Name).One(&r)
+	c.Assert(err, check.IsNil)
+	c.Assert(r.Users, check.HasLen, 0)
+	c.Assert(r.Maintainers, check.HasLen, 0)
+	c.Assert(r.Admins, check.DeepEquals, []string{"bob"})
+	err = conn.Repository().Insert(&r2)
+	c.Assert(err, check.IsNil)
+	defer conn.Repository().RemoveId(r2.Name)
+	err = u.handleAssociatedRepositories()
+	c.Assert(err, check.ErrorMatches, "^Could not remove user: user is the only one with access to at least one of it's repositories$")
+}
+
+func (s *S) TestHandleAssociatedRepositoriesAllowsRemovalWhenGroupKeepsAccess(c *check.C) {
+	u, err := New("umi", map[string]string{})
+	c.Assert(err, check.IsNil)
+	r := repository.Repository{Name: "proj1", Users: []string{"umi"}, Groups: []string{"devs"}}
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(&r)
+	c.Assert(err, check.IsNil)
+	defer conn.User().RemoveId(u.Name)
+	defer conn.Repository().RemoveId(r.Name)
+	err = conn.Group().Insert(group.Group{Name: "devs", Members: []string{"umi", "bob"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Group().RemoveId("devs")
+	err = u.handleAssociatedRepositories()
+	c.Assert(err, check.IsNil)
+	err = conn.Repository().FindId(r.Name).One(&r)
+	c.Assert(err, check.IsNil)
+	c.Assert(r.Users, check.HasLen, 0)
+}
+
+func (s *S) TestRemoveRemovesUserFromGroups(c *check.C) {
+	u, err := New("umi", map[string]string{})
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Group().Insert(group.Group{Name: "devs", Members: []string{"umi", "bob"}})
+	c.Assert(err, check.IsNil)
+	defer conn.Group().RemoveId("devs")
+	err = Remove(u.Name)
+	c.Assert(err, check.IsNil)
+	g, err := group.Get("devs")
+	c.Assert(err, check.IsNil)
+	c.Assert(g.Members, check.DeepEquals, []string{"bob"})
+}
+
+func (s *S) TestRemoveRevokesUserTokens(c *check.C) {
+	u, err := New("umi", map[string]string{})
+	c.Assert(err, check.IsNil)
+	_, raw, err := auth.NewUserToken("umi-laptop", u.Name, []string{auth.WriteScope})
+	c.Assert(err, check.IsNil)
+	_, other, err := auth.NewUserToken("bob-laptop", "bob", []string{auth.WriteScope})
+	c.Assert(err, check.IsNil)
+	defer auth.Revoke("bob-laptop")
+	err = Remove(u.Name)
+	c.Assert(err, check.IsNil)
+	_, err = auth.Authenticate(raw)
+	c.Assert(err, check.Equals, auth.ErrInvalidToken)
+	_, err = auth.Authenticate(other)
+	c.Assert(err, check.IsNil)
+}
+
 func (s *S) TestAddKeyShouldSaveTheKeyInTheDatabase(c *check.C) {
 	u, err := New("umi", map[string]string{})
 	conn, err := db.Conn()
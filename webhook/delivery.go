@@ -0,0 +1,297 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webhook
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/tsuru/log"
+)
+
+// Status of a delivery.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Defaults of the webhooks settings.
+const (
+	defaultTimeout       = 10 * time.Second
+	defaultRetryInterval = time.Minute
+	defaultMaxAttempts   = 5
+	defaultPollInterval  = 5 * time.Second
+)
+
+// DefaultDeliveryLimit is the maximum number of deliveries returned by
+// ListDeliveries when no limit is given.
+const DefaultDeliveryLimit = 50
+
+// RefUpdate is the update of a ref in a push. Before is the zero SHA for
+// created refs, and After is the zero SHA for deleted refs.
+type RefUpdate struct {
+	Ref    string `json:"ref"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// Payload is the JSON body sent to webhooks.
+type Payload struct {
+	Event      string      `json:"event"`
+	Repository string      `json:"repository"`
+	Pusher     string      `json:"pusher"`
+	Refs       []RefUpdate `json:"refs"`
+	Timestamp  time.Time   `json:"timestamp"`
+}
+
+// Delivery is the notification of an event to a webhook. StatusCode and Error
+// describe the result of the last attempt.
+type Delivery struct {
+	ID          bson.ObjectId `bson:"_id" json:"id"`
+	Webhook     bson.ObjectId `json:"webhook"`
+	Payload     Payload       `json:"payload"`
+	Status      string        `json:"status"`
+	Attempts    int           `json:"attempts"`
+	StatusCode  int           `json:"status_code,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+	NextAttempt time.Time     `json:"next_attempt"`
+}
+
+// ParseRefUpdates parses the ref updates sent by git to the pre-receive and
+// post-receive hooks, one per line in the format "<old> <new> <ref>".
+func ParseRefUpdates(input []byte) []RefUpdate {
+	var updates []RefUpdate
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		updates = append(updates, RefUpdate{Ref: fields[2], Before: fields[0], After: fields[1]})
+	}
+	return updates
+}
+
+func isZeroSHA(sha string) bool {
+	return strings.Trim(sha, "0") == ""
+}
+
+func eventOf(u RefUpdate) string {
+	switch {
+	case isZeroSHA(u.After):
+		return EventDelete
+	case strings.HasPrefix(u.Ref, "refs/tags/"):
+		return EventTag
+	}
+	return EventPush
+}
+
+// Notify queues the deliveries of a push to the given repository to the
+// webhooks of the repository and to the global ones. The ref updates are
+// grouped by event, and each webhook gets a delivery for each of the events it
+// is notified of.
+func Notify(repo, pusher string, updates []RefUpdate) error {
+	byEvent := map[string][]RefUpdate{}
+	for _, u := range updates {
+		event := eventOf(u)
+		byEvent[event] = append(byEvent[event], u)
+	}
+	if len(byEvent) == 0 {
+		return nil
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var hooks []Webhook
+	err = conn.Webhook().Find(bson.M{"repository": bson.M{"$in": []string{repo, ""}}}).Sort("_id").All(&hooks)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, h := range hooks {
+		for _, event := range events {
+			refs, ok := byEvent[event]
+			if !ok || !h.Matches(event) {
+				continue
+			}
+			d := Delivery{
+				ID:          bson.NewObjectId(),
+				Webhook:     h.ID,
+				Payload:     Payload{Event: event, Repository: repo, Pusher: pusher, Refs: refs, Timestamp: now},
+				Status:      DeliveryPending,
+				CreatedAt:   now,
+				NextAttempt: now,
+			}
+			if err = conn.WebhookDelivery().Insert(&d); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ListDeliveries returns the last deliveries of the webhook with the given id,
+// the most recent first.
+func ListDeliveries(id string, limit int) ([]Delivery, error) {
+	h, err := Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = DefaultDeliveryLimit
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deliveries := []Delivery{}
+	err = conn.WebhookDelivery().Find(bson.M{"webhook": h.ID}).Sort("-_id").Limit(limit).All(&deliveries)
+	return deliveries, err
+}
+
+// Sign returns the signature of a payload sent to a webhook with the given
+// secret, which is sent in the X-Gandalf-Signature header.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func duration(key string, def time.Duration) time.Duration {
+	if d, err := config.GetDuration(key); err == nil && d > 0 {
+		return d
+	}
+	return def
+}
+
+func maxAttempts() int {
+	if n, err := config.GetInt("webhooks:max-attempts"); err == nil && n > 0 {
+		return n
+	}
+	return defaultMaxAttempts
+}
+
+// retryDelay returns the delay before the next attempt of a delivery that
+// failed the given number of times. It doubles after each failure.
+func retryDelay(attempts int) time.Duration {
+	return duration("webhooks:retry-interval", defaultRetryInterval) << uint(attempts-1)
+}
+
+// DeliverPending sends the pending deliveries due to be sent, returning the
+// number of deliveries attempted. Each delivery is claimed before being sent,
+// so many dispatchers may run concurrently.
+func DeliverPending() (int, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	timeout := duration("webhooks:timeout", defaultTimeout)
+	var count int
+	for {
+		now := time.Now().UTC()
+		var d Delivery
+		change := mgo.Change{
+			Update:    bson.M{"$set": bson.M{"nextattempt": now.Add(2 * timeout)}},
+			ReturnNew: true,
+		}
+		query := bson.M{"status": DeliveryPending, "nextattempt": bson.M{"$lte": now}}
+		_, err = conn.WebhookDelivery().Find(query).Sort("nextattempt").Apply(change, &d)
+		if err == mgo.ErrNotFound {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		count++
+		attemptErr := deliver(&d, timeout)
+		d.Attempts++
+		update := bson.M{"attempts": d.Attempts, "statuscode": d.StatusCode, "error": ""}
+		switch {
+		case attemptErr == nil:
+			update["status"] = DeliveryDelivered
+		case d.Attempts >= maxAttempts() || attemptErr == ErrWebhookNotFound:
+			update["status"] = DeliveryFailed
+			update["error"] = attemptErr.Error()
+		default:
+			update["nextattempt"] = time.Now().UTC().Add(retryDelay(d.Attempts))
+			update["error"] = attemptErr.Error()
+		}
+		if attemptErr != nil {
+			log.Errorf("webhook: delivery %s to webhook %s failed: %s", d.ID.Hex(), d.Webhook.Hex(), attemptErr)
+		}
+		if err = conn.WebhookDelivery().UpdateId(d.ID, bson.M{"$set": update}); err != nil {
+			return count, err
+		}
+	}
+}
+
+// deliver sends the delivery to its webhook, setting the status code of the
+// response. Responses with a status other than 2xx are failures.
+func deliver(d *Delivery, timeout time.Duration) error {
+	h, err := Get(d.Webhook.Hex())
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(&d.Payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gandalf-webhook")
+	req.Header.Set("X-Gandalf-Event", d.Payload.Event)
+	req.Header.Set("X-Gandalf-Delivery", d.ID.Hex())
+	if h.Secret != "" {
+		req.Header.Set("X-Gandalf-Signature", Sign(h.Secret, body))
+	}
+	client := http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+	d.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// StartDispatcher starts a goroutine that sends the pending deliveries
+// periodically, as defined by the "webhooks:poll-interval" setting.
+func StartDispatcher() {
+	interval := duration("webhooks:poll-interval", defaultPollInterval)
+	go func() {
+		for range time.Tick(interval) {
+			if _, err := DeliverPending(); err != nil {
+				log.Errorf("webhook: failed to deliver pending deliveries: %s", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,170 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
+	"gopkg.in/check.v1"
+)
+
+const (
+	zero = "0000000000000000000000000000000000000000"
+	sha  = "9a8b7c6d5e4f30219a8b7c6d5e4f30219a8b7c6d"
+)
+
+func (s *S) TestParseRefUpdates(c *check.C) {
+	input := "1f2e3d4c5b6a79881f2e3d4c5b6a79881f2e3d4c " + sha + " refs/heads/master\n" +
+		"invalid line\n" +
+		zero + " " + sha + " refs/tags/v1\n"
+	updates := ParseRefUpdates([]byte(input))
+	c.Assert(updates, check.DeepEquals, []RefUpdate{
+		{Ref: "refs/heads/master", Before: "1f2e3d4c5b6a79881f2e3d4c5b6a79881f2e3d4c", After: sha},
+		{Ref: "refs/tags/v1", Before: zero, After: sha},
+	})
+}
+
+func (s *S) TestEventOf(c *check.C) {
+	c.Assert(eventOf(RefUpdate{Ref: "refs/heads/master", Before: zero, After: sha}), check.Equals, EventPush)
+	c.Assert(eventOf(RefUpdate{Ref: "refs/tags/v1", Before: zero, After: sha}), check.Equals, EventTag)
+	c.Assert(eventOf(RefUpdate{Ref: "refs/tags/v1", Before: sha, After: zero}), check.Equals, EventDelete)
+	c.Assert(eventOf(RefUpdate{Ref: "refs/heads/feature", Before: sha, After: zero}), check.Equals, EventDelete)
+}
+
+func (s *S) TestSign(c *check.C) {
+	signature := Sign("It's a Secret to Everybody", []byte("Hello, World!"))
+	c.Assert(signature, check.Equals, "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17")
+}
+
+func (s *S) TestRetryDelay(c *check.C) {
+	c.Assert(retryDelay(1), check.Equals, time.Minute)
+	c.Assert(retryDelay(3), check.Equals, 4*time.Minute)
+	config.Set("webhooks:retry-interval", "10s")
+	defer config.Unset("webhooks:retry-interval")
+	c.Assert(retryDelay(2), check.Equals, 20*time.Second)
+}
+
+func (s *S) TestNotify(c *check.C) {
+	s.insertRepository(c, "the-shire")
+	all := Webhook{Repository: "the-shire", URL: "http://tsuru.example.com/hook"}
+	tags := Webhook{URL: "http://chat.example.com/hook", Events: []string{EventTag}}
+	other := Webhook{URL: "http://chat.example.com/deletes", Events: []string{EventDelete}}
+	for _, h := range []*Webhook{&all, &tags, &other} {
+		c.Assert(Create(h), check.IsNil)
+	}
+	updates := []RefUpdate{
+		{Ref: "refs/heads/master", Before: zero, After: sha},
+		{Ref: "refs/tags/v1", Before: zero, After: sha},
+	}
+	err := Notify("the-shire", "bilbo", updates)
+	c.Assert(err, check.IsNil)
+	deliveries, err := ListDeliveries(all.ID.Hex(), 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(deliveries, check.HasLen, 2)
+	c.Assert(deliveries[0].Payload.Event, check.Equals, EventTag)
+	c.Assert(deliveries[1].Payload.Event, check.Equals, EventPush)
+	c.Assert(deliveries[1].Payload.Repository, check.Equals, "the-shire")
+	c.Assert(deliveries[1].Payload.Pusher, check.Equals, "bilbo")
+	c.Assert(deliveries[1].Payload.Refs, check.DeepEquals, updates[:1])
+	c.Assert(deliveries[1].Status, check.Equals, DeliveryPending)
+	deliveries, err = ListDeliveries(tags.ID.Hex(), 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(deliveries, check.HasLen, 1)
+	c.Assert(deliveries[0].Payload.Refs, check.DeepEquals, updates[1:])
+	deliveries, err = ListDeliveries(other.ID.Hex(), 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(deliveries, check.HasLen, 0)
+}
+
+func (s *S) TestDeliverPending(c *check.C) {
+	var (
+		req  *http.Request
+		body []byte
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req = r
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+	h := Webhook{URL: server.URL, Secret: "s3cr3t"}
+	c.Assert(Create(&h), check.IsNil)
+	err := Notify("the-shire", "bilbo", []RefUpdate{{Ref: "refs/heads/master", Before: zero, After: sha}})
+	c.Assert(err, check.IsNil)
+	n, err := DeliverPending()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 1)
+	c.Assert(req, check.NotNil)
+	c.Assert(req.Header.Get("Content-Type"), check.Equals, "application/json")
+	c.Assert(req.Header.Get("X-Gandalf-Event"), check.Equals, EventPush)
+	c.Assert(req.Header.Get("X-Gandalf-Signature"), check.Equals, Sign("s3cr3t", body))
+	var payload Payload
+	err = json.Unmarshal(body, &payload)
+	c.Assert(err, check.IsNil)
+	c.Assert(payload.Pusher, check.Equals, "bilbo")
+	c.Assert(payload.Refs, check.HasLen, 1)
+	deliveries, err := ListDeliveries(h.ID.Hex(), 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(deliveries, check.HasLen, 1)
+	c.Assert(req.Header.Get("X-Gandalf-Delivery"), check.Equals, deliveries[0].ID.Hex())
+	c.Assert(deliveries[0].Status, check.Equals, DeliveryDelivered)
+	c.Assert(deliveries[0].Attempts, check.Equals, 1)
+	c.Assert(deliveries[0].StatusCode, check.Equals, http.StatusOK)
+	n, err = DeliverPending()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 0)
+}
+
+func (s *S) TestDeliverPendingRetries(c *check.C) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	config.Set("webhooks:max-attempts", 2)
+	defer config.Unset("webhooks:max-attempts")
+	h := Webhook{URL: server.URL}
+	c.Assert(Create(&h), check.IsNil)
+	err := Notify("the-shire", "bilbo", []RefUpdate{{Ref: "refs/heads/master", Before: zero, After: sha}})
+	c.Assert(err, check.IsNil)
+	_, err = DeliverPending()
+	c.Assert(err, check.IsNil)
+	deliveries, err := ListDeliveries(h.ID.Hex(), 0)
+	c.Assert(err, check.IsNil)
+	d := deliveries[0]
+	c.Assert(d.Status, check.Equals, DeliveryPending)
+	c.Assert(d.Attempts, check.Equals, 1)
+	c.Assert(d.StatusCode, check.Equals, http.StatusServiceUnavailable)
+	c.Assert(d.Error, check.Equals, "unexpected response status 503")
+	c.Assert(d.NextAttempt.After(time.Now().Add(50*time.Second)), check.Equals, true)
+	n, err := DeliverPending()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 0)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.WebhookDelivery().UpdateId(d.ID, bson.M{"$set": bson.M{"nextattempt": time.Now().UTC()}})
+	c.Assert(err, check.IsNil)
+	n, err = DeliverPending()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 1)
+	deliveries, err = ListDeliveries(h.ID.Hex(), 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(deliveries[0].Status, check.Equals, DeliveryFailed)
+	c.Assert(deliveries[0].Attempts, check.Equals, 2)
+	c.Assert(calls, check.Equals, 2)
+}
+
+func (s *S) TestListDeliveriesWebhookNotFound(c *check.C) {
+	_, err := ListDeliveries("5a1b2c3d4e5f60718293a4b5", 0)
+	c.Assert(err, check.Equals, ErrWebhookNotFound)
+}
@@ -0,0 +1,178 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package webhook notifies external services, such as tsuru or chat tools, of
+// the pushes to Gandalf repositories.
+//
+// Webhooks are registered for a repository, or for all of them, and are
+// notified through deliveries: HTTP POST requests with a JSON payload
+// describing the updated refs. Deliveries are queued in the database by the
+// post-receive hook and sent by a dispatcher running in the Gandalf servers,
+// which retries the failed ones.
+package webhook
+
+import (
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/gandalf/repository"
+	"github.com/tsuru/tsuru/log"
+)
+
+// Events that may trigger a webhook.
+const (
+	// EventPush is triggered when branches are created or updated.
+	EventPush = "push"
+	// EventTag is triggered when tags are created or updated.
+	EventTag = "tag"
+	// EventDelete is triggered when branches or tags are deleted.
+	EventDelete = "delete"
+)
+
+var events = []string{EventPush, EventTag, EventDelete}
+
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+type InvalidWebhookError struct {
+	message string
+}
+
+func (err *InvalidWebhookError) Error() string {
+	return err.message
+}
+
+// Webhook is an URL notified of the pushes to a repository. Webhooks without a
+// repository are notified of the pushes to all repositories, and webhooks
+// without events are notified of all events.
+//
+// When the secret is set, deliveries are signed with it, see Deliver.
+type Webhook struct {
+	ID         bson.ObjectId `bson:"_id" json:"id"`
+	Repository string        `json:"repository"`
+	URL        string        `json:"url"`
+	Secret     string        `json:"-"`
+	Events     []string      `json:"events"`
+	CreatedAt  time.Time     `json:"created_at"`
+}
+
+// Create validates and stores the given webhook, setting its id and creation
+// time.
+func Create(h *Webhook) error {
+	log.Debugf("Creating webhook for %q on repository %q", h.URL, h.Repository)
+	if err := h.validate(); err != nil {
+		return err
+	}
+	if h.Repository != "" {
+		if _, err := repository.Get(h.Repository); err != nil {
+			return err
+		}
+	}
+	if h.Events == nil {
+		h.Events = []string{}
+	}
+	h.ID = bson.NewObjectId()
+	h.CreatedAt = time.Now().UTC()
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Webhook().Insert(h)
+}
+
+func (h *Webhook) validate() error {
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return &InvalidWebhookError{message: "webhook URL must be an absolute http or https URL"}
+	}
+	for _, event := range h.Events {
+		if !validEvent(event) {
+			return &InvalidWebhookError{message: "event " + event + " is not valid, valid events are push, tag and delete"}
+		}
+	}
+	return nil
+}
+
+func validEvent(event string) bool {
+	for _, e := range events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches returns whether the webhook is notified of the given event.
+func (h *Webhook) Matches(event string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns the webhook with the given id.
+func Get(id string) (*Webhook, error) {
+	if !bson.IsObjectIdHex(id) {
+		return nil, ErrWebhookNotFound
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var h Webhook
+	err = conn.Webhook().FindId(bson.ObjectIdHex(id)).One(&h)
+	if err == mgo.ErrNotFound {
+		return nil, ErrWebhookNotFound
+	}
+	return &h, err
+}
+
+// List returns the webhooks of the given repository, or all webhooks when the
+// repository is empty, the oldest first.
+func List(repo string) ([]Webhook, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var query bson.M
+	if repo != "" {
+		query = bson.M{"repository": repo}
+	}
+	hooks := []Webhook{}
+	err = conn.Webhook().Find(query).Sort("_id").All(&hooks)
+	return hooks, err
+}
+
+// Remove removes the webhook with the given id, along with its deliveries.
+func Remove(id string) error {
+	log.Debugf("Removing webhook %q", id)
+	if !bson.IsObjectIdHex(id) {
+		return ErrWebhookNotFound
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Webhook().RemoveId(bson.ObjectIdHex(id))
+	if err == mgo.ErrNotFound {
+		return ErrWebhookNotFound
+	}
+	if err != nil {
+		return err
+	}
+	_, err = conn.WebhookDelivery().RemoveAll(bson.M{"webhook": bson.ObjectIdHex(id)})
+	return err
+}
@@ -0,0 +1,176 @@
+// Copyright 2026 gandalf authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webhook
+
+import (
+	"testing"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/gandalf/db"
+	"github.com/tsuru/gandalf/repository"
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct{}
+
+var _ = check.Suite(&S{})
+
+func (s *S) SetUpSuite(c *check.C) {
+	err := config.ReadConfigFile("../etc/gandalf.conf")
+	c.Assert(err, check.IsNil)
+	config.Set("database:url", "127.0.0.1:27017")
+	config.Set("database:name", "gandalf_webhook_tests")
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	conn.Webhook().RemoveAll(nil)
+	conn.WebhookDelivery().RemoveAll(nil)
+	conn.Repository().RemoveAll(nil)
+}
+
+func (s *S) TearDownSuite(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	conn.User().Database.DropDatabase()
+}
+
+func (s *S) insertRepository(c *check.C, name string) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Repository().Insert(repository.Repository{Name: name, Users: []string{"bilbo"}})
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestCreate(c *check.C) {
+	s.insertRepository(c, "the-shire")
+	h := Webhook{Repository: "the-shire", URL: "https://tsuru.example.com/hook", Secret: "s3cr3t", Events: []string{EventPush}}
+	err := Create(&h)
+	c.Assert(err, check.IsNil)
+	c.Assert(h.ID.Valid(), check.Equals, true)
+	c.Assert(h.CreatedAt.IsZero(), check.Equals, false)
+	stored, err := Get(h.ID.Hex())
+	c.Assert(err, check.IsNil)
+	c.Assert(stored.URL, check.Equals, h.URL)
+	c.Assert(stored.Secret, check.Equals, "s3cr3t")
+	c.Assert(stored.Events, check.DeepEquals, []string{EventPush})
+}
+
+func (s *S) TestCreateGlobal(c *check.C) {
+	h := Webhook{URL: "http://chat.example.com/notify"}
+	err := Create(&h)
+	c.Assert(err, check.IsNil)
+	c.Assert(h.Events, check.DeepEquals, []string{})
+	hooks, err := List("")
+	c.Assert(err, check.IsNil)
+	c.Assert(hooks, check.HasLen, 1)
+	c.Assert(hooks[0].Repository, check.Equals, "")
+}
+
+func (s *S) TestCreateRepositoryNotFound(c *check.C) {
+	h := Webhook{Repository: "mordor", URL: "http://chat.example.com/notify"}
+	err := Create(&h)
+	c.Assert(err, check.Equals, repository.ErrRepositoryNotFound)
+}
+
+func (s *S) TestValidate(c *check.C) {
+	var tests = []struct {
+		hook Webhook
+		msg  string
+	}{
+		{Webhook{URL: "http://chat.example.com/notify", Events: []string{EventPush, EventTag, EventDelete}}, ""},
+		{Webhook{URL: "ftp://chat.example.com/notify"}, "webhook URL must be an absolute http or https URL"},
+		{Webhook{URL: "/notify"}, "webhook URL must be an absolute http or https URL"},
+		{Webhook{URL: "http://chat.example.com", Events: []string{"fetch"}}, "event fetch is not valid, valid events are push, tag and delete"},
+	}
+	for _, t := range tests {
+		err := t.hook.validate()
+		if t.msg == "" {
+			c.Check(err, check.IsNil)
+		} else {
+			c.Check(err, check.FitsTypeOf, &InvalidWebhookError{})
+			c.Check(err, check.ErrorMatches, t.msg)
+		}
+	}
+}
+
+func (s *S) TestMatches(c *check.C) {
+	h := Webhook{}
+	c.Assert(h.Matches(EventPush), check.Equals, true)
+	c.Assert(h.Matches(EventDelete), check.Equals, true)
+	h.Events = []string{EventTag}
+	c.Assert(h.Matches(EventTag), check.Equals, true)
+	c.Assert(h.Matches(EventPush), check.Equals, false)
+}
+
+func (s *S) TestGetNotFound(c *check.C) {
+	_, err := Get("not-an-id")
+	c.Assert(err, check.Equals, ErrWebhookNotFound)
+	_, err = Get("5a1b2c3d4e5f60718293a4b5")
+	c.Assert(err, check.Equals, ErrWebhookNotFound)
+}
+
+func (s *S) TestList(c *check.C) {
+	s.insertRepository(c, "the-shire")
+	s.insertRepository(c, "mordor")
+	for _, repo := range []string{"the-shire", "mordor", ""} {
+		err := Create(&Webhook{Repository: repo, URL: "http://chat.example.com/" + repo})
+		c.Assert(err, check.IsNil)
+	}
+	hooks, err := List("the-shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(hooks, check.HasLen, 1)
+	c.Assert(hooks[0].URL, check.Equals, "http://chat.example.com/the-shire")
+	hooks, err = List("")
+	c.Assert(err, check.IsNil)
+	c.Assert(hooks, check.HasLen, 3)
+}
+
+func (s *S) TestRemovedRepositoryWebhooksAreNotInherited(c *check.C) {
+	s.insertRepository(c, "the-shire")
+	h := Webhook{Repository: "the-shire", URL: "https://bilbo.example.com/hook"}
+	err := Create(&h)
+	c.Assert(err, check.IsNil)
+	err = Notify("the-shire", "bilbo", []RefUpdate{{Ref: "refs/heads/master", Before: "1a2b3c", After: "4d5e6f"}})
+	c.Assert(err, check.IsNil)
+	err = repository.Remove("the-shire")
+	c.Assert(err, check.IsNil)
+	s.insertRepository(c, "the-shire")
+	hooks, err := List("the-shire")
+	c.Assert(err, check.IsNil)
+	c.Assert(hooks, check.HasLen, 0)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	n, err := conn.WebhookDelivery().Find(nil).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 0)
+}
+
+func (s *S) TestRemove(c *check.C) {
+	h := Webhook{URL: "http://chat.example.com/notify"}
+	err := Create(&h)
+	c.Assert(err, check.IsNil)
+	err = Notify("the-shire", "bilbo", []RefUpdate{{Ref: "refs/heads/master", Before: zero, After: sha}})
+	c.Assert(err, check.IsNil)
+	err = Remove(h.ID.Hex())
+	c.Assert(err, check.IsNil)
+	_, err = Get(h.ID.Hex())
+	c.Assert(err, check.Equals, ErrWebhookNotFound)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	n, err := conn.WebhookDelivery().Find(nil).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 0)
+	err = Remove(h.ID.Hex())
+	c.Assert(err, check.Equals, ErrWebhookNotFound)
+}
@@ -14,6 +14,10 @@ import (
 
 	"github.com/tsuru/config"
 	"github.com/tsuru/gandalf/api"
+	"github.com/tsuru/gandalf/hook"
+	"github.com/tsuru/gandalf/repository"
+	"github.com/tsuru/gandalf/sshserver"
+	"github.com/tsuru/gandalf/webhook"
 	"github.com/tsuru/tsuru/log"
 )
 
@@ -38,10 +42,12 @@ func main() {
 		log.Fatalf(msg, *configFile, err)
 	}
 	log.Init()
+	hook.SetConfigFile(*configFile)
 	log.Debugf("Successfully read config file: %s\n", *configFile)
 	router := api.SetupRouter()
 	n := negroni.New()
 	n.Use(api.NewLoggerMiddleware())
+	n.Use(api.NewAuthMiddleware())
 	n.Use(api.NewResponseHeaderMiddleware("Server", "gandalf-webserver/"+version))
 	n.Use(api.NewResponseHeaderMiddleware("Cache-Control", "private, max-age=0"))
 	n.Use(api.NewResponseHeaderMiddleware("Expires", "-1"))
@@ -68,7 +74,18 @@ func main() {
 		}
 
 		fmt.Printf("Repository location: %s\n", bareLocation)
+		webhook.StartDispatcher()
+		repository.StartMaintenanceScheduler()
+		repository.StartTrashPurger()
+		if sshBind, err := config.GetString("ssh:bind"); err == nil {
+			go func() {
+				fmt.Printf("gandalf-webserver %s listening for SSH connections on %s\n", version, sshBind)
+				if err := sshserver.ListenAndServe(sshBind); err != nil {
+					log.Fatal(err.Error())
+				}
+			}()
+		}
 		fmt.Printf("gandalf-webserver %s listening on %s\n", version, bind)
-		http.ListenAndServe(bind, router)
+		http.ListenAndServe(bind, n)
 	}
 }